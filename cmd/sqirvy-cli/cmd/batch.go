@@ -0,0 +1,559 @@
+/*
+Copyright © 2025 NAME HERE <EMAIL ADDRESS>
+*/
+package cmd
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"maps"
+	"os"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	sqirvy "sqirvy-ai/pkg/sqirvy"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// maxBatchLine limits the size of one request or result line
+const maxBatchLine = 16 << 20
+
+// batchRequest is a line of a batch input file. Fields that are not set
+// use the model and temperature flags and the query system prompt.
+type batchRequest struct {
+	ID          string   `json:"id"`          // Identifies the request in the results, defaults to its line number
+	Model       string   `json:"model"`       // Model name or alias
+	System      string   `json:"system"`      // System prompt
+	Prompt      string   `json:"prompt"`      // Prompt sent to the model
+	Temperature *float32 `json:"temperature"` // Temperature (0..100)
+}
+
+// batchResult is a line of a batch output file
+type batchResult struct {
+	ID           string  `json:"id"`
+	Model        string  `json:"model"`
+	Response     string  `json:"response,omitempty"`
+	Error        string  `json:"error,omitempty"`
+	FinishReason string  `json:"finish_reason,omitempty"`
+	InputTokens  int64   `json:"input_tokens"`
+	OutputTokens int64   `json:"output_tokens"`
+	Duration     float64 `json:"duration"` // Time taken by the request in seconds, including retries
+}
+
+// batchCmd represents the batch command
+var batchCmd = &cobra.Command{
+	Use:   "batch",
+	Short: "Execute the requests of a JSONL file and write their results as JSONL",
+	Long: `sqirvy-cli batch will execute every request of a JSONL file, read from the file argument
+or stdin, with several requests in flight at once. Each line is a JSON object such as
+  {"id": "q1", "model": "gpt-4o", "system": "be brief", "prompt": "What is Go?", "temperature": 20}
+where only prompt is required. The result of each request is written as a line of JSONL
+with its response or error, token usage and duration, in the order the requests complete.
+With --output, requests whose id already has a successful result in the output file are
+skipped, so an interrupted batch can be resumed by running the same command again.
+
+With --async the requests are submitted to the batch APIs of their providers (OpenAI
+and Anthropic), which cost about half as much but may take up to a day. The command
+polls the batches until they end and then writes their results. The batch IDs are kept
+in the output file name with .batches appended, so an interrupted --async run collects
+the same batches when it is run again instead of submitting new ones.
+`,
+	Args: cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := executeBatch(cmd, args); err != nil {
+			log.Fatal(err)
+		}
+	},
+}
+
+// executeBatch runs the requests of the input file and appends their results
+// to the output file, or writes them to stdout. It fails if any request failed.
+func executeBatch(cmd *cobra.Command, args []string) error {
+	temperature, err := cmd.Flags().GetInt("temperature")
+	if err != nil {
+		return fmt.Errorf("error: getting temperature: %v", err)
+	}
+	output, _ := cmd.Flags().GetString("output")
+	parallel, _ := cmd.Flags().GetInt("parallel")
+	retries, _ := cmd.Flags().GetInt("retries")
+	models := viper.GetStringSlice("model")
+	if len(models) == 0 || models[0] == "" {
+		return fmt.Errorf("error: no model selected")
+	}
+
+	// Read the requests from the file argument or stdin
+	input := io.Reader(os.Stdin)
+	if len(args) == 1 {
+		file, err := os.Open(args[0])
+		if err != nil {
+			return fmt.Errorf("error: opening batch file: %v", err)
+		}
+		defer file.Close()
+		input = file
+	}
+	requests, err := readBatchRequests(input)
+	if err != nil {
+		return fmt.Errorf("error: reading batch file: %v", err)
+	}
+
+	// Skip the requests already answered in the output file
+	out := io.Writer(os.Stdout)
+	done := map[string]bool{}
+	if output != "" {
+		if done, err = readBatchDone(output); err != nil {
+			return fmt.Errorf("error: reading batch output: %v", err)
+		}
+		file, err := openBatchOutput(output)
+		if err != nil {
+			return fmt.Errorf("error: opening batch output: %v", err)
+		}
+		defer file.Close()
+		out = file
+	}
+	pending := pendingBatchRequests(requests, done)
+	fmt.Fprintf(os.Stderr, "Batch       : %d requests, %d already done\n", len(requests), len(requests)-len(pending))
+
+	defaults := batchRequest{Model: models[0], System: queryPrompt}
+	options := sqirvy.Options{
+		Temperature: float32(temperature),
+		Retry:       batchRetryPolicy(retries),
+	}
+
+	start := time.Now()
+	writer := &batchWriter{out: out}
+	if async, _ := cmd.Flags().GetBool("async"); async {
+		poll, _ := cmd.Flags().GetDuration("poll")
+		statePath := ""
+		if output != "" {
+			statePath = output + ".batches"
+		}
+		if err := runBatchAsync(pending, defaults, options, writer, statePath, poll); err != nil {
+			return err
+		}
+	} else {
+		runBatchSync(pending, defaults, options, writer, parallel)
+	}
+
+	fmt.Fprintf(os.Stderr, "Batch       : %d succeeded, %d failed in %s\n",
+		writer.written-writer.failed, writer.failed, time.Since(start).Round(time.Millisecond))
+	if writer.err != nil {
+		return fmt.Errorf("error: writing batch results: %v", writer.err)
+	}
+	if writer.failed > 0 {
+		return fmt.Errorf("error: %d of %d requests failed", writer.failed, writer.written)
+	}
+	return nil
+}
+
+// batchRetryPolicy returns the retry policy of the requests, the default
+// backoff with retries attempts for each request
+func batchRetryPolicy(retries int) sqirvy.RetryPolicy {
+	policy := sqirvy.DefaultRetryPolicy
+	policy.MaxAttempts = retries
+	return policy
+}
+
+// batchWriter writes results as JSONL lines as they complete, counting the
+// failed requests. It is safe for concurrent use.
+type batchWriter struct {
+	mu      sync.Mutex
+	out     io.Writer
+	written int   // Results written
+	failed  int   // Results of failed requests
+	err     error // First error writing a result
+}
+
+// write appends a result to the output and reports a failed request on stderr
+func (w *batchWriter) write(result batchResult) {
+	line, err := json.Marshal(result)
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.written++
+	if result.Error != "" {
+		w.failed++
+		fmt.Fprintf(os.Stderr, "Failed      : %s: %s\n", result.ID, result.Error)
+	}
+	if err == nil && w.err == nil {
+		_, w.err = fmt.Fprintf(w.out, "%s\n", line)
+	}
+}
+
+// runBatchSync executes the requests with at most parallel in flight
+func runBatchSync(requests []batchRequest, defaults batchRequest, options sqirvy.Options, writer *batchWriter, parallel int) {
+	clients := newBatchClients()
+	defer clients.Close()
+
+	queue := make(chan batchRequest)
+	var wg sync.WaitGroup
+	for range max(parallel, 1) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for request := range queue {
+				writer.write(runBatchRequest(clients, request, defaults, options))
+			}
+		}()
+	}
+	for _, request := range requests {
+		queue <- request
+	}
+	close(queue)
+	wg.Wait()
+}
+
+// resolveBatchRequest returns the model, system prompt and options of a
+// request, filling the fields it does not set from defaults and options
+func resolveBatchRequest(request, defaults batchRequest, options sqirvy.Options) (string, string, sqirvy.Options) {
+	model := sqirvy.GetModelAlias(request.Model)
+	if model == "" {
+		model = sqirvy.GetModelAlias(defaults.Model)
+	}
+	system := request.System
+	if system == "" {
+		system = defaults.System
+	}
+	if request.Temperature != nil {
+		options.Temperature = *request.Temperature
+	}
+	options.MaxTokens = sqirvy.GetMaxTokens(model)
+	return model, system, options
+}
+
+// runBatchRequest sends one request to its model
+func runBatchRequest(clients *batchClients, request, defaults batchRequest, options sqirvy.Options) batchResult {
+	model, system, options := resolveBatchRequest(request, defaults, options)
+	result := batchResult{ID: request.ID, Model: model}
+	start := time.Now()
+	response, err := func() (*sqirvy.Response, error) {
+		client, err := clients.get(model)
+		if err != nil {
+			return nil, err
+		}
+		return client.QueryMessages(context.Background(), system, sqirvy.UserMessages([]string{request.Prompt}), model, options, nil)
+	}()
+	result.Duration = time.Since(start).Seconds()
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	result.Response = response.Text
+	result.FinishReason = response.FinishReason
+	result.InputTokens = response.InputTokens
+	result.OutputTokens = response.OutputTokens
+	return result
+}
+
+// runBatchAsync submits the requests to the batch API of each provider, waits
+// for the batches to end and writes their results. Batches recorded in the state
+// file by an interrupted run are collected instead of submitting new ones.
+func runBatchAsync(requests []batchRequest, defaults batchRequest, options sqirvy.Options, writer *batchWriter, statePath string, poll time.Duration) error {
+	ctx := context.Background()
+
+	// Group the requests by the provider of their model
+	models := make(map[string]string, len(requests))
+	groups := map[string][]sqirvy.BatchRequest{}
+	for _, request := range requests {
+		model, system, options := resolveBatchRequest(request, defaults, options)
+		provider, err := sqirvy.GetProviderName(model)
+		if err != nil {
+			return fmt.Errorf("error: request %s: %v", request.ID, err)
+		}
+		models[request.ID] = model
+		groups[provider] = append(groups[provider], sqirvy.BatchRequest{
+			ID:       request.ID,
+			Model:    model,
+			System:   system,
+			Messages: sqirvy.UserMessages([]string{request.Prompt}),
+			Options:  options,
+		})
+	}
+
+	batches, err := readBatchState(statePath)
+	if err != nil {
+		return fmt.Errorf("error: reading batch state: %v", err)
+	}
+	resumed := len(batches) > 0
+	if !resumed {
+		for provider := range groups {
+			batches[provider] = ""
+		}
+	}
+
+	// Every provider must have a batch API before anything is submitted
+	clients := map[string]sqirvy.BatchClient{}
+	for provider := range batches {
+		client, err := sqirvy.NewClient(provider)
+		if err != nil {
+			return fmt.Errorf("error: creating client for provider %s: %v", provider, err)
+		}
+		defer client.Close()
+		batcher, ok := client.(sqirvy.BatchClient)
+		if !ok {
+			return fmt.Errorf("error: provider %s has no batch API, run without --async", provider)
+		}
+		clients[provider] = batcher
+	}
+	providers := slices.Sorted(maps.Keys(batches))
+
+	if resumed {
+		fmt.Fprintf(os.Stderr, "Batch       : collecting the batches of an earlier run from %s\n", statePath)
+	} else {
+		// Record each batch as soon as it is submitted, so an interrupted run
+		// collects it instead of paying for it twice
+		for _, provider := range providers {
+			status, err := clients[provider].SubmitBatch(ctx, groups[provider])
+			if err != nil {
+				return fmt.Errorf("error: submitting %s batch: %v", provider, err)
+			}
+			batches[provider] = status.ID
+			if err := writeBatchState(statePath, batches); err != nil {
+				return fmt.Errorf("error: writing batch state: %v", err)
+			}
+			fmt.Fprintf(os.Stderr, "Submitted   : %s batch %s of %d requests\n", provider, status.ID, len(groups[provider]))
+		}
+	}
+
+	// Wait for each batch and write its results
+	collected := map[string]bool{}
+	for _, provider := range providers {
+		id := batches[provider]
+		if id == "" {
+			continue
+		}
+		_, err := sqirvy.WaitBatch(ctx, clients[provider], id, poll, func(status *sqirvy.BatchStatus) {
+			fmt.Fprintln(os.Stderr, "Status      :", status)
+		})
+		if err != nil {
+			return fmt.Errorf("error: waiting for %s batch %s: %v", provider, id, err)
+		}
+		results, err := clients[provider].BatchResults(ctx, id)
+		if err != nil {
+			return fmt.Errorf("error: collecting %s batch %s: %v", provider, id, err)
+		}
+		for _, result := range results {
+			collected[result.ID] = true
+			writer.write(asyncBatchResult(result, models[result.ID]))
+		}
+
+		// a request the provider did not process has no result
+		if !resumed {
+			for _, request := range groups[provider] {
+				if !collected[request.ID] {
+					writer.write(batchResult{ID: request.ID, Model: request.Model, Error: fmt.Sprintf("no result in %s batch %s", provider, id)})
+				}
+			}
+		}
+	}
+
+	if statePath != "" {
+		os.Remove(statePath)
+	}
+	if resumed {
+		if left := len(requests) - len(collected); left > 0 {
+			fmt.Fprintf(os.Stderr, "Batch       : %d requests were not part of the collected batches, run again to submit them\n", left)
+		}
+	}
+	return nil
+}
+
+// asyncBatchResult converts the result of a provider batch into a batchResult
+func asyncBatchResult(result sqirvy.BatchResult, model string) batchResult {
+	converted := batchResult{ID: result.ID, Model: model}
+	if result.Err != nil {
+		converted.Error = result.Err.Error()
+		return converted
+	}
+	if converted.Model == "" {
+		converted.Model = result.Response.Model
+	}
+	converted.Response = result.Response.Text
+	converted.FinishReason = result.Response.FinishReason
+	converted.InputTokens = result.Response.InputTokens
+	converted.OutputTokens = result.Response.OutputTokens
+	return converted
+}
+
+// readBatchState returns the batch ID of each provider recorded in a state
+// file, an empty map if there is no state file
+func readBatchState(path string) (map[string]string, error) {
+	batches := map[string]string{}
+	if path == "" {
+		return batches, nil
+	}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return batches, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &batches); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return batches, nil
+}
+
+// writeBatchState records the submitted batches in a state file
+func writeBatchState(path string, batches map[string]string) error {
+	if path == "" {
+		return nil
+	}
+	submitted := map[string]string{}
+	for provider, id := range batches {
+		if id != "" {
+			submitted[provider] = id
+		}
+	}
+	data, err := json.MarshalIndent(submitted, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+// readBatchRequests reads the requests of a JSONL input, skipping blank lines.
+// A request without an id is identified by its line number.
+func readBatchRequests(r io.Reader) ([]batchRequest, error) {
+	var requests []batchRequest
+	ids := map[string]bool{}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, maxBatchLine)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var request batchRequest
+		if err := json.Unmarshal(scanner.Bytes(), &request); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		if request.Prompt == "" {
+			return nil, fmt.Errorf("line %d: prompt is required", line)
+		}
+		if request.ID == "" {
+			request.ID = strconv.Itoa(line)
+		}
+		if ids[request.ID] {
+			return nil, fmt.Errorf("line %d: duplicate id %s", line, request.ID)
+		}
+		ids[request.ID] = true
+		requests = append(requests, request)
+	}
+	return requests, scanner.Err()
+}
+
+// readBatchDone returns the ids with a successful result in an output file,
+// an empty set if the file does not exist. Lines that cannot be decoded, such
+// as a line cut short by an interrupted batch, are ignored.
+func readBatchDone(path string) (map[string]bool, error) {
+	done := map[string]bool{}
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return done, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(nil, maxBatchLine)
+	for scanner.Scan() {
+		var result batchResult
+		if json.Unmarshal(scanner.Bytes(), &result) == nil && result.Error == "" {
+			done[result.ID] = true
+		}
+	}
+	return done, scanner.Err()
+}
+
+// pendingBatchRequests returns the requests whose id is not done
+func pendingBatchRequests(requests []batchRequest, done map[string]bool) []batchRequest {
+	var pending []batchRequest
+	for _, request := range requests {
+		if !done[request.ID] {
+			pending = append(pending, request)
+		}
+	}
+	return pending
+}
+
+// openBatchOutput opens an output file to append results, ending a last line
+// cut short by an interrupted batch so the next result starts on its own line
+func openBatchOutput(path string) (*os.File, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+	info, err := file.Stat()
+	if err == nil && info.Size() > 0 {
+		last := make([]byte, 1)
+		if _, err = file.ReadAt(last, info.Size()-1); err == nil && last[0] != '\n' {
+			_, err = file.Write([]byte("\n"))
+		}
+	}
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return file, nil
+}
+
+// batchClients creates one client per provider on first use and shares it
+// between the requests of a batch
+type batchClients struct {
+	mu      sync.Mutex
+	clients map[string]sqirvy.Client
+}
+
+func newBatchClients() *batchClients {
+	return &batchClients{clients: make(map[string]sqirvy.Client)}
+}
+
+// get returns the client for the provider of model
+func (b *batchClients) get(model string) (sqirvy.Client, error) {
+	provider, err := sqirvy.GetProviderName(model)
+	if err != nil {
+		return nil, err
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if client, ok := b.clients[provider]; ok {
+		return client, nil
+	}
+	client, err := sqirvy.NewClient(provider, sqirvy.WithTimeout(viper.GetDuration("timeout")))
+	if err != nil {
+		return nil, err
+	}
+	b.clients[provider] = client
+	return client, nil
+}
+
+// Close closes the client of every provider
+func (b *batchClients) Close() {
+	for _, client := range b.clients {
+		client.Close()
+	}
+}
+
+func batchUsage(cmd *cobra.Command) error {
+	fmt.Println("Usage: sqirvy-cli batch [flags] [requests.jsonl] [-o results.jsonl]")
+	return nil
+}
+
+func init() {
+	rootCmd.AddCommand(batchCmd)
+	batchCmd.Flags().StringP("output", "o", "", "append results to this JSONL file and skip requests it already answered (default stdout)")
+	batchCmd.Flags().Int("parallel", sqirvy.DefaultCompareParallel, "number of requests in flight at once")
+	batchCmd.Flags().Int("retries", sqirvy.DefaultRetryPolicy.MaxAttempts, "attempts for each request, including the first")
+	batchCmd.Flags().Bool("async", false, "submit the requests to the discounted batch APIs of their providers and wait for the results")
+	batchCmd.Flags().Duration("poll", sqirvy.DefaultBatchPollInterval, "time between status checks of --async batches")
+	batchCmd.SetUsageFunc(batchUsage)
+}
@@ -0,0 +1,205 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	sqirvy "sqirvy-ai/pkg/sqirvy"
+)
+
+func TestBatchRetryPolicy(t *testing.T) {
+	policy := batchRetryPolicy(2)
+	if policy.MaxAttempts != 2 {
+		t.Errorf("batchRetryPolicy(2).MaxAttempts = %d, want 2", policy.MaxAttempts)
+	}
+	// retries back off and honor Retry-After like the default policy
+	if policy.BaseDelay != sqirvy.DefaultRetryPolicy.BaseDelay || policy.MaxDelay != sqirvy.DefaultRetryPolicy.MaxDelay {
+		t.Errorf("batchRetryPolicy(2) = %+v, want the delays of %+v", policy, sqirvy.DefaultRetryPolicy)
+	}
+	if policy.BaseDelay <= 0 || policy.MaxDelay <= 0 {
+		t.Errorf("batchRetryPolicy(2) = %+v, want non-zero delays", policy)
+	}
+}
+
+func TestReadBatchRequests(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		wantIDs []string
+		wantErr string
+	}{
+		{
+			name:    "ids default to the line number",
+			input:   `{"id":"q1","prompt":"a"}` + "\n\n" + `{"prompt":"b"}` + "\n",
+			wantIDs: []string{"q1", "3"},
+		},
+		{
+			name:    "duplicate ids are rejected",
+			input:   `{"id":"q1","prompt":"a"}` + "\n" + `{"id":"q1","prompt":"b"}` + "\n",
+			wantErr: "line 2: duplicate id q1",
+		},
+		{
+			name:    "a default id can be a duplicate",
+			input:   `{"id":"2","prompt":"a"}` + "\n" + `{"prompt":"b"}` + "\n",
+			wantErr: "line 2: duplicate id 2",
+		},
+		{
+			name:    "a missing prompt is rejected",
+			input:   `{"id":"q1","model":"gpt-4o"}` + "\n",
+			wantErr: "line 1: prompt is required",
+		},
+		{
+			name:    "invalid json is rejected",
+			input:   `{"id":"q1",` + "\n",
+			wantErr: "line 1:",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			requests, err := readBatchRequests(strings.NewReader(tt.input))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("readBatchRequests() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("readBatchRequests() error = %v", err)
+			}
+			var ids []string
+			for _, request := range requests {
+				ids = append(ids, request.ID)
+			}
+			if !reflect.DeepEqual(ids, tt.wantIDs) {
+				t.Errorf("readBatchRequests() ids = %q, want %q", ids, tt.wantIDs)
+			}
+		})
+	}
+}
+
+func TestReadBatchDone(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "results.jsonl")
+
+	// a missing output file has nothing done
+	done, err := readBatchDone(path)
+	if err != nil || len(done) != 0 {
+		t.Fatalf("readBatchDone() of a missing file = %v, %v", done, err)
+	}
+
+	output := `{"id":"ok","model":"mock-echo","response":"hi"}` + "\n" +
+		`{"id":"failed","model":"mock-echo","error":"overloaded"}` + "\n" +
+		`{"id":"cut","model":"mock-echo","resp`
+	if err := os.WriteFile(path, []byte(output), 0644); err != nil {
+		t.Fatal(err)
+	}
+	done, err = readBatchDone(path)
+	if err != nil {
+		t.Fatalf("readBatchDone() error = %v", err)
+	}
+	// failed requests are retried, and a truncated last line is ignored
+	if !reflect.DeepEqual(done, map[string]bool{"ok": true}) {
+		t.Errorf("readBatchDone() = %v, want only ok", done)
+	}
+}
+
+// readBatchResults decodes the results of an output file sorted by id
+func readBatchResults(t *testing.T, data []byte) []batchResult {
+	t.Helper()
+	var results []batchResult
+	for _, line := range strings.Split(strings.TrimSuffix(string(data), "\n"), "\n") {
+		var result batchResult
+		if err := json.Unmarshal([]byte(line), &result); err != nil {
+			t.Fatalf("invalid result line %q: %v", line, err)
+		}
+		results = append(results, result)
+	}
+	sort.Slice(results, func(i, j int) bool { return results[i].ID < results[j].ID })
+	return results
+}
+
+func TestRunBatchSync(t *testing.T) {
+	dir := t.TempDir()
+	script := filepath.Join(dir, "script.yaml")
+	if err := os.WriteFile(script, []byte("responses:\n  - match: fail\n    status: 400\n    error: bad request\n  - match: hello\n    text: scripted\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("MOCK_SCRIPT", script)
+
+	requests, err := readBatchRequests(strings.NewReader(
+		`{"id":"a","prompt":"hello"}` + "\n" +
+			`{"id":"b","model":"mock-echo","prompt":"echo me"}` + "\n" +
+			`{"id":"c","prompt":"fail"}` + "\n"))
+	if err != nil {
+		t.Fatalf("readBatchRequests() error = %v", err)
+	}
+	defaults := batchRequest{Model: sqirvy.MockScriptModel, System: queryPrompt}
+	options := sqirvy.Options{Retry: sqirvy.RetryPolicy{MaxAttempts: 1}}
+
+	var out bytes.Buffer
+	writer := &batchWriter{out: &out}
+	runBatchSync(requests, defaults, options, writer, 2)
+	if writer.written != 3 || writer.failed != 1 || writer.err != nil {
+		t.Fatalf("runBatchSync() wrote %d results, %d failed, error %v", writer.written, writer.failed, writer.err)
+	}
+	results := readBatchResults(t, out.Bytes())
+	if results[0].ID != "a" || results[0].Model != sqirvy.MockScriptModel || results[0].Response != "scripted" || results[0].FinishReason == "" {
+		t.Errorf("result a = %+v, want scripted from mock-script", results[0])
+	}
+	if results[1].ID != "b" || results[1].Model != sqirvy.MockEchoModel || results[1].Response != "echo me" || results[1].InputTokens == 0 {
+		t.Errorf("result b = %+v, want echo me from mock-echo", results[1])
+	}
+	if results[2].ID != "c" || results[2].Error == "" || results[2].Response != "" {
+		t.Errorf("result c = %+v, want an error", results[2])
+	}
+
+	// an interrupted output file is resumed with the failed and missing
+	// requests only, the next result starting on a line of its own
+	path := filepath.Join(dir, "results.jsonl")
+	lines := strings.SplitAfter(out.String(), "\n")
+	var kept []string
+	for _, line := range lines {
+		if strings.Contains(line, `"id":"a"`) || strings.Contains(line, `"id":"c"`) {
+			kept = append(kept, line)
+		}
+	}
+	if err := os.WriteFile(path, []byte(strings.Join(kept, "")+`{"id":"b","mod`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	done, err := readBatchDone(path)
+	if err != nil {
+		t.Fatalf("readBatchDone() error = %v", err)
+	}
+	pending := pendingBatchRequests(requests, done)
+	if len(pending) != 2 || pending[0].ID != "b" || pending[1].ID != "c" {
+		t.Fatalf("pendingBatchRequests() = %+v, want b and c", pending)
+	}
+
+	file, err := openBatchOutput(path)
+	if err != nil {
+		t.Fatalf("openBatchOutput() error = %v", err)
+	}
+	writer = &batchWriter{out: file}
+	runBatchSync(pending, defaults, options, writer, 2)
+	file.Close()
+	if writer.written != 2 || writer.failed != 1 {
+		t.Errorf("resumed runBatchSync() wrote %d results, %d failed", writer.written, writer.failed)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	done, err = readBatchDone(path)
+	if err != nil || !reflect.DeepEqual(done, map[string]bool{"a": true, "b": true}) {
+		t.Errorf("readBatchDone() after the resume = %v, %v, want a and b\n%s", done, err, data)
+	}
+	if n := strings.Count(string(data), "\n"); n != 5 {
+		t.Errorf("output has %d lines, want 5\n%s", n, data)
+	}
+}
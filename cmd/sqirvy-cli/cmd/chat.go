@@ -0,0 +1,294 @@
+/*
+Copyright © 2025 NAME HERE <EMAIL ADDRESS>
+*/
+package cmd
+
+import (
+	"bufio"
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+
+	sqirvy "sqirvy-ai/pkg/sqirvy"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+const chatHelp = `Commands:
+  /model <name>        switch to another model or alias
+  /temperature <n>     set the temperature (0..100)
+  /attach <file|url>   add the content of files or urls to the conversation
+  /save <file>         save the transcript to a file
+  /load <file>         load a transcript from a file
+  /clear               start a new conversation
+  /help                show this help
+  /exit                end the session
+`
+
+// chatCmd represents the chat command
+var chatCmd = &cobra.Command{
+	Use:   "chat",
+	Short: "Start an interactive conversation with the LLM",
+	Long: `sqirvy-cli chat starts an interactive session in the terminal.
+Each line you enter is sent to the LLM along with the conversation so far,
+and the reply is printed as it is generated. Lines starting with / are
+commands; enter /help to list them. Any filename or url arguments are
+attached to the conversation before the first message.
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+		temperature, err := cmd.Flags().GetInt("temperature")
+		if err != nil {
+			log.Fatalf("error: getting temperature: %v", err)
+		}
+
+		session := &chatSession{temperature: temperature}
+		defer session.close()
+
+		if err := session.setModel(viper.GetStringSlice("model")...); err != nil {
+			log.Fatal(err)
+		}
+		if transcript, _ := cmd.Flags().GetString("load"); transcript != "" {
+			if err := session.load(transcript); err != nil {
+				log.Fatal(err)
+			}
+		}
+		if len(args) > 0 {
+			if err := session.attach(args); err != nil {
+				log.Fatal(err)
+			}
+		}
+
+		if err := session.run(os.Stdin, os.Stdout); err != nil {
+			log.Fatal(err)
+		}
+	},
+}
+
+// chatTranscript is the file format used by /save and /load
+type chatTranscript struct {
+	Model       string           `json:"model"`
+	Temperature int              `json:"temperature"`
+	Messages    []sqirvy.Message `json:"messages"`
+}
+
+// chatSession holds the state of an interactive chat
+type chatSession struct {
+	client      sqirvy.Client
+	model       string   // first model of the session
+	models      []string // model and its fallback models
+	answered    string   // fallback model that answered the last query, empty if model did
+	temperature int
+	messages    []sqirvy.Message
+}
+
+// run reads lines from in until EOF or /exit, sending each message to the model
+// and writing the streamed reply to out. Prompts and status go to stderr.
+func (s *chatSession) run(in io.Reader, out io.Writer) error {
+	scanner := bufio.NewScanner(in)
+	scanner.Buffer(make([]byte, 0, 64*1024), MaxChatLineBytes)
+
+	fmt.Fprintln(os.Stderr, "Enter /help for commands, /exit to quit")
+	for {
+		fmt.Fprint(os.Stderr, "> ")
+		if !scanner.Scan() {
+			break
+		}
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		if strings.HasPrefix(line, "/") {
+			quit, err := s.command(line)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+			}
+			if quit {
+				return nil
+			}
+			continue
+		}
+
+		if err := s.send(line, out); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+		}
+	}
+	fmt.Fprintln(os.Stderr)
+
+	return scanner.Err()
+}
+
+// send adds a user message to the conversation and streams the model's reply to out.
+// If the query fails the user message is removed so it can be retried.
+func (s *chatSession) send(text string, out io.Writer) error {
+	s.messages = append(s.messages, sqirvy.Message{Role: sqirvy.RoleUser, Content: text})
+
+	// the fallback client lowers the tokens to the limit of each model
+	options := sqirvy.Options{Temperature: float32(s.temperature), MaxTokens: maxOutputTokens(s.models)}
+	s.answered = ""
+	var wrote bool
+	reply, err := s.client.QueryMessages(context.Background(), queryPrompt, s.messages, s.model, options, func(chunk string) error {
+		wrote = true
+		_, err := fmt.Fprint(out, chunk)
+		return err
+	})
+	if wrote {
+		fmt.Fprintln(out)
+	}
+	if err != nil {
+		s.messages = s.messages[:len(s.messages)-1]
+		return fmt.Errorf("error: querying model %s: %v", cmp.Or(s.answered, s.model), err)
+	}
+	printUsage(cmp.Or(s.answered, s.model), reply)
+
+	s.messages = append(s.messages, sqirvy.Message{Role: sqirvy.RoleAssistant, Content: reply.Text})
+	return nil
+}
+
+// command executes a slash command. It returns true if the session should end.
+func (s *chatSession) command(line string) (bool, error) {
+	fields := strings.Fields(line)
+	name, args := fields[0], fields[1:]
+
+	switch name {
+	case "/exit", "/quit":
+		return true, nil
+	case "/help":
+		fmt.Fprint(os.Stderr, chatHelp)
+	case "/model":
+		if len(args) != 1 {
+			return false, fmt.Errorf("usage: /model <name>")
+		}
+		return false, s.setModel(args[0])
+	case "/temperature":
+		if len(args) != 1 {
+			return false, fmt.Errorf("usage: /temperature <0..100>")
+		}
+		temperature, err := strconv.Atoi(args[0])
+		if err != nil || temperature < sqirvy.MinTemperature || temperature > sqirvy.MaxTemperature {
+			return false, fmt.Errorf("error: temperature must be between %d and %d", int(sqirvy.MinTemperature), int(sqirvy.MaxTemperature))
+		}
+		s.temperature = temperature
+		fmt.Fprintln(os.Stderr, "Using temperature :", s.temperature)
+	case "/attach":
+		if len(args) == 0 {
+			return false, fmt.Errorf("usage: /attach <file|url> ...")
+		}
+		return false, s.attach(args)
+	case "/save":
+		if len(args) != 1 {
+			return false, fmt.Errorf("usage: /save <file>")
+		}
+		return false, s.save(args[0])
+	case "/load":
+		if len(args) != 1 {
+			return false, fmt.Errorf("usage: /load <file>")
+		}
+		return false, s.load(args[0])
+	case "/clear":
+		s.messages = nil
+		fmt.Fprintln(os.Stderr, "Conversation cleared")
+	default:
+		return false, fmt.Errorf("unknown command %s, enter /help for commands", name)
+	}
+	return false, nil
+}
+
+// setModel switches the session to a new model and its fallback models,
+// replacing the client
+func (s *chatSession) setModel(models ...string) error {
+	client, model, err := newModelClient(func(next string) { s.answered = next }, models...)
+	if err != nil {
+		return err
+	}
+	s.close()
+	s.client = client
+	s.model = model
+	s.models = models
+	return nil
+}
+
+// attach reads files or urls and adds their content to the conversation as
+// user messages, within the context window the conversation has left
+func (s *chatSession) attach(args []string) error {
+	budget, err := newInputBudget(queryPrompt, s.models...)
+	if err != nil {
+		return err
+	}
+	for _, message := range s.messages {
+		budget.tokens -= sqirvy.EstimateTokens(budget.provider, message.Content)
+	}
+	content, err := ReadArgs(args, budget)
+	if err != nil {
+		return err
+	}
+	for _, c := range content {
+		s.messages = append(s.messages, sqirvy.Message{Role: sqirvy.RoleUser, Content: c})
+	}
+	fmt.Fprintf(os.Stderr, "Attached %d of %d inputs\n", len(content), len(args))
+	return nil
+}
+
+// save writes the conversation to a transcript file
+func (s *chatSession) save(fname string) error {
+	data, err := json.MarshalIndent(chatTranscript{
+		Model:       s.model,
+		Temperature: s.temperature,
+		Messages:    s.messages,
+	}, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error: encoding transcript: %v", err)
+	}
+	if err := os.WriteFile(fname, data, 0644); err != nil {
+		return fmt.Errorf("error: saving transcript: %v", err)
+	}
+	fmt.Fprintf(os.Stderr, "Saved %d messages to %s\n", len(s.messages), fname)
+	return nil
+}
+
+// load replaces the conversation with one read from a transcript file,
+// switching to the model and temperature it was saved with
+func (s *chatSession) load(fname string) error {
+	data, err := os.ReadFile(fname)
+	if err != nil {
+		return fmt.Errorf("error: loading transcript: %v", err)
+	}
+	var transcript chatTranscript
+	if err := json.Unmarshal(data, &transcript); err != nil {
+		return fmt.Errorf("error: decoding transcript %s: %v", fname, err)
+	}
+	if transcript.Model != "" && transcript.Model != s.model {
+		if err := s.setModel(transcript.Model); err != nil {
+			return err
+		}
+	}
+	s.temperature = transcript.Temperature
+	s.messages = transcript.Messages
+	fmt.Fprintf(os.Stderr, "Loaded %d messages from %s\n", len(s.messages), fname)
+	return nil
+}
+
+// close releases the session's client
+func (s *chatSession) close() {
+	if s.client != nil {
+		s.client.Close()
+	}
+}
+
+func chatUsage(cmd *cobra.Command) error {
+	fmt.Println("Usage: sqirvy-cli chat [flags] [files| urls]")
+	return nil
+}
+
+func init() {
+	rootCmd.AddCommand(chatCmd)
+	chatCmd.Flags().String("load", "", "load a transcript saved with /save")
+	chatCmd.SetUsageFunc(chatUsage)
+}
@@ -20,7 +20,7 @@ and will output the results to stdout.
 The prompt is constructed in this order:
 	An internal system prompt for code generation
 	Input from stdin
-	Any number of filename or url arguments	
+	Any number of file, directory, glob or url arguments	
 	`,
 	Run: func(cmd *cobra.Command, args []string) {
 		response, err := executeQuery(cmd, codePrompt, args)
@@ -34,11 +34,12 @@ The prompt is constructed in this order:
 }
 
 func codeUsage(cmd *cobra.Command) error {
-	fmt.Println("Usage: stdin | sqirvy-cli code [flags] [files| urls]")
+	fmt.Println("Usage: stdin | sqirvy-cli code [flags] [files| dirs| globs| urls]")
 	return nil
 }
 
 func init() {
 	rootCmd.AddCommand(codeCmd)
+	addChunkFlags(codeCmd)
 	codeCmd.SetUsageFunc(codeUsage)
 }
@@ -0,0 +1,211 @@
+/*
+Copyright © 2025 NAME HERE <EMAIL ADDRESS>
+*/
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	sqirvy "sqirvy-ai/pkg/sqirvy"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// defaultCompareWidth is the width of side by side output when the
+// terminal width is not known from the COLUMNS environment variable
+const defaultCompareWidth = 160
+
+// compareCmd represents the compare command
+var compareCmd = &cobra.Command{
+	Use:   "compare",
+	Short: "Send the same query to several models and compare the responses",
+	Long: `sqirvy-cli compare will send the same query to every model given with --model,
+querying several models at once, and print the response of each model in its own section,
+or side by side in columns with --side-by-side. The prompt is read from stdin and any
+filename or url arguments, as for the query command. A model that fails does not stop the others.
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+		response, err := executeCompare(cmd, queryPrompt, args)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Print(response)
+	},
+}
+
+// executeCompare sends the prompt read from args to every model of the
+// model flag concurrently and formats their responses for stdout.
+// It fails only if no model could answer.
+func executeCompare(cmd *cobra.Command, system string, args []string) (string, error) {
+	models := viper.GetStringSlice("model")
+	if len(models) < 2 {
+		return "", fmt.Errorf("error: compare needs at least two models, repeat --model to add them")
+	}
+
+	temperature, err := cmd.Flags().GetInt("temperature")
+	if err != nil {
+		return "", fmt.Errorf("error: getting temperature: %v", err)
+	}
+	parallel, _ := cmd.Flags().GetInt("parallel")
+	sideBySide, _ := cmd.Flags().GetBool("side-by-side")
+	width, _ := cmd.Flags().GetInt("width")
+
+	budget, err := newInputBudget(system, models...)
+	if err != nil {
+		return "", err
+	}
+	prompts, err := ReadPrompt(args, budget)
+	if err != nil {
+		return "", fmt.Errorf("error: reading prompt: %v", err)
+	}
+
+	fmt.Fprintln(os.Stderr, "Comparing   :", strings.Join(models, ", "))
+	// the output limit of each model, as reserved by the budget, is applied by Compare
+	results := sqirvy.Compare(context.Background(), sqirvy.CompareQuery{
+		Models:   models,
+		System:   system,
+		Messages: sqirvy.UserMessages(prompts),
+		Options:  sqirvy.Options{Temperature: float32(temperature), MaxTokens: maxOutputTokens(models)},
+		Parallel: parallel,
+	}, sqirvy.WithTimeout(viper.GetDuration("timeout")))
+
+	failed := 0
+	for _, result := range results {
+		if result.Err != nil {
+			failed++
+		}
+	}
+	if failed == len(results) {
+		return "", fmt.Errorf("error: all %d models failed, first error: %v", failed, results[0].Err)
+	}
+
+	if sideBySide {
+		if width <= 0 {
+			width, _ = strconv.Atoi(os.Getenv("COLUMNS"))
+		}
+		if width <= 0 {
+			width = defaultCompareWidth
+		}
+		return formatColumns(results, width), nil
+	}
+	return formatSections(results), nil
+}
+
+// compareSummary describes the outcome of a model: its duration and either
+// its token usage and cost, or that it failed
+func compareSummary(result sqirvy.CompareResult) string {
+	duration := result.Duration.Round(10 * time.Millisecond)
+	if result.Err != nil {
+		return fmt.Sprintf("failed after %s", duration)
+	}
+	summary := fmt.Sprintf("%s, %d input tokens, %d output tokens",
+		duration, result.Response.InputTokens, result.Response.OutputTokens)
+	if cost, ok := sqirvy.GetCost(result.Model, result.Response.InputTokens, result.Response.OutputTokens); ok {
+		summary += fmt.Sprintf(", $%.6f", cost)
+	}
+	return summary
+}
+
+// compareText returns the response text of a model, or its error
+func compareText(result sqirvy.CompareResult) string {
+	if result.Err != nil {
+		return fmt.Sprintf("error: %v", result.Err)
+	}
+	return strings.TrimSpace(result.Response.Text)
+}
+
+// formatSections prints each response under a header naming its model
+func formatSections(results []sqirvy.CompareResult) string {
+	var b strings.Builder
+	for i, result := range results {
+		if i > 0 {
+			b.WriteString("\n")
+		}
+		fmt.Fprintf(&b, "==== %s (%s) ====\n%s\n", result.Model, compareSummary(result), compareText(result))
+	}
+	return b.String()
+}
+
+// formatColumns prints the responses side by side in columns that share width,
+// wrapping their text to fit
+func formatColumns(results []sqirvy.CompareResult, width int) string {
+	const separator = " | "
+	columnWidth := max((width-len(separator)*(len(results)-1))/len(results), 10)
+
+	columns := make([][]string, len(results))
+	rows := 0
+	for i, result := range results {
+		columns[i] = append(wrapText(result.Model, columnWidth), wrapText(compareSummary(result), columnWidth)...)
+		columns[i] = append(columns[i], strings.Repeat("-", columnWidth))
+		columns[i] = append(columns[i], wrapText(compareText(result), columnWidth)...)
+		rows = max(rows, len(columns[i]))
+	}
+
+	var b strings.Builder
+	for row := 0; row < rows; row++ {
+		var cells []string
+		for _, column := range columns {
+			cell := ""
+			if row < len(column) {
+				cell = column[row]
+			}
+			cells = append(cells, cell+strings.Repeat(" ", columnWidth-utf8.RuneCountInString(cell)))
+		}
+		b.WriteString(strings.TrimRight(strings.Join(cells, separator), " "))
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+// wrapText splits text into lines of at most width characters, breaking
+// lines between words where possible
+func wrapText(text string, width int) []string {
+	var lines []string
+	for _, line := range strings.Split(strings.ReplaceAll(text, "\t", "    "), "\n") {
+		current := ""
+		for _, word := range strings.Fields(line) {
+			// split words longer than a line
+			for utf8.RuneCountInString(word) > width {
+				if current != "" {
+					lines = append(lines, current)
+					current = ""
+				}
+				runes := []rune(word)
+				lines = append(lines, string(runes[:width]))
+				word = string(runes[width:])
+			}
+			switch {
+			case current == "":
+				current = word
+			case utf8.RuneCountInString(current)+1+utf8.RuneCountInString(word) <= width:
+				current += " " + word
+			default:
+				lines = append(lines, current)
+				current = word
+			}
+		}
+		lines = append(lines, current)
+	}
+	return lines
+}
+
+func compareUsage(cmd *cobra.Command) error {
+	fmt.Println("Usage: stdin | sqirvy-cli compare -m model -m model [flags] [files| dirs| globs| urls]")
+	return nil
+}
+
+func init() {
+	rootCmd.AddCommand(compareCmd)
+	compareCmd.Flags().Bool("side-by-side", false, "print the responses in columns instead of sections")
+	compareCmd.Flags().Int("width", 0, "width of side by side output (default $COLUMNS or 160)")
+	compareCmd.Flags().Int("parallel", sqirvy.DefaultCompareParallel, "number of models queried at once")
+	compareCmd.SetUsageFunc(compareUsage)
+}
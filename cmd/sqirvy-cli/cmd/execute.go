@@ -4,10 +4,13 @@
 package cmd
 
 import (
+	"cmp"
 	"context"
 	_ "embed"
 	"fmt"
 	"os"
+	"strings"
+	"time"
 
 	sqirvy "sqirvy-ai/pkg/sqirvy"
 
@@ -23,18 +26,15 @@ import (
 //   - sysprompt: The system prompt to provide context to the AI model
 //   - args: Additional arguments to be processed as part of the query
 //
+// If the stream flag is set, the response is written to stdout as it is generated
+// and the returned response text is empty.
+//
 // Returns:
 //   - string: The model's response text
 //   - error: Any error encountered during execution
 func executeQuery(cmd *cobra.Command, system string, args []string) (string, error) {
-	// Extract model name from command flags
-	model := viper.GetString("model")
-
-	// check if it has an alias
-	model = sqirvy.GetModelAlias(model)
-
-	// Print the selected model to stderr
-	fmt.Fprintln(os.Stderr, "Using model :", model)
+	// Extract the model and any fallback models from command flags
+	models := viper.GetStringSlice("model")
 
 	// Extract temperature setting from command flags
 	temperature, err := cmd.Flags().GetInt("temperature")
@@ -42,32 +42,245 @@ func executeQuery(cmd *cobra.Command, system string, args []string) (string, err
 		return "", fmt.Errorf("error: getting temperature: %v", err)
 	}
 
-	// Process system prompt and arguments into query prompts
-	prompts, err := ReadPrompt(args)
-	if err != nil {
-		return "", fmt.Errorf("error: reading prompt:[]string{\n%v", err)
+	// Split input that may not fit the context window into chunks
+	if chunk, _ := cmd.Flags().GetBool("chunk"); chunk {
+		documents, err := readDocuments(args)
+		if err != nil {
+			return "", err
+		}
+		if len(documents) == 0 {
+			return "", fmt.Errorf("error: --chunk needs input from stdin, files or urls")
+		}
+		return executeChunked(cmd, system, "", documents, models, temperature)
 	}
 
-	// Determine the AI provider based on the selected model
-	provider, err := sqirvy.GetProviderName(model)
+	// Process arguments into query prompts that fit the context window of every model
+	budget, err := newInputBudget(system, models...)
 	if err != nil {
-		return "", fmt.Errorf("error: model is not supported %s: %v", model, err)
+		return "", err
+	}
+	prompts, err := ReadPrompt(args, budget)
+	if err != nil {
+		return "", fmt.Errorf("error: reading prompt:[]string{\n%v", err)
 	}
 
-	// Create client for the provider
-	client, err := sqirvy.NewClient(provider)
+	return queryModels(system, prompts, models, temperature)
+}
+
+// queryModels sends prompts to the first of models, falling back to the
+// others, and prints the token usage to stderr. If the stream flag is set the
+// response is written to stdout as it is generated and the returned text is empty.
+func queryModels(system string, prompts []string, models []string, temperature int) (string, error) {
+	// Resolve the models and create a client for their providers,
+	// noting the model that answers if the query falls back
+	answered := ""
+	client, model, err := newModelClient(func(next string) { answered = next }, models...)
 	if err != nil {
-		return "", fmt.Errorf("error: creating client for provider %s: %v", provider, err)
+		return "", err
 	}
 	defer client.Close()
+	cached := false
+	client = cacheClient(client, func() { cached = true })
 
 	// Configure query options and execute the query
 	options := sqirvy.Options{Temperature: float32(temperature), MaxTokens: sqirvy.GetMaxTokens(model)}
 	ctx := context.Background()
-	response, err := client.QueryText(ctx, system, prompts, model, options)
+	messages := sqirvy.UserMessages(prompts)
+	if viper.GetBool("stream") {
+		response, err := client.QueryMessages(ctx, system, messages, model, options, func(chunk string) error {
+			_, err := fmt.Print(chunk)
+			return err
+		})
+		if err != nil {
+			return "", fmt.Errorf("error: streaming model %s: %v", model, err)
+		}
+		if cached {
+			answered = cachedModel(models, response)
+		}
+		printUsage(cmp.Or(answered, model), response)
+		return "", nil
+	}
+
+	response, err := client.QueryMessages(ctx, system, messages, model, options, nil)
 	if err != nil {
 		return "", fmt.Errorf("error: querying model %s: %v", model, err)
 	}
+	if cached {
+		answered = cachedModel(models, response)
+	}
+	printUsage(cmp.Or(answered, model), response)
 
-	return response, nil
+	return response.Text, nil
+}
+
+// executeChunked answers a query whose input may not fit the context window,
+// splitting documents into chunks that are queried separately after prompt and
+// merging the answers with sqirvy.MapReduce. The chunks fit every model of the
+// fallback chain. The response is returned once merged, even when streaming.
+func executeChunked(cmd *cobra.Command, system, prompt string, documents []sqirvy.Document, models []string, temperature int) (string, error) {
+	parallel, _ := cmd.Flags().GetInt("parallel")
+
+	// leave room in each chunk for the prompt of the reduce queries
+	budget, err := newInputBudget(system+prompt+sqirvy.DefaultReducePrompt, models...)
+	if err != nil {
+		return "", err
+	}
+
+	answered := ""
+	client, model, err := newModelClient(func(next string) { answered = next }, models...)
+	if err != nil {
+		return "", err
+	}
+	defer client.Close()
+	client = cacheClient(client, nil)
+
+	response, err := sqirvy.MapReduce(context.Background(), client, sqirvy.MapReduceQuery{
+		Model:       model,
+		System:      system,
+		Prompt:      prompt,
+		Documents:   documents,
+		ChunkTokens: budget.tokens,
+		Parallel:    parallel,
+		Options:     sqirvy.Options{Temperature: float32(temperature), MaxTokens: sqirvy.GetMaxTokens(model)},
+		OnStage: func(stage string, queries int) {
+			fmt.Fprintf(os.Stderr, "Chunks      : %s, %d queries of up to %d tokens\n", stage, queries, budget.tokens)
+		},
+	})
+	if err != nil {
+		return "", fmt.Errorf("error: querying model %s: %v", model, err)
+	}
+	printUsage(cmp.Or(answered, model), response)
+
+	return response.Text, nil
+}
+
+// addChunkFlags adds the flags of executeChunked to a command that runs executeQuery
+func addChunkFlags(cmd *cobra.Command) {
+	cmd.Flags().Bool("chunk", false, "split input too large for the context window into chunks queried separately, then merge the answers")
+	cmd.Flags().Int("parallel", sqirvy.DefaultMapReduceParallel, "number of chunks queried at once with --chunk")
+}
+
+// printUsage prints the token usage of a response, and its estimated cost
+// if the model has a known price, to stderr
+func printUsage(model string, response *sqirvy.Response) {
+	fmt.Fprintf(os.Stderr, "Usage       : %d input tokens, %d output tokens (%s, %s)\n",
+		response.InputTokens, response.OutputTokens, response.Model, response.FinishReason)
+	if cost, ok := sqirvy.GetCost(model, response.InputTokens, response.OutputTokens); ok {
+		fmt.Fprintf(os.Stderr, "Cost        : $%.6f\n", cost)
+	}
+}
+
+// cacheMaxBytes limits the size of the response cache on disk
+const cacheMaxBytes = 100 << 20
+
+// cacheClient wraps client with the response cache in the user's cache
+// directory, unless the no-cache flag is set. Cache hits are reported on stderr
+// and to onHit if it is not nil. If the cache cannot be created a warning is
+// printed and client is returned.
+func cacheClient(client sqirvy.Client, onHit func()) sqirvy.Client {
+	if viper.GetBool("no-cache") {
+		return client
+	}
+	dir, err := sqirvy.DefaultCacheDir()
+	if err == nil {
+		var cached *sqirvy.CachedClient
+		cached, err = sqirvy.NewCachedClient(client, sqirvy.CacheConfig{
+			Dir:      dir,
+			TTL:      viper.GetDuration("cache-ttl"),
+			MaxBytes: cacheMaxBytes,
+			OnHit: func(model string, age time.Duration) {
+				fmt.Fprintf(os.Stderr, "Cache       : response from %s cached %s ago\n", model, age.Round(time.Second))
+				if onHit != nil {
+					onHit()
+				}
+			},
+		})
+		if err == nil {
+			return cached
+		}
+	}
+	fmt.Fprintf(os.Stderr, "Cache       : disabled, %v\n", err)
+	return client
+}
+
+// cachedModel returns the model of models that produced a cached response,
+// which may be a fallback rather than the model queried. Providers report
+// versioned names such as gpt-4o-2024-08-06, so the model is the registered
+// one with that name, or else the one of models with the longest name the
+// reported name starts with. If none matches the reported name is returned.
+func cachedModel(models []string, response *sqirvy.Response) string {
+	if _, ok := sqirvy.LookupModel(response.Model); ok {
+		return response.Model
+	}
+	match := ""
+	for _, name := range models {
+		name = sqirvy.GetModelAlias(name)
+		if strings.HasPrefix(response.Model, strings.TrimSuffix(name, "-latest")) && len(name) > len(match) {
+			match = name
+		}
+	}
+	return cmp.Or(match, response.Model)
+}
+
+// maxOutputTokens returns the largest output token limit of models, for a
+// query that a fallback or compare client lowers to the limit of each model
+func maxOutputTokens(models []string) int64 {
+	var tokens int64
+	for _, model := range models {
+		tokens = max(tokens, sqirvy.GetMaxTokens(sqirvy.GetModelAlias(model)))
+	}
+	return tokens
+}
+
+// newModelClient resolves model names or aliases and creates a client for the
+// providers that serve them. With more than one model the client falls back to
+// the next model when a model fails with a retryable error. Each fallback is
+// reported on stderr and passed to onFallback if it is not nil. The selected
+// model is printed to stderr.
+//
+// Returns:
+//   - sqirvy.Client: The client for the models' providers, the caller must close it
+//   - string: The resolved name of the first model
+//   - error: Any error encountered resolving the models or creating the clients
+func newModelClient(onFallback func(next string), models ...string) (sqirvy.Client, string, error) {
+	if len(models) == 0 {
+		return nil, "", fmt.Errorf("error: no model selected")
+	}
+
+	// check if it has an alias
+	model := sqirvy.GetModelAlias(models[0])
+
+	// Print the selected model to stderr
+	fmt.Fprintln(os.Stderr, "Using model :", model)
+
+	// Determine the AI provider based on the selected model
+	provider, err := sqirvy.GetProviderName(model)
+	if err != nil {
+		return nil, "", fmt.Errorf("error: model is not supported %s: %v", model, err)
+	}
+
+	// Create client for the provider, limiting each query to the --timeout flag
+	timeout := sqirvy.WithTimeout(viper.GetDuration("timeout"))
+	if len(models) == 1 {
+		client, err := sqirvy.NewClient(provider, timeout)
+		if err != nil {
+			return nil, "", fmt.Errorf("error: creating client for provider %s: %v", provider, err)
+		}
+		return client, model, nil
+	}
+
+	client, err := sqirvy.NewFallbackClient(sqirvy.FallbackConfig{
+		Models: models,
+		OnFallback: func(failed, next string, err error) {
+			fmt.Fprintf(os.Stderr, "Fallback    : %s failed (%v), using model %s\n", failed, err, next)
+			if onFallback != nil {
+				onFallback(next)
+			}
+		},
+	}, timeout)
+	if err != nil {
+		return nil, "", fmt.Errorf("error: creating clients for models %v: %v", models, err)
+	}
+	fmt.Fprintln(os.Stderr, "Fallbacks   :", strings.Join(client.Models()[1:], ", "))
+	return client, model, nil
 }
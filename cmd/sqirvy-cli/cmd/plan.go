@@ -22,7 +22,7 @@ to [sqirvy-cli code] to generate the actual code.
 The prompt is constructed in this order:
 	An internal system prompt for general planning 
 	Input from stdin
-	Any number of filename or url arguments	`,
+	Any number of file, directory, glob or url arguments	`,
 	Run: func(cmd *cobra.Command, args []string) {
 		response, err := executeQuery(cmd, planPrompt, args)
 		if err != nil {
@@ -35,11 +35,12 @@ The prompt is constructed in this order:
 }
 
 func planUsage(cmd *cobra.Command) error {
-	fmt.Println("Usage: stdin | sqirvy-cli plan [flags] [files| urls]")
+	fmt.Println("Usage: stdin | sqirvy-cli plan [flags] [files| dirs| globs| urls]")
 	return nil
 }
 
 func init() {
 	rootCmd.AddCommand(planCmd)
+	addChunkFlags(planCmd)
 	planCmd.SetUsageFunc(planUsage)
 }
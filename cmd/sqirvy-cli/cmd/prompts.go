@@ -6,7 +6,13 @@ import (
 	_ "embed"
 	"fmt"
 	"net/url"
+	"os"
+	"strings"
+
+	sqirvy "sqirvy-ai/pkg/sqirvy"
 	util "sqirvy-ai/pkg/util"
+
+	"github.com/spf13/viper"
 )
 
 // queryPrompt contains the embedded content of the query.md file,
@@ -33,76 +39,257 @@ var codePrompt string
 //go:embed prompts/review.md
 var reviewPrompt string
 
+// diffPrompt contains the embedded content of the diff.md file,
+// which explains the numbered diffs sent by review --diff and --staged.
+//
+//go:embed prompts/diff.md
+var diffPrompt string
+
+// findingsPrompt contains the embedded content of the findings.md file,
+// which defines the system prompt for review --format json and sarif.
+//
+//go:embed prompts/findings.md
+var findingsPrompt string
+
 // ReadPrompt processes input from multiple sources and combines them into a slice of prompts.
 // It handles input from:
-//   - A base system prompt
 //   - Standard input (stdin)
 //   - URLs (which are scraped for content)
-//   - Local files
+//   - Local files, directories and glob patterns
 //
-// The function ensures that the total size of all inputs does not exceed MaxInputTotalBytes.
+// Each input is charged to budget, which reports the tokens it uses on stderr
+// and fails or truncates the input that does not fit the context window.
 //
 // Parameters:
-//   - prompt: The initial system prompt to use
 //   - args: A slice of strings that can be either URLs or file paths
+//   - budget: The tokens available for the input, see newInputBudget
 //
 // Returns:
 //   - []string: A slice containing all processed prompts
-//   - error: An error if any operation fails or if size limits are exceeded
-func ReadPrompt(args []string) ([]string, error) {
+//   - error: An error if any operation fails or if the input does not fit the budget
+func ReadPrompt(args []string, budget *inputBudget) ([]string, error) {
 
 	var prompts []string
-	var length int64
-
-	// Initialize with the system prompt and check size limit
 
-	// Process standard input and check size limit
-	var stdinData string
-	stdinData, _, err := util.ReadStdin(MaxInputTotalBytes)
+	// Process standard input
+	stdinData, _, err := util.ReadStdin(MaxInputReadBytes)
 	if err != nil {
 		return []string{""}, fmt.Errorf("error: reading from stdin: %w", err)
 	}
-	prompts = append(prompts, stdinData)
-	length += int64(len(stdinData))
-	if length > MaxInputTotalBytes {
-		return []string{""}, fmt.Errorf("error: total size would exceed limit of %d bytes (stdin)", MaxInputTotalBytes)
+	if stdinData != "" {
+		stdinData, err = budget.add("stdin", stdinData)
+		if err != nil {
+			return []string{""}, err
+		}
+		prompts = append(prompts, stdinData)
 	}
 
 	// Process each argument which can be either a URL or a file path
+	content, err := ReadArgs(args, budget)
+	if err != nil {
+		return []string{""}, err
+	}
+	prompts = append(prompts, content...)
+
+	// use default prompt if no other ones are specified
+	if len(prompts) == 0 {
+		prompts = []string{defaultPrompt}
+	}
+
+	return prompts, nil
+}
+
+// ReadArgs reads the content of each argument, which can be either a URL or a file path.
+// URLs are scraped for content and files are read from disk.
+//
+// Parameters:
+//   - args: A slice of strings that can be either URLs or file paths
+//   - budget: The tokens available for the content, charged for each argument
+//
+// Returns:
+//   - []string: The content of each argument that fits the budget, in the order given
+//   - error: An error if any operation fails or if the content does not fit the budget
+func ReadArgs(args []string, budget *inputBudget) ([]string, error) {
+	documents, err := readArgs(args)
+	if err != nil {
+		return nil, err
+	}
+
+	var prompts []string
+	for _, doc := range documents {
+		content, err := budget.add(doc.Name, doc.Content)
+		if err != nil {
+			return nil, err
+		}
+		if content != "" {
+			prompts = append(prompts, content)
+		}
+	}
+	return prompts, nil
+}
+
+// readArgs reads each argument into a document named by the argument. URLs
+// are scraped, and directories and glob patterns are expanded into the files
+// they contain, honoring .gitignore and the include and exclude flags.
+func readArgs(args []string) ([]sqirvy.Document, error) {
+	var documents []sqirvy.Document
+	expand := util.ExpandOptions{
+		Include: viper.GetStringSlice("include"),
+		Exclude: viper.GetStringSlice("exclude"),
+	}
+	seen := make(map[string]bool)
+
 	for _, arg := range args {
 		// Attempt to parse argument as URL
-		_, err := url.ParseRequestURI(arg)
-		if err == nil {
+		if _, err := url.ParseRequestURI(arg); err == nil {
 			// Handle URL content
 			content, err := util.ScrapeURL(arg)
 			if err != nil {
-				return []string{""}, fmt.Errorf("error: failed to scrape URL %s: %w", arg, err)
-			}
-			content += "\n\n"
-			prompts = append(prompts, content)
-			length += int64(len(content))
-			if length > MaxInputTotalBytes {
-				return []string{""}, fmt.Errorf("error: total size would exceed limit of %d bytes (urls)", MaxInputTotalBytes)
+				return nil, fmt.Errorf("error: failed to scrape URL %s: %w", arg, err)
 			}
+			documents = append(documents, sqirvy.Document{Name: arg, Content: content + "\n\n"})
 			continue
 		}
 
 		// Handle file content if not a URL
-		fileData, _, err := util.ReadFile(arg, MaxInputTotalBytes)
+		files, err := util.ExpandPaths([]string{arg}, expand)
 		if err != nil {
-			return []string{""}, fmt.Errorf("error: failed to read file %s: %w", arg, err)
+			return nil, fmt.Errorf("error: failed to read %s: %w", arg, err)
 		}
-		prompts = append(prompts, string(fileData))
-		length += int64(len(fileData))
-		if length > MaxInputTotalBytes {
-			return []string{""}, fmt.Errorf("error: total size would exceed limit of %d bytes (files)", MaxInputTotalBytes)
+		for _, fname := range files {
+			if seen[fname] {
+				continue
+			}
+			seen[fname] = true
+			fileData, _, err := util.ReadFile(fname, MaxInputReadBytes)
+			if err != nil {
+				return nil, fmt.Errorf("error: failed to read file %s: %w", fname, err)
+			}
+			documents = append(documents, sqirvy.Document{Name: fname, Content: string(fileData)})
 		}
 	}
 
-	// use default prompt if no other ones are specified
-	if len(prompts) == 0 || (len(prompts) == 1 && prompts[0] == "") {
-		prompts = []string{defaultPrompt}
+	return documents, nil
+}
+
+// readDocuments reads stdin and each argument into documents for a
+// map-reduce query, which fences every document with its name itself
+func readDocuments(args []string) ([]sqirvy.Document, error) {
+	stdinData, _, err := util.ReadStdin(MaxInputReadBytes)
+	if err != nil {
+		return nil, fmt.Errorf("error: reading from stdin: %w", err)
+	}
+	var documents []sqirvy.Document
+	if stdinData != "" {
+		stdinData = strings.TrimSuffix(strings.TrimPrefix(stdinData, "```stdin\n"), "```")
+		documents = append(documents, sqirvy.Document{Name: "stdin", Content: stdinData})
 	}
 
-	return prompts, nil
+	content, err := readArgs(args)
+	if err != nil {
+		return nil, err
+	}
+	return append(documents, content...), nil
+}
+
+// truncatedMarker is appended to input cut to fit the context window
+const truncatedMarker = "\n[truncated to fit the context window]\n"
+
+// inputBudget tracks the tokens of the context window left for input, after
+// the system prompt and the response, as stdin, files and URLs are read.
+type inputBudget struct {
+	model    string // model with the smallest room for input
+	provider string // provider of model, whose tokenizer the estimates use
+	window   int64  // context window of model, DefaultInputTokens if unknown
+	tokens   int64  // tokens left for input
+	truncate bool   // cut input that does not fit instead of failing
+}
+
+// newInputBudget returns the budget for input sent with system to every one
+// of models, as when they are fallbacks or compared. The budget is the room
+// left by the model with the smallest context window once the system prompt
+// and its maximum output tokens are reserved. Models with an unknown context
+// window are given DefaultInputTokens for input. The overflow flag chooses
+// whether input that does not fit fails or is truncated.
+func newInputBudget(system string, models ...string) (*inputBudget, error) {
+	overflow := viper.GetString("overflow")
+	if overflow != overflowError && overflow != overflowTruncate {
+		return nil, fmt.Errorf("error: invalid overflow policy %q, use %s or %s", overflow, overflowError, overflowTruncate)
+	}
+	if len(models) == 0 {
+		return nil, fmt.Errorf("error: no model selected")
+	}
+
+	var budget *inputBudget
+	for _, name := range models {
+		model := sqirvy.GetModelAlias(name)
+		provider, err := sqirvy.GetProviderName(model)
+		if err != nil {
+			return nil, fmt.Errorf("error: model is not supported %s: %v", model, err)
+		}
+		window := sqirvy.GetContextWindow(model)
+		tokens := DefaultInputTokens
+		if window > 0 {
+			tokens = window - sqirvy.GetMaxTokens(model) - sqirvy.EstimateTokens(provider, system)
+		} else {
+			window = DefaultInputTokens
+		}
+		if tokens <= 0 {
+			return nil, fmt.Errorf("error: the system prompt and response of model %s do not fit its context window of %d tokens", model, window)
+		}
+		if budget == nil || tokens < budget.tokens {
+			budget = &inputBudget{model: model, provider: provider, window: window, tokens: tokens}
+		}
+	}
+	budget.truncate = overflow == overflowTruncate
+	return budget, nil
+}
+
+// add charges the content of the input named name to the budget and reports
+// the share of the context window it uses on stderr. Content that does not fit
+// is an error, or when truncating is cut to the tokens left, and dropped once
+// none are left.
+func (b *inputBudget) add(name, content string) (string, error) {
+	tokens := sqirvy.EstimateTokens(b.provider, content)
+	if tokens <= b.tokens {
+		b.tokens -= tokens
+		fmt.Fprintf(os.Stderr, "Input       : %s, %d tokens (%.1f%% of %s context window)\n",
+			name, tokens, b.percent(tokens), b.model)
+		return content, nil
+	}
+	if !b.truncate {
+		return "", fmt.Errorf("error: %s is about %d tokens, over the %d tokens left in the context window of %s (use --overflow %s to cut it)",
+			name, tokens, b.tokens, b.model, overflowTruncate)
+	}
+
+	room := b.tokens - sqirvy.EstimateTokens(b.provider, truncatedMarker)
+	if room <= 0 {
+		fmt.Fprintf(os.Stderr, "Input       : %s, %d tokens skipped, the context window of %s is full\n", name, tokens, b.model)
+		return "", nil
+	}
+	// the estimate of the cut content can be over room, so cut it again with
+	// less room until it fits
+	var truncated string
+	var used int64
+	for {
+		truncated = sqirvy.TruncateTokens(b.provider, content, room) + truncatedMarker
+		used = sqirvy.EstimateTokens(b.provider, truncated)
+		if used <= b.tokens {
+			break
+		}
+		if room -= used - b.tokens; room <= 0 {
+			fmt.Fprintf(os.Stderr, "Input       : %s, %d tokens skipped, the context window of %s is full\n", name, tokens, b.model)
+			return "", nil
+		}
+	}
+	content = truncated
+	b.tokens -= used
+	fmt.Fprintf(os.Stderr, "Input       : %s, truncated from %d to %d tokens (%.1f%% of %s context window)\n",
+		name, tokens, used, b.percent(used), b.model)
+	return content, nil
+}
+
+// percent returns tokens as a percentage of the context window
+func (b *inputBudget) percent(tokens int64) float64 {
+	return 100 * float64(tokens) / float64(b.window)
 }
@@ -0,0 +1,25 @@
+package cmd
+
+import (
+	"strings"
+	"testing"
+
+	sqirvy "sqirvy-ai/pkg/sqirvy"
+)
+
+func TestInputBudget_Truncate(t *testing.T) {
+	content := strings.Repeat("func main() { fmt.Println(\"héllo, 世界\") }\n", 200)
+	for _, provider := range []string{sqirvy.OpenAI, sqirvy.Anthropic, sqirvy.Gemini, sqirvy.Mock} {
+		for tokens := int64(1); tokens < 300; tokens += 7 {
+			budget := &inputBudget{model: "test", provider: provider, window: 1000, tokens: tokens, truncate: true}
+			cut, err := budget.add("input", content)
+			if err != nil {
+				t.Fatalf("add() error = %v", err)
+			}
+			// the cut content is charged and never overdraws the budget
+			if budget.tokens < 0 || (cut != "" && sqirvy.EstimateTokens(provider, cut) != tokens-budget.tokens) {
+				t.Errorf("%s: add() with %d tokens left %d tokens for %d of content", provider, tokens, budget.tokens, sqirvy.EstimateTokens(provider, cut))
+			}
+		}
+	}
+}
@@ -16,7 +16,7 @@ var queryCmd = &cobra.Command{
 	Short: "Execute an arbitrary query to the LLM",
 	Long: `sqirvy-cli query will send a request to the LLM to execute an arbitrary query.
 It will not add internal prompts or context. The prompt to the LLM will consist of 
-any input from stdint, and then any filename or url arguments, in the order specified.
+any input from stdint, and then any file, directory, glob or url arguments, in the order specified.
 `,
 	Run: func(cmd *cobra.Command, args []string) {
 		response, err := executeQuery(cmd, queryPrompt, args)
@@ -30,11 +30,12 @@ any input from stdint, and then any filename or url arguments, in the order spec
 }
 
 func queryUsage(cmd *cobra.Command) error {
-	fmt.Println("Usage: stdin | sqirvy-cli query [flags] [files| urls]")
+	fmt.Println("Usage: stdin | sqirvy-cli query [flags] [files| dirs| globs| urls]")
 	return nil
 }
 
 func init() {
 	rootCmd.AddCommand(queryCmd)
+	addChunkFlags(queryCmd)
 	queryCmd.SetUsageFunc(queryUsage)
 }
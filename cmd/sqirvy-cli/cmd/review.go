@@ -4,10 +4,19 @@ Copyright © 2025 NAME HERE <EMAIL ADDRESS>
 package cmd
 
 import (
+	"cmp"
+	"context"
+	"encoding/json"
 	"fmt"
 	"log"
+	"os"
+	"strings"
+
+	sqirvy "sqirvy-ai/pkg/sqirvy"
+	util "sqirvy-ai/pkg/util"
 
 	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
 )
 
 // reviewCmd represents the review command
@@ -19,25 +28,236 @@ It will to review input code and will output the results to stdout.
 The prompt is constructed in this order:
     An internal system prompt for code review
     Input from stdin
-    Any number of filename or url arguments
+    Any number of file, directory, glob or url arguments
+
+With --diff or --staged it reviews the changes of the git repository in the
+current directory instead, and any arguments limit the review to those paths.
+
+With --format json or sarif the review is a list of findings, each with a file,
+line range, severity, category, message and suggested fix. SARIF output can be
+uploaded to code scanning dashboards, and --fail-on exits with an error when
+there are findings of a severity or higher.
 `,
 	Run: func(cmd *cobra.Command, args []string) {
-		response, err := executeQuery(cmd, reviewPrompt, args)
-		if err != nil {
+		var response string
+		var err error
+		rev, _ := cmd.Flags().GetString("diff")
+		staged, _ := cmd.Flags().GetBool("staged")
+		format, _ := cmd.Flags().GetString("format")
+		failOn, _ := cmd.Flags().GetString("fail-on")
+		switch {
+		case failOn != "" && format == formatMarkdown:
+			err = fmt.Errorf("error: --fail-on needs --format %s or %s", formatJSON, formatSARIF)
+		case format != formatMarkdown:
+			response, err = executeFindings(cmd, format, rev, staged, args)
+		case rev != "" || staged:
+			response, err = executeDiffReview(cmd, rev, staged, args)
+		default:
+			response, err = executeQuery(cmd, reviewPrompt, args)
+		}
+		if err != nil && response == "" {
 			log.Fatal(err)
 		}
 		// Print response to stdout
 		fmt.Print(response)
 		fmt.Println()
+		// a review that fails the build is still printed
+		if err != nil {
+			log.Fatal(err)
+		}
 	},
 }
 
+// executeDiffReview reviews the changes of a revision range, or the staged
+// changes when rev is empty, of the git repository in the current directory,
+// limited to the paths of args. Each changed file is sent as its diff with the
+// line numbers of the new version, after the instructions of prompts/diff.md,
+// so the review refers to the changed lines by file and line.
+func executeDiffReview(cmd *cobra.Command, rev string, staged bool, args []string) (string, error) {
+	models := viper.GetStringSlice("model")
+	temperature, err := cmd.Flags().GetInt("temperature")
+	if err != nil {
+		return "", fmt.Errorf("error: getting temperature: %v", err)
+	}
+
+	documents, err := diffDocuments(cmd, rev, staged, args)
+	if err != nil {
+		return "", err
+	}
+	if chunk, _ := cmd.Flags().GetBool("chunk"); chunk {
+		return executeChunked(cmd, reviewPrompt, diffPrompt, documents, models, temperature)
+	}
+
+	budget, err := newInputBudget(reviewPrompt+diffPrompt, models...)
+	if err != nil {
+		return "", err
+	}
+	prompts, err := budgetDocuments(budget, documents)
+	if err != nil {
+		return "", err
+	}
+
+	return queryModels(reviewPrompt, append([]string{diffPrompt}, prompts...), models, temperature)
+}
+
+// diffDocuments collects the changes reviewed by --diff and --staged as a
+// document for each changed file, holding its diff with line numbers
+func diffDocuments(cmd *cobra.Command, rev string, staged bool, args []string) ([]sqirvy.Document, error) {
+	lines, _ := cmd.Flags().GetInt("context")
+
+	diffs, err := util.GitDiff(util.DiffOptions{Range: rev, Staged: staged, Context: lines, Paths: args})
+	if err != nil {
+		return nil, fmt.Errorf("error: collecting changes: %v", err)
+	}
+	if len(diffs) == 0 {
+		return nil, fmt.Errorf("error: no changes to review")
+	}
+	fmt.Fprintf(os.Stderr, "Changes     : %d files\n", len(diffs))
+
+	var documents []sqirvy.Document
+	for _, diff := range diffs {
+		documents = append(documents, sqirvy.Document{Name: diff.Path, Content: diff.Numbered()})
+	}
+	return documents, nil
+}
+
+// budgetDocuments charges each document to budget and returns the content of
+// those that fit, fenced with their name
+func budgetDocuments(budget *inputBudget, documents []sqirvy.Document) ([]string, error) {
+	var prompts []string
+	for _, doc := range documents {
+		content, err := budget.add(doc.Name, "```"+doc.Name+"\n"+doc.Content+"\n```\n")
+		if err != nil {
+			return nil, err
+		}
+		if content != "" {
+			prompts = append(prompts, content)
+		}
+	}
+	return prompts, nil
+}
+
+// executeFindings reviews the input, or the changes selected by rev and
+// staged, with a structured query and returns the findings as JSON or SARIF.
+// Files are sent with line numbers so the findings refer to the right lines.
+// If the fail-on flag is set and there are findings of that severity or
+// higher, the findings are returned with an error.
+func executeFindings(cmd *cobra.Command, format, rev string, staged bool, args []string) (string, error) {
+	if format != formatJSON && format != formatSARIF {
+		return "", fmt.Errorf("error: invalid format %q, use %s, %s or %s", format, formatMarkdown, formatJSON, formatSARIF)
+	}
+	failOn, _ := cmd.Flags().GetString("fail-on")
+	if failOn != "" && !sqirvy.ValidSeverity(failOn) {
+		return "", fmt.Errorf("error: invalid severity %q for --fail-on, use critical, high, medium, low or info", failOn)
+	}
+	if chunk, _ := cmd.Flags().GetBool("chunk"); chunk {
+		return "", fmt.Errorf("error: --chunk only supports the %s format", formatMarkdown)
+	}
+	models := viper.GetStringSlice("model")
+	temperature, err := cmd.Flags().GetInt("temperature")
+	if err != nil {
+		return "", fmt.Errorf("error: getting temperature: %v", err)
+	}
+
+	// Collect the numbered diffs or files to review
+	var prompts []string
+	var documents []sqirvy.Document
+	if rev != "" || staged {
+		prompts = append(prompts, diffPrompt)
+		documents, err = diffDocuments(cmd, rev, staged, args)
+	} else {
+		documents, err = readDocuments(args)
+		for i := range documents {
+			documents[i].Content = numberLines(documents[i].Content)
+		}
+	}
+	if err != nil {
+		return "", err
+	}
+	if len(documents) == 0 {
+		return "", fmt.Errorf("error: no code to review, use stdin, files, urls, --diff or --staged")
+	}
+	budget, err := newInputBudget(findingsPrompt+strings.Join(prompts, ""), models...)
+	if err != nil {
+		return "", err
+	}
+	content, err := budgetDocuments(budget, documents)
+	if err != nil {
+		return "", err
+	}
+	prompts = append(prompts, content...)
+
+	// Query the model for the findings, falling back to the others
+	answered := ""
+	client, model, err := newModelClient(func(next string) { answered = next }, models...)
+	if err != nil {
+		return "", err
+	}
+	defer client.Close()
+	cached := false
+	client = cacheClient(client, func() { cached = true })
+
+	options := sqirvy.Options{Temperature: float32(temperature), MaxTokens: sqirvy.GetMaxTokens(model)}
+	review, response, err := sqirvy.QueryReview(context.Background(), client, findingsPrompt, prompts, model, options)
+	if err != nil {
+		return "", fmt.Errorf("error: querying model %s: %v", model, err)
+	}
+	if cached {
+		answered = cachedModel(models, response)
+	}
+	printUsage(cmp.Or(answered, model), response)
+	fmt.Fprintf(os.Stderr, "Findings    : %d (%s)\n", len(review.Findings), review.Counts())
+
+	var output strings.Builder
+	if format == formatSARIF {
+		sarif, err := review.SARIF("sqirvy-cli", "")
+		if err != nil {
+			return "", fmt.Errorf("error: formatting findings: %v", err)
+		}
+		output.Write(sarif)
+	} else {
+		encoder := json.NewEncoder(&output)
+		encoder.SetEscapeHTML(false)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(review); err != nil {
+			return "", fmt.Errorf("error: formatting findings: %v", err)
+		}
+	}
+	result := strings.TrimSuffix(output.String(), "\n")
+
+	if failOn != "" {
+		if n := len(review.AtLeast(failOn)); n > 0 {
+			return result, fmt.Errorf("error: %d findings of severity %s or higher", n, failOn)
+		}
+	}
+	return result, nil
+}
+
+// numberLines puts the line number in front of each line of content, as
+// util.FileDiff.Numbered does for diffs
+func numberLines(content string) string {
+	var b strings.Builder
+	for i, line := range strings.SplitAfter(strings.TrimSuffix(content, "\n"), "\n") {
+		fmt.Fprintf(&b, "%6d %s", i+1, line)
+	}
+	return b.String()
+}
+
 func reviewUsage(cmd *cobra.Command) error {
-	fmt.Println("Usage: stdin | sqirvy-cli review [flags] [files| urls]")
+	fmt.Println("Usage: stdin | sqirvy-cli review [flags] [files| dirs| globs| urls]")
+	fmt.Println("       sqirvy-cli review --diff <rev-range> | --staged [flags] [paths]")
+	fmt.Println("       sqirvy-cli review --format json|sarif [--fail-on <severity>] [flags] [inputs]")
 	return nil
 }
 
 func init() {
 	rootCmd.AddCommand(reviewCmd)
+	addChunkFlags(reviewCmd)
 	reviewCmd.SetUsageFunc(reviewUsage)
+	reviewCmd.Flags().String("diff", "", "review the changes of a git revision range, e.g. main..HEAD, instead of whole files")
+	reviewCmd.Flags().Bool("staged", false, "review the staged changes of the git repository")
+	reviewCmd.Flags().Int("context", util.DefaultDiffContext, "lines of context around each change with --diff and --staged")
+	reviewCmd.Flags().String("format", formatMarkdown, "output format: markdown, or json and sarif for structured findings")
+	reviewCmd.Flags().String("fail-on", "", "with --format json or sarif, exit with an error if there are findings of this severity or higher: critical, high, medium, low or info")
+	reviewCmd.MarkFlagsMutuallyExclusive("diff", "staged")
 }
@@ -6,6 +6,9 @@ package cmd
 import (
 	"fmt"
 	"os"
+	"time"
+
+	sqirvy "sqirvy-ai/pkg/sqirvy"
 
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
@@ -16,6 +19,7 @@ var defaultPrompt = "Hello"
 
 const defaultModel = "gpt-4-turbo"
 const defaultTemperature = 50
+const defaultCacheTTL = 24 * time.Hour
 
 // rootCmd represents the base command when called without any subcommands
 var rootCmd = &cobra.Command{
@@ -30,6 +34,7 @@ var rootCmd = &cobra.Command{
    - The "plan" command is used to send a prompt to the LLM and receive a plan in response.
    - The "code" command is used to send a prompt to the LLM and receive source code in response.
    - The "review" command is used to send a prompt to the LLM and receive a code review in response.
+   - The "chat" command is used to hold an interactive conversation with the LLM.
    - Sqirvy-cli is designed to support terminal command pipelines. 
 	`,
 
@@ -56,9 +61,25 @@ func init() {
 	// rootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "config file (default is $HOME/.config/sqirvy-cli/config.yaml)")
 	rootCmd.PersistentFlags().StringVar(&defaultPrompt, "default-prompt", "Hello", "default prompt to use")
 	viper.BindPFlag("default-prompt", rootCmd.PersistentFlags().Lookup("default-prompt"))
-	rootCmd.PersistentFlags().StringP("model", "m", defaultModel, "LLM model to use")
+	rootCmd.PersistentFlags().StringSliceP("model", "m", []string{defaultModel}, "LLM model to use, repeat to add fallback models tried in order")
 	viper.BindPFlag("model", rootCmd.PersistentFlags().Lookup("model"))
 	rootCmd.PersistentFlags().IntP("temperature", "t", defaultTemperature, "LLM temperature to use (0..100)")
+	rootCmd.PersistentFlags().BoolP("stream", "s", false, "print the response as it is generated")
+	viper.BindPFlag("stream", rootCmd.PersistentFlags().Lookup("stream"))
+	rootCmd.PersistentFlags().Duration("timeout", 0, "time limit for each query, e.g. 90s or 10m (0 for no limit)")
+	viper.BindPFlag("timeout", rootCmd.PersistentFlags().Lookup("timeout"))
+	rootCmd.PersistentFlags().String("catalog", "", "model catalog file (YAML or JSON) merged over the built-in models")
+	viper.BindPFlag("catalog", rootCmd.PersistentFlags().Lookup("catalog"))
+	rootCmd.PersistentFlags().Bool("no-cache", false, "always query the model instead of reusing a cached response")
+	viper.BindPFlag("no-cache", rootCmd.PersistentFlags().Lookup("no-cache"))
+	rootCmd.PersistentFlags().Duration("cache-ttl", defaultCacheTTL, "reuse cached responses up to this age (0 for no limit)")
+	viper.BindPFlag("cache-ttl", rootCmd.PersistentFlags().Lookup("cache-ttl"))
+	rootCmd.PersistentFlags().String("overflow", overflowError, "input that does not fit the context window: error or truncate")
+	viper.BindPFlag("overflow", rootCmd.PersistentFlags().Lookup("overflow"))
+	rootCmd.PersistentFlags().StringSlice("include", nil, "patterns of the files to read from directory and glob arguments, e.g. '*.go'")
+	viper.BindPFlag("include", rootCmd.PersistentFlags().Lookup("include"))
+	rootCmd.PersistentFlags().StringSlice("exclude", nil, "patterns of the files and directories to skip in directory and glob arguments")
+	viper.BindPFlag("exclude", rootCmd.PersistentFlags().Lookup("exclude"))
 }
 
 // print config filename only once
@@ -89,4 +110,9 @@ func initConfig() {
 			fmt.Fprintln(os.Stderr, "Config file :", viper.ConfigFileUsed())
 		}
 	}
+
+	// Add models from the catalog file, if any, to the built-in models
+	if catalog := viper.GetString("catalog"); catalog != "" {
+		cobra.CheckErr(sqirvy.LoadCatalog(catalog))
+	}
 }
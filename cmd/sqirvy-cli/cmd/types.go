@@ -1,5 +1,26 @@
 package cmd
 
 const (
-	MaxInputTotalBytes = 262144
+	// MaxChatLineBytes limits the length of a line read by chat
+	MaxChatLineBytes = 262144
+
+	// MaxInputReadBytes limits the size of any one input read from stdin or a file,
+	// the input sent to a model is limited by its context window instead
+	MaxInputReadBytes = 64 << 20
+
+	// DefaultInputTokens is the input allowed for models with an unknown context window
+	DefaultInputTokens int64 = 65536
+)
+
+// Policies of the overflow flag for input that does not fit the context window
+const (
+	overflowError    = "error"
+	overflowTruncate = "truncate"
+)
+
+// Output formats of the review command
+const (
+	formatMarkdown = "markdown"
+	formatJSON     = "json"
+	formatSARIF    = "sarif"
 )
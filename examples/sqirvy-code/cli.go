@@ -37,7 +37,7 @@ func helpMessage(prefix string) {
 	if prefix != "" {
 		fmt.Println(prefix)
 	}
-	fmt.Println("Usage: sqirvy-code[options] files...")
+	fmt.Println("Usage: sqirvy-code[options] files, directories or globs...")
 	fmt.Println("Generates code based on the input prompt")
 	fmt.Println("initializes the context from stdin, pipe or redirection (if any)")
 	fmt.Println("concatenates files to the context in order")
@@ -112,8 +112,14 @@ func processCommandLine() (string, string, error) {
 	// Check if total size of stdin exceeds MaxTotalBytes
 	builder.WriteString(string(stdinData))
 
+	// Expand directories and glob patterns into the files they contain
+	files, err := util.ExpandPaths(flag.Args(), util.ExpandOptions{})
+	if err != nil {
+		return "", "", fmt.Errorf("error reading files: %w", err)
+	}
+
 	// Read all files (will return error if MaxTotalBytes exceeded)
-	fileData, _, err := util.ReadFiles(flag.Args(), MaxTotalBytes)
+	fileData, _, err := util.ReadFiles(files, MaxTotalBytes)
 	if err != nil {
 		return "", "", fmt.Errorf("error reading files: %w", err)
 	}
@@ -32,7 +32,7 @@ const MaxTotalBytes = maxTokens * bytesPerToken // 262144 bytes limit
 // helpMessage prints usage information for the command line tool,
 // including available options and supported AI models.
 func helpMessage() {
-	fmt.Println("Usage: sqirvy-query [options] files...")
+	fmt.Println("Usage: sqirvy-query [options] files, directories or globs...")
 	fmt.Println("initializes the context from stdin, pipe or redirection (if any)")
 	fmt.Println("concatenates files to the context in order")
 	fmt.Println("Options:")
@@ -102,8 +102,14 @@ func processCommandLine() (string, string, error) {
 	}
 	builder.WriteString(string(stdinData))
 
+	// Expand directories and glob patterns into the files they contain
+	files, err := util.ExpandPaths(flag.Args(), util.ExpandOptions{})
+	if err != nil {
+		return "", "", fmt.Errorf("error reading files: %w", err)
+	}
+
 	// Read all files
-	fileData, fileSize, err := util.ReadFiles(flag.Args(), MaxTotalBytes)
+	fileData, fileSize, err := util.ReadFiles(files, MaxTotalBytes)
 	if err != nil {
 		return "", "", fmt.Errorf("error reading files: %w", err)
 	}
@@ -37,12 +37,14 @@ func helpMessage(prefix string) {
 	if prefix != "" {
 		fmt.Println(prefix)
 	}
-	fmt.Println("Usage: sqirvy-review [options] files...")
+	fmt.Println("Usage: sqirvy-review [options] files, directories or globs...")
 	fmt.Println("initializes the context from stdin, pipe or redirection (if any)")
 	fmt.Println("concatenates files to the context in order")
 	fmt.Println("Options:")
 	fmt.Println("  -h    print this help message")
 	fmt.Println("  -m    AI model to use (default: gemini-1.5-flash)")
+	fmt.Println("  -chunk  split input larger than the model's context window into")
+	fmt.Println("          chunks reviewed separately, then merge the reviews")
 	fmt.Println("")
 	fmt.Println("Supported models:")
 	keys := sqirvy.GetModelList()
@@ -52,75 +54,101 @@ func helpMessage(prefix string) {
 	}
 }
 
-// processCommandLine handles command line argument processing and input file handling.
-//
-// The function:
-// - Parses and validates command line flags
-// - Processes help requests
-// - Reads and concatenates input files
-// - Enforces size limits (MaxTotalBytes)
-// - Validates model selection
-//
-// Returns:
-//   - string: Concatenated content from all input files
-//   - string: Selected AI model name (or empty for default)
-//   - error: Any error encountered during processing
-//
-// Size limits are enforced to ensure the total input stays within
-// the AI model's context window (default 256KB).
-// processCommandLine parses command line arguments and assembles the input prompt.
-// It handles input from both files and stdin, enforcing size limits.
+// MaxChunkedBytes limits the size of each file read with -chunk, which is
+// split to fit the model's context window instead of limited to MaxTotalBytes
+const MaxChunkedBytes = 64 << 20
+
+// reviewInput is the input and options of a review from the command line.
+type reviewInput struct {
+	prompt    string            // stdin and files concatenated, without -chunk
+	documents []sqirvy.Document // stdin and each file, with -chunk
+	model     string            // AI model name, empty for the default
+	chunk     bool              // split the input into chunks that fit the model's context window
+	help      bool              // the help message was requested
+}
+
+// processCommandLine parses command line arguments and reads the input to review.
+// It handles input from both files and stdin. Without -chunk the input is
+// concatenated into one prompt, limited to MaxTotalBytes. With -chunk each
+// file is kept as a document of its own, to be split along file and function
+// boundaries, so the input may be larger than the model's context window.
 //
 // Returns:
-//   - string: The assembled prompt text
-//   - string: The specified AI model name (or empty for default)
+//   - *reviewInput: The input and the selected options
 //   - error: Any error that occurred during processing
-func processCommandLine() (string, string, error) {
+func processCommandLine() (*reviewInput, error) {
 	// suppress the default help message
 	flag.Usage = func() {}
 
-	var help bool
-	var model string
-
-	flag.BoolVar(&help, "h", false, "print help message")
-	flag.StringVar(&model, "m", "", "AI model to use")
+	input := &reviewInput{}
+	flag.BoolVar(&input.help, "h", false, "print help message")
+	flag.StringVar(&input.model, "m", "", "AI model to use")
+	flag.BoolVar(&input.chunk, "chunk", false, "split large input into chunks")
 	flag.Parse()
 
-	if help {
+	if input.help {
 		helpMessage("")
-		return "help", "", nil
+		return input, nil
 	}
 
-	var builder strings.Builder
-
 	// Check if we have data from stdin
 	p, err := util.InputIsFromPipe()
 	if err != nil {
-		return "", "", fmt.Errorf("error checking if input is from pipe: %w", err)
+		return nil, fmt.Errorf("error checking if input is from pipe: %w", err)
+	}
+
+	maxBytes := int64(MaxTotalBytes)
+	if input.chunk {
+		maxBytes = MaxChunkedBytes
 	}
 
 	// Read stdin
 	var stdinData string
 	if p {
-		stdinData, _, err = util.ReadStdin(MaxTotalBytes)
+		stdinData, _, err = util.ReadStdin(maxBytes)
 		if err != nil {
-			return "", "", fmt.Errorf("error reading from stdin: %w", err)
+			return nil, fmt.Errorf("error reading from stdin: %w", err)
+		}
+	}
+
+	// Expand directories and glob patterns into the files they contain
+	files, err := util.ExpandPaths(flag.Args(), util.ExpandOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("error reading files: %w", err)
+	}
+
+	if input.chunk {
+		if stdinData != "" {
+			stdinData = strings.TrimSuffix(strings.TrimPrefix(stdinData, "```stdin\n"), "```")
+			input.documents = append(input.documents, sqirvy.Document{Name: "stdin", Content: stdinData})
+		}
+		for _, fname := range files {
+			data, _, err := util.ReadFile(fname, maxBytes)
+			if err != nil {
+				return nil, fmt.Errorf("error reading file %s: %w", fname, err)
+			}
+			input.documents = append(input.documents, sqirvy.Document{Name: fname, Content: string(data)})
 		}
+		if len(input.documents) == 0 {
+			return nil, fmt.Errorf("no files specified or files have no data")
+		}
+		return input, nil
 	}
 
-	// Check if total size of stdin exceeds MaxTotalBytes
-	builder.WriteString(string(stdinData))
+	var builder strings.Builder
+	builder.WriteString(stdinData)
 
 	// Read all files (will return error if MaxTotalBytes exceeded)
-	fileData, _, err := util.ReadFiles(flag.Args(), MaxTotalBytes)
+	fileData, _, err := util.ReadFiles(files, MaxTotalBytes)
 	if err != nil {
-		return "", "", fmt.Errorf("error reading files: %w", err)
+		return nil, fmt.Errorf("error reading files: %w (use -chunk to review input of any size)", err)
 	}
 
 	builder.WriteString(fileData)
 	if builder.Len() == 0 {
-		return "", "", fmt.Errorf("no files specified or files have no data")
+		return nil, fmt.Errorf("no files specified or files have no data")
 	}
+	input.prompt = builder.String()
 
-	return builder.String(), model, nil
+	return input, nil
 }
@@ -40,24 +40,20 @@ var DEFAULT_MODEL = "gemini-1.5-flash"
 func main() {
 	log.SetFlags(log.LstdFlags | log.Lshortfile)
 
-	prompt, modelFlag, err := processCommandLine()
+	input, err := processCommandLine()
 	if err != nil {
 		helpMessage("Error:" + err.Error())
 		log.Fatal(err)
 	}
 	// -h ?
-	if prompt == "help" {
+	if input.help {
 		return
 	}
 
-	if prompt == "" {
-		log.Fatal("no prompt provided")
-	}
-
 	// Use default model if none specified
 	model := DEFAULT_MODEL
-	if modelFlag != "" {
-		model = modelFlag
+	if input.model != "" {
+		model = input.model
 	}
 
 	// Get the provider for the model
@@ -72,8 +68,23 @@ func main() {
 		log.Fatal(err)
 	}
 
-	// Make the query
-	response, err := client.QueryText(context.Background(), system, []string{reviewPrompt, prompt}, model, sqirvy.Options{})
+	// Make the query, splitting the input into chunks if requested
+	var response string
+	if input.chunk {
+		var merged *sqirvy.Response
+		merged, err = sqirvy.MapReduce(context.Background(), client, sqirvy.MapReduceQuery{
+			Model:     model,
+			System:    system,
+			Prompt:    reviewPrompt,
+			Documents: input.documents,
+			Options:   sqirvy.Options{MaxTokens: sqirvy.GetMaxTokens(model)},
+		})
+		if merged != nil {
+			response = merged.Text
+		}
+	} else {
+		response, err = client.QueryText(context.Background(), system, []string{reviewPrompt, input.prompt}, model, sqirvy.Options{})
+	}
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -0,0 +1,291 @@
+// Package cassette records and replays HTTP exchanges for offline tests.
+//
+// A Recorder is an http.RoundTripper. In Record mode it sends requests to the
+// network and saves each request and response to a cassette file, with API keys
+// and other secrets scrubbed. In Replay mode it answers requests from the file
+// without a network connection, so provider clients can be tested against
+// real responses that were captured once. Requests are matched by method, URL
+// path and query, and body, with JSON bodies compared by value.
+package cassette
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Mode selects whether a Recorder records or replays exchanges.
+type Mode int
+
+const (
+	Replay Mode = iota // Answer requests from the cassette file
+	Record             // Send requests to the network and save them to the cassette file
+)
+
+// Redacted replaces the values of secrets in a cassette.
+const Redacted = "REDACTED"
+
+// SecretHeaders are the headers whose values are scrubbed when recording.
+var SecretHeaders = []string{
+	"Authorization",
+	"X-Api-Key",
+	"X-Goog-Api-Key",
+	"Api-Key",
+	"Openai-Organization",
+	"Openai-Project",
+	"Cookie",
+	"Set-Cookie",
+}
+
+// SecretParams are the URL query parameters whose values are scrubbed when recording.
+var SecretParams = []string{"key", "api_key"}
+
+// Cassette is the contents of a cassette file.
+type Cassette struct {
+	Interactions []Interaction `yaml:"interactions"`
+}
+
+// Interaction is a recorded request and the response to it.
+type Interaction struct {
+	Request  Request  `yaml:"request"`
+	Response Response `yaml:"response"`
+}
+
+// Request is a recorded HTTP request.
+type Request struct {
+	Method  string      `yaml:"method"`
+	URL     string      `yaml:"url"`
+	Headers http.Header `yaml:"headers,omitempty"`
+	Body    string      `yaml:"body,omitempty"`
+}
+
+// Response is a recorded HTTP response.
+type Response struct {
+	StatusCode int         `yaml:"status"`
+	Headers    http.Header `yaml:"headers,omitempty"`
+	Body       string      `yaml:"body,omitempty"`
+}
+
+// Recorder records or replays the requests sent through it.
+type Recorder struct {
+	path      string
+	mode      Mode
+	transport http.RoundTripper // Sends requests when recording
+
+	mu       sync.Mutex
+	cassette Cassette
+	used     []bool // Interactions already replayed
+}
+
+// New creates a Recorder for the cassette file at path. In Replay mode the
+// file must exist. In Record mode the file is written by Stop.
+func New(path string, mode Mode) (*Recorder, error) {
+	r := &Recorder{path: path, mode: mode, transport: http.DefaultTransport}
+	if mode == Record {
+		return r, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read cassette: %w", err)
+	}
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&r.cassette); err != nil {
+		return nil, fmt.Errorf("failed to decode cassette %s: %w", path, err)
+	}
+	r.used = make([]bool, len(r.cassette.Interactions))
+	return r, nil
+}
+
+// Client returns an HTTP client that sends its requests through the Recorder.
+func (r *Recorder) Client() *http.Client {
+	return &http.Client{Transport: r}
+}
+
+// RoundTrip records or replays a single request.
+func (r *Recorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	body, err := readBody(req)
+	if err != nil {
+		return nil, err
+	}
+	if r.mode == Record {
+		return r.record(req, body)
+	}
+	return r.replay(req, body)
+}
+
+// record sends a request and saves the exchange
+func (r *Recorder) record(req *http.Request, body []byte) (*http.Response, error) {
+	resp, err := r.transport.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	respBody, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response: %w", err)
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(respBody))
+
+	interaction := Interaction{
+		Request: Request{
+			Method:  req.Method,
+			URL:     scrubURL(req.URL),
+			Headers: scrubHeaders(req.Header),
+			Body:    indentJSON(body),
+		},
+		Response: Response{
+			StatusCode: resp.StatusCode,
+			Headers:    scrubHeaders(resp.Header),
+			Body:       indentJSON(respBody),
+		},
+	}
+
+	r.mu.Lock()
+	r.cassette.Interactions = append(r.cassette.Interactions, interaction)
+	r.mu.Unlock()
+	return resp, nil
+}
+
+// replay answers a request with the first unused interaction that matches it
+func (r *Recorder) replay(req *http.Request, body []byte) (*http.Response, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	target := pathAndQuery(scrubURL(req.URL))
+	var mismatch string
+	for i, interaction := range r.cassette.Interactions {
+		if r.used[i] || interaction.Request.Method != req.Method || pathAndQuery(interaction.Request.URL) != target {
+			continue
+		}
+		if !sameBody(interaction.Request.Body, string(body)) {
+			mismatch = fmt.Sprintf("\nrecorded body:\n%s\nrequest body:\n%s", interaction.Request.Body, indentJSON(body))
+			continue
+		}
+
+		r.used[i] = true
+		recorded := interaction.Response
+		return &http.Response{
+			Status:        fmt.Sprintf("%d %s", recorded.StatusCode, http.StatusText(recorded.StatusCode)),
+			StatusCode:    recorded.StatusCode,
+			Proto:         "HTTP/1.1",
+			ProtoMajor:    1,
+			ProtoMinor:    1,
+			Header:        recorded.Headers.Clone(),
+			Body:          io.NopCloser(strings.NewReader(recorded.Body)),
+			ContentLength: int64(len(recorded.Body)),
+			Request:       req,
+		}, nil
+	}
+	return nil, fmt.Errorf("cassette %s has no interaction for %s %s%s", r.path, req.Method, target, mismatch)
+}
+
+// Stop finishes the Recorder. In Record mode it writes the cassette file, and
+// in Replay mode it returns an error if any interaction was not replayed.
+func (r *Recorder) Stop() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.mode == Replay {
+		unused := 0
+		for _, used := range r.used {
+			if !used {
+				unused++
+			}
+		}
+		if unused > 0 {
+			return fmt.Errorf("cassette %s has %d interactions that were not replayed", r.path, unused)
+		}
+		return nil
+	}
+
+	data, err := yaml.Marshal(r.cassette)
+	if err != nil {
+		return fmt.Errorf("failed to encode cassette: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(r.path), 0755); err != nil {
+		return fmt.Errorf("failed to create cassette directory: %w", err)
+	}
+	if err := os.WriteFile(r.path, data, 0644); err != nil {
+		return fmt.Errorf("failed to write cassette: %w", err)
+	}
+	return nil
+}
+
+// readBody reads the body of a request and replaces it so it can be sent
+func readBody(req *http.Request) ([]byte, error) {
+	if req.Body == nil {
+		return nil, nil
+	}
+	body, err := io.ReadAll(req.Body)
+	req.Body.Close()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read request: %w", err)
+	}
+	req.Body = io.NopCloser(bytes.NewReader(body))
+	return body, nil
+}
+
+// scrubHeaders returns a copy of headers with the values of secrets replaced
+func scrubHeaders(headers http.Header) http.Header {
+	scrubbed := headers.Clone()
+	for _, name := range SecretHeaders {
+		if _, ok := scrubbed[http.CanonicalHeaderKey(name)]; ok {
+			scrubbed.Set(name, Redacted)
+		}
+	}
+	return scrubbed
+}
+
+// scrubURL returns a URL with the values of secret query parameters replaced
+func scrubURL(u *url.URL) string {
+	scrubbed := *u
+	query := scrubbed.Query()
+	for _, name := range SecretParams {
+		if query.Has(name) {
+			query.Set(name, Redacted)
+		}
+	}
+	scrubbed.RawQuery = query.Encode()
+	return scrubbed.String()
+}
+
+// pathAndQuery returns the path and query of a recorded URL, so a cassette
+// recorded against one base URL can be replayed against another
+func pathAndQuery(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return rawURL
+	}
+	return u.RequestURI()
+}
+
+// indentJSON returns a JSON body indented for reading, or any other body unchanged
+func indentJSON(body []byte) string {
+	var buf bytes.Buffer
+	if json.Valid(body) && json.Indent(&buf, body, "", "  ") == nil {
+		return buf.String()
+	}
+	return string(body)
+}
+
+// sameBody reports whether two bodies are equal, comparing JSON bodies by value
+func sameBody(recorded, body string) bool {
+	var a, b any
+	if json.Unmarshal([]byte(recorded), &a) == nil && json.Unmarshal([]byte(body), &b) == nil {
+		ja, _ := json.Marshal(a)
+		jb, _ := json.Marshal(b)
+		return bytes.Equal(ja, jb)
+	}
+	return recorded == body
+}
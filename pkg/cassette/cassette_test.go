@@ -0,0 +1,88 @@
+package cassette
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRecordReplay(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Set-Cookie", "session=secret")
+		if strings.Contains(string(body), "fail") {
+			w.WriteHeader(http.StatusTooManyRequests)
+			io.WriteString(w, `{"error":"rate limited"}`)
+			return
+		}
+		io.WriteString(w, `{"reply":"hello"}`)
+	}))
+	defer ts.Close()
+	path := filepath.Join(t.TempDir(), "cassettes", "test.yaml")
+
+	// record two exchanges with secrets in a header and the query
+	recorder, err := New(path, Record)
+	if err != nil {
+		t.Fatalf("New(Record) error = %v", err)
+	}
+	send := func(client *http.Client, url, body string) (int, string) {
+		t.Helper()
+		req, _ := http.NewRequest(http.MethodPost, url, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer sk-secret")
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("Do() error = %v", err)
+		}
+		defer resp.Body.Close()
+		data, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(data)
+	}
+	if status, body := send(recorder.Client(), ts.URL+"/v1/chat?key=secret", `{"prompt":"hi"}`); status != 200 || body != `{"reply":"hello"}` {
+		t.Errorf("recorded response = %d %s", status, body)
+	}
+	send(recorder.Client(), ts.URL+"/v1/chat?key=secret", `{"prompt":"fail"}`)
+	if err := recorder.Stop(); err != nil {
+		t.Fatalf("Stop() error = %v", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("cassette not written: %v", err)
+	}
+	if strings.Contains(string(data), "secret") {
+		t.Errorf("cassette contains a secret:\n%s", data)
+	}
+
+	// replay against another host, with the JSON body written differently
+	replayer, err := New(path, Replay)
+	if err != nil {
+		t.Fatalf("New(Replay) error = %v", err)
+	}
+	status, body := send(replayer.Client(), "https://api.example.com/v1/chat?key=other", `{"prompt": "fail"}`)
+	if status != http.StatusTooManyRequests || !strings.Contains(body, "rate limited") {
+		t.Errorf("replayed response = %d %s", status, body)
+	}
+	if err := replayer.Stop(); err == nil {
+		t.Errorf("Stop() should report the interaction that was not replayed")
+	}
+	if status, _ := send(replayer.Client(), "https://api.example.com/v1/chat?key=other", `{"prompt":"hi"}`); status != 200 {
+		t.Errorf("replayed status = %d, want 200", status)
+	}
+	if err := replayer.Stop(); err != nil {
+		t.Errorf("Stop() error = %v", err)
+	}
+
+	// requests that were not recorded fail
+	req, _ := http.NewRequest(http.MethodPost, "https://api.example.com/v1/chat", strings.NewReader(`{"prompt":"new"}`))
+	if _, err := replayer.Client().Do(req); err == nil || !strings.Contains(err.Error(), "no interaction") {
+		t.Errorf("Do() error = %v, want no interaction", err)
+	}
+	if _, err := New(filepath.Join(t.TempDir(), "missing.yaml"), Replay); err == nil {
+		t.Errorf("New(Replay) should fail for a missing cassette")
+	}
+}
@@ -6,32 +6,64 @@
 package sqirvy
 
 import (
+	"bufio"
 	"context"
+	"encoding/json"
 	"fmt"
-	"os"
+	"net/http"
+	"net/url"
 	"strings"
+	"time"
 
 	"github.com/anthropics/anthropic-sdk-go"
+	"github.com/anthropics/anthropic-sdk-go/option"
 )
 
 // AnthropicClient implements the Client interface for Anthropic's API.
 // It provides methods for querying Anthropic's language models through
 // their official API client.
 type AnthropicClient struct {
-	client *anthropic.Client // Anthropic API client
+	client  *anthropic.Client // Anthropic API client
+	timeout time.Duration     // Default timeout of a query
 }
 
 // Ensure AnthropicClient implements the Client interface
 var _ Client = (*AnthropicClient)(nil)
 
+func init() {
+	mustRegister(Anthropic, func(opts ...ClientOption) (Client, error) { return NewAnthropicClient(opts...) }, anthropicModels)
+}
+
 // NewAnthropicClient creates a new instance of AnthropicClient.
-// It returns an error if the required ANTHROPIC_API_KEY environment variable is not set.
-func NewAnthropicClient() (*AnthropicClient, error) {
-	if os.Getenv("ANTHROPIC_API_KEY") == "" {
-		return nil, fmt.Errorf("ANTHROPIC_API_KEY environment variable not set")
+// The API key is taken from the options, or from the ANTHROPIC_API_KEY
+// environment variable if not set, and an error is returned if it is missing.
+// The base URL defaults to ANTHROPIC_BASE_URL or the SDK's production URL.
+func NewAnthropicClient(opts ...ClientOption) (*AnthropicClient, error) {
+	config := NewClientConfig(opts...)
+	apiKey, err := config.apiKey("ANTHROPIC_API_KEY")
+	if err != nil {
+		return nil, err
+	}
+
+	// retries are handled by withRetry so they follow Options.Retry
+	requestOptions := []option.RequestOption{
+		option.WithAPIKey(apiKey),
+		option.WithMaxRetries(0),
+	}
+	if baseURL := config.baseURL("ANTHROPIC_BASE_URL"); baseURL != "" {
+		requestOptions = append(requestOptions, option.WithBaseURL(baseURL))
 	}
+	if config.customHTTP() {
+		httpClient, err := config.NewHTTPClient()
+		if err != nil {
+			return nil, err
+		}
+		requestOptions = append(requestOptions, option.WithHTTPClient(httpClient))
+	}
+
 	return &AnthropicClient{
-		client: anthropic.NewClient(),
+		client:  anthropic.NewClient(requestOptions...),
+		timeout: config.Timeout,
 	}, nil
 }
 
@@ -39,12 +71,131 @@ func NewAnthropicClient() (*AnthropicClient, error) {
 // It accepts a prompt string, model identifier, and query options.
 // Returns the model's response as a string or an error if the query fails.
 func (c *AnthropicClient) QueryText(ctx context.Context, system string, prompts []string, model string, options Options) (string, error) {
+	return responseText(c.QueryMessages(ctx, system, UserMessages(prompts), model, options, nil))
+}
+
+// QueryStream sends a streaming text query to the specified Anthropic model.
+// Each text delta is passed to stream as it arrives and the complete response is returned.
+func (c *AnthropicClient) QueryStream(ctx context.Context, system string, prompts []string, model string, options Options, stream StreamFunc) (string, error) {
+	return responseText(c.QueryMessages(ctx, system, UserMessages(prompts), model, options, stream))
+}
+
+// QueryMessages sends a conversation to the specified Anthropic model and returns the reply.
+// If stream is not nil, each text delta is passed to it as it arrives.
+func (c *AnthropicClient) QueryMessages(ctx context.Context, system string, messages []Message, model string, options Options, stream StreamFunc) (*Response, error) {
+	ctx, cancel := queryContext(ctx, options, c.timeout)
+	defer cancel()
+
+	params, err := newAnthropicParams(ctx, system, messages, model, options)
+	if err != nil {
+		return nil, err
+	}
+
+	if stream != nil {
+		resp, err := withRetry(ctx, options.Retry, stream, func(stream StreamFunc) (*Response, error) {
+			return c.streamMessage(ctx, params, options.JSON != nil, stream)
+		})
+		return resp, newProviderError(Anthropic, err)
+	}
+
+	resp, err := withRetry(ctx, options.Retry, nil, func(StreamFunc) (*Response, error) {
+		return c.newMessage(ctx, params, options.JSON != nil)
+	})
+	return resp, newProviderError(Anthropic, err)
+}
+
+// newMessage sends a message request and waits for the complete response
+func (c *AnthropicClient) newMessage(ctx context.Context, params anthropic.MessageNewParams, jsonQuery bool) (*Response, error) {
+	// Create new message request with the provided prompt and temperature
+	message, err := c.client.Messages.New(ctx, params)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create message: %w", err)
+	}
+
+	// Verify we got a non-empty response
+	if len(message.Content) == 0 {
+		return nil, fmt.Errorf("no content in response")
+	}
+
+	return anthropicResponse(message, jsonQuery), nil
+}
+
+// streamMessage sends a streaming message request, passing each text delta to stream
+func (c *AnthropicClient) streamMessage(ctx context.Context, params anthropic.MessageNewParams, jsonQuery bool, stream StreamFunc) (*Response, error) {
+	events := c.client.Messages.NewStreaming(ctx, params)
+	// the stream has no decoder to close if the request itself failed
+	if err := events.Err(); err != nil {
+		return nil, fmt.Errorf("failed to stream message: %w", err)
+	}
+	defer events.Close()
+
+	// accumulate the events into a message to collect usage and stop reason
+	message := &anthropic.Message{}
+	for events.Next() {
+		event := events.Current()
+		if err := message.Accumulate(event); err != nil {
+			return nil, fmt.Errorf("failed to accumulate message: %w", err)
+		}
+
+		delta, ok := event.AsUnion().(anthropic.ContentBlockDeltaEvent)
+		if !ok {
+			continue
+		}
+		// a JSON query streams the input of the forced tool call as its response,
+		// the input of other tool calls is not part of the response text
+		chunk := delta.Delta.Text
+		if jsonQuery {
+			chunk += delta.Delta.PartialJSON
+		}
+		if chunk == "" {
+			continue
+		}
+		if err := stream(chunk); err != nil {
+			return nil, err
+		}
+	}
+	if err := events.Err(); err != nil {
+		return nil, fmt.Errorf("failed to stream message: %w", err)
+	}
+
+	return anthropicResponse(message, jsonQuery), nil
+}
+
+// anthropicResponse converts a message into a Response.
+// For a JSON query the input of the forced tool call is the response text.
+func anthropicResponse(message *anthropic.Message, jsonQuery bool) *Response {
+	// Build response using strings.Builder for better performance
+	var response strings.Builder
+	var toolCalls []ToolCall
+	for _, content := range message.Content {
+		switch {
+		case content.Type == anthropic.ContentBlockTypeToolUse && jsonQuery:
+			response.Write(content.Input)
+		case content.Type == anthropic.ContentBlockTypeToolUse:
+			toolCalls = append(toolCalls, ToolCall{ID: content.ID, Name: content.Name, Arguments: content.Input})
+		default:
+			response.WriteString(content.Text)
+		}
+	}
+
+	return &Response{
+		Text:         response.String(),
+		Model:        string(message.Model),
+		FinishReason: string(message.StopReason),
+		InputTokens:  message.Usage.InputTokens,
+		OutputTokens: message.Usage.OutputTokens,
+		ToolCalls:    toolCalls,
+	}
+}
+
+// newAnthropicParams validates the query parameters and builds the message request
+func newAnthropicParams(ctx context.Context, system string, messages []Message, model string, options Options) (anthropic.MessageNewParams, error) {
 	if ctx.Err() != nil {
-		return "", fmt.Errorf("request context error %w", ctx.Err())
+		return anthropic.MessageNewParams{}, fmt.Errorf("request context error %w", ctx.Err())
 	}
 
-	if len(prompts) == 0 {
-		return "", fmt.Errorf("prompts cannot be empty for text query")
+	if err := validateMessages(messages); err != nil {
+		return anthropic.MessageNewParams{}, err
 	}
 
 	// set default and validate temperature
@@ -52,7 +203,7 @@ func (c *AnthropicClient) QueryText(ctx context.Context, system string, prompts
 		options.Temperature = MinTemperature
 	}
 	if options.Temperature > MaxTemperature {
-		return "", fmt.Errorf("temperature must be between %.1f and %.1f", MinTemperature, MaxTemperature)
+		return anthropic.MessageNewParams{}, fmt.Errorf("temperature must be between %.1f and %.1f", MinTemperature, MaxTemperature)
 	}
 	// scale temperature for Claude 0..1.0
 	options.Temperature /= MaxTemperature
@@ -69,37 +220,111 @@ func (c *AnthropicClient) QueryText(ctx context.Context, system string, prompts
 		anthropic.NewTextBlock(system),
 	}
 
-	// addtional prompts are user messages
-	messages := make([]anthropic.MessageParam, 0, len(prompts))
-	for _, p := range prompts {
-		messages = append(messages, anthropic.NewUserMessage(anthropic.NewTextBlock(p)))
+	// conversation messages keep their user or assistant role,
+	// tool results are sent back in a user message
+	params := make([]anthropic.MessageParam, 0, len(messages))
+	var results []anthropic.ContentBlockParamUnion
+	for i, m := range messages {
+		switch m.Role {
+		case RoleTool:
+			results = append(results, anthropic.NewToolResultBlock(m.ToolCallID, m.Content, false))
+			// the results of one turn's tool calls go back together
+			if i+1 == len(messages) || messages[i+1].Role != RoleTool {
+				params = append(params, anthropic.NewUserMessage(results...))
+				results = nil
+			}
+		case RoleAssistant:
+			blocks, err := anthropicAssistantBlocks(m)
+			if err != nil {
+				return anthropic.MessageNewParams{}, err
+			}
+			params = append(params, anthropic.NewAssistantMessage(blocks...))
+		default:
+			params = append(params, anthropic.NewUserMessage(anthropic.NewTextBlock(m.Content)))
+		}
 	}
 
-	// Create new message request with the provided prompt and temperature
-	message, err := c.client.Messages.New(ctx, anthropic.MessageNewParams{
+	request := anthropic.MessageNewParams{
 		Model:       anthropic.F(model),                        // Specify which model to use
 		MaxTokens:   anthropic.F(maxTokens),                    // Limit response length
 		Temperature: anthropic.F(float64(options.Temperature)), // Set temperature
 		System:      anthropic.F(systemPrompt),
 		Messages: anthropic.F(
-			messages,
+			params,
 		),
-	})
-	if err != nil {
-		return "", fmt.Errorf("failed to create message: %w", err)
 	}
 
-	// Verify we got a non-empty response
-	if len(message.Content) == 0 {
-		return "", fmt.Errorf("no content in response")
+	// Claude has no JSON mode, so a JSON query forces a call to a tool
+	// whose input schema is the response schema
+	if options.JSON != nil {
+		if len(options.Tools) > 0 {
+			return anthropic.MessageNewParams{}, fmt.Errorf("a JSON query cannot use tools with Anthropic models")
+		}
+		inputSchema, err := anthropicInputSchema(options.JSON.Schema)
+		if err != nil {
+			return anthropic.MessageNewParams{}, err
+		}
+		name := options.JSON.name()
+		request.Tools = anthropic.F([]anthropic.ToolParam{{
+			Name:        anthropic.F(name),
+			Description: anthropic.F("Respond by calling this tool with the response as its input."),
+			InputSchema: anthropic.F[interface{}](inputSchema),
+		}})
+		request.ToolChoice = anthropic.F[anthropic.ToolChoiceUnionParam](anthropic.ToolChoiceToolParam{
+			Type: anthropic.F(anthropic.ToolChoiceToolTypeTool),
+			Name: anthropic.F(name),
+		})
 	}
 
-	// Build response using strings.Builder for better performance
-	var response strings.Builder
-	for _, content := range message.Content {
-		response.WriteString(content.Text)
+	if len(options.Tools) > 0 {
+		tools := make([]anthropic.ToolParam, 0, len(options.Tools))
+		for _, t := range options.Tools {
+			inputSchema, err := anthropicInputSchema(t.parameters())
+			if err != nil {
+				return anthropic.MessageNewParams{}, fmt.Errorf("tool %s: %w", t.Name, err)
+			}
+			tools = append(tools, anthropic.ToolParam{
+				Name:        anthropic.F(t.Name),
+				Description: anthropic.F(t.Description),
+				InputSchema: anthropic.F[interface{}](inputSchema),
+			})
+		}
+		request.Tools = anthropic.F(tools)
 	}
-	return response.String(), nil
+
+	return request, nil
+}
+
+// anthropicAssistantBlocks converts an assistant message and its tool calls into content blocks
+func anthropicAssistantBlocks(m Message) ([]anthropic.ContentBlockParamUnion, error) {
+	var blocks []anthropic.ContentBlockParamUnion
+	// empty text blocks are rejected, a message with tool calls may have no text
+	if m.Content != "" || len(m.ToolCalls) == 0 {
+		blocks = append(blocks, anthropic.NewTextBlock(m.Content))
+	}
+	for _, call := range m.ToolCalls {
+		args, err := toolArguments(call)
+		if err != nil {
+			return nil, err
+		}
+		blocks = append(blocks, anthropic.NewToolUseBlockParam(call.ID, call.Name, args))
+	}
+	return blocks, nil
+}
+
+// anthropicInputSchema returns a tool input schema.
+// Tool inputs are always objects, so an empty schema accepts any object.
+func anthropicInputSchema(schema json.RawMessage) (map[string]any, error) {
+	if _, err := parseSchema(schema); err != nil {
+		return nil, err
+	}
+	inputSchema := map[string]any{"type": "object"}
+	if len(schema) > 0 {
+		if err := json.Unmarshal(schema, &inputSchema); err != nil {
+			return nil, fmt.Errorf("invalid JSON schema: %w", err)
+		}
+	}
+	return inputSchema, nil
 }
 
 // Close implements the Close method for the Client interface.
@@ -107,3 +332,116 @@ func (c *AnthropicClient) Close() error {
 	// the anthropic client does not require explicit close
 	return nil
 }
+
+// Ensure AnthropicClient implements the BatchClient interface
+var _ BatchClient = (*AnthropicClient)(nil)
+
+// SubmitBatch creates a Message Batch of the requests. JSON queries are not
+// supported, because the response text of a batch result cannot be told apart
+// from a tool call without the options of its query.
+func (c *AnthropicClient) SubmitBatch(ctx context.Context, requests []BatchRequest) (*BatchStatus, error) {
+	if err := validateBatch(requests); err != nil {
+		return nil, err
+	}
+
+	batchRequests := make([]anthropic.MessageBatchNewParamsRequest, 0, len(requests))
+	for _, r := range requests {
+		if r.Options.JSON != nil {
+			return nil, fmt.Errorf("batch request %s: JSON queries are not supported in Anthropic batches", r.ID)
+		}
+		params, err := newAnthropicParams(ctx, r.System, r.Messages, r.Model, r.Options)
+		if err != nil {
+			return nil, fmt.Errorf("batch request %s: %w", r.ID, err)
+		}
+		batchRequests = append(batchRequests, anthropic.MessageBatchNewParamsRequest{
+			CustomID: anthropic.F(r.ID),
+			Params:   anthropic.F(params),
+		})
+	}
+
+	batch, err := c.client.Messages.Batches.New(ctx, anthropic.MessageBatchNewParams{
+		Requests: anthropic.F(batchRequests),
+	})
+	if err != nil {
+		return nil, newProviderError(Anthropic, fmt.Errorf("failed to create message batch: %w", err))
+	}
+	return anthropicBatchStatus(batch), nil
+}
+
+// BatchStatus returns the status of a Message Batch.
+func (c *AnthropicClient) BatchStatus(ctx context.Context, id string) (*BatchStatus, error) {
+	batch, err := c.client.Messages.Batches.Get(ctx, url.PathEscape(id))
+	if err != nil {
+		return nil, newProviderError(Anthropic, fmt.Errorf("failed to get message batch: %w", err))
+	}
+	return anthropicBatchStatus(batch), nil
+}
+
+// BatchResults downloads the results of an ended Message Batch.
+func (c *AnthropicClient) BatchResults(ctx context.Context, id string) ([]BatchResult, error) {
+	resp, err := c.client.Messages.Batches.Results(ctx, url.PathEscape(id))
+	if err != nil {
+		return nil, newProviderError(Anthropic, fmt.Errorf("failed to get message batch results: %w", err))
+	}
+	defer resp.Body.Close()
+
+	var results []BatchResult
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(nil, 64<<20)
+	for scanner.Scan() {
+		if len(strings.TrimSpace(scanner.Text())) == 0 {
+			continue
+		}
+		var individual anthropic.MessageBatchIndividualResponse
+		if err := json.Unmarshal(scanner.Bytes(), &individual); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal batch result: %w", err)
+		}
+
+		result := BatchResult{ID: individual.CustomID}
+		switch individual.Result.Type {
+		case anthropic.MessageBatchResultTypeSucceeded:
+			result.Response = anthropicResponse(&individual.Result.Message, false)
+		case anthropic.MessageBatchResultTypeErrored:
+			// an errored result has the body of the error response without its status
+			errObject := individual.Result.Error.Error
+			result.Err = newProviderError(Anthropic, &StatusError{
+				StatusCode: anthropicErrorStatus[string(errObject.Type)],
+				Body:       fmt.Sprintf("%s: %s", errObject.Type, errObject.Message),
+			})
+		default:
+			result.Err = newProviderError(Anthropic, fmt.Errorf("request %s", individual.Result.Type))
+		}
+		results = append(results, result)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read batch results: %w", err)
+	}
+	return results, nil
+}
+
+// anthropicErrorStatus maps the error types of the Anthropic API to the HTTP
+// status of their responses
+var anthropicErrorStatus = map[string]int{
+	"invalid_request_error": http.StatusBadRequest,
+	"authentication_error":  http.StatusUnauthorized,
+	"permission_error":      http.StatusForbidden,
+	"not_found_error":       http.StatusNotFound,
+	"request_too_large":     http.StatusRequestEntityTooLarge,
+	"rate_limit_error":      http.StatusTooManyRequests,
+	"api_error":             http.StatusInternalServerError,
+	"overloaded_error":      529,
+}
+
+// anthropicBatchStatus converts a Message Batch into a BatchStatus
+func anthropicBatchStatus(batch *anthropic.MessageBatch) *BatchStatus {
+	counts := batch.RequestCounts
+	return &BatchStatus{
+		ID:        batch.ID,
+		Provider:  Anthropic,
+		Status:    string(batch.ProcessingStatus),
+		Done:      batch.ProcessingStatus == anthropic.MessageBatchProcessingStatusEnded,
+		Succeeded: int(counts.Succeeded),
+		Failed:    int(counts.Errored + counts.Canceled + counts.Expired),
+		Pending:   int(counts.Processing),
+	}
+}
@@ -2,6 +2,7 @@ package sqirvy
 
 import (
 	"context"
+	"encoding/json"
 	"os"
 	"strings"
 	"testing"
@@ -60,3 +61,51 @@ func TestAnthropicClient_QueryText(t *testing.T) {
 		t.Log(response)
 	})
 }
+
+func TestAnthropicClient_Replay(t *testing.T) {
+	add := newAddTool(t).Tool
+	runCassetteTests(t, Anthropic, []cassetteTest{
+		{
+			cassette: "anthropic_text",
+			model:    "claude-3-5-haiku-latest",
+			prompts:  []string{"Say hello"},
+			want:     Response{Text: "Hello! How can I help you today?", Model: "claude-3-5-haiku-20241022", FinishReason: "end_turn", InputTokens: 15, OutputTokens: 12},
+		},
+		{
+			cassette: "anthropic_stream",
+			model:    "claude-3-5-haiku-latest",
+			prompts:  []string{"Say hello"},
+			stream:   true,
+			want:     Response{Text: "Hello! How can I help you today?", Model: "claude-3-5-haiku-20241022", FinishReason: "end_turn", InputTokens: 15, OutputTokens: 12},
+		},
+		{
+			cassette: "anthropic_max_tokens",
+			model:    "claude-3-5-haiku-latest",
+			prompts:  []string{"Write a story about a squirrel"},
+			options:  Options{MaxTokens: 8},
+			want:     Response{Text: "In the heart of an old oak", Model: "claude-3-5-haiku-20241022", FinishReason: "max_tokens", InputTokens: 18, OutputTokens: 8},
+		},
+		{
+			// text before a tool call is returned along with the call
+			cassette: "anthropic_tool_use",
+			model:    "claude-3-5-haiku-latest",
+			prompts:  []string{"What is 2 + 3?"},
+			options:  Options{Tools: []Tool{add}},
+			want: Response{Text: "I'll add those numbers for you.", Model: "claude-3-5-haiku-20241022", FinishReason: "tool_use", InputTokens: 402, OutputTokens: 71,
+				ToolCalls: []ToolCall{{ID: "toolu_01A09q90qw90lq917835lq9", Name: "add", Arguments: json.RawMessage(`{"a":2,"b":3}`)}}},
+		},
+		{
+			cassette: "anthropic_auth_error",
+			model:    "claude-3-5-haiku-latest",
+			prompts:  []string{"Say hello"},
+			apiKey:   "sk-ant-invalid",
+			wantErr:  ErrAuthentication,
+		},
+		{
+			cassette: "anthropic_model_not_found",
+			model:    "claude-0-nope",
+			prompts:  []string{"Say hello"},
+			wantErr:  ErrModelNotFound,
+		},
+	})
+}
@@ -0,0 +1,108 @@
+// Package sqirvy provides access to the batch APIs of providers.
+//
+// This file defines BatchClient, implemented by the clients of providers that
+// accept a batch of queries to process asynchronously at a lower price, such as
+// the OpenAI Batch API and Anthropic Message Batches. A batch is submitted
+// once, its status polled until it ends, and its results collected and mapped
+// back to the ID of each request.
+package sqirvy
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// DefaultBatchPollInterval is the time between status requests of WaitBatch
+// when no interval is given
+const DefaultBatchPollInterval = 30 * time.Second
+
+// BatchRequest is one query of a batch.
+type BatchRequest struct {
+	ID       string    // Identifies the request and its result, unique within the batch
+	Model    string    // Model name
+	System   string    // System prompt
+	Messages []Message // Conversation sent to the model
+	Options  Options   // Temperature, MaxTokens, JSON and Tools of the query, Retry and Timeout are not used
+}
+
+// BatchStatus is the progress of a submitted batch.
+type BatchStatus struct {
+	ID        string // Batch identifier assigned by the provider
+	Provider  string // Provider processing the batch
+	Status    string // Provider specific status, such as in_progress or ended
+	Done      bool   // The batch has ended and its results can be collected
+	Succeeded int    // Requests that succeeded so far
+	Failed    int    // Requests that failed, expired or were cancelled so far
+	Pending   int    // Requests still processing
+}
+
+func (s *BatchStatus) String() string {
+	return fmt.Sprintf("%s batch %s %s: %d succeeded, %d failed, %d pending",
+		s.Provider, s.ID, s.Status, s.Succeeded, s.Failed, s.Pending)
+}
+
+// BatchResult is the outcome of one request of a batch.
+// Exactly one of Response and Err is set.
+type BatchResult struct {
+	ID       string    // ID of the request
+	Response *Response // Response of the model
+	Err      error     // Error of the request, a *ProviderError when the provider reported it
+}
+
+// BatchClient is implemented by the clients of providers with a batch API.
+// Use a type assertion on a Client to find out if it supports batches:
+//
+//	batcher, ok := client.(sqirvy.BatchClient)
+type BatchClient interface {
+	// SubmitBatch creates a batch of requests and returns its initial status.
+	SubmitBatch(ctx context.Context, requests []BatchRequest) (*BatchStatus, error)
+
+	// BatchStatus returns the current status of a batch.
+	BatchStatus(ctx context.Context, id string) (*BatchStatus, error)
+
+	// BatchResults returns the results of an ended batch, in no particular order.
+	BatchResults(ctx context.Context, id string) ([]BatchResult, error)
+}
+
+// WaitBatch polls the status of a batch every interval until it is done or ctx
+// is done, passing each status to onStatus if it is not nil. A zero interval
+// uses DefaultBatchPollInterval.
+func WaitBatch(ctx context.Context, client BatchClient, id string, interval time.Duration, onStatus func(*BatchStatus)) (*BatchStatus, error) {
+	if interval <= 0 {
+		interval = DefaultBatchPollInterval
+	}
+	for {
+		status, err := client.BatchStatus(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		if onStatus != nil {
+			onStatus(status)
+		}
+		if status.Done {
+			return status, nil
+		}
+		if err := sleepContext(ctx, interval); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// validateBatch checks that a batch has requests with unique, non-empty IDs
+func validateBatch(requests []BatchRequest) error {
+	if len(requests) == 0 {
+		return fmt.Errorf("batch has no requests")
+	}
+	ids := make(map[string]bool, len(requests))
+	for _, request := range requests {
+		if request.ID == "" {
+			return fmt.Errorf("batch request without an ID")
+		}
+		if ids[request.ID] {
+			return fmt.Errorf("duplicate batch request ID %s", request.ID)
+		}
+		ids[request.ID] = true
+	}
+	return nil
+}
@@ -0,0 +1,266 @@
+package sqirvy
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// batchRequests returns the requests sent to each batch stand-in: two that
+// succeed, and one the stand-in fails
+func batchRequests(model string) []BatchRequest {
+	var requests []BatchRequest
+	for _, id := range []string{"q1", "q2", "bad"} {
+		requests = append(requests, BatchRequest{
+			ID:       id,
+			Model:    model,
+			System:   assistant,
+			Messages: UserMessages([]string{"prompt " + id}),
+			Options:  Options{Temperature: 50},
+		})
+	}
+	return requests
+}
+
+// checkBatchResults checks that the results echo the prompts of q1 and q2
+// and that bad failed with wantErr
+func checkBatchResults(t *testing.T, results []BatchResult, wantErr error) {
+	t.Helper()
+	sort.Slice(results, func(i, j int) bool { return results[i].ID < results[j].ID })
+	if len(results) != 3 {
+		t.Fatalf("BatchResults() returned %d results, want 3", len(results))
+	}
+	if !errors.Is(results[0].Err, wantErr) || results[0].ID != "bad" {
+		t.Errorf("result %s error = %v, want %v", results[0].ID, results[0].Err, wantErr)
+	}
+	for _, result := range results[1:] {
+		if result.Err != nil || result.Response == nil || result.Response.Text != "prompt "+result.ID || result.Response.OutputTokens != 2 {
+			t.Errorf("result %s = %+v, %v, want its prompt", result.ID, result.Response, result.Err)
+		}
+	}
+}
+
+// newOpenAIBatchServer returns a stand-in for the OpenAI files and batches
+// endpoints. The batch is in progress on the first two status requests and
+// completed after them, answering each request with its last message.
+func newOpenAIBatchServer(t *testing.T) *httptest.Server {
+	var input []openAIBatchLine
+	polls := 0
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer test-key" {
+			http.Error(w, `{"error":{"message":"invalid api key"}}`, http.StatusUnauthorized)
+			return
+		}
+		batch := `{"id":"batch_1","status":"%s","output_file_id":"file-out","error_file_id":"file-err","request_counts":{"total":3,"completed":%d,"failed":%d}}`
+		switch r.Method + " " + r.URL.Path {
+		case "POST /v1/files":
+			file, _, err := r.FormFile("file")
+			if err != nil || r.FormValue("purpose") != "batch" {
+				http.Error(w, `{"error":{"message":"bad upload"}}`, http.StatusBadRequest)
+				return
+			}
+			scanner := bufio.NewScanner(file)
+			for scanner.Scan() {
+				var line openAIBatchLine
+				if err := json.Unmarshal(scanner.Bytes(), &line); err != nil || line.URL != "/v1/chat/completions" {
+					t.Errorf("batch input line %s: %v", scanner.Text(), err)
+				}
+				input = append(input, line)
+			}
+			fmt.Fprint(w, `{"id":"file-in","purpose":"batch"}`)
+		case "POST /v1/batches":
+			body, _ := io.ReadAll(r.Body)
+			if !strings.Contains(string(body), `"input_file_id":"file-in"`) {
+				t.Errorf("batch request %s does not name the input file", body)
+			}
+			fmt.Fprintf(w, batch, "validating", 0, 0)
+		case "GET /v1/batches/batch_1":
+			if polls++; polls <= 2 {
+				fmt.Fprintf(w, batch, "in_progress", 1, 0)
+				return
+			}
+			fmt.Fprintf(w, batch, "completed", 2, 1)
+		case "GET /v1/files/file-out/content", "GET /v1/files/file-err/content":
+			for _, line := range input {
+				if (line.CustomID == "bad") != strings.Contains(r.URL.Path, "err") {
+					continue
+				}
+				if line.CustomID == "bad" {
+					fmt.Fprintf(w, `{"custom_id":"bad","response":{"status_code":404,"body":{"error":{"message":"The model does not exist"}}}}`+"\n")
+					continue
+				}
+				prompt := line.Body.Messages[len(line.Body.Messages)-1].Content
+				fmt.Fprintf(w, `{"custom_id":%q,"response":{"status_code":200,"body":{"model":%q,"choices":[{"message":{"content":%q},"finish_reason":"stop"}],"usage":{"prompt_tokens":10,"completion_tokens":2}}}}`+"\n",
+					line.CustomID, line.Body.Model, prompt)
+			}
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+}
+
+func TestOpenAIClient_Batch(t *testing.T) {
+	ts := newOpenAIBatchServer(t)
+	defer ts.Close()
+	client, err := NewOpenAIClient(WithAPIKey("test-key"), WithBaseURL(ts.URL))
+	if err != nil {
+		t.Fatalf("NewOpenAIClient() error = %v", err)
+	}
+	ctx := context.Background()
+
+	status, err := client.SubmitBatch(ctx, batchRequests("gpt-4o-mini"))
+	if err != nil || status.ID != "batch_1" || status.Done {
+		t.Fatalf("SubmitBatch() = %+v, %v", status, err)
+	}
+	if _, err := client.BatchResults(ctx, status.ID); err == nil {
+		t.Errorf("BatchResults() of a batch in progress should fail")
+	}
+
+	var polls []string
+	status, err = WaitBatch(ctx, client, status.ID, time.Millisecond, func(s *BatchStatus) { polls = append(polls, s.Status) })
+	if err != nil || !status.Done || status.Succeeded != 2 || status.Failed != 1 || len(polls) != 2 {
+		t.Fatalf("WaitBatch() = %+v, %v after polls %q", status, err, polls)
+	}
+
+	results, err := client.BatchResults(ctx, status.ID)
+	if err != nil {
+		t.Fatalf("BatchResults() error = %v", err)
+	}
+	checkBatchResults(t, results, ErrModelNotFound)
+
+	// requests are validated before anything is uploaded
+	if _, err := client.SubmitBatch(ctx, nil); err == nil {
+		t.Errorf("SubmitBatch() of no requests should fail")
+	}
+	duplicate := append(batchRequests("gpt-4o-mini"), BatchRequest{ID: "q1", Messages: UserMessages([]string{"again"})})
+	if _, err := client.SubmitBatch(ctx, duplicate); err == nil {
+		t.Errorf("SubmitBatch() with a duplicate ID should fail")
+	}
+}
+
+// newAnthropicBatchServer returns a stand-in for the Anthropic Message Batches
+// endpoints, with a batch that has ended and answers each request with its
+// last message
+func newAnthropicBatchServer(t *testing.T) *httptest.Server {
+	type batchRequest struct {
+		CustomID string `json:"custom_id"`
+		Params   struct {
+			Model    string `json:"model"`
+			Messages []struct {
+				Content []struct {
+					Text string `json:"text"`
+				} `json:"content"`
+			} `json:"messages"`
+		} `json:"params"`
+	}
+	var requests []batchRequest
+	batch := `{"id":"msgbatch_1","type":"message_batch","processing_status":"%s","request_counts":{"processing":%d,"succeeded":%d,"errored":%d,"canceled":0,"expired":0},"created_at":"2024-09-24T18:37:24Z","expires_at":"2024-09-25T18:37:24Z"}`
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.Method + " " + r.URL.Path {
+		case "POST /v1/messages/batches":
+			var body struct {
+				Requests []batchRequest `json:"requests"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+				t.Errorf("batch request: %v", err)
+			}
+			requests = body.Requests
+			fmt.Fprintf(w, batch, "in_progress", len(requests), 0, 0)
+		case "GET /v1/messages/batches/msgbatch_1":
+			fmt.Fprintf(w, batch, "ended", 0, 2, 1)
+		case "GET /v1/messages/batches/msgbatch_1/results":
+			for _, request := range requests {
+				if request.CustomID == "bad" {
+					fmt.Fprintln(w, `{"custom_id":"bad","result":{"type":"errored","error":{"type":"error","error":{"type":"not_found_error","message":"model: not found"}}}}`)
+					continue
+				}
+				messages := request.Params.Messages
+				prompt := messages[len(messages)-1].Content[0].Text
+				fmt.Fprintf(w, `{"custom_id":%q,"result":{"type":"succeeded","message":{"id":"msg_1","type":"message","role":"assistant","model":%q,"content":[{"type":"text","text":%q}],"stop_reason":"end_turn","usage":{"input_tokens":10,"output_tokens":2}}}}`+"\n",
+					request.CustomID, request.Params.Model, prompt)
+			}
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+}
+
+func TestAnthropicClient_Batch(t *testing.T) {
+	ts := newAnthropicBatchServer(t)
+	defer ts.Close()
+	client, err := NewAnthropicClient(WithAPIKey("test-key"), WithBaseURL(ts.URL))
+	if err != nil {
+		t.Fatalf("NewAnthropicClient() error = %v", err)
+	}
+	ctx := context.Background()
+
+	status, err := client.SubmitBatch(ctx, batchRequests("claude-3-5-haiku-latest"))
+	if err != nil || status.ID != "msgbatch_1" || status.Done || status.Pending != 3 {
+		t.Fatalf("SubmitBatch() = %+v, %v", status, err)
+	}
+	status, err = WaitBatch(ctx, client, status.ID, time.Millisecond, nil)
+	if err != nil || !status.Done || status.Succeeded != 2 || status.Failed != 1 {
+		t.Fatalf("WaitBatch() = %+v, %v", status, err)
+	}
+
+	results, err := client.BatchResults(ctx, status.ID)
+	if err != nil {
+		t.Fatalf("BatchResults() error = %v", err)
+	}
+	checkBatchResults(t, results, ErrModelNotFound)
+
+	// JSON queries cannot be mapped back from batch results
+	jsonRequest := batchRequests("claude-3-5-haiku-latest")[:1]
+	jsonRequest[0].Options.JSON = &JSONSchema{}
+	if _, err := client.SubmitBatch(ctx, jsonRequest); err == nil {
+		t.Errorf("SubmitBatch() of a JSON query should fail")
+	}
+}
+
+func TestBatch_EscapesID(t *testing.T) {
+	var paths []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.EscapedPath())
+		http.NotFound(w, r)
+	}))
+	defer ts.Close()
+	openai, err := NewOpenAIClient(WithAPIKey("test-key"), WithBaseURL(ts.URL))
+	if err != nil {
+		t.Fatalf("NewOpenAIClient() error = %v", err)
+	}
+	anthropic, err := NewAnthropicClient(WithAPIKey("test-key"), WithBaseURL(ts.URL))
+	if err != nil {
+		t.Fatalf("NewAnthropicClient() error = %v", err)
+	}
+	ctx := context.Background()
+
+	// an id cannot reach another endpoint
+	id := "a/../b?c"
+	for _, client := range []BatchClient{openai, anthropic} {
+		if _, err := client.BatchStatus(ctx, id); err == nil {
+			t.Errorf("%T.BatchStatus() should fail", client)
+		}
+		if _, err := client.BatchResults(ctx, id); err == nil {
+			t.Errorf("%T.BatchResults() should fail", client)
+		}
+	}
+	want := []string{
+		"/v1/batches/a%2F..%2Fb%3Fc",
+		"/v1/batches/a%2F..%2Fb%3Fc",
+		"/v1/messages/batches/a%2F..%2Fb%3Fc",
+		"/v1/messages/batches/a%2F..%2Fb%3Fc/results",
+	}
+	if strings.Join(paths, "\n") != strings.Join(want, "\n") {
+		t.Errorf("requested paths %q, want %q", paths, want)
+	}
+}
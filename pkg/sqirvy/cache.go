@@ -0,0 +1,294 @@
+// Package sqirvy provides a response cache for any client.
+//
+// This file implements CachedClient, which wraps a Client and answers repeated
+// queries from a cache instead of the provider. Queries are keyed on the model,
+// system prompt, messages and the options that change the response. Responses
+// are kept in an in-memory LRU and, if a directory is configured, in a disk
+// store shared between processes, with limits on their age and size.
+package sqirvy
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultCacheEntries is the number of responses kept in memory when
+// CacheConfig.MaxEntries is not set
+const DefaultCacheEntries = 256
+
+// CacheConfig sets where a CachedClient stores responses and for how long.
+type CacheConfig struct {
+	Dir        string                                // Directory of the disk store, empty to cache in memory only
+	TTL        time.Duration                         // Age after which a response is queried again, zero for no limit
+	MaxEntries int                                   // Responses kept in memory, zero for DefaultCacheEntries
+	MaxBytes   int64                                 // Total size of the disk store, zero for no limit
+	OnHit      func(model string, age time.Duration) // Called when a query is answered from the cache, may be nil
+}
+
+// DefaultCacheDir returns the directory of the disk store used by the
+// command line tool, sqirvy under the user's cache directory (~/.cache/sqirvy on Linux).
+func DefaultCacheDir() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to find cache directory: %w", err)
+	}
+	return filepath.Join(dir, "sqirvy"), nil
+}
+
+// CachedClient implements the Client interface by answering queries from a
+// cache, and passing the queries it has not seen to the client it wraps.
+// Only successful responses are cached.
+type CachedClient struct {
+	client Client
+	config CacheConfig
+
+	mu      sync.Mutex
+	order   *list.List               // Keys in memory, most recently used first
+	entries map[string]*list.Element // Elements of order by key, holding a *cacheEntry
+}
+
+// Ensure CachedClient implements the Client interface
+var _ Client = (*CachedClient)(nil)
+
+// cacheEntry is a cached response, stored as JSON on disk
+type cacheEntry struct {
+	Key      string    `json:"key"`
+	Created  time.Time `json:"created"`
+	Response Response  `json:"response"`
+}
+
+// cacheKey holds everything that changes the response to a query
+type cacheKey struct {
+	Model       string      `json:"model"`
+	System      string      `json:"system"`
+	Messages    []Message   `json:"messages"`
+	Temperature float32     `json:"temperature"`
+	MaxTokens   int64       `json:"max_tokens"`
+	JSON        *JSONSchema `json:"json,omitempty"`
+	Tools       []Tool      `json:"tools,omitempty"`
+}
+
+// NewCachedClient wraps client with a response cache.
+// The disk store directory is created if it does not exist.
+func NewCachedClient(client Client, config CacheConfig) (*CachedClient, error) {
+	if config.MaxEntries <= 0 {
+		config.MaxEntries = DefaultCacheEntries
+	}
+	if config.Dir != "" {
+		if err := os.MkdirAll(config.Dir, 0700); err != nil {
+			return nil, fmt.Errorf("failed to create cache directory: %w", err)
+		}
+	}
+	return &CachedClient{
+		client:  client,
+		config:  config,
+		order:   list.New(),
+		entries: make(map[string]*list.Element),
+	}, nil
+}
+
+// QueryText answers a text query from the cache or the wrapped client.
+func (c *CachedClient) QueryText(ctx context.Context, system string, prompts []string, model string, options Options) (string, error) {
+	return responseText(c.QueryMessages(ctx, system, UserMessages(prompts), model, options, nil))
+}
+
+// QueryStream answers a streaming text query from the cache or the wrapped client.
+func (c *CachedClient) QueryStream(ctx context.Context, system string, prompts []string, model string, options Options, stream StreamFunc) (string, error) {
+	return responseText(c.QueryMessages(ctx, system, UserMessages(prompts), model, options, stream))
+}
+
+// QueryMessages answers a conversation from the cache if the same query was
+// answered before, passing a cached response to stream as a single chunk.
+// Otherwise the query is sent to the wrapped client and its response cached.
+func (c *CachedClient) QueryMessages(ctx context.Context, system string, messages []Message, model string, options Options, stream StreamFunc) (*Response, error) {
+	key, err := queryCacheKey(system, messages, model, options)
+	if err != nil {
+		return nil, err
+	}
+
+	if entry := c.get(key); entry != nil {
+		if c.config.OnHit != nil {
+			c.config.OnHit(model, time.Since(entry.Created))
+		}
+		response := entry.Response
+		if stream != nil && response.Text != "" {
+			if err := stream(response.Text); err != nil {
+				return nil, err
+			}
+		}
+		return &response, nil
+	}
+
+	response, err := c.client.QueryMessages(ctx, system, messages, model, options, stream)
+	if err != nil {
+		return nil, err
+	}
+	c.put(&cacheEntry{Key: key, Created: time.Now(), Response: *response})
+	return response, nil
+}
+
+// Close closes the wrapped client.
+func (c *CachedClient) Close() error {
+	return c.client.Close()
+}
+
+// queryCacheKey returns the hex SHA-256 of a query's cacheKey
+func queryCacheKey(system string, messages []Message, model string, options Options) (string, error) {
+	data, err := json.Marshal(cacheKey{
+		Model:       model,
+		System:      system,
+		Messages:    messages,
+		Temperature: options.Temperature,
+		MaxTokens:   options.MaxTokens,
+		JSON:        options.JSON,
+		Tools:       options.Tools,
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal cache key: %w", err)
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// get returns the entry for key from memory or disk, nil if there is none or it expired
+func (c *CachedClient) get(key string) *cacheEntry {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if element, ok := c.entries[key]; ok {
+		entry := element.Value.(*cacheEntry)
+		if !c.expired(entry) {
+			c.order.MoveToFront(element)
+			return entry
+		}
+		c.order.Remove(element)
+		delete(c.entries, key)
+	}
+
+	entry := c.readEntry(key)
+	if entry == nil {
+		return nil
+	}
+	if c.expired(entry) {
+		os.Remove(c.entryPath(key))
+		return nil
+	}
+	// the disk store is pruned by modification time, so mark the entry as used
+	now := time.Now()
+	os.Chtimes(c.entryPath(key), now, now)
+	c.remember(entry)
+	return entry
+}
+
+// put stores an entry in memory and on disk. A failure to write the disk
+// store only loses the entry, it does not fail the query.
+func (c *CachedClient) put(entry *cacheEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.remember(entry)
+	if c.config.Dir == "" {
+		return
+	}
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return
+	}
+	// write to a temporary file first so other processes never read a partial entry
+	tmp := c.entryPath(entry.Key) + ".tmp"
+	if os.WriteFile(tmp, data, 0600) != nil || os.Rename(tmp, c.entryPath(entry.Key)) != nil {
+		os.Remove(tmp)
+		return
+	}
+	c.prune(filepath.Base(c.entryPath(entry.Key)))
+}
+
+// remember adds an entry to the in-memory LRU, evicting the least recently used
+func (c *CachedClient) remember(entry *cacheEntry) {
+	if element, ok := c.entries[entry.Key]; ok {
+		element.Value = entry
+		c.order.MoveToFront(element)
+		return
+	}
+	c.entries[entry.Key] = c.order.PushFront(entry)
+	for c.order.Len() > c.config.MaxEntries {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cacheEntry).Key)
+	}
+}
+
+// expired reports whether an entry is older than the TTL
+func (c *CachedClient) expired(entry *cacheEntry) bool {
+	return c.config.TTL > 0 && time.Since(entry.Created) > c.config.TTL
+}
+
+// entryPath returns the path of the disk store file for key
+func (c *CachedClient) entryPath(key string) string {
+	return filepath.Join(c.config.Dir, key+".json")
+}
+
+// readEntry reads the entry for key from the disk store, nil if it is missing or unreadable
+func (c *CachedClient) readEntry(key string) *cacheEntry {
+	if c.config.Dir == "" {
+		return nil
+	}
+	data, err := os.ReadFile(c.entryPath(key))
+	if err != nil {
+		return nil
+	}
+	var entry cacheEntry
+	if json.Unmarshal(data, &entry) != nil || entry.Key != key {
+		return nil
+	}
+	return &entry
+}
+
+// prune removes the oldest files of the disk store until it fits in MaxBytes,
+// counting but never removing the file named keep that was just written, which
+// stays even if it is larger than MaxBytes by itself
+func (c *CachedClient) prune(keep string) {
+	if c.config.MaxBytes <= 0 {
+		return
+	}
+	dirEntries, err := os.ReadDir(c.config.Dir)
+	if err != nil {
+		return
+	}
+
+	var files []os.FileInfo
+	var total int64
+	for _, dirEntry := range dirEntries {
+		if !strings.HasSuffix(dirEntry.Name(), ".json") {
+			continue
+		}
+		info, err := dirEntry.Info()
+		if err != nil {
+			continue
+		}
+		total += info.Size()
+		if dirEntry.Name() != keep {
+			files = append(files, info)
+		}
+	}
+
+	sort.Slice(files, func(i, j int) bool { return files[i].ModTime().Before(files[j].ModTime()) })
+	for _, info := range files {
+		if total <= c.config.MaxBytes {
+			break
+		}
+		if os.Remove(filepath.Join(c.config.Dir, info.Name())) == nil {
+			total -= info.Size()
+		}
+	}
+}
@@ -0,0 +1,168 @@
+package sqirvy
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newScriptedCache returns a cache over a mock client that answers each
+// query it receives with the next of texts
+func newScriptedCache(t *testing.T, config CacheConfig, texts ...string) *CachedClient {
+	t.Helper()
+	mock, _ := NewMockClient()
+	script := MockScript{}
+	for _, text := range texts {
+		script.Responses = append(script.Responses, MockResponse{Text: text})
+	}
+	mock.SetScript(script)
+	cache, err := NewCachedClient(mock, config)
+	if err != nil {
+		t.Fatalf("NewCachedClient() error = %v", err)
+	}
+	return cache
+}
+
+func TestCachedClient(t *testing.T) {
+	var hits int
+	cache := newScriptedCache(t, CacheConfig{OnHit: func(model string, age time.Duration) { hits++ }}, "first", "second", "third")
+	ctx := context.Background()
+	query := func(prompt string, options Options) string {
+		t.Helper()
+		got, err := cache.QueryText(ctx, assistant, []string{prompt}, MockScriptModel, options)
+		if err != nil {
+			t.Fatalf("QueryText() error = %v", err)
+		}
+		return got
+	}
+
+	if got := query("hello", Options{}); got != "first" {
+		t.Errorf("QueryText() = %q, want first", got)
+	}
+	if got := query("hello", Options{Timeout: time.Minute}); got != "first" || hits != 1 {
+		t.Errorf("QueryText() = %q with %d hits, want first from the cache", got, hits)
+	}
+	if got := query("hello", Options{Temperature: 50}); got != "second" {
+		t.Errorf("QueryText() with another temperature = %q, want second", got)
+	}
+
+	// a cached response is streamed as a single chunk
+	var chunks []string
+	got, err := cache.QueryStream(ctx, assistant, []string{"hello"}, MockScriptModel, Options{}, func(chunk string) error {
+		chunks = append(chunks, chunk)
+		return nil
+	})
+	if err != nil || got != "first" || len(chunks) != 1 || hits != 2 {
+		t.Errorf("QueryStream() = %q, %v in chunks %q with %d hits", got, err, chunks, hits)
+	}
+
+	// failures are not cached
+	if _, err := cache.QueryText(ctx, assistant, []string{"hello"}, "mock-unknown", Options{Retry: testRetryPolicy}); err == nil {
+		t.Fatalf("QueryText() with an unknown model should fail")
+	}
+	if got := query("hello again", Options{}); got != "third" {
+		t.Errorf("QueryText() = %q, want third", got)
+	}
+}
+
+func TestCachedClient_Disk(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "cache")
+	ctx := context.Background()
+
+	cache := newScriptedCache(t, CacheConfig{Dir: dir}, "stored")
+	if got, err := cache.QueryText(ctx, "", []string{"hello"}, MockScriptModel, Options{}); err != nil || got != "stored" {
+		t.Fatalf("QueryText() = %q, %v, want stored", got, err)
+	}
+
+	// another client finds the response on disk
+	var hitAge time.Duration = -1
+	cache = newScriptedCache(t, CacheConfig{Dir: dir, OnHit: func(model string, age time.Duration) { hitAge = age }}, "queried")
+	if got, err := cache.QueryText(ctx, "", []string{"hello"}, MockScriptModel, Options{}); err != nil || got != "stored" || hitAge < 0 {
+		t.Errorf("QueryText() = %q, %v, want stored from disk", got, err)
+	}
+
+	// an expired response is queried again
+	cache = newScriptedCache(t, CacheConfig{Dir: dir, TTL: time.Nanosecond}, "queried")
+	time.Sleep(time.Millisecond)
+	if got, err := cache.QueryText(ctx, "", []string{"hello"}, MockScriptModel, Options{}); err != nil || got != "queried" {
+		t.Errorf("QueryText() = %q, %v, want queried after the TTL", got, err)
+	}
+}
+
+func TestCachedClient_Limits(t *testing.T) {
+	dir := t.TempDir()
+	ctx := context.Background()
+
+	// one response in memory, and a disk store too small for any but the newest
+	cache := newScriptedCache(t, CacheConfig{Dir: dir, MaxEntries: 1, MaxBytes: 1}, "one", "two", "three")
+	for _, prompt := range []string{"a", "b"} {
+		if _, err := cache.QueryText(ctx, "", []string{prompt}, MockScriptModel, Options{}); err != nil {
+			t.Fatalf("QueryText() error = %v", err)
+		}
+	}
+	if len(cache.entries) != 1 {
+		t.Errorf("cache holds %d responses in memory, want 1", len(cache.entries))
+	}
+	files, _ := filepath.Glob(filepath.Join(dir, "*.json"))
+	if len(files) != 1 {
+		t.Errorf("disk store holds %d files, want 1", len(files))
+	}
+
+	// the first response was evicted from both
+	if got, _ := cache.QueryText(ctx, "", []string{"a"}, MockScriptModel, Options{}); got != "three" {
+		t.Errorf("QueryText() = %q, want three", got)
+	}
+	if got, _ := cache.QueryText(ctx, "", []string{"a"}, MockScriptModel, Options{}); got != "three" {
+		t.Errorf("QueryText() = %q, want three from the cache", got)
+	}
+}
+
+func TestCachedClient_MaxBytes(t *testing.T) {
+	dir := t.TempDir()
+	ctx := context.Background()
+	text := strings.Repeat("x", 1000)
+
+	// measure the size of one entry on disk
+	cache := newScriptedCache(t, CacheConfig{Dir: dir}, text)
+	if _, err := cache.QueryText(ctx, "", []string{"size"}, MockScriptModel, Options{}); err != nil {
+		t.Fatalf("QueryText() error = %v", err)
+	}
+	size := dirSize(t, dir)
+
+	// room for two and a half entries keeps the two newest
+	maxBytes := size * 5 / 2
+	cache = newScriptedCache(t, CacheConfig{Dir: dir, MaxBytes: maxBytes}, text, text, text, text, text)
+	for _, prompt := range []string{"a", "b", "c", "d", "e"} {
+		if _, err := cache.QueryText(ctx, "", []string{prompt}, MockScriptModel, Options{}); err != nil {
+			t.Fatalf("QueryText() error = %v", err)
+		}
+		if got := dirSize(t, dir); got > maxBytes {
+			t.Errorf("disk store holds %d bytes after %s, want at most %d", got, prompt, maxBytes)
+		}
+	}
+	files, _ := filepath.Glob(filepath.Join(dir, "*.json"))
+	if len(files) != 2 {
+		t.Errorf("disk store holds %d files, want 2", len(files))
+	}
+}
+
+// dirSize returns the total size of the files in dir
+func dirSize(t *testing.T, dir string) int64 {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var total int64
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil {
+			t.Fatal(err)
+		}
+		total += info.Size()
+	}
+	return total
+}
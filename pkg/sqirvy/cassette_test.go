@@ -0,0 +1,153 @@
+package sqirvy
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"sqirvy-ai/pkg/cassette"
+)
+
+// recordCassettes re-records the cassettes under testdata/cassettes against
+// the live provider APIs, using the API keys and base URLs of the environment
+var recordCassettes = os.Getenv("SQIRVY_RECORD") != ""
+
+// replayBaseURLs are the base URLs of the providers when replaying. Cassettes
+// match requests by path and query, so the host is never contacted.
+var replayBaseURLs = map[string]string{
+	OpenAI:    "https://api.openai.com",
+	DeepSeek:  "https://api.deepseek.com",
+	Llama:     "https://api.llama-api.com",
+	Anthropic: "https://api.anthropic.com",
+	Gemini:    "https://generativelanguage.googleapis.com",
+}
+
+// cassetteTest is a query answered from a cassette and its expected result
+type cassetteTest struct {
+	cassette string   // Cassette file under testdata/cassettes, without .yaml
+	model    string   // Model queried
+	prompts  []string // User messages of the query
+	options  Options  // Query options
+	apiKey   string   // API key to record with instead of the environment's, for authentication failures
+	stream   bool     // Query with a StreamFunc
+	want     Response // Expected response, ignored if wantErr is set
+	wantErr  error    // Expected kind of the ProviderError
+}
+
+// newCassetteClient creates a client for provider whose requests are answered
+// from the named cassette, or recorded to it if SQIRVY_RECORD is set
+func newCassetteClient(t *testing.T, provider string, tt cassetteTest) Client {
+	t.Helper()
+	path := filepath.Join("testdata", "cassettes", tt.cassette+".yaml")
+
+	mode := cassette.Replay
+	if recordCassettes {
+		mode = cassette.Record
+	}
+	recorder, err := cassette.New(path, mode)
+	if err != nil {
+		t.Fatalf("cassette.New() error = %v", err)
+	}
+	t.Cleanup(func() {
+		if err := recorder.Stop(); err != nil {
+			t.Errorf("recorder.Stop() error = %v", err)
+		}
+	})
+
+	opts := []ClientOption{WithHTTPClient(recorder.Client())}
+	if !recordCassettes {
+		opts = append(opts, WithAPIKey("test-key"), WithBaseURL(replayBaseURLs[provider]))
+	}
+	if tt.apiKey != "" {
+		opts = append(opts, WithAPIKey(tt.apiKey))
+	}
+	client, err := NewClient(provider, opts...)
+	if err != nil {
+		t.Fatalf("NewClient(%s) error = %v", provider, err)
+	}
+	t.Cleanup(func() { client.Close() })
+	return client
+}
+
+// runCassetteTests replays each query against a client for provider and
+// checks the response, or the kind of error the adapter returned
+func runCassetteTests(t *testing.T, provider string, tests []cassetteTest) {
+	t.Helper()
+	for _, tt := range tests {
+		t.Run(tt.cassette, func(t *testing.T) {
+			client := newCassetteClient(t, provider, tt)
+
+			var chunks []string
+			var stream StreamFunc
+			if tt.stream {
+				stream = func(chunk string) error {
+					chunks = append(chunks, chunk)
+					return nil
+				}
+			}
+			resp, err := client.QueryMessages(context.Background(), assistant, UserMessages(tt.prompts), tt.model, tt.options, stream)
+
+			if tt.wantErr != nil {
+				var providerErr *ProviderError
+				if !errors.As(err, &providerErr) || providerErr.Provider != provider || !errors.Is(err, tt.wantErr) {
+					t.Errorf("QueryMessages() error = %v, want %v from %s", err, tt.wantErr, provider)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("QueryMessages() error = %v", err)
+			}
+			if recordCassettes {
+				t.Logf("recorded %+v", resp)
+				return
+			}
+
+			want := tt.want
+			if resp.Text != want.Text || resp.Model != want.Model || resp.FinishReason != want.FinishReason ||
+				resp.InputTokens != want.InputTokens || resp.OutputTokens != want.OutputTokens {
+				t.Errorf("QueryMessages() = %+v, want %+v", *resp, want)
+			}
+			if len(resp.ToolCalls) != len(tt.want.ToolCalls) {
+				t.Fatalf("QueryMessages() tool calls = %+v, want %+v", resp.ToolCalls, tt.want.ToolCalls)
+			}
+			for i, call := range resp.ToolCalls {
+				wantCall := tt.want.ToolCalls[i]
+				if call.ID != wantCall.ID || call.Name != wantCall.Name || !sameJSON(call.Arguments, wantCall.Arguments) {
+					t.Errorf("tool call %d = %+v, want %+v", i, call, wantCall)
+				}
+			}
+			if tt.stream && strings.Join(chunks, "") != resp.Text {
+				t.Errorf("streamed chunks %q, want %q", chunks, resp.Text)
+			}
+		})
+	}
+}
+
+// sameJSON reports whether two JSON values are equal
+func sameJSON(a, b json.RawMessage) bool {
+	var va, vb any
+	if json.Unmarshal(a, &va) != nil || json.Unmarshal(b, &vb) != nil {
+		return false
+	}
+	ja, _ := json.Marshal(va)
+	jb, _ := json.Marshal(vb)
+	return string(ja) == string(jb)
+}
+
+// geminiStreamSupported reports whether encoding/json can find the end of a
+// Gemini REST stream. The SDK's stream reader relies on a json.Decoder error
+// that differs under GOEXPERIMENT=jsonv2, where every Gemini reply fails at
+// the closing bracket.
+func geminiStreamSupported() bool {
+	decoder := json.NewDecoder(strings.NewReader(`[{}]`))
+	var raw json.RawMessage
+	if _, err := decoder.Token(); err != nil || decoder.Decode(&raw) != nil || decoder.Decode(&raw) == nil {
+		return false
+	}
+	token, _ := decoder.Token()
+	return token == json.Delim(']')
+}
@@ -0,0 +1,138 @@
+// Package sqirvy provides model catalog files.
+//
+// This file loads a catalog of models from a YAML or JSON file and merges it
+// over the registered models, so new models, aliases and prices can be added
+// without rebuilding. An entry for a model that is already registered only
+// changes the fields it sets, and an entry for a new model must name a
+// registered provider.
+//
+//	models:
+//	  - name: gpt-4.1
+//	    provider: openai
+//	    aliases: [gpt4.1]
+//	    max_tokens: 32768
+//	    context_window: 1047576
+//	    price: {input: 2.00, output: 8.00}
+//	    capabilities: {streaming: true, json: true, tools: true}
+package sqirvy
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Catalog is the contents of a model catalog file.
+type Catalog struct {
+	Models []CatalogModel `json:"models" yaml:"models"`
+}
+
+// CatalogModel is a model entry in a catalog. Fields that are not set keep the
+// value of the registered model with the same name.
+type CatalogModel struct {
+	Name          string        `json:"name" yaml:"name"`                     // Model identifier sent to the provider
+	Provider      string        `json:"provider" yaml:"provider"`             // Required for a model that is not registered
+	Aliases       []string      `json:"aliases" yaml:"aliases"`               // Replaces the registered aliases if set
+	MaxTokens     int64         `json:"max_tokens" yaml:"max_tokens"`         // Maximum tokens in a response
+	ContextWindow int64         `json:"context_window" yaml:"context_window"` // Maximum tokens in the prompt and response
+	Price         *ModelPrice   `json:"price" yaml:"price"`                   // Price per million tokens
+	Capabilities  *Capabilities `json:"capabilities" yaml:"capabilities"`     // Optional features the model supports
+}
+
+// LoadCatalog reads a catalog file and merges it over the registered models.
+// Files ending in .json are decoded as JSON and all others as YAML.
+func LoadCatalog(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read model catalog: %w", err)
+	}
+
+	var catalog Catalog
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.DisallowUnknownFields()
+		err = decoder.Decode(&catalog)
+	} else {
+		decoder := yaml.NewDecoder(bytes.NewReader(data))
+		decoder.KnownFields(true)
+		err = decoder.Decode(&catalog)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to decode model catalog %s: %w", path, err)
+	}
+
+	if err := ApplyCatalog(catalog); err != nil {
+		return fmt.Errorf("invalid model catalog %s: %w", path, err)
+	}
+	return nil
+}
+
+// ApplyCatalog merges a catalog over the registered models. The catalog is
+// applied completely or not at all: if any entry is invalid, for example because
+// its provider is not registered, the errors of all invalid entries are returned
+// and the registry is unchanged.
+func ApplyCatalog(catalog Catalog) error {
+	r := defaultRegistry
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// apply the entries to a copy and keep it only if they are all valid
+	next := &registry{
+		providers: r.providers,
+		models:    maps.Clone(r.models),
+		aliases:   maps.Clone(r.aliases),
+	}
+	var errs []error
+	for i, entry := range catalog.Models {
+		if entry.Name == "" {
+			errs = append(errs, fmt.Errorf("model %d: name is required", i+1))
+			continue
+		}
+		model, ok := next.models[entry.Name]
+		if !ok && entry.Provider == "" {
+			errs = append(errs, fmt.Errorf("model %s: provider is required for a new model", entry.Name))
+			continue
+		}
+		if err := next.addModel(entry.merge(model)); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) > 0 {
+		return errors.Join(errs...)
+	}
+
+	r.models = next.models
+	r.aliases = next.aliases
+	return nil
+}
+
+// merge returns model with the fields set in the catalog entry replaced
+func (c CatalogModel) merge(model ModelInfo) ModelInfo {
+	model.Name = c.Name
+	if c.Provider != "" {
+		model.Provider = c.Provider
+	}
+	if c.Aliases != nil {
+		model.Aliases = c.Aliases
+	}
+	if c.MaxTokens > 0 {
+		model.MaxTokens = c.MaxTokens
+	}
+	if c.ContextWindow > 0 {
+		model.ContextWindow = c.ContextWindow
+	}
+	if c.Price != nil {
+		model.Price = c.Price
+	}
+	if c.Capabilities != nil {
+		model.Capabilities = *c.Capabilities
+	}
+	return model
+}
@@ -0,0 +1,123 @@
+package sqirvy
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeCatalog(t *testing.T, name, contents string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(contents), 0644); err != nil {
+		t.Fatalf("failed to write catalog: %v", err)
+	}
+	return path
+}
+
+func TestLoadCatalog(t *testing.T) {
+	// restore the built-in model the catalog changes
+	original, _ := LookupModel("gpt-4o-mini")
+	t.Cleanup(func() { RegisterModel(original) })
+
+	path := writeCatalog(t, "models.yaml", `
+models:
+  - name: test-catalog-model
+    provider: openai
+    aliases: [test-catalog]
+    max_tokens: 16384
+    context_window: 1000000
+    price: {input: 2.00, output: 8.00}
+    capabilities: {streaming: true, json: true, tools: false}
+  - name: gpt-4o-mini
+    context_window: 64000
+`)
+	if err := LoadCatalog(path); err != nil {
+		t.Fatalf("LoadCatalog() error = %v", err)
+	}
+
+	model, ok := LookupModel("test-catalog")
+	if !ok || model.Name != "test-catalog-model" || model.Provider != OpenAI {
+		t.Fatalf("LookupModel(test-catalog) = %+v, %v", model, ok)
+	}
+	if model.MaxTokens != 16384 || model.ContextWindow != 1000000 || !model.Capabilities.JSON || model.Capabilities.Tools {
+		t.Errorf("catalog model = %+v", model)
+	}
+	if cost, ok := GetCost("test-catalog-model", 1e6, 1e6); !ok || cost != 10 {
+		t.Errorf("GetCost() = %v, %v, want 10, true", cost, ok)
+	}
+
+	// an entry for a built-in model only changes the fields it sets
+	mini, _ := LookupModel("gpt-4o-mini")
+	if mini.ContextWindow != 64000 || mini.MaxTokens != 4096 || mini.Price == nil || !mini.Capabilities.Tools {
+		t.Errorf("merged built-in model = %+v", mini)
+	}
+}
+
+func TestLoadCatalog_JSON(t *testing.T) {
+	path := writeCatalog(t, "models.json", `{"models": [{"name": "test-json-model", "provider": "gemini", "max_tokens": 2048}]}`)
+	if err := LoadCatalog(path); err != nil {
+		t.Fatalf("LoadCatalog() error = %v", err)
+	}
+	if got := GetMaxTokens("test-json-model"); got != 2048 {
+		t.Errorf("GetMaxTokens() = %d, want 2048", got)
+	}
+}
+
+func TestLoadCatalog_Invalid(t *testing.T) {
+	tests := []struct {
+		name     string
+		file     string
+		contents string
+		wantErr  []string
+	}{
+		{
+			name: "Unknown provider",
+			file: "models.yaml",
+			contents: `
+models:
+  - name: test-invalid-good
+    provider: openai
+  - name: test-invalid-bad
+    provider: mistral
+  - name: test-invalid-new
+`,
+			wantErr: []string{"unsupported provider: mistral", "provider is required for a new model"},
+		},
+		{
+			name:     "Unknown field",
+			file:     "models.yaml",
+			contents: "models:\n  - name: gpt-4o\n    max_token: 10\n",
+			wantErr:  []string{"max_token"},
+		},
+		{
+			name:     "Bad JSON",
+			file:     "models.json",
+			contents: `{"models": [`,
+			wantErr:  []string{"failed to decode"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := LoadCatalog(writeCatalog(t, tt.file, tt.contents))
+			if err == nil {
+				t.Fatalf("LoadCatalog() should fail")
+			}
+			for _, want := range tt.wantErr {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("LoadCatalog() error = %v, want %q", err, want)
+				}
+			}
+		})
+	}
+
+	// an invalid catalog is not applied at all
+	if _, ok := LookupModel("test-invalid-good"); ok {
+		t.Errorf("LookupModel() found a model from an invalid catalog")
+	}
+	if err := LoadCatalog(filepath.Join(t.TempDir(), "missing.yaml")); err == nil {
+		t.Errorf("LoadCatalog() should fail for a missing file")
+	}
+}
@@ -0,0 +1,293 @@
+// Package sqirvy provides a map-reduce helper for inputs larger than a
+// model's context window.
+//
+// This file implements SplitDocuments, which splits named documents into
+// chunks that fit a token budget along file and declaration boundaries, and
+// MapReduce, which queries a model with each chunk concurrently and merges the
+// partial answers with a reduce prompt until a single answer remains.
+package sqirvy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// DefaultMapReduceParallel is the number of queries MapReduce sends at once
+// when MapReduceQuery.Parallel is not set
+const DefaultMapReduceParallel = 4
+
+// DefaultReducePrompt asks the model to merge the partial answers of a
+// map-reduce query into one
+const DefaultReducePrompt = `The input of the following instructions was too long for one query, so it was split into parts and each part was answered separately. Merge the partial answers below into a single answer to the whole input that follows the original instructions and format. Keep every distinct finding, remove duplicates, and do not mention the parts.`
+
+// Document is a named input to split into chunks, such as a file or the page of a URL.
+type Document struct {
+	Name    string // File name, URL or stdin, shown to the model with the content
+	Content string // Content of the document
+}
+
+// MapReduceQuery is a query whose documents are split into chunks that are
+// answered separately and then merged.
+type MapReduceQuery struct {
+	Model        string                          // Model name
+	System       string                          // System prompt of every query
+	Prompt       string                          // Instructions sent before each chunk, may be empty
+	Documents    []Document                      // Input of the query
+	ReducePrompt string                          // Instructions sent before the partial answers, DefaultReducePrompt if empty
+	ChunkTokens  int64                           // Tokens of each chunk, zero to fill the context window of Model
+	Parallel     int                             // Queries sent at once, zero for DefaultMapReduceParallel
+	Options      Options                         // Options of every query
+	OnStage      func(stage string, queries int) // Called before the map stage and each reduce stage, may be nil
+}
+
+// MapReduce answers query with client when its documents do not fit one
+// query. The documents are split into chunks of query.ChunkTokens, each chunk
+// is sent with query.Prompt concurrently (the map stage), and the partial
+// answers are sent with the reduce prompt and the original instructions until
+// one answer is left (the reduce stages). Partial answers that do not fit one
+// query are reduced in groups first. Documents that fit a single chunk are
+// answered with one query.
+//
+// The returned response is the last answer, with the tokens of every query
+// added up. The first query to fail cancels the others and its error is returned.
+func MapReduce(ctx context.Context, client Client, query MapReduceQuery) (*Response, error) {
+	provider, err := GetProviderName(query.Model)
+	if err != nil {
+		return nil, err
+	}
+	if query.ReducePrompt == "" {
+		query.ReducePrompt = DefaultReducePrompt
+	}
+	chunkTokens := query.ChunkTokens
+	if chunkTokens <= 0 {
+		if chunkTokens, err = mapReduceChunkTokens(provider, query); err != nil {
+			return nil, err
+		}
+	}
+
+	chunks, err := SplitDocuments(provider, query.Documents, chunkTokens)
+	if err != nil {
+		return nil, err
+	}
+	if len(chunks) == 0 {
+		return nil, fmt.Errorf("map-reduce query has no input")
+	}
+	total := &Response{}
+	if query.OnStage != nil {
+		query.OnStage("map", len(chunks))
+	}
+	answers, err := mapReduceStage(ctx, client, query, []string{query.Prompt}, chunks, total)
+	if err != nil {
+		return nil, err
+	}
+
+	for stage := 1; len(answers) > 1; stage++ {
+		var partials []Document
+		for i, answer := range answers {
+			partials = append(partials, Document{Name: fmt.Sprintf("answer %d of %d", i+1, len(answers)), Content: answer.Text})
+		}
+		groups, err := SplitDocuments(provider, partials, chunkTokens)
+		if err != nil {
+			return nil, err
+		}
+		if len(groups) >= len(answers) {
+			return nil, fmt.Errorf("partial answers of %s are too long to merge in chunks of %d tokens", query.Model, chunkTokens)
+		}
+		if query.OnStage != nil {
+			query.OnStage(fmt.Sprintf("reduce %d", stage), len(groups))
+		}
+		prompts := []string{query.ReducePrompt}
+		if query.Prompt != "" {
+			prompts = append(prompts, "Original instructions:\n"+query.Prompt)
+		}
+		if answers, err = mapReduceStage(ctx, client, query, prompts, groups, total); err != nil {
+			return nil, err
+		}
+	}
+
+	total.Text = answers[0].Text
+	total.Model = answers[0].Model
+	total.FinishReason = answers[0].FinishReason
+	return total, nil
+}
+
+// mapReduceChunkTokens returns the tokens of a chunk that fills the context
+// window of the query's model once the system prompt, the longer of the
+// prompts and the response are reserved
+func mapReduceChunkTokens(provider string, query MapReduceQuery) (int64, error) {
+	window := GetContextWindow(query.Model)
+	if window <= 0 {
+		return 0, fmt.Errorf("context window of %s is unknown, set ChunkTokens", query.Model)
+	}
+	maxTokens := query.Options.MaxTokens
+	if maxTokens <= 0 {
+		maxTokens = GetMaxTokens(query.Model)
+	}
+	prompt := max(EstimateTokens(provider, query.Prompt), EstimateTokens(provider, query.ReducePrompt+query.Prompt))
+	tokens := window - maxTokens - EstimateTokens(provider, query.System) - prompt
+	if tokens <= 0 {
+		return 0, fmt.Errorf("prompts and response of %s do not fit its context window of %d tokens", query.Model, window)
+	}
+	return tokens, nil
+}
+
+// mapReduceStage sends each chunk after prompts concurrently and returns the
+// answers in the order of chunks, adding their tokens to total
+func mapReduceStage(ctx context.Context, client Client, query MapReduceQuery, prompts []string, chunks []string, total *Response) ([]*Response, error) {
+	parallel := query.Parallel
+	if parallel <= 0 {
+		parallel = DefaultMapReduceParallel
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	answers := make([]*Response, len(chunks))
+	errs := make([]error, len(chunks))
+	slots := make(chan struct{}, parallel)
+	var wg sync.WaitGroup
+	for i, chunk := range chunks {
+		var messages []Message
+		for _, prompt := range prompts {
+			if prompt != "" {
+				messages = append(messages, Message{Role: RoleUser, Content: prompt})
+			}
+		}
+		messages = append(messages, Message{Role: RoleUser, Content: chunk})
+
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			select {
+			case slots <- struct{}{}:
+				defer func() { <-slots }()
+			case <-ctx.Done():
+				errs[i] = ctx.Err()
+				return
+			}
+			answers[i], errs[i] = client.QueryMessages(ctx, query.System, messages, query.Model, query.Options, nil)
+			if errs[i] != nil {
+				cancel()
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	// report the error that caused the others to be cancelled
+	failed := -1
+	for i, err := range errs {
+		if err != nil && (failed < 0 || errors.Is(errs[failed], context.Canceled)) {
+			failed = i
+		}
+	}
+	if failed >= 0 {
+		return nil, fmt.Errorf("chunk %d of %d: %w", failed+1, len(chunks), errs[failed])
+	}
+	for _, answer := range answers {
+		total.InputTokens += answer.InputTokens
+		total.OutputTokens += answer.OutputTokens
+	}
+	return answers, nil
+}
+
+// SplitDocuments splits documents into chunks of at most maxTokens estimated
+// tokens for provider. Each document is fenced with its name, and whole
+// documents are packed into a chunk while they fit. A document larger than a
+// chunk is split into parts, named "part i of n", between top-level
+// declarations, which start at an unindented line after a blank line, then
+// between lines, and only cut within a line that is longer than a chunk.
+func SplitDocuments(provider string, documents []Document, maxTokens int64) ([]string, error) {
+	var pieces []string
+	for _, doc := range documents {
+		if doc.Content == "" {
+			continue
+		}
+		room := maxTokens - EstimateTokens(provider, fenceDocument(doc.Name, 999, 999, ""))
+		if room <= 0 {
+			return nil, fmt.Errorf("chunks of %d tokens are too small for %s", maxTokens, doc.Name)
+		}
+		parts := splitText(provider, doc.Content, room)
+		for i, part := range parts {
+			pieces = append(pieces, fenceDocument(doc.Name, i+1, len(parts), part))
+		}
+	}
+	return packPieces(provider, pieces, maxTokens), nil
+}
+
+// fenceDocument wraps part of a document in a code fence naming it
+func fenceDocument(name string, part, parts int, content string) string {
+	if parts > 1 {
+		name = fmt.Sprintf("%s (part %d of %d)", name, part, parts)
+	}
+	if !strings.HasSuffix(content, "\n") {
+		content += "\n"
+	}
+	return "```" + name + "\n" + content + "```\n"
+}
+
+// splitText splits text into parts of at most maxTokens, preferring
+// declaration boundaries, then line breaks
+func splitText(provider, text string, maxTokens int64) []string {
+	if EstimateTokens(provider, text) <= maxTokens {
+		return []string{text}
+	}
+
+	var parts []string
+	for _, block := range splitDeclarations(text) {
+		if EstimateTokens(provider, block) <= maxTokens {
+			parts = append(parts, block)
+			continue
+		}
+		for _, line := range strings.SplitAfter(block, "\n") {
+			for line != "" {
+				part := TruncateTokens(provider, line, maxTokens)
+				if part == "" {
+					part = line
+				}
+				parts = append(parts, part)
+				line = line[len(part):]
+			}
+		}
+	}
+	return packPieces(provider, parts, maxTokens)
+}
+
+// splitDeclarations splits text before each unindented line that follows a
+// blank line, which starts a function, type or paragraph in most languages
+func splitDeclarations(text string) []string {
+	var blocks []string
+	lines := strings.SplitAfter(text, "\n")
+	start, offset := 0, 0
+	for i, line := range lines {
+		if i > 0 && strings.TrimSpace(lines[i-1]) == "" && line != "" &&
+			!strings.ContainsAny(line[:1], " \t\r\n}])") {
+			blocks = append(blocks, text[start:offset])
+			start = offset
+		}
+		offset += len(line)
+	}
+	return append(blocks, text[start:])
+}
+
+// packPieces joins consecutive pieces while the result stays within maxTokens
+func packPieces(provider string, pieces []string, maxTokens int64) []string {
+	var chunks []string
+	var current strings.Builder
+	var tokens int64
+	for _, piece := range pieces {
+		n := EstimateTokens(provider, piece)
+		if current.Len() > 0 && tokens+n > maxTokens {
+			chunks = append(chunks, current.String())
+			current.Reset()
+			tokens = 0
+		}
+		current.WriteString(piece)
+		tokens += n
+	}
+	if current.Len() > 0 {
+		chunks = append(chunks, current.String())
+	}
+	return chunks
+}
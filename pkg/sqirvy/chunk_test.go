@@ -0,0 +1,117 @@
+package sqirvy
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// goSource returns a Go file of n functions of about 40 words each
+func goSource(n int) string {
+	var b strings.Builder
+	b.WriteString("package main\n")
+	for i := range n {
+		fmt.Fprintf(&b, "\n// f%d does something\nfunc f%d() {\n", i, i)
+		for range 6 {
+			b.WriteString("\tx := compute(a, b, c)\n\tif x > 0 { return }\n")
+		}
+		b.WriteString("}\n")
+	}
+	return b.String()
+}
+
+func TestSplitDocuments(t *testing.T) {
+	// small documents are packed into one chunk
+	chunks, err := SplitDocuments(Mock, []Document{{"a.go", "one two"}, {"b.go", ""}, {"c.go", "three"}}, 100)
+	if err != nil || len(chunks) != 1 {
+		t.Fatalf("SplitDocuments() = %q, %v, want one chunk", chunks, err)
+	}
+	if !strings.Contains(chunks[0], "```a.go\none two\n```") || strings.Contains(chunks[0], "b.go") {
+		t.Errorf("SplitDocuments() chunk = %q, want a.go and c.go fenced", chunks[0])
+	}
+
+	// a large file is split into parts between functions
+	source := goSource(10)
+	chunks, err = SplitDocuments(Mock, []Document{{"main.go", source}}, 120)
+	if err != nil || len(chunks) < 4 {
+		t.Fatalf("SplitDocuments() = %d chunks, %v, want at least 4", len(chunks), err)
+	}
+	for i, chunk := range chunks {
+		if n := EstimateTokens(Mock, chunk); n > 120 {
+			t.Errorf("chunk %d is %d tokens, over the limit of 120", i, n)
+		}
+		if !strings.Contains(chunk, fmt.Sprintf("```main.go (part %d of %d)", i+1, len(chunks))) {
+			t.Errorf("chunk %d does not name its part: %q", i, chunk[:40])
+		}
+		if strings.Count(chunk, "func f") != strings.Count(chunk, "\n}\n") {
+			t.Errorf("chunk %d splits a function: %q", i, chunk)
+		}
+	}
+
+	// a function longer than a chunk is split between lines
+	chunks, err = SplitDocuments(Mock, []Document{{"main.go", goSource(1)}}, 30)
+	if err != nil || len(chunks) < 2 {
+		t.Fatalf("SplitDocuments() = %d chunks, %v, want the function split", len(chunks), err)
+	}
+
+	if _, err := SplitDocuments(Mock, []Document{{"main.go", source}}, 3); err == nil {
+		t.Errorf("SplitDocuments() into chunks smaller than a fence should fail")
+	}
+}
+
+func TestMapReduce(t *testing.T) {
+	client, err := NewMockClient()
+	if err != nil {
+		t.Fatalf("NewMockClient() error = %v", err)
+	}
+	client.SetScript(MockScript{Responses: []MockResponse{
+		{Match: "```answer", Text: "merged review"},
+		{Match: "```main.go", Text: "partial review"},
+	}})
+	ctx := context.Background()
+
+	var stages []string
+	query := MapReduceQuery{
+		Model:       MockScriptModel,
+		System:      assistant,
+		Prompt:      "review this",
+		Documents:   []Document{{"main.go", goSource(10)}},
+		ChunkTokens: 120,
+		Parallel:    2,
+		OnStage:     func(stage string, queries int) { stages = append(stages, fmt.Sprintf("%s %d", stage, queries)) },
+	}
+	response, err := MapReduce(ctx, client, query)
+	if err != nil {
+		t.Fatalf("MapReduce() error = %v", err)
+	}
+	if response.Text != "merged review" || len(stages) != 2 || !strings.HasPrefix(stages[1], "reduce 1 1") {
+		t.Errorf("MapReduce() = %q after stages %q, want one merged review", response.Text, stages)
+	}
+	if response.OutputTokens < 2*3 || response.InputTokens < 400 {
+		t.Errorf("MapReduce() usage = %d in, %d out, want the tokens of every query", response.InputTokens, response.OutputTokens)
+	}
+
+	// input that fits one chunk is answered with a single query
+	stages = nil
+	query.Documents = []Document{{"main.go", "package main"}}
+	response, err = MapReduce(ctx, client, query)
+	if err != nil || response.Text != "partial review" || len(stages) != 1 {
+		t.Errorf("MapReduce() of one chunk = %+v, %v after stages %q", response, err, stages)
+	}
+
+	// the first chunk to fail fails the query
+	client.SetScript(MockScript{Responses: []MockResponse{
+		{Match: "func f3(", Status: 400, Error: "bad chunk"},
+		{Match: "```main.go", Text: "partial review"},
+	}})
+	query.Documents = []Document{{"main.go", goSource(10)}}
+	if _, err := MapReduce(ctx, client, query); err == nil || !strings.Contains(err.Error(), "bad chunk") {
+		t.Errorf("MapReduce() with a failing chunk error = %v, want bad chunk", err)
+	}
+
+	query.Documents = nil
+	if _, err := MapReduce(ctx, client, query); err == nil {
+		t.Errorf("MapReduce() without input should fail")
+	}
+}
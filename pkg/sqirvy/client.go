@@ -24,9 +24,6 @@ const (
 	// Temperature limits for model queries (0-100 scale)
 	MinTemperature = 0.0
 	MaxTemperature = 100.0
-
-	// request timeout in seconds
-	RequestTimeout = time.Second * 15
 )
 
 // Provider represents supported AI providers.
@@ -37,52 +34,107 @@ type Provider string
 // Options combines all provider-specific options into a single structure.
 // This allows for provider-specific configuration while maintaining a unified interface.
 type Options struct {
-	Temperature float32 // Controls the randomness of the output
-	MaxTokens   int64   // Maximum number of tokens in the response
+	Temperature float32       // Controls the randomness of the output
+	MaxTokens   int64         // Maximum number of tokens in the response
+	Retry       RetryPolicy   // Retries for failed requests, the zero value uses DefaultRetryPolicy
+	JSON        *JSONSchema   // Request a JSON response matching the schema, nil for plain text
+	Tools       []Tool        // Tools the model may call, see RunTools
+	Timeout     time.Duration // Limit on the whole query including retries, zero for the client's default
+}
+
+// queryContext limits ctx by the timeout of a query, which defaults to the
+// timeout the client was created with. Zero for both means no limit.
+func queryContext(ctx context.Context, options Options, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if options.Timeout > 0 {
+		timeout = options.Timeout
+	}
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// Message roles used in a conversation
+const (
+	RoleUser      = "user"      // Message written by the user
+	RoleAssistant = "assistant" // Message previously generated by the model
+	RoleTool      = "tool"      // Result of a tool call requested by the model
+)
+
+// Message is a single turn in a conversation with a model.
+// The system prompt is passed separately and is not part of the message list.
+type Message struct {
+	Role       string     `json:"role"`                   // RoleUser, RoleAssistant or RoleTool
+	Content    string     `json:"content"`                // Text of the message, or the result of a tool call
+	ToolCalls  []ToolCall `json:"tool_calls,omitempty"`   // Tools called by an assistant message
+	ToolCallID string     `json:"tool_call_id,omitempty"` // Call answered by a tool message
+	ToolName   string     `json:"tool_name,omitempty"`    // Tool that produced a tool message
+}
+
+// UserMessages converts a list of prompts into user messages.
+func UserMessages(prompts []string) []Message {
+	messages := make([]Message, 0, len(prompts))
+	for _, p := range prompts {
+		messages = append(messages, Message{Role: RoleUser, Content: p})
+	}
+	return messages
+}
+
+// validateMessages checks that a conversation is not empty and only uses supported roles
+func validateMessages(messages []Message) error {
+	if len(messages) == 0 {
+		return fmt.Errorf("messages cannot be empty for text query")
+	}
+	for i, m := range messages {
+		switch m.Role {
+		case RoleUser, RoleAssistant:
+		case RoleTool:
+			if m.ToolCallID == "" || m.ToolName == "" {
+				return fmt.Errorf("tool message %d must have a tool call id and tool name", i)
+			}
+		default:
+			return fmt.Errorf("message %d has unsupported role %q", i, m.Role)
+		}
+	}
+	return nil
+}
+
+// Response is a model reply along with the metadata the provider reports about it.
+// Token counts are zero if the provider did not report usage.
+type Response struct {
+	Text         string     `json:"text"`                 // Generated text
+	Model        string     `json:"model"`                // Model identifier reported by the provider
+	FinishReason string     `json:"finish_reason"`        // Why the model stopped generating
+	InputTokens  int64      `json:"input_tokens"`         // Tokens in the prompt
+	OutputTokens int64      `json:"output_tokens"`        // Tokens in the generated text
+	ToolCalls    []ToolCall `json:"tool_calls,omitempty"` // Tools the model asked to call, see RunTools
 }
 
+// responseText returns the text of a response, for QueryText and QueryStream
+// implementations built on QueryMessages
+func responseText(resp *Response, err error) (string, error) {
+	if err != nil {
+		return "", err
+	}
+	return resp.Text, nil
+}
+
+// StreamFunc receives each chunk of a streamed response as it arrives.
+// Returning an error stops the stream and the error is returned by the query.
+type StreamFunc func(chunk string) error
+
 // Client provides a unified interface for AI operations.
 // It abstracts away provider-specific implementations behind a common interface
 // for making text and JSON queries to AI models.
 type Client interface {
 	QueryText(ctx context.Context, system string, prompts []string, model string, options Options) (string, error)
+	// QueryStream sends the same query as QueryText but delivers the response
+	// to stream in chunks as the provider generates it. The complete response
+	// is also returned once the stream ends.
+	QueryStream(ctx context.Context, system string, prompts []string, model string, options Options, stream StreamFunc) (string, error)
+	// QueryMessages sends a conversation of user and assistant messages to the model
+	// and returns the next assistant reply with its token usage. If stream is not nil
+	// the reply is also delivered to it in chunks as it is generated.
+	QueryMessages(ctx context.Context, system string, messages []Message, model string, options Options, stream StreamFunc) (*Response, error)
 	Close() error
 }
-
-// NewClient creates a new AI client for the specified provider
-func NewClient(provider string) (Client, error) {
-	switch provider {
-	case Anthropic:
-		client, err := NewAnthropicClient()
-		if err != nil {
-			return nil, fmt.Errorf("failed to create Anthropic client: %w", err)
-		}
-		return client, nil
-	case DeepSeek:
-		client, err := NewDeepSeekClient()
-		if err != nil {
-			return nil, fmt.Errorf("failed to create DeepSeek client: %w", err)
-		}
-		return client, nil
-	case Gemini:
-		client, err := NewGeminiClient()
-		if err != nil {
-			return nil, fmt.Errorf("failed to create Geminip client: %w", err)
-		}
-		return client, nil
-	case OpenAI:
-		client, err := NewOpenAIClient()
-		if err != nil {
-			return nil, fmt.Errorf("failed to create OpenAI client: %w", err)
-		}
-		return client, nil
-	case Llama:
-		client, err := NewLlamaClient()
-		if err != nil {
-			return nil, fmt.Errorf("failed to create Llama client: %w", err)
-		}
-		return client, nil
-	default:
-		return nil, fmt.Errorf("unsupported provider: %s", provider)
-	}
-}
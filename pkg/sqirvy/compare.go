@@ -0,0 +1,89 @@
+// Package sqirvy provides a helper that sends one query to several models.
+//
+// This file implements Compare, which queries a list of models concurrently
+// with a limit on the number of queries in flight, and returns the response or
+// error of every model so they can be compared side by side.
+package sqirvy
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// DefaultCompareParallel is the number of models queried at once when
+// CompareQuery.Parallel is not set
+const DefaultCompareParallel = 4
+
+// CompareQuery is a query sent to every model of a comparison.
+type CompareQuery struct {
+	Models   []string  // Models to query, by name or alias
+	System   string    // System prompt sent to every model
+	Messages []Message // Conversation sent to every model
+	Options  Options   // Options of every query, MaxTokens is reduced to the limit of each model
+	Parallel int       // Models queried at once, zero for DefaultCompareParallel
+}
+
+// CompareResult is the outcome of a comparison for one model.
+// Exactly one of Response and Err is set.
+type CompareResult struct {
+	Model    string        // Resolved model name
+	Response *Response     // Response of the model
+	Err      error         // Error of the model, including failures to create its client
+	Duration time.Duration // Time taken by the query
+}
+
+// Compare sends query to each of its models concurrently, with at most
+// query.Parallel queries in flight, and returns one result per model in the
+// order of query.Models. The failure of a model does not stop the others.
+// opts are passed to the client of every model, so a comparison across
+// providers should leave the API key and base URL to the environment.
+func Compare(ctx context.Context, query CompareQuery, opts ...ClientOption) []CompareResult {
+	parallel := query.Parallel
+	if parallel <= 0 {
+		parallel = DefaultCompareParallel
+	}
+
+	results := make([]CompareResult, len(query.Models))
+	slots := make(chan struct{}, parallel)
+	var wg sync.WaitGroup
+	for i, model := range query.Models {
+		results[i].Model = GetModelAlias(model)
+		wg.Add(1)
+		go func(result *CompareResult) {
+			defer wg.Done()
+			select {
+			case slots <- struct{}{}:
+				defer func() { <-slots }()
+			case <-ctx.Done():
+				result.Err = ctx.Err()
+				return
+			}
+			start := time.Now()
+			result.Response, result.Err = compareModel(ctx, query, result.Model, opts)
+			result.Duration = time.Since(start)
+		}(&results[i])
+	}
+	wg.Wait()
+	return results
+}
+
+// compareModel sends query to a single model with a client of its own
+func compareModel(ctx context.Context, query CompareQuery, model string, opts []ClientOption) (*Response, error) {
+	provider, err := GetProviderName(model)
+	if err != nil {
+		return nil, err
+	}
+	client, err := NewClient(provider, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create client for %s: %w", provider, err)
+	}
+	defer client.Close()
+
+	options := query.Options
+	if limit := GetMaxTokens(model); options.MaxTokens > limit {
+		options.MaxTokens = limit
+	}
+	return client.QueryMessages(ctx, query.System, query.Messages, model, options, nil)
+}
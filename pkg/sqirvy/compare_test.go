@@ -0,0 +1,65 @@
+package sqirvy
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestCompare(t *testing.T) {
+	setMockScript(t, "responses:\n  - match: hello\n    text: scripted hello\n")
+	results := Compare(context.Background(), CompareQuery{
+		Models:   []string{MockEchoModel, "no-such-model", MockScriptModel},
+		Messages: UserMessages([]string{"hello"}),
+		Options:  Options{Retry: testRetryPolicy},
+	})
+	if len(results) != 3 {
+		t.Fatalf("Compare() returned %d results, want 3", len(results))
+	}
+
+	want := []string{"hello", "", "scripted hello"}
+	for i, result := range results {
+		if i == 1 {
+			if result.Err == nil || result.Response != nil {
+				t.Errorf("result for %s = %+v, want an error", result.Model, result)
+			}
+			continue
+		}
+		if result.Err != nil || result.Response == nil || result.Response.Text != want[i] {
+			t.Errorf("result for %s = %+v, want %q", result.Model, result, want[i])
+		}
+	}
+	if results[0].Model != MockEchoModel || results[2].Model != MockScriptModel {
+		t.Errorf("results are not in the order of the models: %s, %s", results[0].Model, results[2].Model)
+	}
+}
+
+func TestCompare_Parallel(t *testing.T) {
+	setMockScript(t, "latency: 20ms\nresponses:\n  - text: done\n")
+	query := CompareQuery{
+		Models:   []string{MockEchoModel, MockEchoModel, MockEchoModel},
+		Messages: UserMessages([]string{"hello"}),
+		Parallel: 1,
+	}
+
+	// one query at a time takes the latency of every model
+	start := time.Now()
+	for _, result := range Compare(context.Background(), query) {
+		if result.Err != nil || result.Duration < 20*time.Millisecond {
+			t.Errorf("result = %+v, want a reply after the latency", result)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 60*time.Millisecond {
+		t.Errorf("Compare() took %v with one query at a time, want at least 60ms", elapsed)
+	}
+
+	// a cancelled comparison fails the models it did not query
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	for _, result := range Compare(ctx, query) {
+		if !errors.Is(result.Err, context.Canceled) {
+			t.Errorf("result error = %v, want context.Canceled", result.Err)
+		}
+	}
+}
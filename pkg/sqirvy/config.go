@@ -0,0 +1,160 @@
+// Package sqirvy provides client configuration.
+//
+// This file implements the options accepted by NewClient and every provider
+// constructor. Options set the API key, base URL, HTTP client, timeout, proxy
+// extra headers and rate limiter of a client. The API key and base URL fall back to the
+// provider's environment variables when they are not set, so existing callers
+// that configure clients through the environment keep working.
+package sqirvy
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+)
+
+// ClientConfig holds the settings used to construct a client.
+type ClientConfig struct {
+	APIKey     string        // API key, defaults to the provider's API key environment variable
+	BaseURL    string        // API base URL, defaults to the provider's base URL environment variable
+	HTTPClient *http.Client  // HTTP client used for requests, defaults to a new client
+	Timeout    time.Duration // Default Options.Timeout of the client's queries, zero for no limit
+	Proxy      *url.URL      // Proxy for all requests, defaults to the transport's proxy
+	Headers    http.Header   // Extra headers added to every request
+	Limiter    *RateLimiter  // Rate limits applied by NewClient, nil for none
+}
+
+// ClientOption sets a field of the ClientConfig used to construct a client.
+type ClientOption func(*ClientConfig)
+
+// WithAPIKey sets the API key used to authenticate with the provider.
+func WithAPIKey(key string) ClientOption {
+	return func(c *ClientConfig) { c.APIKey = key }
+}
+
+// WithBaseURL sets the base URL of the provider's API.
+func WithBaseURL(baseURL string) ClientOption {
+	return func(c *ClientConfig) { c.BaseURL = baseURL }
+}
+
+// WithHTTPClient sets the HTTP client used for requests. The client is copied
+// before the proxy and headers are applied, so it is not modified.
+func WithHTTPClient(client *http.Client) ClientOption {
+	return func(c *ClientConfig) { c.HTTPClient = client }
+}
+
+// WithTimeout sets the default timeout of the client's queries. A query that
+// sets Options.Timeout uses its own timeout instead.
+func WithTimeout(timeout time.Duration) ClientOption {
+	return func(c *ClientConfig) { c.Timeout = timeout }
+}
+
+// WithProxy sends all requests through the proxy at proxyURL.
+func WithProxy(proxyURL *url.URL) ClientOption {
+	return func(c *ClientConfig) { c.Proxy = proxyURL }
+}
+
+// WithHeader adds a header to every request. Headers set this way replace
+// headers of the same name set by the provider's client.
+func WithHeader(key, value string) ClientOption {
+	return func(c *ClientConfig) {
+		if c.Headers == nil {
+			c.Headers = http.Header{}
+		}
+		c.Headers.Add(key, value)
+	}
+}
+
+// WithRateLimiter makes NewClient wrap the client in a RateLimitedClient, so
+// its queries wait for the limits of their provider. Clients that share a
+// limiter share its limits.
+func WithRateLimiter(limiter *RateLimiter) ClientOption {
+	return func(c *ClientConfig) { c.Limiter = limiter }
+}
+
+// NewClientConfig applies options to an empty ClientConfig. Provider factories
+// registered with RegisterProvider use it to read the options they are given.
+func NewClientConfig(opts ...ClientOption) ClientConfig {
+	var config ClientConfig
+	for _, opt := range opts {
+		if opt != nil {
+			opt(&config)
+		}
+	}
+	return config
+}
+
+// apiKey returns the configured API key or the value of the environment variable
+func (c ClientConfig) apiKey(env string) (string, error) {
+	if c.APIKey != "" {
+		return c.APIKey, nil
+	}
+	if key := os.Getenv(env); key != "" {
+		return key, nil
+	}
+	return "", fmt.Errorf("%s environment variable not set", env)
+}
+
+// baseURL returns the configured base URL or the value of the environment variable
+func (c ClientConfig) baseURL(env string) string {
+	if c.BaseURL != "" {
+		return c.BaseURL
+	}
+	return os.Getenv(env)
+}
+
+// customHTTP reports whether the config changes how HTTP requests are sent
+func (c ClientConfig) customHTTP() bool {
+	return c.HTTPClient != nil || c.Proxy != nil || len(c.Headers) > 0
+}
+
+// NewHTTPClient returns an HTTP client with the proxy and headers of the config
+// applied to a copy of HTTPClient. A proxy can only be applied if the client's
+// transport is an *http.Transport.
+func (c ClientConfig) NewHTTPClient() (*http.Client, error) {
+	client := &http.Client{}
+	if c.HTTPClient != nil {
+		copied := *c.HTTPClient
+		client = &copied
+	}
+
+	if c.Proxy != nil {
+		base := client.Transport
+		if base == nil {
+			base = http.DefaultTransport
+		}
+		transport, ok := base.(*http.Transport)
+		if !ok {
+			return nil, fmt.Errorf("cannot set a proxy on a %T transport", base)
+		}
+		transport = transport.Clone()
+		transport.Proxy = http.ProxyURL(c.Proxy)
+		client.Transport = transport
+	}
+
+	if len(c.Headers) > 0 {
+		base := client.Transport
+		if base == nil {
+			base = http.DefaultTransport
+		}
+		client.Transport = &headerTransport{base: base, headers: c.Headers.Clone()}
+	}
+	return client, nil
+}
+
+// headerTransport adds headers to every request sent through base
+type headerTransport struct {
+	base    http.RoundTripper
+	headers http.Header
+}
+
+func (t *headerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// a RoundTripper must not modify the request it is given
+	req = req.Clone(req.Context())
+	for key, values := range t.headers {
+		req.Header[key] = values
+	}
+	return t.base.RoundTrip(req)
+}
@@ -0,0 +1,202 @@
+package sqirvy
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNewClient_EnvFallback(t *testing.T) {
+	t.Setenv("OPENAI_API_KEY", "env-key")
+	t.Setenv("OPENAI_BASE_URL", "https://env.example.com")
+
+	client, err := NewOpenAIClient()
+	if err != nil {
+		t.Fatalf("NewOpenAIClient() error = %v", err)
+	}
+	if client.apiKey != "env-key" || client.baseURL != "https://env.example.com" {
+		t.Errorf("NewOpenAIClient() = %s, %s, want the environment", client.apiKey, client.baseURL)
+	}
+
+	client, err = NewOpenAIClient(WithAPIKey("option-key"), WithBaseURL("https://option.example.com"))
+	if err != nil {
+		t.Fatalf("NewOpenAIClient() error = %v", err)
+	}
+	if client.apiKey != "option-key" || client.baseURL != "https://option.example.com" {
+		t.Errorf("NewOpenAIClient() = %s, %s, want the options", client.apiKey, client.baseURL)
+	}
+
+	t.Setenv("DEEPSEEK_API_KEY", "")
+	if _, err := NewClient(DeepSeek, WithBaseURL("https://option.example.com")); err == nil || !strings.Contains(err.Error(), "DEEPSEEK_API_KEY") {
+		t.Errorf("NewClient(deepseek) error = %v, want a missing API key", err)
+	}
+}
+
+func TestNewHTTPClient(t *testing.T) {
+	base := &http.Client{Timeout: time.Minute}
+	proxy, _ := url.Parse("http://proxy.example.com:3128")
+	config := NewClientConfig(
+		WithHTTPClient(base),
+		WithProxy(proxy),
+		WithHeader("X-Tenant", "acme"),
+	)
+
+	client, err := config.NewHTTPClient()
+	if err != nil {
+		t.Fatalf("NewHTTPClient() error = %v", err)
+	}
+	if client == base || base.Timeout != time.Minute || base.Transport != nil {
+		t.Errorf("NewHTTPClient() modified the given client")
+	}
+	if client.Timeout != time.Minute {
+		t.Errorf("Timeout = %v, want the timeout of the given client", client.Timeout)
+	}
+	headers, ok := client.Transport.(*headerTransport)
+	if !ok || headers.headers.Get("X-Tenant") != "acme" {
+		t.Fatalf("Transport = %T, want headers added", client.Transport)
+	}
+	transport := headers.base.(*http.Transport)
+	got, err := transport.Proxy(httptest.NewRequest(http.MethodGet, "https://api.example.com", nil))
+	if err != nil || got.String() != proxy.String() {
+		t.Errorf("Proxy = %v, %v, want %v", got, err, proxy)
+	}
+
+	// a proxy cannot be set on an unknown transport
+	config = NewClientConfig(WithHTTPClient(&http.Client{Transport: headers}), WithProxy(proxy))
+	if _, err := config.NewHTTPClient(); err == nil {
+		t.Errorf("NewHTTPClient() should fail to set a proxy")
+	}
+}
+
+// TestClientOptions checks that every provider sends its requests to the
+// base URL with the API key and headers from the options
+func TestClientOptions(t *testing.T) {
+	for _, env := range []string{"ANTHROPIC_API_KEY", "ANTHROPIC_AUTH_TOKEN", "DEEPSEEK_API_KEY", "GEMINI_API_KEY", "LLAMA_API_KEY", "OPENAI_API_KEY"} {
+		t.Setenv(env, "")
+	}
+
+	responses := map[string]string{
+		"/v1/chat/completions": `{"model":"gpt-4o","choices":[{"message":{"content":"ok"},"finish_reason":"stop"}]}`,
+		"/chat/completions":    `{"id":"1","object":"chat.completion","model":"test","choices":[{"index":0,"message":{"role":"assistant","content":"ok"},"finish_reason":"stop"}]}`,
+		"/v1/messages":         `{"id":"msg_1","type":"message","role":"assistant","model":"claude-3-5-haiku-latest","content":[{"type":"text","text":"ok"}],"stop_reason":"end_turn","usage":{"input_tokens":1,"output_tokens":1}}`,
+		"/v1beta/models/gemini-2.0-flash:streamGenerateContent": `[{"candidates":[{"content":{"role":"model","parts":[{"text":"ok"}]},"finishReason":"STOP"}]}]`,
+	}
+	var got http.Header
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Clone()
+		response, ok := responses[r.URL.Path]
+		if !ok {
+			http.Error(w, "unexpected path "+r.URL.Path, http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(response))
+	}))
+	defer ts.Close()
+
+	tests := []struct {
+		provider   string
+		model      string
+		baseURL    string
+		authHeader string
+		wantAuth   string
+	}{
+		{provider: OpenAI, model: "gpt-4o", baseURL: ts.URL, authHeader: "Authorization", wantAuth: "Bearer test-key"},
+		{provider: DeepSeek, model: "deepseek-v3", baseURL: ts.URL, authHeader: "Authorization", wantAuth: "Bearer test-key"},
+		{provider: Llama, model: "llama3.3-70b", baseURL: ts.URL, authHeader: "Authorization", wantAuth: "Bearer test-key"},
+		{provider: Anthropic, model: "claude-3-5-haiku-latest", baseURL: ts.URL, authHeader: "X-Api-Key", wantAuth: "test-key"},
+		{provider: Gemini, model: "gemini-2.0-flash", baseURL: ts.URL, authHeader: "X-Goog-Api-Key", wantAuth: "test-key"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.provider, func(t *testing.T) {
+			client, err := NewClient(tt.provider,
+				WithAPIKey("test-key"),
+				WithBaseURL(tt.baseURL),
+				WithHTTPClient(ts.Client()),
+				WithHeader("X-Tenant", "acme"),
+			)
+			if err != nil {
+				t.Fatalf("NewClient(%s) error = %v", tt.provider, err)
+			}
+			defer client.Close()
+
+			got = nil
+			text, err := client.QueryText(context.Background(), "", []string{"hello"}, tt.model, Options{})
+			if got == nil {
+				t.Fatalf("QueryText() did not reach the base URL: %v", err)
+			}
+			// only the request is checked where the Gemini SDK cannot read its reply
+			if (tt.provider != Gemini || geminiStreamSupported()) && (err != nil || text != "ok") {
+				t.Errorf("QueryText() = %q, %v, want ok", text, err)
+			}
+			if auth := got.Get(tt.authHeader); auth != tt.wantAuth {
+				t.Errorf("%s = %q, want %q", tt.authHeader, auth, tt.wantAuth)
+			}
+			if tenant := got.Get("X-Tenant"); tenant != "acme" {
+				t.Errorf("X-Tenant = %q, want acme", tenant)
+			}
+		})
+	}
+}
+
+// TestQueryTimeout checks that every provider stops a query that outlasts
+// the timeout of the client or of the query
+func TestQueryTimeout(t *testing.T) {
+	release := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-release:
+		}
+	}))
+	defer ts.Close()
+	defer close(release)
+
+	tests := []struct {
+		provider string
+		model    string
+	}{
+		{provider: OpenAI, model: "gpt-4o"},
+		{provider: DeepSeek, model: "deepseek-v3"},
+		{provider: Llama, model: "llama3.3-70b"},
+		{provider: Anthropic, model: "claude-3-5-haiku-latest"},
+		{provider: Gemini, model: "gemini-2.0-flash"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.provider, func(t *testing.T) {
+			client, err := NewClient(tt.provider,
+				WithAPIKey("test-key"),
+				WithBaseURL(ts.URL),
+				WithHTTPClient(ts.Client()),
+				WithTimeout(time.Hour),
+			)
+			if err != nil {
+				t.Fatalf("NewClient(%s) error = %v", tt.provider, err)
+			}
+			defer client.Close()
+
+			start := time.Now()
+			_, err = client.QueryText(context.Background(), "", []string{"hello"}, tt.model, Options{Timeout: 50 * time.Millisecond})
+			if !errors.Is(err, ErrTimeout) {
+				t.Errorf("QueryText() error = %v, want ErrTimeout", err)
+			}
+			if elapsed := time.Since(start); elapsed > 2*time.Second {
+				t.Errorf("QueryText() took %v, want the query timeout", elapsed)
+			}
+		})
+	}
+
+	// the client's timeout is used when the query does not set one
+	client, err := NewOpenAIClient(WithAPIKey("test-key"), WithBaseURL(ts.URL), WithTimeout(50*time.Millisecond))
+	if err != nil {
+		t.Fatalf("NewOpenAIClient() error = %v", err)
+	}
+	if _, err := client.QueryText(context.Background(), "", []string{"hello"}, "gpt-4o", Options{}); !errors.Is(err, ErrTimeout) {
+		t.Errorf("QueryText() error = %v, want ErrTimeout", err)
+	}
+}
@@ -12,7 +12,7 @@ import (
 	"fmt"
 	"io"
 	"net/http"
-	"os"
+	"time"
 )
 
 const (
@@ -24,63 +24,125 @@ const (
 // It provides methods for querying DeepSeek's language models through
 // their HTTP API.
 type DeepSeekClient struct {
-	apiKey  string       // DeepSeek API authentication key
-	baseURL string       // DeepSeek API base URL
-	client  *http.Client // HTTP client for making API requests
+	apiKey  string        // DeepSeek API authentication key
+	baseURL string        // DeepSeek API base URL
+	client  *http.Client  // HTTP client for making API requests
+	timeout time.Duration // Default timeout of a query
 }
 
 // Ensure DeepSeekClient implements the Client interface
 var _ Client = (*DeepSeekClient)(nil)
 
+func init() {
+	mustRegister(DeepSeek, func(opts ...ClientOption) (Client, error) { return NewDeepSeekClient(opts...) }, deepseekModels)
+}
+
 // NewDeepSeekClient creates a new instance of DeepSeekClient.
-// It returns an error if the required environment variables are not set.
-func NewDeepSeekClient() (*DeepSeekClient, error) {
-	apiKey := os.Getenv("DEEPSEEK_API_KEY")
-	if apiKey == "" {
-		return nil, fmt.Errorf("DEEPSEEK_API_KEY environment variable not set")
+// The API key and base URL are taken from the options, or from the
+// DEEPSEEK_API_KEY and DEEPSEEK_BASE_URL environment variables if not set.
+// It returns an error if either is missing.
+func NewDeepSeekClient(opts ...ClientOption) (*DeepSeekClient, error) {
+	config := NewClientConfig(opts...)
+	apiKey, err := config.apiKey("DEEPSEEK_API_KEY")
+	if err != nil {
+		return nil, err
 	}
 
-	baseURL := os.Getenv("DEEPSEEK_BASE_URL")
+	baseURL := config.baseURL("DEEPSEEK_BASE_URL")
 	if baseURL == "" {
 		return nil, fmt.Errorf("DEEPSEEK_BASE_URL environment variable not set")
 	}
 
+	client, err := config.NewHTTPClient()
+	if err != nil {
+		return nil, err
+	}
+
 	return &DeepSeekClient{
 		apiKey:  apiKey,
 		baseURL: baseURL,
-		client:  &http.Client{},
+		client:  client,
+		timeout: config.Timeout,
 	}, nil
 }
 
 // deepseekRequest represents the structure of a request to deepseek's chat completion API
 type deepseekRequest struct {
-	Model          string            `json:"model"`                           // Model identifier
-	Messages       []deepseekMessage `json:"messages"`                        // Conversation messages
-	MaxTokens      int               `json:"max_completion_tokens,omitempty"` // Max response length
-	ResponseFormat string            `json:"response_format,omitempty"`       // Desired response format
-	Temperature    float32           `json:"temperature,omitempty"`           // Controls the randomness of the output
+	Model          string              `json:"model"`                           // Model identifier
+	Messages       []deepseekMessage   `json:"messages"`                        // Conversation messages
+	MaxTokens      int                 `json:"max_completion_tokens,omitempty"` // Max response length
+	ResponseFormat *chatResponseFormat `json:"response_format,omitempty"`       // Desired response format
+	Temperature    float32             `json:"temperature,omitempty"`           // Controls the randomness of the output
+	Tools          []chatTool          `json:"tools,omitempty"`                 // Functions the model may call
+	StreamOptions  *chatStreamOptions  `json:"stream_options,omitempty"`        // Request usage in the final stream event
+	Stream         bool                `json:"stream,omitempty"`                // Stream the response as server-sent events
 }
 
 type deepseekMessage struct {
-	Role    string `json:"role"`
-	Content string `json:"content"`
+	Role       string         `json:"role"`
+	Content    string         `json:"content"`
+	ToolCalls  []chatToolCall `json:"tool_calls,omitempty"`
+	ToolCallID string         `json:"tool_call_id,omitempty"`
 }
 
 type deepseekResponse struct {
+	Model   string `json:"model"`
 	Choices []struct {
 		Message struct {
-			Content string `json:"content"`
+			Content   string         `json:"content"`
+			ToolCalls []chatToolCall `json:"tool_calls"`
 		} `json:"message"`
+		FinishReason string `json:"finish_reason"`
 	} `json:"choices"`
+	Usage chatUsage `json:"usage"`
 }
 
+// DeepSeekClient.QueryText implements the QueryText method for the Client interface.
+// It sends a text query to DeepSeek's API and returns the generated text response.
 func (c *DeepSeekClient) QueryText(ctx context.Context, system string, prompts []string, model string, options Options) (string, error) {
+	return responseText(c.QueryMessages(ctx, system, UserMessages(prompts), model, options, nil))
+}
+
+// DeepSeekClient.QueryStream implements the QueryStream method for the Client interface.
+// It sends a streaming text query to DeepSeek's API and passes each content delta to stream.
+func (c *DeepSeekClient) QueryStream(ctx context.Context, system string, prompts []string, model string, options Options, stream StreamFunc) (string, error) {
+	return responseText(c.QueryMessages(ctx, system, UserMessages(prompts), model, options, stream))
+}
+
+// DeepSeekClient.QueryMessages implements the QueryMessages method for the Client interface.
+// It sends a conversation to DeepSeek's API, streaming the reply if stream is not nil.
+func (c *DeepSeekClient) QueryMessages(ctx context.Context, system string, messages []Message, model string, options Options, stream StreamFunc) (*Response, error) {
+	ctx, cancel := queryContext(ctx, options, c.timeout)
+	defer cancel()
+
+	reqBody, err := c.newRequest(ctx, system, messages, model, options)
+	if err != nil {
+		return nil, err
+	}
+
+	// Send request and return response, retrying transient failures
+	if stream == nil {
+		resp, err := withRetry(ctx, options.Retry, nil, func(StreamFunc) (*Response, error) {
+			return c.makeRequest(ctx, reqBody)
+		})
+		return resp, newProviderError(DeepSeek, err)
+	}
+	reqBody.Stream = true
+	reqBody.StreamOptions = &chatStreamOptions{IncludeUsage: true}
+	resp, err := withRetry(ctx, options.Retry, stream, func(stream StreamFunc) (*Response, error) {
+		return c.makeStreamRequest(ctx, reqBody, stream)
+	})
+	return resp, newProviderError(DeepSeek, err)
+}
+
+// newRequest validates the query parameters and builds the chat completion request body
+func (c *DeepSeekClient) newRequest(ctx context.Context, system string, messages []Message, model string, options Options) (deepseekRequest, error) {
 	if ctx.Err() != nil {
-		return "", fmt.Errorf("request context error %w", ctx.Err())
+		return deepseekRequest{}, fmt.Errorf("request context error %w", ctx.Err())
 	}
 
-	if len(prompts) == 0 {
-		return "", fmt.Errorf("prompts cannot be empty for text query")
+	if err := validateMessages(messages); err != nil {
+		return deepseekRequest{}, err
 	}
 
 	// Set default and validate temperature
@@ -88,7 +150,7 @@ func (c *DeepSeekClient) QueryText(ctx context.Context, system string, prompts [
 		options.Temperature = MinTemperature
 	}
 	if options.Temperature > MaxTemperature {
-		return "", fmt.Errorf("temperature must be between %.1f and %.1f", MinTemperature, MaxTemperature)
+		return deepseekRequest{}, fmt.Errorf("temperature must be between %.1f and %.1f", MinTemperature, MaxTemperature)
 	}
 	// Scale temperature for DeepSeek's 0-2 range
 	options.Temperature = (options.Temperature * DeepSeekTempScale) / MaxTemperature
@@ -99,41 +161,46 @@ func (c *DeepSeekClient) QueryText(ctx context.Context, system string, prompts [
 		maxTokens = MaxTokensDefault
 	}
 
-	messages := make([]deepseekMessage, 0, len(prompts))
-	// First prompt is system prompt
-	messages = append(messages, deepseekMessage{Role: "system", Content: system})
-	for _, p := range prompts {
-		messages = append(messages, deepseekMessage{Role: "user", Content: p})
-	}
+	// DeepSeek only supports JSON mode, so the schema goes in the prompt
+	responseFormat := newChatResponseFormat(options, false)
+	system = jsonSystemPrompt(system, options)
 
-	// Construct the request body with the prompt as a user message
-	reqBody := deepseekRequest{
-		Model:       model,
-		Messages:    messages,
-		MaxTokens:   int(maxTokens),      // Limit response length
-		Temperature: options.Temperature, // Set temperature
+	// system prompt first, then the conversation
+	reqMessages := make([]deepseekMessage, 0, len(messages)+1)
+	reqMessages = append(reqMessages, deepseekMessage{Role: "system", Content: system})
+	for _, m := range messages {
+		reqMessages = append(reqMessages, deepseekMessage{
+			Role:       m.Role,
+			Content:    m.Content,
+			ToolCalls:  newChatToolCalls(m.ToolCalls),
+			ToolCallID: m.ToolCallID,
+		})
 	}
 
-	// Send request and return response
-	return c.makeRequest(ctx, reqBody)
+	// Construct the request body
+	return deepseekRequest{
+		Model:          model,
+		Messages:       reqMessages,
+		MaxTokens:      int(maxTokens),      // Limit response length
+		Temperature:    options.Temperature, // Set temperature
+		ResponseFormat: responseFormat,
+		Tools:          newChatTools(options.Tools),
+	}, nil
 }
 
-func (c *DeepSeekClient) makeRequest(ctx context.Context, reqBody deepseekRequest) (string, error) {
+func (c *DeepSeekClient) makeRequest(ctx context.Context, reqBody deepseekRequest) (*Response, error) {
 	// Convert request body to JSON
 	jsonBody, err := json.Marshal(reqBody)
 	if err != nil {
-		return "", fmt.Errorf("failed to marshal request: %w", err)
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
 	}
 
 	// Create new HTTP request with JSON body
 	endpoint := c.baseURL + "/chat/completions"
 
-	ctx, cancel := context.WithTimeout(ctx, RequestTimeout)
-	defer cancel()
-
 	req, err := http.NewRequestWithContext(ctx, "POST", endpoint, bytes.NewBuffer(jsonBody))
 	if err != nil {
-		return "", fmt.Errorf("failed to create request: %w", err)
+		return nil, fmt.Errorf("failed to create request: %w", err)
 	}
 
 	// Set required headers
@@ -143,34 +210,82 @@ func (c *DeepSeekClient) makeRequest(ctx context.Context, reqBody deepseekReques
 	// Send the request
 	resp, err := c.client.Do(req)
 	if err != nil {
-		return "", fmt.Errorf("failed to make request: %w", err)
+		return nil, fmt.Errorf("failed to make request: %w", err)
 	}
 	defer resp.Body.Close()
 
 	// Read response body
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return "", fmt.Errorf("failed to read response body: %w", err)
+		return nil, fmt.Errorf("failed to read response body: %w", err)
 	}
 
 	// Check for non-200 status code
 	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("API request failed with status %d: %s", resp.StatusCode, string(body))
+		return nil, newStatusError(resp, body)
 	}
 
 	// Parse response JSON
 	var deepseekResp deepseekResponse
 	if err := json.Unmarshal(body, &deepseekResp); err != nil {
-		return "", fmt.Errorf("failed to unmarshal response: %w", err)
+		return nil, fmt.Errorf("failed to unmarshal response: %w", err)
 	}
 
 	// Ensure we got at least one choice back
 	if len(deepseekResp.Choices) == 0 {
-		return "", fmt.Errorf("no content in response")
+		return nil, fmt.Errorf("no content in response")
+	}
+
+	// A filtered response has no content to return
+	if deepseekResp.Choices[0].FinishReason == "content_filter" && deepseekResp.Choices[0].Message.Content == "" {
+		return nil, fmt.Errorf("response blocked: %w", ErrContentFiltered)
 	}
 
 	// Return the content of the first choice
-	return deepseekResp.Choices[0].Message.Content, nil
+	return &Response{
+		Text:         deepseekResp.Choices[0].Message.Content,
+		Model:        deepseekResp.Model,
+		FinishReason: deepseekResp.Choices[0].FinishReason,
+		InputTokens:  deepseekResp.Usage.PromptTokens,
+		OutputTokens: deepseekResp.Usage.CompletionTokens,
+		ToolCalls:    chatToolCalls(deepseekResp.Choices[0].Message.ToolCalls),
+	}, nil
+}
+
+func (c *DeepSeekClient) makeStreamRequest(ctx context.Context, reqBody deepseekRequest, stream StreamFunc) (*Response, error) {
+	// Convert request body to JSON
+	jsonBody, err := json.Marshal(reqBody)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	endpoint := c.baseURL + "/chat/completions"
+
+	req, err := http.NewRequestWithContext(ctx, "POST", endpoint, bytes.NewBuffer(jsonBody))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	// Set required headers
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "text/event-stream")
+	req.Header.Set("Authorization", "Bearer "+c.apiKey)
+
+	// Send the request
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to make request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	// Check for non-200 status code
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, newStatusError(resp, body)
+	}
+
+	// Read the event stream
+	return readChatStream(resp.Body, stream)
 }
 
 // Close implements the Close method for the Client interface.
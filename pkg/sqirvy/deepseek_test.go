@@ -102,3 +102,42 @@ func TestDeepSeekClient_Query_R1(t *testing.T) {
 // 		})
 // 	}
 // }
+
+func TestDeepSeekClient_Replay(t *testing.T) {
+	runCassetteTests(t, DeepSeek, []cassetteTest{
+		{
+			cassette: "deepseek_text",
+			model:    "deepseek-v3",
+			prompts:  []string{"Say hello"},
+			want:     Response{Text: "Hello! How can I assist you today? 😊", Model: "deepseek-v3", FinishReason: "stop", InputTokens: 11, OutputTokens: 11},
+		},
+		{
+			cassette: "deepseek_stream",
+			model:    "deepseek-v3",
+			prompts:  []string{"Say hello"},
+			stream:   true,
+			want:     Response{Text: "Hello! How can I assist you today?", Model: "deepseek-v3", FinishReason: "stop", InputTokens: 11, OutputTokens: 9},
+		},
+		{
+			// the reasoning of R1 is not part of the response text
+			cassette: "deepseek_reasoning",
+			model:    "deepseek-r1",
+			prompts:  []string{"Is 17 prime? Answer yes or no."},
+			want:     Response{Text: "Yes", Model: "deepseek-r1", FinishReason: "stop", InputTokens: 20, OutputTokens: 18},
+		},
+		{
+			cassette: "deepseek_auth_error",
+			model:    "deepseek-v3",
+			prompts:  []string{"Say hello"},
+			apiKey:   "sk-invalid",
+			wantErr:  ErrAuthentication,
+		},
+		{
+			// DeepSeek answers an unknown model with 400 Model Not Exist
+			cassette: "deepseek_model_not_found",
+			model:    "deepseek-v0",
+			prompts:  []string{"Say hello"},
+			wantErr:  ErrModelNotFound,
+		},
+	})
+}
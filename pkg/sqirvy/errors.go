@@ -0,0 +1,249 @@
+// Package sqirvy provides typed errors for provider failures.
+//
+// This file defines the sentinel errors that classify why a provider request
+// failed, and the ProviderError type that every client returns when a request
+// to its provider fails. Callers can test for a kind of failure with errors.Is,
+// and inspect the details with errors.As.
+package sqirvy
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/generative-ai-go/genai"
+)
+
+// Kinds of provider failure. Errors returned by clients match one of these
+// with errors.Is when the failure can be classified.
+var (
+	ErrAuthentication  = errors.New("authentication failed")        // Missing, invalid or unauthorized API key
+	ErrRateLimited     = errors.New("rate limited")                 // Too many requests or tokens, or quota exhausted
+	ErrContextTooLong  = errors.New("context too long")             // Input exceeds the model's context window
+	ErrContentFiltered = errors.New("content filtered")             // Prompt or response blocked by a safety filter
+	ErrModelNotFound   = errors.New("model not found")              // Model does not exist or is not available to the key
+	ErrTimeout         = errors.New("request timed out")            // Request did not complete in time
+	ErrUnavailable     = errors.New("provider service unavailable") // Provider is overloaded or failing
+)
+
+// ProviderError is returned when a request to a provider fails.
+type ProviderError struct {
+	Provider   string        // Provider that returned the error
+	Kind       error         // One of the Err* sentinel errors, nil if the failure is not classified
+	StatusCode int           // HTTP status returned by the provider, zero if unknown
+	RetryAfter time.Duration // Delay requested by the provider before retrying, zero if not set
+	Err        error         // Underlying error
+}
+
+func (e *ProviderError) Error() string {
+	if e.Kind == nil {
+		return fmt.Sprintf("%s: %v", e.Provider, e.Err)
+	}
+	return fmt.Sprintf("%s: %v: %v", e.Provider, e.Kind, e.Err)
+}
+
+// Unwrap returns both the kind and the underlying error, so errors.Is and
+// errors.As match either of them.
+func (e *ProviderError) Unwrap() []error {
+	if e.Kind == nil {
+		return []error{e.Err}
+	}
+	return []error{e.Kind, e.Err}
+}
+
+// newProviderError classifies a failed request to provider and wraps it in a ProviderError.
+// Errors that are already a ProviderError are returned unchanged.
+func newProviderError(provider string, err error) error {
+	if err == nil {
+		return nil
+	}
+	var providerErr *ProviderError
+	if errors.As(err, &providerErr) {
+		return err
+	}
+
+	code, retryAfter := errorStatus(err)
+	return &ProviderError{
+		Provider:   provider,
+		Kind:       errorKind(provider, code, err),
+		StatusCode: code,
+		RetryAfter: retryAfter,
+		Err:        err,
+	}
+}
+
+// errorKind classifies an error of provider. Typed errors decide first, then
+// the error code or type the provider reports in the body of its response,
+// then the HTTP status. Providers do not agree on status codes for every
+// failure, for example a context that is too long is a 400 without a code for
+// some of them, so only then is the message matched against the phrases the
+// provider is known to use. Matching arbitrary words such as "quota" would
+// misclassify an error that quotes the prompt.
+func errorKind(provider string, code int, err error) error {
+	var blockedErr *genai.BlockedError
+	switch {
+	case errors.As(err, &blockedErr), errors.Is(err, ErrContentFiltered):
+		return ErrContentFiltered
+	case errors.Is(err, context.DeadlineExceeded):
+		return ErrTimeout
+	}
+
+	for _, errorCode := range errorCodes(err) {
+		if kind, ok := providerErrorCodes[errorCode]; ok {
+			return kind
+		}
+	}
+
+	switch {
+	case code == http.StatusUnauthorized, code == http.StatusForbidden:
+		return ErrAuthentication
+	case code == http.StatusTooManyRequests:
+		return ErrRateLimited
+	case code == http.StatusRequestEntityTooLarge:
+		return ErrContextTooLong
+	case code == http.StatusNotFound:
+		return ErrModelNotFound
+	case code == http.StatusRequestTimeout, code == http.StatusGatewayTimeout:
+		return ErrTimeout
+	case code >= http.StatusInternalServerError:
+		return ErrUnavailable
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return ErrTimeout
+	}
+
+	// the mock provider simulates the failures of every provider
+	message := strings.ToLower(err.Error())
+	for name, phrases := range providerErrorPhrases {
+		if name != provider && provider != Mock {
+			continue
+		}
+		for _, phrase := range phrases {
+			if strings.Contains(message, phrase.text) {
+				return phrase.kind
+			}
+		}
+	}
+	return nil
+}
+
+// providerErrorCodes maps the error codes and types providers report to the
+// kind of failure
+var providerErrorCodes = map[string]error{
+	// OpenAI and compatible APIs, in error.code or error.type
+	"invalid_api_key":          ErrAuthentication,
+	"insufficient_quota":       ErrRateLimited,
+	"rate_limit_exceeded":      ErrRateLimited,
+	"context_length_exceeded":  ErrContextTooLong,
+	"string_above_max_length":  ErrContextTooLong,
+	"content_filter":           ErrContentFiltered,
+	"content_policy_violation": ErrContentFiltered,
+	"model_not_found":          ErrModelNotFound,
+
+	// Anthropic, in error.type
+	"authentication_error": ErrAuthentication,
+	"permission_error":     ErrAuthentication,
+	"rate_limit_error":     ErrRateLimited,
+	"request_too_large":    ErrContextTooLong,
+	"not_found_error":      ErrModelNotFound,
+	"overloaded_error":     ErrUnavailable,
+	"api_error":            ErrUnavailable,
+
+	// Gemini, in error.status or the reason of its error details
+	"UNAUTHENTICATED":    ErrAuthentication,
+	"PERMISSION_DENIED":  ErrAuthentication,
+	"API_KEY_INVALID":    ErrAuthentication,
+	"RESOURCE_EXHAUSTED": ErrRateLimited,
+	"NOT_FOUND":          ErrModelNotFound,
+	"DEADLINE_EXCEEDED":  ErrTimeout,
+	"UNAVAILABLE":        ErrUnavailable,
+}
+
+// errorPhrase is a phrase of an error message that classifies it
+type errorPhrase struct {
+	text string // lower case phrase
+	kind error  // kind of failure
+}
+
+// providerErrorPhrases lists the phrases each provider uses in messages of
+// failures that have neither an error code nor a distinct status
+var providerErrorPhrases = map[string][]errorPhrase{
+	OpenAI: {
+		{"incorrect api key", ErrAuthentication},
+		{"maximum context length", ErrContextTooLong},
+	},
+	DeepSeek: {
+		{"maximum context length", ErrContextTooLong},
+		{"model not exist", ErrModelNotFound},
+	},
+	Llama: {
+		{"maximum context length", ErrContextTooLong},
+		{"model does not exist", ErrModelNotFound},
+	},
+	Anthropic: {
+		{"invalid x-api-key", ErrAuthentication},
+		{"prompt is too long", ErrContextTooLong},
+	},
+	Gemini: {
+		{"api key not valid", ErrAuthentication},
+		{"input token count", ErrContextTooLong},
+		{"is not found for api version", ErrModelNotFound},
+	},
+}
+
+// errorCodes returns the error codes, types and reasons a provider reported
+// for err, from the JSON error object of the response body and the reason of
+// a Google API error, most specific first
+func errorCodes(err error) []string {
+	var codes []string
+	var reasonErr interface{ Reason() string }
+	if errors.As(err, &reasonErr) && reasonErr.Reason() != "" {
+		codes = append(codes, reasonErr.Reason())
+	}
+
+	body := err.Error()
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		body = statusErr.Body
+	}
+	start := strings.Index(body, "{")
+	if start < 0 {
+		return codes
+	}
+	var response struct {
+		Error struct {
+			Code    any    `json:"code"`
+			Type    string `json:"type"`
+			Status  string `json:"status"`
+			Details []struct {
+				Reason string `json:"reason"`
+			} `json:"details"`
+		} `json:"error"`
+	}
+	// the body may be followed by other text, so decode only its first value
+	if json.NewDecoder(strings.NewReader(body[start:])).Decode(&response) != nil {
+		return codes
+	}
+	if code, ok := response.Error.Code.(string); ok && code != "" {
+		codes = append(codes, code)
+	}
+	for _, detail := range response.Error.Details {
+		if detail.Reason != "" {
+			codes = append(codes, detail.Reason)
+		}
+	}
+	if response.Error.Type != "" {
+		codes = append(codes, response.Error.Type)
+	}
+	if response.Error.Status != "" {
+		codes = append(codes, response.Error.Status)
+	}
+	return codes
+}
@@ -0,0 +1,89 @@
+package sqirvy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/generative-ai-go/genai"
+)
+
+func TestNewProviderError(t *testing.T) {
+	tests := []struct {
+		name     string
+		provider string
+		err      error
+		wantKind error
+	}{
+		{name: "Unauthorized", provider: OpenAI, err: &StatusError{StatusCode: 401}, wantKind: ErrAuthentication},
+		{name: "Gemini invalid key", provider: Gemini, err: errors.New("googleapi: Error 400: API key not valid. Please pass a valid API key."), wantKind: ErrAuthentication},
+		{name: "Gemini invalid key reason", provider: Gemini, err: &StatusError{StatusCode: 400, Body: `{"error":{"code":400,"status":"INVALID_ARGUMENT","details":[{"reason":"API_KEY_INVALID"}]}}`}, wantKind: ErrAuthentication},
+		{name: "Rate limited", provider: OpenAI, err: &StatusError{StatusCode: 429, RetryAfter: time.Second}, wantKind: ErrRateLimited},
+		{name: "OpenAI quota", provider: OpenAI, err: &StatusError{StatusCode: 429, Body: `{"error":{"code":"insufficient_quota","type":"insufficient_quota"}}`}, wantKind: ErrRateLimited},
+		{name: "OpenAI context length", provider: OpenAI, err: &StatusError{StatusCode: 400, Body: `{"error":{"code":"context_length_exceeded","message":"This model's maximum context length is 128000 tokens."}}`}, wantKind: ErrContextTooLong},
+		{name: "Anthropic prompt too long", provider: Anthropic, err: &StatusError{StatusCode: 400, Body: `{"error":{"type":"invalid_request_error","message":"prompt is too long: 210000 tokens > 200000 maximum"}}`}, wantKind: ErrContextTooLong},
+		{name: "Anthropic overloaded", provider: Anthropic, err: &StatusError{StatusCode: 529, Body: `{"type":"error","error":{"type":"overloaded_error","message":"Overloaded"}}`}, wantKind: ErrUnavailable},
+		{name: "Content filter", provider: OpenAI, err: fmt.Errorf("response blocked: %w", ErrContentFiltered), wantKind: ErrContentFiltered},
+		{name: "OpenAI content policy", provider: OpenAI, err: &StatusError{StatusCode: 400, Body: `{"error":{"code":"content_policy_violation","type":"invalid_request_error"}}`}, wantKind: ErrContentFiltered},
+		{name: "Gemini blocked", provider: Gemini, err: fmt.Errorf("failed to generate content: %w", &genai.BlockedError{}), wantKind: ErrContentFiltered},
+		{name: "Model not found", provider: OpenAI, err: &StatusError{StatusCode: 404, Body: `{"error":{"code":"model_not_found"}}`}, wantKind: ErrModelNotFound},
+		{name: "Langchaingo model not found", provider: Llama, err: errors.New("API returned unexpected status code: 404: The model does not exist"), wantKind: ErrModelNotFound},
+		{name: "Timeout", provider: OpenAI, err: fmt.Errorf("failed to make request: %w", context.DeadlineExceeded), wantKind: ErrTimeout},
+		{name: "Gateway timeout", provider: OpenAI, err: &StatusError{StatusCode: 504}, wantKind: ErrTimeout},
+		{name: "Overloaded", provider: Anthropic, err: &StatusError{StatusCode: 529}, wantKind: ErrUnavailable},
+		{name: "Unclassified", provider: OpenAI, err: errors.New("no content in response"), wantKind: nil},
+		{name: "Message quoting the prompt", provider: OpenAI, err: &StatusError{StatusCode: 400, Body: `{"error":{"type":"invalid_request_error","message":"Invalid value 'safety quota, rate limit and context window' for tools"}}`}, wantKind: nil},
+		{name: "Phrase of another provider", provider: OpenAI, err: &StatusError{StatusCode: 400, Body: `{"error":{"message":"prompt is too long"}}`}, wantKind: nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := newProviderError(tt.provider, tt.err)
+
+			var providerErr *ProviderError
+			if !errors.As(err, &providerErr) {
+				t.Fatalf("newProviderError() = %T, want *ProviderError", err)
+			}
+			if providerErr.Kind != tt.wantKind {
+				t.Errorf("newProviderError() kind = %v, want %v", providerErr.Kind, tt.wantKind)
+			}
+			if tt.wantKind != nil && !errors.Is(err, tt.wantKind) {
+				t.Errorf("errors.Is(%v, %v) = false", err, tt.wantKind)
+			}
+			if !errors.Is(err, tt.err) {
+				t.Errorf("newProviderError() does not wrap the original error")
+			}
+		})
+	}
+
+	if newProviderError(OpenAI, nil) != nil {
+		t.Error("newProviderError(nil) should be nil")
+	}
+}
+
+func TestOpenAIClient_ProviderError(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, `{"error":{"message":"Incorrect API key provided"}}`, http.StatusUnauthorized)
+	}))
+	defer ts.Close()
+
+	client := &OpenAIClient{apiKey: "test", baseURL: ts.URL, client: ts.Client()}
+	_, err := client.QueryText(context.Background(), assistant, []string{"Say 'Hello, World!'"}, "gpt-4o", Options{})
+	if !errors.Is(err, ErrAuthentication) {
+		t.Errorf("OpenAIClient.QueryText() error = %v, want ErrAuthentication", err)
+	}
+
+	var statusErr *StatusError
+	if !errors.As(err, &statusErr) || statusErr.StatusCode != http.StatusUnauthorized {
+		t.Errorf("OpenAIClient.QueryText() error = %v, want a StatusError with status 401", err)
+	}
+
+	var providerErr *ProviderError
+	if !errors.As(err, &providerErr) || providerErr.Provider != OpenAI {
+		t.Errorf("OpenAIClient.QueryText() error = %v, want a ProviderError from %s", err, OpenAI)
+	}
+}
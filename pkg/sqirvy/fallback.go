@@ -0,0 +1,167 @@
+// Package sqirvy provides a client that falls back across models.
+//
+// This file implements FallbackClient, which queries an ordered chain of
+// models, possibly served by different providers. When a model fails with a
+// retryable error, such as an overloaded or rate limited provider or a
+// timeout, the query is sent to the next model of the chain.
+package sqirvy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+)
+
+// FallbackConfig sets the models of a FallbackClient.
+type FallbackConfig struct {
+	Models     []string                             // Models to query in order, by name or alias
+	OnFallback func(failed, next string, err error) // Called before a query falls back to the next model, may be nil
+}
+
+// FallbackClient implements the Client interface by querying a chain of
+// models in order, falling back to the next model on retryable errors.
+type FallbackClient struct {
+	models     []string          // Resolved model names of the chain
+	clients    map[string]Client // Clients by provider
+	onFallback func(failed, next string, err error)
+}
+
+// Ensure FallbackClient implements the Client interface
+var _ Client = (*FallbackClient)(nil)
+
+// FallbackError is returned when every model of a chain failed.
+// It unwraps to the error of each model.
+type FallbackError struct {
+	Models []string // Models queried, in order
+	Errs   []error  // Error of each model
+}
+
+func (e *FallbackError) Error() string {
+	return fmt.Sprintf("all %d models failed, last error: %v", len(e.Models), e.Errs[len(e.Errs)-1])
+}
+
+func (e *FallbackError) Unwrap() []error {
+	return e.Errs
+}
+
+// NewFallbackClient creates a client for the models of config, creating one
+// client for each provider that serves them. opts are passed to the client of
+// every provider, so a chain of several providers should leave the API key and
+// base URL to the environment.
+func NewFallbackClient(config FallbackConfig, opts ...ClientOption) (*FallbackClient, error) {
+	if len(config.Models) == 0 {
+		return nil, fmt.Errorf("no models for fallback client")
+	}
+
+	c := &FallbackClient{clients: make(map[string]Client), onFallback: config.OnFallback}
+	for _, model := range config.Models {
+		model = GetModelAlias(model)
+		provider, err := GetProviderName(model)
+		if err != nil {
+			c.Close()
+			return nil, err
+		}
+		if _, ok := c.clients[provider]; !ok {
+			client, err := NewClient(provider, opts...)
+			if err != nil {
+				c.Close()
+				return nil, fmt.Errorf("failed to create client for %s: %w", provider, err)
+			}
+			c.clients[provider] = client
+		}
+		if !slices.Contains(c.models, model) {
+			c.models = append(c.models, model)
+		}
+	}
+	return c, nil
+}
+
+// Models returns the resolved model names of the chain in order.
+func (c *FallbackClient) Models() []string {
+	return slices.Clone(c.models)
+}
+
+// QueryText sends a text query to the models of the chain and returns the first response.
+func (c *FallbackClient) QueryText(ctx context.Context, system string, prompts []string, model string, options Options) (string, error) {
+	return responseText(c.QueryMessages(ctx, system, UserMessages(prompts), model, options, nil))
+}
+
+// QueryStream sends a streaming text query to the models of the chain and returns the first response.
+func (c *FallbackClient) QueryStream(ctx context.Context, system string, prompts []string, model string, options Options, stream StreamFunc) (string, error) {
+	return responseText(c.QueryMessages(ctx, system, UserMessages(prompts), model, options, stream))
+}
+
+// QueryMessages sends a conversation to the models of the chain in order and
+// returns the first response. The query starts from model if it is part of
+// the chain, or from the first model if model is empty. Any other model is
+// queried before the chain, and must be served by one of its providers.
+//
+// Each model retries following Options.Retry before the query falls back, so
+// a small MaxAttempts falls back sooner. Options.MaxTokens is reduced to the
+// limit of a model that allows fewer tokens. A streamed query does not fall
+// back once a model has streamed part of its response.
+func (c *FallbackClient) QueryMessages(ctx context.Context, system string, messages []Message, model string, options Options, stream StreamFunc) (*Response, error) {
+	chain := c.chain(model)
+	var errs []error
+	for i, model := range chain {
+		provider, err := GetProviderName(model)
+		if err != nil {
+			return nil, err
+		}
+		client, ok := c.clients[provider]
+		if !ok {
+			return nil, fmt.Errorf("model %s is served by %s, which is not part of the fallback chain", model, provider)
+		}
+
+		modelOptions := options
+		if limit := GetMaxTokens(model); modelOptions.MaxTokens > limit {
+			modelOptions.MaxTokens = limit
+		}
+		streamed := false
+		var modelStream StreamFunc
+		if stream != nil {
+			modelStream = func(chunk string) error {
+				streamed = true
+				return stream(chunk)
+			}
+		}
+
+		resp, err := client.QueryMessages(ctx, system, messages, model, modelOptions, modelStream)
+		if err == nil {
+			return resp, nil
+		}
+		errs = append(errs, err)
+		if streamed || ctx.Err() != nil || !isRetryable(err) || i == len(chain)-1 {
+			if len(errs) == 1 {
+				return nil, err
+			}
+			return nil, &FallbackError{Models: chain[:i+1], Errs: errs}
+		}
+		if c.onFallback != nil {
+			c.onFallback(model, chain[i+1], err)
+		}
+	}
+	return nil, errors.New("no models in fallback chain")
+}
+
+// chain returns the models a query for model is sent to, in order
+func (c *FallbackClient) chain(model string) []string {
+	if model == "" {
+		return c.models
+	}
+	model = GetModelAlias(model)
+	if i := slices.Index(c.models, model); i >= 0 {
+		return c.models[i:]
+	}
+	return append([]string{model}, c.models...)
+}
+
+// Close closes the client of every provider.
+func (c *FallbackClient) Close() error {
+	var errs []error
+	for _, client := range c.clients {
+		errs = append(errs, client.Close())
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,113 @@
+package sqirvy
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// setMockScript points MOCK_SCRIPT at a script file with the given YAML
+func setMockScript(t *testing.T, script string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "script.yaml")
+	if err := os.WriteFile(path, []byte(script), 0644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("MOCK_SCRIPT", path)
+}
+
+func TestFallbackClient(t *testing.T) {
+	setMockScript(t, "responses:\n  - status: 503\n    error: overloaded\n  - status: 400\n    error: bad request\n")
+	var fallbacks []string
+	client, err := NewFallbackClient(FallbackConfig{
+		Models:     []string{MockScriptModel, MockEchoModel},
+		OnFallback: func(failed, next string, err error) { fallbacks = append(fallbacks, failed+" -> "+next) },
+	})
+	if err != nil {
+		t.Fatalf("NewFallbackClient() error = %v", err)
+	}
+	defer client.Close()
+	options := Options{Retry: RetryPolicy{MaxAttempts: 1}}
+
+	// the overloaded model falls back to the next
+	var chunks []string
+	resp, err := client.QueryMessages(context.Background(), "", UserMessages([]string{"hello world"}), "", options, func(chunk string) error {
+		chunks = append(chunks, chunk)
+		return nil
+	})
+	if err != nil || resp.Model != MockEchoModel || resp.Text != "hello world" {
+		t.Fatalf("QueryMessages() = %+v, %v, want the echo model's reply", resp, err)
+	}
+	if strings.Join(chunks, "") != "hello world" || len(fallbacks) != 1 || fallbacks[0] != "mock-script -> mock-echo" {
+		t.Errorf("streamed %q with fallbacks %q", chunks, fallbacks)
+	}
+
+	// errors that are not retryable do not fall back
+	_, err = client.QueryText(context.Background(), "", []string{"hello"}, "", options)
+	var providerErr *ProviderError
+	if !errors.As(err, &providerErr) || providerErr.StatusCode != 400 || len(fallbacks) != 1 {
+		t.Errorf("QueryText() error = %v with fallbacks %q, want the 400 error", err, fallbacks)
+	}
+
+	// a query for a later model of the chain starts from it
+	if got, err := client.QueryText(context.Background(), "", []string{"hi"}, MockEchoModel, options); err != nil || got != "hi" {
+		t.Errorf("QueryText(mock-echo) = %q, %v", got, err)
+	}
+}
+
+func TestFallbackClient_AllFail(t *testing.T) {
+	setMockScript(t, "latency: 1s\nresponses:\n  - text: too late\n")
+	client, err := NewFallbackClient(FallbackConfig{Models: []string{MockScriptModel, MockEchoModel}})
+	if err != nil {
+		t.Fatalf("NewFallbackClient() error = %v", err)
+	}
+	defer client.Close()
+
+	_, err = client.QueryText(context.Background(), "", []string{"hello"}, "", Options{Timeout: 10 * time.Millisecond})
+	var fallbackErr *FallbackError
+	if !errors.As(err, &fallbackErr) || len(fallbackErr.Errs) != 2 || !errors.Is(err, ErrTimeout) {
+		t.Errorf("QueryText() error = %v, want a FallbackError of two timeouts", err)
+	}
+
+	if _, err := NewFallbackClient(FallbackConfig{Models: []string{MockEchoModel, "no-such-model"}}); err == nil {
+		t.Errorf("NewFallbackClient() should fail for an unknown model")
+	}
+	if _, err := NewFallbackClient(FallbackConfig{}); err == nil {
+		t.Errorf("NewFallbackClient() should fail without models")
+	}
+}
+
+func TestFallbackClient_Providers(t *testing.T) {
+	t.Setenv("MOCK_SCRIPT", "")
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, `{"error":{"message":"overloaded"}}`, http.StatusServiceUnavailable)
+	}))
+	defer ts.Close()
+
+	var failed error
+	client, err := NewFallbackClient(FallbackConfig{
+		Models:     []string{"gpt-4o", MockEchoModel},
+		OnFallback: func(_, _ string, err error) { failed = err },
+	}, WithAPIKey("test-key"), WithBaseURL(ts.URL))
+	if err != nil {
+		t.Fatalf("NewFallbackClient() error = %v", err)
+	}
+	defer client.Close()
+	if models := client.Models(); len(models) != 2 || models[0] != "gpt-4o" {
+		t.Errorf("Models() = %q", models)
+	}
+
+	got, err := client.QueryText(context.Background(), "", []string{"hello"}, "gpt-4o", Options{Retry: testRetryPolicy})
+	if err != nil || got != "hello" {
+		t.Errorf("QueryText() = %q, %v, want the mock reply", got, err)
+	}
+	if !errors.Is(failed, ErrUnavailable) {
+		t.Errorf("fallback error = %v, want ErrUnavailable from openai", failed)
+	}
+}
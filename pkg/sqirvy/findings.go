@@ -0,0 +1,225 @@
+// Package sqirvy provides structured code review findings.
+//
+// This file implements QueryReview, a structured-output query that returns the
+// findings of a code review anchored to files and lines, and converts a Review
+// to SARIF 2.1.0 so that CI systems can upload it to code scanning dashboards.
+package sqirvy
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// Severities of a Finding, from the most to the least severe
+const (
+	SeverityCritical = "critical"
+	SeverityHigh     = "high"
+	SeverityMedium   = "medium"
+	SeverityLow      = "low"
+	SeverityInfo     = "info"
+)
+
+// severities lists the severities from the most to the least severe
+var severities = []string{SeverityCritical, SeverityHigh, SeverityMedium, SeverityLow, SeverityInfo}
+
+// Finding is an issue found by a code review.
+type Finding struct {
+	File      string `json:"file" description:"Path of the file as named in the input"`
+	StartLine int    `json:"start_line" description:"First line of the issue, starting at 1, or 0 if it concerns the whole file"`
+	EndLine   int    `json:"end_line" description:"Last line of the issue, equal to start_line for a single line"`
+	Severity  string `json:"severity" enum:"critical,high,medium,low,info"`
+	Category  string `json:"category" enum:"bug,security,performance,style,documentation"`
+	Message   string `json:"message" description:"Description of the issue"`
+	Fix       string `json:"fix,omitempty" description:"Suggested fix, as replacement code or a short description"`
+}
+
+// Review is the structured result of a code review.
+type Review struct {
+	Summary  string    `json:"summary" description:"Summary of the review"`
+	Findings []Finding `json:"findings"`
+}
+
+// QueryReview sends prompts to the model asking for a Review with QueryJSON,
+// and returns the review with the findings sorted by file and line. A finding
+// whose end line is before its start line is treated as a single line.
+func QueryReview(ctx context.Context, client Client, system string, prompts []string, model string, options Options) (*Review, *Response, error) {
+	var review Review
+	options.JSON = nil
+	response, err := QueryJSON(ctx, client, system, prompts, model, options, &review)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for i := range review.Findings {
+		finding := &review.Findings[i]
+		finding.StartLine = max(finding.StartLine, 0)
+		finding.EndLine = max(finding.EndLine, finding.StartLine)
+	}
+	slices.SortStableFunc(review.Findings, func(a, b Finding) int {
+		return cmp.Or(strings.Compare(a.File, b.File), cmp.Compare(a.StartLine, b.StartLine))
+	})
+	return &review, response, nil
+}
+
+// ValidSeverity reports whether severity is one of the severities of a Finding.
+func ValidSeverity(severity string) bool {
+	return slices.Contains(severities, severity)
+}
+
+// AtLeast returns the findings with severity or a more severe one.
+func (r *Review) AtLeast(severity string) []Finding {
+	threshold := slices.Index(severities, severity)
+	var findings []Finding
+	for _, finding := range r.Findings {
+		if rank := slices.Index(severities, finding.Severity); rank >= 0 && rank <= threshold {
+			findings = append(findings, finding)
+		}
+	}
+	return findings
+}
+
+// Counts returns the number of findings of each severity, from the most to
+// the least severe, as "1 high, 2 low", or "none" without findings.
+func (r *Review) Counts() string {
+	var counts []string
+	for _, severity := range severities {
+		n := 0
+		for _, finding := range r.Findings {
+			if finding.Severity == severity {
+				n++
+			}
+		}
+		if n > 0 {
+			counts = append(counts, fmt.Sprintf("%d %s", n, severity))
+		}
+	}
+	if len(counts) == 0 {
+		return "none"
+	}
+	return strings.Join(counts, ", ")
+}
+
+// SARIF 2.1.0 log, with the parts used for review findings
+type sarifLog struct {
+	Schema  string     `json:"$schema"`
+	Version string     `json:"version"`
+	Runs    []sarifRun `json:"runs"`
+}
+
+type sarifRun struct {
+	Tool    sarifTool     `json:"tool"`
+	Results []sarifResult `json:"results"`
+}
+
+type sarifTool struct {
+	Driver sarifDriver `json:"driver"`
+}
+
+type sarifDriver struct {
+	Name           string      `json:"name"`
+	InformationURI string      `json:"informationUri,omitempty"`
+	Rules          []sarifRule `json:"rules"`
+}
+
+type sarifRule struct {
+	ID               string       `json:"id"`
+	ShortDescription sarifMessage `json:"shortDescription"`
+}
+
+type sarifResult struct {
+	RuleID     string            `json:"ruleId"`
+	Level      string            `json:"level"`
+	Message    sarifMessage      `json:"message"`
+	Locations  []sarifLocation   `json:"locations"`
+	Properties map[string]string `json:"properties,omitempty"`
+}
+
+type sarifMessage struct {
+	Text string `json:"text"`
+}
+
+type sarifLocation struct {
+	PhysicalLocation sarifPhysicalLocation `json:"physicalLocation"`
+}
+
+type sarifPhysicalLocation struct {
+	ArtifactLocation sarifArtifactLocation `json:"artifactLocation"`
+	Region           *sarifRegion          `json:"region,omitempty"`
+}
+
+type sarifArtifactLocation struct {
+	URI string `json:"uri"`
+}
+
+type sarifRegion struct {
+	StartLine int `json:"startLine"`
+	EndLine   int `json:"endLine"`
+}
+
+// sarifLevel maps the severity of a finding to a SARIF result level
+func sarifLevel(severity string) string {
+	switch severity {
+	case SeverityCritical, SeverityHigh:
+		return "error"
+	case SeverityMedium:
+		return "warning"
+	}
+	return "note"
+}
+
+// SARIF returns the review as a SARIF 2.1.0 log of a run of the named tool.
+// Each category is a rule, each finding a result whose level is error for
+// critical and high, warning for medium, and note for other severities. The
+// severity and suggested fix are kept in the properties of the result.
+func (r *Review) SARIF(tool, informationURI string) ([]byte, error) {
+	run := sarifRun{
+		Tool:    sarifTool{Driver: sarifDriver{Name: tool, InformationURI: informationURI, Rules: []sarifRule{}}},
+		Results: []sarifResult{},
+	}
+	for _, finding := range r.Findings {
+		category := cmp.Or(finding.Category, "review")
+		if !slices.ContainsFunc(run.Tool.Driver.Rules, func(rule sarifRule) bool { return rule.ID == category }) {
+			run.Tool.Driver.Rules = append(run.Tool.Driver.Rules, sarifRule{ID: category, ShortDescription: sarifMessage{Text: category + " issue found by code review"}})
+		}
+
+		location := sarifPhysicalLocation{ArtifactLocation: sarifArtifactLocation{URI: strings.TrimPrefix(finding.File, "./")}}
+		if finding.StartLine > 0 {
+			location.Region = &sarifRegion{StartLine: finding.StartLine, EndLine: max(finding.EndLine, finding.StartLine)}
+		}
+		message := finding.Message
+		if finding.Fix != "" {
+			message += "\n\nSuggested fix: " + finding.Fix
+		}
+		properties := map[string]string{"severity": finding.Severity}
+		if finding.Fix != "" {
+			properties["fix"] = finding.Fix
+		}
+		run.Results = append(run.Results, sarifResult{
+			RuleID:     category,
+			Level:      sarifLevel(finding.Severity),
+			Message:    sarifMessage{Text: message},
+			Locations:  []sarifLocation{{PhysicalLocation: location}},
+			Properties: properties,
+		})
+	}
+
+	// keep code in messages and fixes readable
+	var b bytes.Buffer
+	encoder := json.NewEncoder(&b)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "  ")
+	err := encoder.Encode(sarifLog{
+		Schema:  "https://json.schemastore.org/sarif-2.1.0.json",
+		Version: "2.1.0",
+		Runs:    []sarifRun{run},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode SARIF: %w", err)
+	}
+	return bytes.TrimSuffix(b.Bytes(), []byte("\n")), nil
+}
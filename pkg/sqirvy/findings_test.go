@@ -0,0 +1,88 @@
+package sqirvy
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+)
+
+func TestQueryReview(t *testing.T) {
+	ts, requests := newJSONServer(t, `{"summary":"Two issues","findings":[`+
+		`{"file":"b.go","start_line":7,"end_line":3,"severity":"low","category":"style","message":"long line"},`+
+		`{"file":"a.go","start_line":12,"end_line":14,"severity":"high","category":"bug","message":"nil dereference","fix":"check err first"}]}`)
+	client := &OpenAIClient{apiKey: "test", baseURL: ts.URL, client: ts.Client()}
+
+	review, _, err := QueryReview(context.Background(), client, assistant, []string{"Review a.go and b.go"}, "gpt-4o", Options{})
+	if err != nil {
+		t.Fatalf("QueryReview() error = %v", err)
+	}
+	if len(review.Findings) != 2 || review.Findings[0].File != "a.go" || review.Findings[1].File != "b.go" {
+		t.Fatalf("QueryReview() findings = %+v, want a.go then b.go", review.Findings)
+	}
+	if review.Findings[1].EndLine != 7 {
+		t.Errorf("end line before the start line = %d, want 7", review.Findings[1].EndLine)
+	}
+
+	// the schema restricts the severity
+	format := (*requests)[0].ResponseFormat
+	if format == nil || format.JSONSchema.Name != "Review" {
+		t.Fatalf("request response_format = %+v, want json_schema Review", format)
+	}
+	schema := &JSONSchema{Schema: format.JSONSchema.Schema}
+	invalid := `{"summary":"","findings":[{"file":"a.go","start_line":1,"end_line":1,"severity":"blocker","category":"bug","message":"x"}]}`
+	if err := schema.Validate([]byte(invalid)); err == nil {
+		t.Errorf("sent schema should reject severity blocker")
+	}
+
+	if got := review.Counts(); got != "1 high, 1 low" {
+		t.Errorf("Counts() = %q", got)
+	}
+	for severity, want := range map[string]int{SeverityCritical: 0, SeverityHigh: 1, SeverityMedium: 1, SeverityInfo: 2} {
+		if got := len(review.AtLeast(severity)); got != want {
+			t.Errorf("AtLeast(%s) = %d findings, want %d", severity, got, want)
+		}
+	}
+	if ValidSeverity("blocker") || !ValidSeverity(SeverityMedium) {
+		t.Errorf("ValidSeverity() accepts the wrong severities")
+	}
+}
+
+func TestReviewSARIF(t *testing.T) {
+	review := &Review{Findings: []Finding{
+		{File: "./a.go", StartLine: 12, EndLine: 14, Severity: SeverityHigh, Category: "bug", Message: "nil dereference", Fix: "check err first"},
+		{File: "b.go", Severity: SeverityInfo, Category: "documentation", Message: "missing package comment"},
+		{File: "c.go", StartLine: 3, EndLine: 3, Severity: SeverityMedium, Category: "bug", Message: "unchecked error"},
+	}}
+	data, err := review.SARIF("sqirvy-cli", "")
+	if err != nil {
+		t.Fatalf("SARIF() error = %v", err)
+	}
+
+	var log sarifLog
+	if err := json.Unmarshal(data, &log); err != nil {
+		t.Fatalf("SARIF() is not valid JSON: %v", err)
+	}
+	if log.Version != "2.1.0" || len(log.Runs) != 1 || log.Runs[0].Tool.Driver.Name != "sqirvy-cli" {
+		t.Fatalf("SARIF() = %s", data)
+	}
+	run := log.Runs[0]
+	if len(run.Tool.Driver.Rules) != 2 || run.Tool.Driver.Rules[0].ID != "bug" || run.Tool.Driver.Rules[1].ID != "documentation" {
+		t.Errorf("rules = %+v, want bug and documentation", run.Tool.Driver.Rules)
+	}
+	if len(run.Results) != 3 {
+		t.Fatalf("results = %+v, want 3", run.Results)
+	}
+
+	first := run.Results[0]
+	location := first.Locations[0].PhysicalLocation
+	if first.Level != "error" || location.ArtifactLocation.URI != "a.go" || location.Region == nil ||
+		*location.Region != (sarifRegion{StartLine: 12, EndLine: 14}) || first.Properties["fix"] != "check err first" {
+		t.Errorf("first result = %+v", first)
+	}
+	if run.Results[1].Level != "note" || run.Results[1].Locations[0].PhysicalLocation.Region != nil {
+		t.Errorf("finding without lines = %+v, want a note without a region", run.Results[1])
+	}
+	if run.Results[2].Level != "warning" {
+		t.Errorf("medium finding level = %s, want warning", run.Results[2].Level)
+	}
+}
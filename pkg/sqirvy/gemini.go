@@ -7,11 +7,14 @@ package sqirvy
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
-	"os"
+	"net/http"
 	"strings"
+	"time"
 
 	"github.com/google/generative-ai-go/genai"
+	"google.golang.org/api/iterator"
 	"google.golang.org/api/option"
 )
 
@@ -24,82 +27,340 @@ const (
 // It provides methods for querying Google's Gemini language models through
 // their official API client.
 type GeminiClient struct {
-	client *genai.Client // Google Gemini API client
+	client  *genai.Client // Google Gemini API client
+	timeout time.Duration // Default timeout of a query
 }
 
 // Ensure GeminiClient implements the Client interface
 var _ Client = (*GeminiClient)(nil)
 
+func init() {
+	mustRegister(Gemini, func(opts ...ClientOption) (Client, error) { return NewGeminiClient(opts...) }, geminiModels)
+}
+
 // NewGeminiClient creates a new instance of GeminiClient.
-// It returns an error if the required GEMINI_API_KEY environment variable is not set.
-func NewGeminiClient() (*GeminiClient, error) {
-	apiKey := os.Getenv("GEMINI_API_KEY")
-	if apiKey == "" {
-		return nil, fmt.Errorf("GEMINI_API_KEY environment variable not set")
+// The API key is taken from the options, or from the GEMINI_API_KEY
+// environment variable if not set, and an error is returned if it is missing.
+// The base URL defaults to GEMINI_BASE_URL or the SDK's endpoint.
+func NewGeminiClient(opts ...ClientOption) (*GeminiClient, error) {
+	config := NewClientConfig(opts...)
+	apiKey, err := config.apiKey("GEMINI_API_KEY")
+	if err != nil {
+		return nil, err
+	}
+
+	clientOptions := []option.ClientOption{option.WithAPIKey(apiKey)}
+	if baseURL := config.baseURL("GEMINI_BASE_URL"); baseURL != "" {
+		clientOptions = append(clientOptions, option.WithEndpoint(baseURL))
+	}
+	if config.customHTTP() {
+		// the SDK ignores the API key option when given an HTTP client,
+		// so the key is sent as a header instead
+		config.Headers = config.Headers.Clone()
+		if config.Headers == nil {
+			config.Headers = http.Header{}
+		}
+		config.Headers.Set("x-goog-api-key", apiKey)
+		httpClient, err := config.NewHTTPClient()
+		if err != nil {
+			return nil, err
+		}
+		clientOptions = append(clientOptions, option.WithHTTPClient(httpClient))
 	}
 
-	client, err := genai.NewClient(context.Background(), option.WithAPIKey(apiKey))
+	client, err := genai.NewClient(context.Background(), clientOptions...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create client: %w", err)
 	}
 
 	return &GeminiClient{
-		client: client,
+		client:  client,
+		timeout: config.Timeout,
 	}, nil
 }
 
 // GeminiClient.QueryText implements the QueryText method for the Client interface.
 // It sends a text query to Google's Gemini API and returns the generated text response.
 func (c *GeminiClient) QueryText(ctx context.Context, system string, prompts []string, model string, options Options) (string, error) {
+	return responseText(c.QueryMessages(ctx, system, UserMessages(prompts), model, options, nil))
+}
+
+// GeminiClient.QueryStream implements the QueryStream method for the Client interface.
+// It sends a streaming text query to Google's Gemini API and passes each chunk to stream.
+func (c *GeminiClient) QueryStream(ctx context.Context, system string, prompts []string, model string, options Options, stream StreamFunc) (string, error) {
+	return responseText(c.QueryMessages(ctx, system, UserMessages(prompts), model, options, stream))
+}
+
+// GeminiClient.QueryMessages implements the QueryMessages method for the Client interface.
+// Earlier messages are sent as chat history and the final user message as the new turn.
+func (c *GeminiClient) QueryMessages(ctx context.Context, system string, messages []Message, model string, options Options, stream StreamFunc) (*Response, error) {
+	ctx, cancel := queryContext(ctx, options, c.timeout)
+	defer cancel()
+
+	genModel, history, parts, err := c.newModel(ctx, system, messages, model, options)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := withRetry(ctx, options.Retry, stream, func(stream StreamFunc) (*Response, error) {
+		return streamMessage(ctx, genModel, history, parts, model, stream)
+	})
+	return resp, newProviderError(Gemini, err)
+}
+
+// streamMessage starts a chat with the given history and streams the reply to
+// the final user turn, passing each chunk to stream if it is not nil. Replies
+// are always read as a stream because the SDK keeps only the token usage of
+// the first chunk when it joins them into a single response.
+func streamMessage(ctx context.Context, genModel *genai.GenerativeModel, history []*genai.Content, parts []genai.Part, model string, stream StreamFunc) (*Response, error) {
+	chat := genModel.StartChat()
+	chat.History = history
+
+	var response strings.Builder
+	result := &Response{Model: model}
+	iter := chat.SendMessageStream(ctx, parts...)
+	for {
+		resp, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate content: %w", err)
+		}
+		geminiUsage(result, resp)
+
+		calls, err := geminiToolCalls(resp, len(result.ToolCalls))
+		if err != nil {
+			return nil, err
+		}
+		result.ToolCalls = append(result.ToolCalls, calls...)
+
+		chunk := geminiText(resp)
+		if chunk == "" {
+			continue
+		}
+		response.WriteString(chunk)
+		if stream != nil {
+			if err := stream(chunk); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	result.Text = response.String()
+	return result, nil
+}
+
+// newModel validates the query parameters and configures a generative model.
+// It returns the chat history and the parts of the final user turn to send.
+func (c *GeminiClient) newModel(ctx context.Context, system string, messages []Message, model string, options Options) (*genai.GenerativeModel, []*genai.Content, []genai.Part, error) {
 	if ctx.Err() != nil {
-		return "", fmt.Errorf("request context error %w", ctx.Err())
+		return nil, nil, nil, fmt.Errorf("request context error %w", ctx.Err())
 	}
 
-	if len(prompts) == 0 {
-		return "", fmt.Errorf("prompts cannot be empty for text query")
+	if err := validateMessages(messages); err != nil {
+		return nil, nil, nil, err
+	}
+	if last := messages[len(messages)-1].Role; last != RoleUser && last != RoleTool {
+		return nil, nil, nil, fmt.Errorf("last message must have role %q or %q", RoleUser, RoleTool)
 	}
 
 	// Create a generative model instance with the specified model name
 	genModel := c.client.GenerativeModel(model)
-	// Set response type to plain text
+	// Set response type to plain text, or JSON constrained by the schema
 	genModel.ResponseMIMEType = "text/plain"
+	if options.JSON != nil {
+		schema, err := geminiSchema(options.JSON)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		genModel.ResponseMIMEType = "application/json"
+		genModel.ResponseSchema = schema
+	}
 
 	// Set default and validate temperature
 	if options.Temperature < MinTemperature {
 		options.Temperature = MinTemperature
 	}
 	if options.Temperature > MaxTemperature {
-		return "", fmt.Errorf("temperature must be between %.1f and %.1f", MinTemperature, MaxTemperature)
+		return nil, nil, nil, fmt.Errorf("temperature must be between %.1f and %.1f", MinTemperature, MaxTemperature)
 	}
 	// Scale temperature for Gemini's 0-2 range
 	options.Temperature = (options.Temperature * GeminiTempScale) / MaxTemperature
 	genModel.Temperature = &options.Temperature
 
-	parts := make([]genai.Part, 0, len(prompts))
-	// First prompt is system prompt
-	parts = append(parts, genai.Text(system))
-	// rest of prompts
-	for _, prompt := range prompts {
-		parts = append(parts, genai.Text(prompt))
+	if len(options.Tools) > 0 {
+		tool := &genai.Tool{}
+		for _, t := range options.Tools {
+			parameters, err := geminiSchema(&JSONSchema{Schema: t.Parameters})
+			if err != nil {
+				return nil, nil, nil, fmt.Errorf("tool %s: %w", t.Name, err)
+			}
+			// Gemini rejects an object schema without properties
+			if parameters != nil && parameters.Type == genai.TypeObject && len(parameters.Properties) == 0 {
+				parameters = nil
+			}
+			tool.FunctionDeclarations = append(tool.FunctionDeclarations, &genai.FunctionDeclaration{
+				Name:        t.Name,
+				Description: t.Description,
+				Parameters:  parameters,
+			})
+		}
+		genModel.Tools = []*genai.Tool{tool}
 	}
 
-	// Generate content from the prompt
-	resp, err := genModel.GenerateContent(ctx, parts...)
+	// First part is the system prompt, then the conversation.
+	// Gemini calls the assistant role "model", tool results are sent
+	// by the user, and consecutive messages with the same role are
+	// merged into one turn.
+	contents := []*genai.Content{genai.NewUserContent(genai.Text(system))}
+	for _, m := range messages {
+		role, parts, err := geminiParts(m)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		last := contents[len(contents)-1]
+		if last.Role == role {
+			last.Parts = append(last.Parts, parts...)
+			continue
+		}
+		contents = append(contents, &genai.Content{Role: role, Parts: parts})
+	}
+
+	last := contents[len(contents)-1]
+	return genModel, contents[:len(contents)-1], last.Parts, nil
+}
+
+// geminiParts converts a message into the role and parts of a Gemini turn
+func geminiParts(m Message) (string, []genai.Part, error) {
+	switch m.Role {
+	case RoleTool:
+		return "user", []genai.Part{genai.FunctionResponse{
+			Name:     m.ToolName,
+			Response: map[string]any{"result": m.Content},
+		}}, nil
+	case RoleAssistant:
+		var parts []genai.Part
+		if m.Content != "" || len(m.ToolCalls) == 0 {
+			parts = append(parts, genai.Text(m.Content))
+		}
+		for _, call := range m.ToolCalls {
+			args, err := toolArguments(call)
+			if err != nil {
+				return "", nil, err
+			}
+			parts = append(parts, genai.FunctionCall{Name: call.Name, Args: args})
+		}
+		return "model", parts, nil
+	}
+	return "user", []genai.Part{genai.Text(m.Content)}, nil
+}
+
+// geminiToolCalls returns the function calls in a response.
+// Gemini does not identify calls, so they are numbered from first.
+func geminiToolCalls(resp *genai.GenerateContentResponse, first int) ([]ToolCall, error) {
+	var calls []ToolCall
+	for _, candidate := range resp.Candidates {
+		if candidate.Content == nil {
+			continue
+		}
+		for _, part := range candidate.Content.Parts {
+			call, ok := part.(genai.FunctionCall)
+			if !ok {
+				continue
+			}
+			args, err := json.Marshal(call.Args)
+			if err != nil {
+				return nil, fmt.Errorf("failed to marshal arguments of %s: %w", call.Name, err)
+			}
+			calls = append(calls, ToolCall{ID: fmt.Sprintf("call_%d", first+len(calls)), Name: call.Name, Arguments: args})
+		}
+	}
+	return calls, nil
+}
+
+// geminiSchema converts a JSON schema into a Gemini response schema.
+// Returns nil for an empty schema, which asks for any JSON document.
+func geminiSchema(schema *JSONSchema) (*genai.Schema, error) {
+	node, err := parseSchema(schema.Schema)
 	if err != nil {
-		return "", fmt.Errorf("failed to generate content: %w", err)
+		return nil, err
+	}
+	if len(node.Type) == 0 {
+		return nil, nil
 	}
+	return node.geminiSchema(), nil
+}
 
-	// Build response using strings.Builder for better performance
-	var response strings.Builder
+// geminiTypes maps JSON Schema types to Gemini schema types
+var geminiTypes = map[string]genai.Type{
+	"string":  genai.TypeString,
+	"number":  genai.TypeNumber,
+	"integer": genai.TypeInteger,
+	"boolean": genai.TypeBoolean,
+	"array":   genai.TypeArray,
+	"object":  genai.TypeObject,
+}
+
+// geminiSchema converts a schema node, a list of types containing "null" becomes nullable
+func (n *schemaNode) geminiSchema() *genai.Schema {
+	schema := &genai.Schema{
+		Description: n.Description,
+		Required:    n.Required,
+	}
+	for _, t := range n.Type {
+		if t == "null" {
+			schema.Nullable = true
+		} else if geminiType, ok := geminiTypes[t]; ok {
+			schema.Type = geminiType
+		}
+	}
+	if schema.Type == genai.TypeString {
+		schema.Format = n.Format
+	}
+	for _, e := range n.Enum {
+		if s, ok := e.(string); ok {
+			schema.Enum = append(schema.Enum, s)
+		}
+	}
+	if n.Items != nil {
+		schema.Items = n.Items.geminiSchema()
+	}
+	if len(n.Properties) > 0 {
+		schema.Properties = make(map[string]*genai.Schema, len(n.Properties))
+		for name, prop := range n.Properties {
+			schema.Properties[name] = prop.geminiSchema()
+		}
+	}
+	return schema
+}
+
+// geminiText concatenates the text parts of all candidates in a response
+func geminiText(resp *genai.GenerateContentResponse) string {
+	var text strings.Builder
 	for _, candidate := range resp.Candidates {
+		if candidate.Content == nil {
+			continue
+		}
 		for _, part := range candidate.Content.Parts {
 			if textValue, ok := part.(genai.Text); ok {
-				response.WriteString(string(textValue))
+				text.WriteString(string(textValue))
 			}
 		}
 	}
+	return text.String()
+}
 
-	return response.String(), nil
+// geminiUsage copies the finish reason and token usage of a response into result.
+// Streamed responses report them on the final chunk.
+func geminiUsage(result *Response, resp *genai.GenerateContentResponse) {
+	if len(resp.Candidates) > 0 && resp.Candidates[0].FinishReason != genai.FinishReasonUnspecified {
+		result.FinishReason = resp.Candidates[0].FinishReason.String()
+	}
+	if resp.UsageMetadata != nil {
+		result.InputTokens = int64(resp.UsageMetadata.PromptTokenCount)
+		result.OutputTokens = int64(resp.UsageMetadata.CandidatesTokenCount)
+	}
 }
 
 // Close implements the Close method for the Client interface.
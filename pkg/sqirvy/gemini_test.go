@@ -2,6 +2,7 @@ package sqirvy
 
 import (
 	"context"
+	"encoding/json"
 	"os"
 	"testing"
 )
@@ -55,3 +56,60 @@ func TestGeminiClient_QueryText(t *testing.T) {
 		})
 	}
 }
+
+func TestGeminiClient_Replay(t *testing.T) {
+	add := newAddTool(t).Tool
+	tests := []cassetteTest{
+		{
+			cassette: "gemini_invalid_key",
+			model:    "gemini-2.0-flash",
+			prompts:  []string{"Say hello"},
+			apiKey:   "invalid",
+			wantErr:  ErrAuthentication,
+		},
+		{
+			cassette: "gemini_model_not_found",
+			model:    "gemini-0.0-nope",
+			prompts:  []string{"Say hello"},
+			wantErr:  ErrModelNotFound,
+		},
+	}
+	if !geminiStreamSupported() {
+		t.Log("skipping Gemini replies, encoding/json cannot read the end of a Gemini stream")
+		runCassetteTests(t, Gemini, tests)
+		return
+	}
+
+	tests = append(tests,
+		cassetteTest{
+			// the token usage is reported in the last chunk of a reply
+			cassette: "gemini_text",
+			model:    "gemini-2.0-flash",
+			prompts:  []string{"Say hello"},
+			want:     Response{Text: "Hello! How can I help you today?\n", Model: "gemini-2.0-flash", FinishReason: "FinishReasonStop", InputTokens: 9, OutputTokens: 10},
+		},
+		cassetteTest{
+			cassette: "gemini_stream",
+			model:    "gemini-2.0-flash",
+			prompts:  []string{"Say hello"},
+			stream:   true,
+			want:     Response{Text: "Hello! How can I help you today?\n", Model: "gemini-2.0-flash", FinishReason: "FinishReasonStop", InputTokens: 9, OutputTokens: 10},
+		},
+		cassetteTest{
+			cassette: "gemini_max_tokens",
+			model:    "gemini-2.0-flash",
+			prompts:  []string{"Write a story about a squirrel"},
+			options:  Options{MaxTokens: 8},
+			want:     Response{Text: "Pip was a squirrel with a secret.", Model: "gemini-2.0-flash", FinishReason: "FinishReasonMaxTokens", InputTokens: 12, OutputTokens: 8},
+		},
+		cassetteTest{
+			cassette: "gemini_function_call",
+			model:    "gemini-2.0-flash",
+			prompts:  []string{"What is 2 + 3?"},
+			options:  Options{Tools: []Tool{add}},
+			want: Response{Model: "gemini-2.0-flash", FinishReason: "FinishReasonStop", InputTokens: 41, OutputTokens: 5,
+				ToolCalls: []ToolCall{{ID: "call_0", Name: "add", Arguments: json.RawMessage(`{"a":2,"b":3}`)}}},
+		},
+	)
+	runCassetteTests(t, Gemini, tests)
+}
@@ -0,0 +1,182 @@
+// Package sqirvy provides structured JSON queries.
+//
+// This file implements QueryJSON, which asks a model for a JSON document,
+// validates the reply against a JSON Schema and unmarshals it. Each provider
+// uses its native structured output feature when Options.JSON is set:
+// response_format for OpenAI, DeepSeek and Llama, a response schema for
+// Gemini, and a forced tool call for Anthropic.
+package sqirvy
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// MaxJSONRepairs is how many times QueryJSON asks the model to correct
+// a reply that is not valid before giving up
+const MaxJSONRepairs = 2
+
+// defaultSchemaName is used for schemas without a name, providers require one
+const defaultSchemaName = "response"
+
+// ErrInvalidJSON is returned by QueryJSON when the model does not produce
+// a document that matches the schema
+var ErrInvalidJSON = errors.New("invalid JSON response")
+
+// JSONSchema requests a JSON response from a model.
+// Schemas should describe an object, which is all that some providers accept.
+type JSONSchema struct {
+	Name   string          // Name of the schema, defaults to "response"
+	Schema json.RawMessage // JSON Schema the response must match, empty accepts any JSON document
+}
+
+// name returns the schema name sent to providers
+func (s *JSONSchema) name() string {
+	if s.Name == "" {
+		return defaultSchemaName
+	}
+	return s.Name
+}
+
+// Validate checks that data is a JSON document matching the schema
+func (s *JSONSchema) Validate(data []byte) error {
+	node, err := parseSchema(s.Schema)
+	if err != nil {
+		return err
+	}
+	var value any
+	if err := json.Unmarshal(data, &value); err != nil {
+		return fmt.Errorf("response is not valid JSON: %w", err)
+	}
+	return node.validate(value, "$")
+}
+
+// instruction returns the system prompt text asking for a JSON response,
+// for providers that cannot enforce the schema themselves
+func (s *JSONSchema) instruction() string {
+	if len(s.Schema) == 0 {
+		return "Respond only with a JSON document, without any other text."
+	}
+	return "Respond only with a JSON document, without any other text, that matches this JSON Schema:\n" + string(s.Schema)
+}
+
+// jsonSystemPrompt adds the JSON instruction for options.JSON to a system prompt
+func jsonSystemPrompt(system string, options Options) string {
+	if options.JSON == nil {
+		return system
+	}
+	if system == "" {
+		return options.JSON.instruction()
+	}
+	return system + "\n\n" + options.JSON.instruction()
+}
+
+// chatResponseFormat is the response_format of OpenAI-compatible chat completion requests
+type chatResponseFormat struct {
+	Type       string          `json:"type"`                  // "json_object" or "json_schema"
+	JSONSchema *chatJSONSchema `json:"json_schema,omitempty"` // Schema for "json_schema"
+}
+
+type chatJSONSchema struct {
+	Name   string          `json:"name"`
+	Schema json.RawMessage `json:"schema"`
+}
+
+// newChatResponseFormat returns the response_format for options.JSON, or nil for a text query.
+// If schemas is false the API only supports JSON mode, and the schema is left to the prompt.
+func newChatResponseFormat(options Options, schemas bool) *chatResponseFormat {
+	if options.JSON == nil {
+		return nil
+	}
+	if !schemas || len(options.JSON.Schema) == 0 {
+		return &chatResponseFormat{Type: "json_object"}
+	}
+	return &chatResponseFormat{
+		Type:       "json_schema",
+		JSONSchema: &chatJSONSchema{Name: options.JSON.name(), Schema: options.JSON.Schema},
+	}
+}
+
+// QueryJSON sends prompts to the model asking for a JSON response, checks that the
+// reply matches the schema, and unmarshals it into v if v is not nil.
+//
+// The schema is taken from options.JSON, or derived from v with SchemaFor if that
+// is not set. If the reply is not valid the model is shown the error and asked to
+// correct it, up to MaxJSONRepairs times. The returned response holds the JSON
+// document and the token usage of all the attempts.
+func QueryJSON(ctx context.Context, client Client, system string, prompts []string, model string, options Options, v any) (*Response, error) {
+	if options.JSON == nil {
+		options.JSON = &JSONSchema{}
+		if v != nil {
+			schema, err := SchemaFor(v)
+			if err != nil {
+				return nil, err
+			}
+			options.JSON = schema
+		}
+	}
+	if _, err := parseSchema(options.JSON.Schema); err != nil {
+		return nil, err
+	}
+
+	messages := UserMessages(prompts)
+	var inputTokens, outputTokens int64
+	for attempt := 0; ; attempt++ {
+		resp, err := client.QueryMessages(ctx, system, messages, model, options, nil)
+		if err != nil {
+			return nil, err
+		}
+		inputTokens += resp.InputTokens
+		outputTokens += resp.OutputTokens
+
+		text := extractJSON(resp.Text)
+		err = options.JSON.Validate([]byte(text))
+		if err == nil && v != nil {
+			err = json.Unmarshal([]byte(text), v)
+		}
+		if err == nil {
+			resp.Text = text
+			resp.InputTokens = inputTokens
+			resp.OutputTokens = outputTokens
+			return resp, nil
+		}
+
+		if attempt >= MaxJSONRepairs {
+			return nil, fmt.Errorf("%w after %d attempts: %v", ErrInvalidJSON, attempt+1, err)
+		}
+		messages = append(messages,
+			Message{Role: RoleAssistant, Content: resp.Text},
+			Message{Role: RoleUser, Content: fmt.Sprintf("That response is not valid: %v\n%s", err, options.JSON.instruction())},
+		)
+	}
+}
+
+// extractJSON returns the JSON document in a model reply, removing any
+// Markdown code fence or surrounding text the model added
+func extractJSON(text string) string {
+	text = strings.TrimSpace(text)
+	if fenced, ok := strings.CutPrefix(text, "```"); ok {
+		// drop the language tag on the opening fence
+		if _, body, found := strings.Cut(fenced, "\n"); found {
+			fenced = body
+		}
+		if body, _, found := strings.Cut(fenced, "```"); found {
+			fenced = body
+		}
+		text = strings.TrimSpace(fenced)
+	}
+
+	// keep the outermost object or array if the model wrapped it in prose
+	start := strings.IndexAny(text, "{[")
+	if start <= 0 {
+		return text
+	}
+	end := strings.LastIndexAny(text, "}]")
+	if end < start {
+		return text
+	}
+	return text[start : end+1]
+}
@@ -0,0 +1,197 @@
+package sqirvy
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/generative-ai-go/genai"
+)
+
+type testGreeting struct {
+	Greeting string `json:"greeting"`
+	Count    int    `json:"count"`
+}
+
+// newJSONServer returns a server that replies with each of the given contents in turn,
+// recording the requests it received
+func newJSONServer(t *testing.T, replies ...string) (*httptest.Server, *[]openAIRequest) {
+	t.Helper()
+	var requests []openAIRequest
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req openAIRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("failed to decode request: %v", err)
+		}
+		requests = append(requests, req)
+
+		reply, _ := json.Marshal(replies[min(len(requests), len(replies))-1])
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"model":"gpt-4o","choices":[{"message":{"content":%s},"finish_reason":"stop"}],"usage":{"prompt_tokens":10,"completion_tokens":5}}`, reply)
+	}))
+	t.Cleanup(ts.Close)
+	return ts, &requests
+}
+
+func TestQueryJSON(t *testing.T) {
+	ts, requests := newJSONServer(t, `{"greeting":"Hello, World!","count":1}`)
+	client := &OpenAIClient{apiKey: "test", baseURL: ts.URL, client: ts.Client()}
+
+	var got testGreeting
+	resp, err := QueryJSON(context.Background(), client, assistant, []string{"Greet the world"}, "gpt-4o", Options{}, &got)
+	if err != nil {
+		t.Fatalf("QueryJSON() error = %v", err)
+	}
+	if got != (testGreeting{Greeting: "Hello, World!", Count: 1}) {
+		t.Errorf("QueryJSON() unmarshaled %+v", got)
+	}
+	if resp.Text != `{"greeting":"Hello, World!","count":1}` {
+		t.Errorf("QueryJSON() text = %q", resp.Text)
+	}
+
+	// the schema derived from the struct is sent as the response format
+	format := (*requests)[0].ResponseFormat
+	if format == nil || format.Type != "json_schema" || format.JSONSchema.Name != "testGreeting" {
+		t.Fatalf("request response_format = %+v, want json_schema testGreeting", format)
+	}
+	schema := &JSONSchema{Schema: format.JSONSchema.Schema}
+	if err := schema.Validate([]byte(`{"greeting":"hi"}`)); err == nil {
+		t.Errorf("sent schema should require count")
+	}
+}
+
+func TestQueryJSON_Repair(t *testing.T) {
+	ts, requests := newJSONServer(t,
+		"Here you go:\n```json\n{\"greeting\":\"Hello\"}\n```",
+		"```json\n{\"greeting\":\"Hello\",\"count\":2}\n```",
+	)
+	client := &OpenAIClient{apiKey: "test", baseURL: ts.URL, client: ts.Client()}
+
+	var got testGreeting
+	resp, err := QueryJSON(context.Background(), client, assistant, []string{"Greet the world"}, "gpt-4o", Options{}, &got)
+	if err != nil {
+		t.Fatalf("QueryJSON() error = %v", err)
+	}
+	if got.Count != 2 || resp.Text != `{"greeting":"Hello","count":2}` {
+		t.Errorf("QueryJSON() = %q, unmarshaled %+v", resp.Text, got)
+	}
+	if resp.InputTokens != 20 || resp.OutputTokens != 10 {
+		t.Errorf("QueryJSON() usage = %d/%d, want the total of both attempts", resp.InputTokens, resp.OutputTokens)
+	}
+
+	// the repair request continues the conversation with the validation error
+	if len(*requests) != 2 {
+		t.Fatalf("server received %d requests, want 2", len(*requests))
+	}
+	messages := (*requests)[1].Messages
+	if len(messages) != 4 || messages[2].Role != RoleAssistant || messages[3].Role != RoleUser {
+		t.Fatalf("repair request messages = %+v", messages)
+	}
+}
+
+func TestQueryJSON_Invalid(t *testing.T) {
+	ts, requests := newJSONServer(t, "not json")
+	client := &OpenAIClient{apiKey: "test", baseURL: ts.URL, client: ts.Client()}
+
+	_, err := QueryJSON(context.Background(), client, assistant, []string{"Greet the world"}, "gpt-4o", Options{}, &testGreeting{})
+	if !errors.Is(err, ErrInvalidJSON) {
+		t.Errorf("QueryJSON() error = %v, want ErrInvalidJSON", err)
+	}
+	if len(*requests) != MaxJSONRepairs+1 {
+		t.Errorf("server received %d requests, want %d", len(*requests), MaxJSONRepairs+1)
+	}
+
+	// without a schema the request uses JSON mode
+	if _, err := QueryJSON(context.Background(), client, assistant, []string{"Greet the world"}, "gpt-4o", Options{}, nil); err == nil {
+		t.Errorf("QueryJSON() should fail for a reply that is not JSON")
+	}
+	format := (*requests)[len(*requests)-1].ResponseFormat
+	if format == nil || format.Type != "json_object" {
+		t.Errorf("request response_format = %+v, want json_object", format)
+	}
+}
+
+func TestExtractJSON(t *testing.T) {
+	tests := []struct {
+		text string
+		want string
+	}{
+		{text: `{"a":1}`, want: `{"a":1}`},
+		{text: "```json\n{\"a\":1}\n```", want: `{"a":1}`},
+		{text: "```\n[1,2]\n```\n", want: `[1,2]`},
+		{text: "Sure! {\"a\":{\"b\":2}} Hope that helps.", want: `{"a":{"b":2}}`},
+		{text: "no json", want: "no json"},
+	}
+	for _, tt := range tests {
+		if got := extractJSON(tt.text); got != tt.want {
+			t.Errorf("extractJSON(%q) = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestGeminiSchema(t *testing.T) {
+	schema, err := geminiSchema(&JSONSchema{Schema: json.RawMessage(`{
+		"type": "object",
+		"properties": {
+			"color": {"type": "string", "enum": ["red", "green"]},
+			"note": {"type": ["string", "null"]},
+			"sizes": {"type": "array", "items": {"type": "integer"}}
+		},
+		"required": ["color"]
+	}`)})
+	if err != nil {
+		t.Fatalf("geminiSchema() error = %v", err)
+	}
+	if schema.Type != genai.TypeObject || len(schema.Required) != 1 {
+		t.Errorf("geminiSchema() = %+v", schema)
+	}
+	if color := schema.Properties["color"]; color.Type != genai.TypeString || len(color.Enum) != 2 {
+		t.Errorf("color schema = %+v", color)
+	}
+	if note := schema.Properties["note"]; note.Type != genai.TypeString || !note.Nullable {
+		t.Errorf("note schema = %+v, want nullable string", note)
+	}
+	if sizes := schema.Properties["sizes"]; sizes.Type != genai.TypeArray || sizes.Items.Type != genai.TypeInteger {
+		t.Errorf("sizes schema = %+v, want array of integer", sizes)
+	}
+
+	if schema, err := geminiSchema(&JSONSchema{}); err != nil || schema != nil {
+		t.Errorf("geminiSchema() of an empty schema = %v, %v, want nil", schema, err)
+	}
+}
+
+func TestNewAnthropicParams_JSON(t *testing.T) {
+	options := Options{JSON: &JSONSchema{Name: "greeting", Schema: json.RawMessage(`{"type":"object","properties":{"greeting":{"type":"string"}}}`)}}
+	params, err := newAnthropicParams(context.Background(), assistant, UserMessages([]string{"Greet the world"}), "claude-3-5-haiku-latest", options)
+	if err != nil {
+		t.Fatalf("newAnthropicParams() error = %v", err)
+	}
+
+	data, err := json.Marshal(params)
+	if err != nil {
+		t.Fatalf("failed to marshal params: %v", err)
+	}
+	var request struct {
+		Tools []struct {
+			Name        string         `json:"name"`
+			InputSchema map[string]any `json:"input_schema"`
+		} `json:"tools"`
+		ToolChoice struct {
+			Type string `json:"type"`
+			Name string `json:"name"`
+		} `json:"tool_choice"`
+	}
+	if err := json.Unmarshal(data, &request); err != nil {
+		t.Fatalf("failed to unmarshal params: %v", err)
+	}
+	if len(request.Tools) != 1 || request.Tools[0].Name != "greeting" || request.Tools[0].InputSchema["properties"] == nil {
+		t.Errorf("params tools = %+v", request.Tools)
+	}
+	if request.ToolChoice.Type != "tool" || request.ToolChoice.Name != "greeting" {
+		t.Errorf("params tool_choice = %+v, want the greeting tool", request.ToolChoice)
+	}
+}
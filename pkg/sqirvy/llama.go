@@ -8,8 +8,8 @@ package sqirvy
 import (
 	"context"
 	"fmt"
-	"os"
 	"strings"
+	"time"
 
 	"github.com/tmc/langchaingo/llms"
 	"github.com/tmc/langchaingo/llms/openai"
@@ -24,47 +24,117 @@ const (
 // It provides methods for querying Llama language models through
 // an OpenAI-compatible interface.
 type LlamaClient struct {
-	llm llms.Model // OpenAI-compatible LLM client
+	llm     llms.Model    // OpenAI-compatible LLM client
+	timeout time.Duration // Default timeout of a query
 }
 
 // Ensure LlamaClient implements the Client interface
 var _ Client = (*LlamaClient)(nil)
 
+func init() {
+	mustRegister(Llama, func(opts ...ClientOption) (Client, error) { return NewLlamaClient(opts...) }, llamaModels)
+}
+
 // NewLlamaClient creates a new instance of LlamaClient.
-// It returns an error if the required environment variables are not set.
-func NewLlamaClient() (*LlamaClient, error) {
-	apiKey := os.Getenv("LLAMA_API_KEY")
-	if apiKey == "" {
-		return nil, fmt.Errorf("LLAMA_API_KEY environment variable not set")
+// The API key and base URL are taken from the options, or from the
+// LLAMA_API_KEY and LLAMA_BASE_URL environment variables if not set.
+// It returns an error if either is missing.
+func NewLlamaClient(opts ...ClientOption) (*LlamaClient, error) {
+	config := NewClientConfig(opts...)
+	apiKey, err := config.apiKey("LLAMA_API_KEY")
+	if err != nil {
+		return nil, err
 	}
 
-	baseURL := os.Getenv("LLAMA_BASE_URL")
+	baseURL := config.baseURL("LLAMA_BASE_URL")
 	if baseURL == "" {
 		return nil, fmt.Errorf("LLAMA_BASE_URL environment variable not set")
 	}
 
+	httpClient, err := config.NewHTTPClient()
+	if err != nil {
+		return nil, err
+	}
+
 	llm, err := openai.New(
 		openai.WithBaseURL(baseURL),
 		openai.WithToken(apiKey),
+		openai.WithHTTPClient(httpClient),
 	)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create Llama client: %w", err)
 	}
 
 	return &LlamaClient{
-		llm: llm,
+		llm:     llm,
+		timeout: config.Timeout,
 	}, nil
 }
 
 // LlamaClient.QueryText implements the QueryText method for the Client interface.
 // It sends a text query to Meta's Llama models and returns the generated text response.
 func (c *LlamaClient) QueryText(ctx context.Context, system string, prompts []string, model string, options Options) (string, error) {
+	return responseText(c.QueryMessages(ctx, system, UserMessages(prompts), model, options, nil))
+}
+
+// LlamaClient.QueryStream implements the QueryStream method for the Client interface.
+// It sends a streaming text query to Meta's Llama models and passes each chunk to stream.
+func (c *LlamaClient) QueryStream(ctx context.Context, system string, prompts []string, model string, options Options, stream StreamFunc) (string, error) {
+	return responseText(c.QueryMessages(ctx, system, UserMessages(prompts), model, options, stream))
+}
+
+// LlamaClient.QueryMessages implements the QueryMessages method for the Client interface.
+// It sends a conversation to Meta's Llama models, streaming the reply if stream is not nil.
+func (c *LlamaClient) QueryMessages(ctx context.Context, system string, messages []Message, model string, options Options, stream StreamFunc) (*Response, error) {
+	ctx, cancel := queryContext(ctx, options, c.timeout)
+	defer cancel()
+
+	content, callOptions, err := newLlamaContent(ctx, system, messages, model, options)
+	if err != nil {
+		return nil, err
+	}
+
+	// langchaingo streams tool call fragments as JSON along with the text,
+	// so with tools the reply is generated in full and delivered in one chunk
+	chunked := stream
+	if len(options.Tools) > 0 {
+		chunked = nil
+	}
+
+	// generate completion, retrying transient failures
+	resp, err := withRetry(ctx, options.Retry, chunked, func(stream StreamFunc) (*Response, error) {
+		opts := callOptions
+		if stream != nil {
+			opts = append(opts[:len(opts):len(opts)], llms.WithStreamingFunc(func(ctx context.Context, chunk []byte) error {
+				return stream(string(chunk))
+			}))
+		}
+		completion, err := c.llm.GenerateContent(ctx, content, opts...)
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate completion: %w", err)
+		}
+		return llamaResponse(completion, model), nil
+	})
+	if err != nil {
+		return nil, newProviderError(Llama, err)
+	}
+	if stream != nil && chunked == nil && resp.Text != "" {
+		if err := stream(resp.Text); err != nil {
+			return nil, err
+		}
+	}
+	return resp, nil
+}
+
+// newLlamaContent validates the query parameters and builds the langchaingo
+// message content and call options for the request
+func newLlamaContent(ctx context.Context, system string, messages []Message, model string, options Options) ([]llms.MessageContent, []llms.CallOption, error) {
 	if ctx.Err() != nil {
-		return "", fmt.Errorf("request context error %w", ctx.Err())
+		return nil, nil, fmt.Errorf("request context error %w", ctx.Err())
 	}
 
-	if len(prompts) == 0 {
-		return "", fmt.Errorf("prompts cannot be empty for text query")
+	if err := validateMessages(messages); err != nil {
+		return nil, nil, err
 	}
 
 	// Set default and validate temperature
@@ -72,37 +142,95 @@ func (c *LlamaClient) QueryText(ctx context.Context, system string, prompts []st
 		options.Temperature = MinTemperature
 	}
 	if options.Temperature > MaxTemperature {
-		return "", fmt.Errorf("temperature must be between %.1f and %.1f", MinTemperature, MaxTemperature)
+		return nil, nil, fmt.Errorf("temperature must be between %.1f and %.1f", MinTemperature, MaxTemperature)
 	}
 	// Scale temperature for Llama's 0-2 range
 	options.Temperature = (options.Temperature * LlamaTempScale) / MaxTemperature
 
-	// system prompt
+	// system prompt, with the schema for a JSON query since only JSON mode is supported
 	content := []llms.MessageContent{
-		llms.TextParts(llms.ChatMessageTypeSystem, system),
+		llms.TextParts(llms.ChatMessageTypeSystem, jsonSystemPrompt(system, options)),
 	}
 
-	// query prompts
-	for _, prompt := range prompts {
-		content = append(content, llms.TextParts(llms.ChatMessageTypeHuman, prompt))
+	// conversation messages, each tool result is a separate message
+	for _, m := range messages {
+		switch m.Role {
+		case RoleTool:
+			content = append(content, llms.MessageContent{
+				Role:  llms.ChatMessageTypeTool,
+				Parts: []llms.ContentPart{llms.ToolCallResponse{ToolCallID: m.ToolCallID, Name: m.ToolName, Content: m.Content}},
+			})
+		case RoleAssistant:
+			msg := llms.MessageContent{Role: llms.ChatMessageTypeAI}
+			if m.Content != "" || len(m.ToolCalls) == 0 {
+				msg.Parts = append(msg.Parts, llms.TextContent{Text: m.Content})
+			}
+			for _, call := range newChatToolCalls(m.ToolCalls) {
+				msg.Parts = append(msg.Parts, llms.ToolCall{
+					ID:           call.ID,
+					Type:         call.Type,
+					FunctionCall: &llms.FunctionCall{Name: call.Function.Name, Arguments: call.Function.Arguments},
+				})
+			}
+			content = append(content, msg)
+		default:
+			content = append(content, llms.TextParts(llms.ChatMessageTypeHuman, m.Content))
+		}
 	}
 
-	// generate completion
-	completion, err := c.llm.GenerateContent(
-		ctx, content,
+	callOptions := []llms.CallOption{
 		llms.WithTemperature(float64(options.Temperature)),
 		llms.WithModel(model),
-	)
-	if err != nil {
-		return "", fmt.Errorf("failed to generate completion: %w", err)
 	}
+	if options.JSON != nil {
+		callOptions = append(callOptions, llms.WithJSONMode())
+	}
+	if len(options.Tools) > 0 {
+		tools := make([]llms.Tool, 0, len(options.Tools))
+		for _, t := range options.Tools {
+			tools = append(tools, llms.Tool{
+				Type: "function",
+				Function: &llms.FunctionDefinition{
+					Name:        t.Name,
+					Description: t.Description,
+					Parameters:  t.parameters(),
+				},
+			})
+		}
+		callOptions = append(callOptions, llms.WithTools(tools))
+	}
+	return content, callOptions, nil
+}
+
+// llamaResponse converts a langchaingo completion into a Response
+func llamaResponse(completion *llms.ContentResponse, model string) *Response {
 
 	var response strings.Builder
 	for _, part := range completion.Choices {
 		response.WriteString(part.Content)
 	}
 
-	return response.String(), nil
+	result := &Response{Text: response.String(), Model: model}
+	if len(completion.Choices) > 0 {
+		// langchaingo reports usage in the generation info of each choice
+		choice := completion.Choices[0]
+		result.FinishReason = choice.StopReason
+		var calls []chatToolCall
+		for _, call := range choice.ToolCalls {
+			if call.FunctionCall != nil {
+				calls = append(calls, chatToolCall{ID: call.ID, Function: chatFunctionCall{Name: call.FunctionCall.Name, Arguments: call.FunctionCall.Arguments}})
+			}
+		}
+		result.ToolCalls = chatToolCalls(calls)
+		if tokens, ok := choice.GenerationInfo["PromptTokens"].(int); ok {
+			result.InputTokens = int64(tokens)
+		}
+		if tokens, ok := choice.GenerationInfo["CompletionTokens"].(int); ok {
+			result.OutputTokens = int64(tokens)
+		}
+	}
+
+	return result
 }
 
 // Close implements the Close method for the Client interface.
@@ -55,3 +55,29 @@ func TestLlamaClient_QueryText(t *testing.T) {
 		})
 	}
 }
+
+func TestLlamaClient_Replay(t *testing.T) {
+	runCassetteTests(t, Llama, []cassetteTest{
+		{
+			cassette: "llama_text",
+			model:    "llama3.3-70b",
+			prompts:  []string{"Say hello"},
+			want:     Response{Text: "Hello! It's nice to meet you. Is there something I can help you with?", Model: "llama3.3-70b", FinishReason: "stop", InputTokens: 23, OutputTokens: 17},
+		},
+		{
+			// langchaingo does not request usage when streaming
+			cassette: "llama_stream",
+			model:    "llama3.3-70b",
+			prompts:  []string{"Say hello"},
+			stream:   true,
+			want:     Response{Text: "Hello! Nice to meet you.", Model: "llama3.3-70b", FinishReason: "stop"},
+		},
+		{
+			cassette: "llama_auth_error",
+			model:    "llama3.3-70b",
+			prompts:  []string{"Say hello"},
+			apiKey:   "invalid",
+			wantErr:  ErrAuthentication,
+		},
+	})
+}
@@ -0,0 +1,273 @@
+// Package sqirvy provides an offline mock provider.
+//
+// This file implements the Client interface for the "mock" provider, which
+// answers queries without a network connection so the library, the command
+// line tool and the API server can be tested end to end. The model selects
+// how a query is answered:
+//
+//   - mock-echo replies with the last user message
+//   - mock-script replies with the responses of a MockScript
+//
+// A script is read from the YAML or JSON file named by the MOCK_SCRIPT
+// environment variable, or set with MockClient.SetScript. Scripted responses
+// can simulate provider errors and latency:
+//
+//	latency: 100ms
+//	responses:
+//	  - match: capital of France
+//	    text: Paris.
+//	  - status: 429
+//	    error: rate limit exceeded
+//	    retry_after: 1s
+//	  - text: Hello from the script.
+//	    delay: 2s
+package sqirvy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Models served by the mock provider
+const (
+	MockEchoModel   = "mock-echo"   // Replies with the last user message
+	MockScriptModel = "mock-script" // Replies with the responses of a script
+)
+
+// MockScript is the list of responses a MockClient answers mock-script queries with.
+type MockScript struct {
+	Latency   time.Duration  `json:"latency" yaml:"latency"`     // Delay before every response of both models
+	Responses []MockResponse `json:"responses" yaml:"responses"` // Responses to mock-script queries
+}
+
+// MockResponse is a scripted reply or failure. A response with Match answers
+// every query whose last message contains it. The responses without Match
+// answer the other queries in order, each of them once.
+type MockResponse struct {
+	Match      string         `json:"match" yaml:"match"`             // Text the last message must contain
+	Text       string         `json:"text" yaml:"text"`               // Reply text
+	ToolCalls  []MockToolCall `json:"tool_calls" yaml:"tool_calls"`   // Tools the reply calls
+	Status     int            `json:"status" yaml:"status"`           // Fail with this HTTP status instead of replying
+	Error      string         `json:"error" yaml:"error"`             // Error message of a failure
+	RetryAfter time.Duration  `json:"retry_after" yaml:"retry_after"` // Retry-After delay of a failure
+	Delay      time.Duration  `json:"delay" yaml:"delay"`             // Delay before this response, replacing the script's latency
+}
+
+// MockToolCall is a tool call in a scripted reply.
+type MockToolCall struct {
+	ID        string         `json:"id" yaml:"id"`               // Call identifier, defaults to call_N
+	Name      string         `json:"name" yaml:"name"`           // Name of the tool to call
+	Arguments map[string]any `json:"arguments" yaml:"arguments"` // Arguments of the call
+}
+
+// MockClient implements the Client interface for the mock provider.
+// It answers queries locally according to the model and its script.
+type MockClient struct {
+	mu      sync.Mutex
+	script  *MockScript   // Script for mock-script queries, nil if not set
+	next    int           // Index of the next unmatched response in the script
+	timeout time.Duration // Default timeout of a query
+}
+
+// Ensure MockClient implements the Client interface
+var _ Client = (*MockClient)(nil)
+
+func init() {
+	mustRegister(Mock, func(opts ...ClientOption) (Client, error) { return NewMockClient(opts...) }, mockModels)
+}
+
+// NewMockClient creates a new instance of MockClient.
+// The script is loaded from the file named by the MOCK_SCRIPT environment
+// variable if it is set. No API key is required.
+func NewMockClient(opts ...ClientOption) (*MockClient, error) {
+	config := NewClientConfig(opts...)
+	client := &MockClient{timeout: config.Timeout}
+
+	if path := os.Getenv("MOCK_SCRIPT"); path != "" {
+		script, err := LoadMockScript(path)
+		if err != nil {
+			return nil, err
+		}
+		client.script = script
+	}
+	return client, nil
+}
+
+// LoadMockScript reads a mock script from a YAML or JSON file.
+// Durations are written as strings such as "250ms" in both formats.
+func LoadMockScript(path string) (*MockScript, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read mock script: %w", err)
+	}
+
+	// JSON is valid YAML, and the YAML decoder parses durations from strings
+	var script MockScript
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&script); err != nil {
+		return nil, fmt.Errorf("failed to decode mock script %s: %w", path, err)
+	}
+	return &script, nil
+}
+
+// SetScript replaces the script of the client and starts it from its first response.
+func (c *MockClient) SetScript(script MockScript) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.script = &script
+	c.next = 0
+}
+
+// QueryText sends a text query to the mock model and returns the response.
+func (c *MockClient) QueryText(ctx context.Context, system string, prompts []string, model string, options Options) (string, error) {
+	return responseText(c.QueryMessages(ctx, system, UserMessages(prompts), model, options, nil))
+}
+
+// QueryStream sends a text query to the mock model and passes the response to stream word by word.
+func (c *MockClient) QueryStream(ctx context.Context, system string, prompts []string, model string, options Options, stream StreamFunc) (string, error) {
+	return responseText(c.QueryMessages(ctx, system, UserMessages(prompts), model, options, stream))
+}
+
+// QueryMessages answers a conversation according to the model and the script.
+// Scripted failures are retried following Options.Retry like provider errors.
+func (c *MockClient) QueryMessages(ctx context.Context, system string, messages []Message, model string, options Options, stream StreamFunc) (*Response, error) {
+	ctx, cancel := queryContext(ctx, options, c.timeout)
+	defer cancel()
+
+	if err := validateMessages(messages); err != nil {
+		return nil, err
+	}
+
+	resp, err := withRetry(ctx, options.Retry, stream, func(stream StreamFunc) (*Response, error) {
+		return c.respond(ctx, system, messages, model, stream)
+	})
+	return resp, newProviderError(Mock, err)
+}
+
+// respond answers a single attempt of a query
+func (c *MockClient) respond(ctx context.Context, system string, messages []Message, model string, stream StreamFunc) (*Response, error) {
+	reply, latency, err := c.reply(messages, model)
+	if err != nil {
+		return nil, err
+	}
+	if reply.Delay > 0 {
+		latency = reply.Delay
+	}
+	if err := sleepContext(ctx, latency); err != nil {
+		return nil, err
+	}
+	if reply.Status != 0 {
+		return nil, &StatusError{StatusCode: reply.Status, RetryAfter: reply.RetryAfter, Body: reply.Error}
+	}
+
+	result := &Response{
+		Text:         reply.Text,
+		Model:        model,
+		FinishReason: "stop",
+		OutputTokens: countWords(reply.Text),
+		InputTokens:  countWords(system),
+	}
+	for _, m := range messages {
+		result.InputTokens += countWords(m.Content)
+	}
+	for i, call := range reply.ToolCalls {
+		arguments, err := json.Marshal(call.Arguments)
+		if err != nil {
+			return nil, fmt.Errorf("invalid arguments for mock tool call %s: %w", call.Name, err)
+		}
+		if call.ID == "" {
+			call.ID = fmt.Sprintf("call_%d", i)
+		}
+		result.ToolCalls = append(result.ToolCalls, ToolCall{ID: call.ID, Name: call.Name, Arguments: arguments})
+		result.FinishReason = "tool_calls"
+	}
+
+	if stream != nil {
+		for _, chunk := range strings.SplitAfter(reply.Text, " ") {
+			if chunk == "" {
+				continue
+			}
+			if err := stream(chunk); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return result, nil
+}
+
+// reply selects the response to a query and the latency before it
+func (c *MockClient) reply(messages []Message, model string) (MockResponse, time.Duration, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var latency time.Duration
+	if c.script != nil {
+		latency = c.script.Latency
+	}
+
+	switch model {
+	case MockEchoModel:
+		for i := len(messages) - 1; i >= 0; i-- {
+			if messages[i].Role == RoleUser {
+				return MockResponse{Text: messages[i].Content}, latency, nil
+			}
+		}
+		return MockResponse{}, latency, nil
+	case MockScriptModel:
+		if c.script == nil {
+			return MockResponse{}, 0, fmt.Errorf("no mock script, set MOCK_SCRIPT to a script file")
+		}
+	default:
+		return MockResponse{}, 0, &StatusError{StatusCode: http.StatusNotFound, Body: fmt.Sprintf("model %s not found", model)}
+	}
+
+	last := messages[len(messages)-1].Content
+	for _, response := range c.script.Responses {
+		if response.Match != "" && strings.Contains(last, response.Match) {
+			return response, latency, nil
+		}
+	}
+	for c.next < len(c.script.Responses) {
+		response := c.script.Responses[c.next]
+		c.next++
+		if response.Match == "" {
+			return response, latency, nil
+		}
+	}
+	return MockResponse{}, 0, fmt.Errorf("mock script has no response left for %q", last)
+}
+
+// Close releases nothing, the mock client holds no resources.
+func (c *MockClient) Close() error {
+	return nil
+}
+
+// sleepContext waits for d or until ctx is done
+func sleepContext(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// countWords approximates the number of tokens in text
+func countWords(text string) int64 {
+	return int64(len(strings.Fields(text)))
+}
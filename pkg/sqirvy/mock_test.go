@@ -0,0 +1,156 @@
+package sqirvy
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMockClient_Echo(t *testing.T) {
+	client, err := NewClient(Mock)
+	if err != nil {
+		t.Fatalf("NewClient(mock) error = %v", err)
+	}
+	defer client.Close()
+
+	var chunks []string
+	got, err := client.QueryStream(context.Background(), assistant, []string{"first", "say hello world"}, MockEchoModel, Options{}, func(chunk string) error {
+		chunks = append(chunks, chunk)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("QueryStream() error = %v", err)
+	}
+	if got != "say hello world" || strings.Join(chunks, "") != got || len(chunks) != 3 {
+		t.Errorf("QueryStream() = %q in chunks %q", got, chunks)
+	}
+
+	resp, err := client.QueryMessages(context.Background(), "be brief", UserMessages([]string{"one two three"}), MockEchoModel, Options{}, nil)
+	if err != nil {
+		t.Fatalf("QueryMessages() error = %v", err)
+	}
+	if resp.InputTokens != 5 || resp.OutputTokens != 3 || resp.Model != MockEchoModel || resp.FinishReason != "stop" {
+		t.Errorf("QueryMessages() = %+v", resp)
+	}
+
+	_, err = client.QueryText(context.Background(), "", []string{"hello"}, "mock-unknown", Options{})
+	if !errors.Is(err, ErrModelNotFound) {
+		t.Errorf("QueryText() with an unknown model error = %v, want ErrModelNotFound", err)
+	}
+	if _, err := client.QueryText(context.Background(), "", nil, MockEchoModel, Options{}); err == nil {
+		t.Errorf("QueryText() should fail without prompts")
+	}
+}
+
+func TestMockClient_Script(t *testing.T) {
+	client, err := NewMockClient()
+	if err != nil {
+		t.Fatalf("NewMockClient() error = %v", err)
+	}
+	if _, err := client.QueryText(context.Background(), "", []string{"hello"}, MockScriptModel, Options{}); err == nil {
+		t.Errorf("QueryText() should fail without a script")
+	}
+
+	client.SetScript(MockScript{Responses: []MockResponse{
+		{Match: "France", Text: "Paris."},
+		{Status: 503, Error: "overloaded"},
+		{Text: "first"},
+		{Status: 400, Error: "prompt is too long"},
+	}})
+	ctx := context.Background()
+	options := Options{Retry: testRetryPolicy}
+
+	// the 503 is retried and answered by the next response in order
+	if got, err := client.QueryText(ctx, "", []string{"hello"}, MockScriptModel, options); err != nil || got != "first" {
+		t.Errorf("QueryText() = %q, %v, want first", got, err)
+	}
+	// a matching response answers every time, and does not use up the order
+	for range 2 {
+		if got, err := client.QueryText(ctx, "", []string{"What is the capital of France?"}, MockScriptModel, options); err != nil || got != "Paris." {
+			t.Errorf("QueryText() = %q, %v, want Paris.", got, err)
+		}
+	}
+	_, err = client.QueryText(ctx, "", []string{"hello"}, MockScriptModel, options)
+	var providerErr *ProviderError
+	if !errors.As(err, &providerErr) || providerErr.Kind != ErrContextTooLong || providerErr.StatusCode != 400 || providerErr.Provider != Mock {
+		t.Errorf("QueryText() error = %#v, want a context too long provider error", err)
+	}
+	if _, err := client.QueryText(ctx, "", []string{"hello"}, MockScriptModel, options); err == nil || !strings.Contains(err.Error(), "no response left") {
+		t.Errorf("QueryText() error = %v, want the script used up", err)
+	}
+}
+
+func TestMockClient_Latency(t *testing.T) {
+	client, _ := NewMockClient()
+	client.SetScript(MockScript{Latency: time.Second, Responses: []MockResponse{{Text: "late"}, {Text: "soon", Delay: time.Millisecond}}})
+
+	start := time.Now()
+	_, err := client.QueryText(context.Background(), "", []string{"hello"}, MockEchoModel, Options{Timeout: 20 * time.Millisecond})
+	if !errors.Is(err, ErrTimeout) || time.Since(start) > 500*time.Millisecond {
+		t.Errorf("QueryText() error = %v after %v, want ErrTimeout", err, time.Since(start))
+	}
+	client.SetScript(MockScript{Latency: time.Second, Responses: []MockResponse{{Text: "soon", Delay: time.Millisecond}}})
+	if got, err := client.QueryText(context.Background(), "", []string{"hello"}, MockScriptModel, Options{}); err != nil || got != "soon" {
+		t.Errorf("QueryText() = %q, %v, want soon", got, err)
+	}
+}
+
+func TestMockClient_Tools(t *testing.T) {
+	client, _ := NewMockClient()
+	client.SetScript(MockScript{Responses: []MockResponse{
+		{ToolCalls: []MockToolCall{{Name: "add", Arguments: map[string]any{"a": 2, "b": 3}}}},
+		{Match: "5", Text: "2 + 3 = 5"},
+	}})
+
+	prompt := []Message{{Role: RoleUser, Content: "What is 2 + 3?"}}
+	resp, messages, err := RunTools(context.Background(), client, assistant, prompt, MockScriptModel, Options{}, []ToolHandler{newAddTool(t)})
+	if err != nil {
+		t.Fatalf("RunTools() error = %v", err)
+	}
+	if resp.Text != "2 + 3 = 5" || len(messages) != 4 {
+		t.Errorf("RunTools() = %+v with %d messages", resp, len(messages))
+	}
+	if call := messages[1].ToolCalls[0]; call.ID != "call_0" || string(call.Arguments) != `{"a":2,"b":3}` {
+		t.Errorf("tool call = %+v", call)
+	}
+}
+
+func TestLoadMockScript(t *testing.T) {
+	dir := t.TempDir()
+	yamlPath := filepath.Join(dir, "script.yaml")
+	os.WriteFile(yamlPath, []byte("latency: 5ms\nresponses:\n  - text: from yaml\n    retry_after: 2s\n"), 0644)
+	jsonPath := filepath.Join(dir, "script.json")
+	os.WriteFile(jsonPath, []byte(`{"responses": [{"match": "hi", "text": "from json", "delay": "1ms"}]}`), 0644)
+	badPath := filepath.Join(dir, "bad.yaml")
+	os.WriteFile(badPath, []byte("responses:\n  - txt: typo\n"), 0644)
+
+	script, err := LoadMockScript(yamlPath)
+	if err != nil {
+		t.Fatalf("LoadMockScript(yaml) error = %v", err)
+	}
+	if script.Latency != 5*time.Millisecond || script.Responses[0].Text != "from yaml" || script.Responses[0].RetryAfter != 2*time.Second {
+		t.Errorf("LoadMockScript(yaml) = %+v", script)
+	}
+	if _, err := LoadMockScript(badPath); err == nil {
+		t.Errorf("LoadMockScript() should reject unknown fields")
+	}
+
+	// NewClient loads the script named by MOCK_SCRIPT
+	t.Setenv("MOCK_SCRIPT", jsonPath)
+	client, err := NewClient(Mock)
+	if err != nil {
+		t.Fatalf("NewClient(mock) error = %v", err)
+	}
+	if got, err := client.QueryText(context.Background(), "", []string{"hi there"}, MockScriptModel, Options{}); err != nil || got != "from json" {
+		t.Errorf("QueryText() = %q, %v, want from json", got, err)
+	}
+
+	t.Setenv("MOCK_SCRIPT", filepath.Join(dir, "missing.yaml"))
+	if _, err := NewClient(Mock); err == nil {
+		t.Errorf("NewClient(mock) should fail for a missing script")
+	}
+}
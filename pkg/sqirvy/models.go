@@ -1,25 +1,11 @@
 // Package api provides model management functionality for AI language models.
 //
-// This file contains model-to-provider mappings and utility functions for
-// working with different AI models across supported providers.
+// This file contains the built-in models of each supported provider, which are
+// added to the registry, and utility functions for looking up model metadata.
 package sqirvy
 
 import "fmt"
 
-var modelAlias = map[string]string{
-	"claude-3-7-sonnet": "claude-3-7-sonnet-latest",
-	"claude-3-5-sonnet": "claude-3-5-sonnet-latest",
-	"claude-3-5-haiku":  "claude-3-5-haiku-latest",
-	"claude-3-opus":     "claude-3-opus-latest",
-}
-
-func GetModelAlias(model string) string {
-	if alias, ok := modelAlias[model]; ok {
-		return alias
-	}
-	return model
-}
-
 // Supported AI providers
 const (
 	Anthropic string = "anthropic" // Anthropic's Claude models
@@ -27,69 +13,85 @@ const (
 	Gemini    string = "gemini"    // Google's Gemini models
 	OpenAI    string = "openai"    // OpenAI's GPT models
 	Llama     string = "llama"     // Meta's Llama models
+	Mock      string = "mock"      // Offline models for testing
 )
 
-// ModelToProvider maps model names to their respective providers.
-// This mapping is used to determine which client implementation should handle
-// requests for a given model.
-var modelToProvider = map[string]string{
-	// anthropic models
-	"claude-3-7-sonnet-20250219": Anthropic,
-	"claude-3-5-sonnet-20241022": Anthropic,
-	"claude-3-7-sonnet-latest":   Anthropic,
-	"claude-3-5-sonnet-latest":   Anthropic,
-	"claude-3-5-haiku-latest":    Anthropic,
-	"claude-3-haiku-20240307":    Anthropic,
-	"claude-3-opus-latest":       Anthropic,
-	"claude-3-opus-20240229":     Anthropic,
-	// deepseek models
-	"deepseek-r1": DeepSeek,
-	"deepseek-v3": DeepSeek,
-	// google gemini models
-	// gemini-2.0-pro-exp-02-05
-	// gemini-2.0-flash-thinking-exp-01-21
-	"gemini-2.0-flash":              Gemini,
-	"gemini-1.5-flash":              Gemini,
-	"gemini-1.5-pro":                Gemini,
-	"gemini-2.0-flash-thinking-exp": Gemini,
-	// openai models
-	"gpt-4o":      OpenAI,
-	"gpt-4o-mini": OpenAI,
-	"gpt-4-turbo": OpenAI,
-	"o1-mini":     OpenAI,
-	// "o3-mini":     "openai",
-	// llama models
-	"llama3.3-70b": Llama,
-}
-
-// ModelToMaxTokens maps model names to their maximum token limits.
-// If a model is not in this map, MaxTokensDefault will be used.
-var modelToMaxTokens = map[string]int64{
-	// anthropic models
-	"claude-3-7-sonnet-latest": MaxTokensDefault,
-	"claude-3-5-sonnet-latest": MaxTokensDefault,
-	"claude-3-5-haiku-latest":  MaxTokensDefault,
-	"claude-3-opus-latest":     4096,
-	// deepseek models
-	"deepseek-r1": MaxTokensDefault,
-	"deepseek-v3": MaxTokensDefault,
-	// google gemini models
-	"gemini-2.0-flash": MaxTokensDefault,
-	"gemini-1.5-flash": MaxTokensDefault,
-	"gemini-1.5-pro":   MaxTokensDefault,
-	// openai models
-	"gpt-4o":      4096,
-	"gpt-4o-mini": 4096,
-	"gpt-4-turbo": 4096,
-	"o1-mini":     MaxTokensDefault,
-	// llama models
-	"llama3.3-70b": MaxTokensDefault,
+// Capabilities of the built-in models
+var (
+	allCapabilities = Capabilities{Streaming: true, JSON: true, Tools: true}
+	textOnly        = Capabilities{Streaming: true}
+)
+
+// perMillion returns a model price in US dollars per million tokens for the model tables below
+func perMillion(input, output float64) *ModelPrice {
+	return &ModelPrice{Input: input, Output: output}
+}
+
+// Built-in models registered by each provider. A model without a
+// MaxTokens uses MaxTokensDefault, and one without a Price has no
+// cost estimate.
+var anthropicModels = []ModelInfo{
+	{Name: "claude-3-7-sonnet-20250219", ContextWindow: 200000, Price: perMillion(3.00, 15.00), Capabilities: allCapabilities},
+	{Name: "claude-3-5-sonnet-20241022", ContextWindow: 200000, Price: perMillion(3.00, 15.00), Capabilities: allCapabilities},
+	{Name: "claude-3-7-sonnet-latest", Aliases: []string{"claude-3-7-sonnet"}, MaxTokens: MaxTokensDefault, ContextWindow: 200000, Price: perMillion(3.00, 15.00), Capabilities: allCapabilities},
+	{Name: "claude-3-5-sonnet-latest", Aliases: []string{"claude-3-5-sonnet"}, MaxTokens: MaxTokensDefault, ContextWindow: 200000, Price: perMillion(3.00, 15.00), Capabilities: allCapabilities},
+	{Name: "claude-3-5-haiku-latest", Aliases: []string{"claude-3-5-haiku"}, MaxTokens: MaxTokensDefault, ContextWindow: 200000, Price: perMillion(0.80, 4.00), Capabilities: allCapabilities},
+	{Name: "claude-3-haiku-20240307", ContextWindow: 200000, Price: perMillion(0.25, 1.25), Capabilities: allCapabilities},
+	{Name: "claude-3-opus-latest", Aliases: []string{"claude-3-opus"}, MaxTokens: 4096, ContextWindow: 200000, Price: perMillion(15.00, 75.00), Capabilities: allCapabilities},
+	{Name: "claude-3-opus-20240229", ContextWindow: 200000, Price: perMillion(15.00, 75.00), Capabilities: allCapabilities},
+}
+
+var deepseekModels = []ModelInfo{
+	{Name: "deepseek-r1", MaxTokens: MaxTokensDefault, ContextWindow: 65536, Price: perMillion(0.55, 2.19), Capabilities: textOnly},
+	{Name: "deepseek-v3", MaxTokens: MaxTokensDefault, ContextWindow: 65536, Price: perMillion(0.27, 1.10), Capabilities: allCapabilities},
+}
+
+// gemini-2.0-pro-exp-02-05
+// gemini-2.0-flash-thinking-exp-01-21
+var geminiModels = []ModelInfo{
+	{Name: "gemini-2.0-flash", MaxTokens: MaxTokensDefault, ContextWindow: 1048576, Price: perMillion(0.10, 0.40), Capabilities: allCapabilities},
+	{Name: "gemini-1.5-flash", MaxTokens: MaxTokensDefault, ContextWindow: 1048576, Price: perMillion(0.075, 0.30), Capabilities: allCapabilities},
+	{Name: "gemini-1.5-pro", MaxTokens: MaxTokensDefault, ContextWindow: 2097152, Price: perMillion(1.25, 5.00), Capabilities: allCapabilities},
+	{Name: "gemini-2.0-flash-thinking-exp", ContextWindow: 32768, Capabilities: textOnly},
+}
+
+// "o3-mini"
+var openAIModels = []ModelInfo{
+	{Name: "gpt-4o", MaxTokens: 4096, ContextWindow: 128000, Price: perMillion(2.50, 10.00), Capabilities: allCapabilities},
+	{Name: "gpt-4o-mini", MaxTokens: 4096, ContextWindow: 128000, Price: perMillion(0.15, 0.60), Capabilities: allCapabilities},
+	{Name: "gpt-4-turbo", MaxTokens: 4096, ContextWindow: 128000, Price: perMillion(10.00, 30.00), Capabilities: allCapabilities},
+	{Name: "o1-mini", MaxTokens: MaxTokensDefault, ContextWindow: 128000, Price: perMillion(1.10, 4.40), Capabilities: textOnly},
+}
+
+var llamaModels = []ModelInfo{
+	{Name: "llama3.3-70b", MaxTokens: MaxTokensDefault, ContextWindow: 128000, Capabilities: allCapabilities},
 }
 
+var mockModels = []ModelInfo{
+	{Name: MockEchoModel, ContextWindow: 128000, Price: perMillion(0, 0), Capabilities: allCapabilities},
+	{Name: MockScriptModel, ContextWindow: 128000, Price: perMillion(0, 0), Capabilities: allCapabilities},
+}
+
+// GetModelAlias returns the model name an alias refers to, or model itself
+// if it is not an alias.
+func GetModelAlias(model string) string {
+	if info, ok := LookupModel(model); ok {
+		return info.Name
+	}
+	return model
+}
+
+// ModelPrice is the price of a model in US dollars per million tokens.
+type ModelPrice struct {
+	Input  float64 `json:"input" yaml:"input"`   // Price per million input tokens
+	Output float64 `json:"output" yaml:"output"` // Price per million output tokens
+}
+
+// GetModelList returns the names of the registered models.
 func GetModelList() []string {
 	var models []string
-	for model := range modelToProvider {
-		models = append(models, model)
+	for _, model := range Models() {
+		models = append(models, model.Name)
 	}
 	return models
 }
@@ -99,10 +101,11 @@ type ModelProvider struct {
 	Provider string
 }
 
+// GetModelProviderList returns the registered models with their providers.
 func GetModelProviderList() []ModelProvider {
 	var mp []ModelProvider
-	for model, provider := range modelToProvider {
-		mp = append(mp, ModelProvider{Model: model, Provider: provider})
+	for _, model := range Models() {
+		mp = append(mp, ModelProvider{Model: model.Name, Provider: model.Provider})
 	}
 	return mp
 }
@@ -110,17 +113,46 @@ func GetModelProviderList() []ModelProvider {
 // GetProviderName returns the provider name for a given model identifier.
 // Returns an error if the model is not recognized.
 func GetProviderName(model string) (string, error) {
-	if provider, ok := modelToProvider[model]; ok {
-		return provider, nil
+	if info, ok := LookupModel(model); ok {
+		return info.Provider, nil
 	}
 	return "", fmt.Errorf("unrecognized model: %s", model)
 }
 
 // GetMaxTokens returns the maximum token limit for a given model identifier.
-// Returns MaxTokensDefault if the model is not in ModelToMaxTokens.
+// Returns MaxTokensDefault if the model is not registered or has no limit set.
 func GetMaxTokens(model string) int64 {
-	if maxTokens, ok := modelToMaxTokens[model]; ok {
-		return maxTokens
+	if info, ok := LookupModel(model); ok && info.MaxTokens > 0 {
+		return info.MaxTokens
 	}
 	return MaxTokensDefault
 }
+
+// GetContextWindow returns the context window of a model identifier in tokens.
+// Returns zero if the model is not registered or its context window is unknown.
+func GetContextWindow(model string) int64 {
+	if info, ok := LookupModel(model); ok {
+		return info.ContextWindow
+	}
+	return 0
+}
+
+// GetModelPrice returns the price of a model identifier.
+// Returns false if the model is not registered or has no price.
+func GetModelPrice(model string) (ModelPrice, bool) {
+	if info, ok := LookupModel(model); ok && info.Price != nil {
+		return *info.Price, true
+	}
+	return ModelPrice{}, false
+}
+
+// GetCost returns the estimated cost in US dollars of a query to a model
+// with the given token usage. Returns false if the model has no price.
+func GetCost(model string, inputTokens, outputTokens int64) (float64, bool) {
+	price, ok := GetModelPrice(model)
+	if !ok {
+		return 0, false
+	}
+	cost := (float64(inputTokens)*price.Input + float64(outputTokens)*price.Output) / 1e6
+	return cost, true
+}
@@ -2,6 +2,7 @@ package sqirvy
 
 import (
 	"context"
+	"math"
 	"os"
 	"testing"
 )
@@ -30,8 +31,12 @@ func TestAllModels(t *testing.T) {
 		},
 	}
 
-	// Test each model from ModelToProvider
-	for model, provider := range modelToProvider {
+	// the mock models need no API key, and mock-script answers every query
+	setMockScript(t, "responses:\n  - match: World\n    text: Hello, World!\n")
+
+	// Test each registered model
+	for _, mp := range GetModelProviderList() {
+		model, provider := mp.Model, mp.Provider
 		// Create client for this provider
 		client, err := NewClient(provider)
 		if err != nil {
@@ -54,7 +59,7 @@ func TestAllModels(t *testing.T) {
 			apiKey = os.Getenv("LLAMA_API_KEY")
 		}
 
-		if apiKey == "" {
+		if apiKey == "" && provider != Mock {
 			t.Logf("Skipping tests for %s model %s: API key not set", provider, model)
 			continue
 		}
@@ -83,3 +88,49 @@ func TestAllModels(t *testing.T) {
 		})
 	}
 }
+
+func TestGetCost(t *testing.T) {
+	tests := []struct {
+		name   string
+		model  string
+		input  int64
+		output int64
+		want   float64
+		wantOk bool
+	}{
+		{
+			name:   "Priced model",
+			model:  "gpt-4o",
+			input:  1_000_000,
+			output: 500_000,
+			want:   7.50,
+			wantOk: true,
+		},
+		{
+			name:   "No usage",
+			model:  "claude-3-5-haiku-latest",
+			want:   0,
+			wantOk: true,
+		},
+		{
+			name:   "Unpriced model",
+			model:  "llama3.3-70b",
+			input:  1000,
+			output: 1000,
+			want:   0,
+			wantOk: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := GetCost(tt.model, tt.input, tt.output)
+			if ok != tt.wantOk {
+				t.Errorf("GetCost() ok = %v, want %v", ok, tt.wantOk)
+			}
+			if math.Abs(got-tt.want) > 1e-9 {
+				t.Errorf("GetCost() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
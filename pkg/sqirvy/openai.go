@@ -11,8 +11,10 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"mime/multipart"
 	"net/http"
-	"os"
+	"net/url"
+	"time"
 )
 
 const (
@@ -24,65 +26,125 @@ const (
 // It provides methods for querying OpenAI's language models through
 // their HTTP API.
 type OpenAIClient struct {
-	apiKey  string       // OpenAI API authentication key
-	baseURL string       // OpenAI API base URL
-	client  *http.Client // HTTP client for making API requests
+	apiKey  string        // OpenAI API authentication key
+	baseURL string        // OpenAI API base URL
+	client  *http.Client  // HTTP client for making API requests
+	timeout time.Duration // Default timeout of a query
 }
 
 // Ensure OpenAIClient implements the Client interface
 var _ Client = (*OpenAIClient)(nil)
 
+func init() {
+	mustRegister(OpenAI, func(opts ...ClientOption) (Client, error) { return NewOpenAIClient(opts...) }, openAIModels)
+}
+
 // NewOpenAIClient creates a new instance of OpenAIClient.
-// It returns an error if the required OPENAI_API_KEY environment variable is not set.
-func NewOpenAIClient() (*OpenAIClient, error) {
-	apiKey := os.Getenv("OPENAI_API_KEY")
-	if apiKey == "" {
-		return nil, fmt.Errorf("OPENAI_API_KEY environment variable not set")
+// The API key and base URL are taken from the options, or from the
+// OPENAI_API_KEY and OPENAI_BASE_URL environment variables if not set.
+// It returns an error if either is missing.
+func NewOpenAIClient(opts ...ClientOption) (*OpenAIClient, error) {
+	config := NewClientConfig(opts...)
+	apiKey, err := config.apiKey("OPENAI_API_KEY")
+	if err != nil {
+		return nil, err
 	}
 
-	baseURL := os.Getenv("OPENAI_BASE_URL")
+	baseURL := config.baseURL("OPENAI_BASE_URL")
 	if baseURL == "" {
 		return nil, fmt.Errorf("OPENAI_BASE_URL environment variable not set")
 	}
 
+	client, err := config.NewHTTPClient()
+	if err != nil {
+		return nil, err
+	}
+
 	return &OpenAIClient{
 		apiKey:  apiKey,
 		baseURL: baseURL,
-		client:  &http.Client{},
+		client:  client,
+		timeout: config.Timeout,
 	}, nil
 }
 
 // openAIRequest represents the structure of a request to OpenAI's chat completion API
 type openAIRequest struct {
-	Model          string          `json:"model"`                           // Model identifier
-	Messages       []openAIMessage `json:"messages"`                        // Conversation messages
-	MaxTokens      int             `json:"max_completion_tokens,omitempty"` // Max response length
-	ResponseFormat string          `json:"response_format,omitempty"`       // Desired response format
-	Temperature    float32         `json:"temperature,omitempty"`           // Controls the randomness of the output
+	Model          string              `json:"model"`                           // Model identifier
+	Messages       []openAIMessage     `json:"messages"`                        // Conversation messages
+	MaxTokens      int                 `json:"max_completion_tokens,omitempty"` // Max response length
+	ResponseFormat *chatResponseFormat `json:"response_format,omitempty"`       // Desired response format
+	Temperature    float32             `json:"temperature,omitempty"`           // Controls the randomness of the output
+	Tools          []chatTool          `json:"tools,omitempty"`                 // Functions the model may call
+	StreamOptions  *chatStreamOptions  `json:"stream_options,omitempty"`        // Request usage in the final stream event
+	Stream         bool                `json:"stream,omitempty"`                // Stream the response as server-sent events
 }
 
 type openAIMessage struct {
-	Role    string `json:"role"`
-	Content string `json:"content"`
+	Role       string         `json:"role"`
+	Content    string         `json:"content"`
+	ToolCalls  []chatToolCall `json:"tool_calls,omitempty"`
+	ToolCallID string         `json:"tool_call_id,omitempty"`
 }
 
 type openAIResponse struct {
+	Model   string `json:"model"`
 	Choices []struct {
 		Message struct {
-			Content string `json:"content"`
+			Content   string         `json:"content"`
+			ToolCalls []chatToolCall `json:"tool_calls"`
 		} `json:"message"`
+		FinishReason string `json:"finish_reason"`
 	} `json:"choices"`
+	Usage chatUsage `json:"usage"`
 }
 
 // OpenAIClient.QueryText implements the QueryText method for the Client interface.
 // It sends a text query to OpenAI's API and returns the generated text response.
 func (c *OpenAIClient) QueryText(ctx context.Context, system string, prompts []string, model string, options Options) (string, error) {
+	return responseText(c.QueryMessages(ctx, system, UserMessages(prompts), model, options, nil))
+}
+
+// OpenAIClient.QueryStream implements the QueryStream method for the Client interface.
+// It sends a streaming text query to OpenAI's API and passes each content delta to stream.
+func (c *OpenAIClient) QueryStream(ctx context.Context, system string, prompts []string, model string, options Options, stream StreamFunc) (string, error) {
+	return responseText(c.QueryMessages(ctx, system, UserMessages(prompts), model, options, stream))
+}
+
+// OpenAIClient.QueryMessages implements the QueryMessages method for the Client interface.
+// It sends a conversation to OpenAI's API, streaming the reply if stream is not nil.
+func (c *OpenAIClient) QueryMessages(ctx context.Context, system string, messages []Message, model string, options Options, stream StreamFunc) (*Response, error) {
+	ctx, cancel := queryContext(ctx, options, c.timeout)
+	defer cancel()
+
+	reqBody, err := c.newRequest(ctx, system, messages, model, options)
+	if err != nil {
+		return nil, err
+	}
+
+	// Send request and return response, retrying transient failures
+	if stream == nil {
+		resp, err := withRetry(ctx, options.Retry, nil, func(StreamFunc) (*Response, error) {
+			return c.makeRequest(ctx, reqBody)
+		})
+		return resp, newProviderError(OpenAI, err)
+	}
+	reqBody.Stream = true
+	reqBody.StreamOptions = &chatStreamOptions{IncludeUsage: true}
+	resp, err := withRetry(ctx, options.Retry, stream, func(stream StreamFunc) (*Response, error) {
+		return c.makeStreamRequest(ctx, reqBody, stream)
+	})
+	return resp, newProviderError(OpenAI, err)
+}
+
+// newRequest validates the query parameters and builds the chat completion request body
+func (c *OpenAIClient) newRequest(ctx context.Context, system string, messages []Message, model string, options Options) (openAIRequest, error) {
 	if ctx.Err() != nil {
-		return "", fmt.Errorf("request context error %w", ctx.Err())
+		return openAIRequest{}, fmt.Errorf("request context error %w", ctx.Err())
 	}
 
-	if len(prompts) == 0 {
-		return "", fmt.Errorf("prompts cannot be empty for text query")
+	if err := validateMessages(messages); err != nil {
+		return openAIRequest{}, err
 	}
 
 	// Set default and validate temperature
@@ -90,7 +152,7 @@ func (c *OpenAIClient) QueryText(ctx context.Context, system string, prompts []s
 		options.Temperature = MinTemperature
 	}
 	if options.Temperature > MaxTemperature {
-		return "", fmt.Errorf("temperature must be between %.1f and %.1f", MinTemperature, MaxTemperature)
+		return openAIRequest{}, fmt.Errorf("temperature must be between %.1f and %.1f", MinTemperature, MaxTemperature)
 	}
 	// Scale temperature for OpenAI's 0-2 range
 	options.Temperature = (options.Temperature * OpenAITempScale) / MaxTemperature
@@ -101,42 +163,49 @@ func (c *OpenAIClient) QueryText(ctx context.Context, system string, prompts []s
 		maxTokens = MaxTokensDefault
 	}
 
-	// create the slice of prompts
-	messages := make([]openAIMessage, 0, len(prompts))
-	messages = append(messages, openAIMessage{Role: "system", Content: system})
-	for _, prompt := range prompts {
-		messages = append(messages, openAIMessage{Role: "user", Content: prompt})
+	// JSON mode needs the schema in the prompt, a json_schema format enforces it
+	responseFormat := newChatResponseFormat(options, true)
+	if responseFormat != nil && responseFormat.Type == "json_object" {
+		system = jsonSystemPrompt(system, options)
 	}
 
-	// Construct the request body with the prompt as a user message
-	reqBody := openAIRequest{
-		Model:       model,
-		Messages:    messages,
-		MaxTokens:   int(maxTokens),      // Limit response length
-		Temperature: options.Temperature, // Set temperature
+	// system prompt first, then the conversation
+	reqMessages := make([]openAIMessage, 0, len(messages)+1)
+	reqMessages = append(reqMessages, openAIMessage{Role: "system", Content: system})
+	for _, m := range messages {
+		reqMessages = append(reqMessages, openAIMessage{
+			Role:       m.Role,
+			Content:    m.Content,
+			ToolCalls:  newChatToolCalls(m.ToolCalls),
+			ToolCallID: m.ToolCallID,
+		})
 	}
 
-	// Send request and return response
-	return c.makeRequest(ctx, reqBody)
+	// Construct the request body
+	return openAIRequest{
+		Model:          model,
+		Messages:       reqMessages,
+		MaxTokens:      int(maxTokens),      // Limit response length
+		Temperature:    options.Temperature, // Set temperature
+		ResponseFormat: responseFormat,
+		Tools:          newChatTools(options.Tools),
+	}, nil
 }
 
-func (c *OpenAIClient) makeRequest(ctx context.Context, reqBody openAIRequest) (string, error) {
+func (c *OpenAIClient) makeRequest(ctx context.Context, reqBody openAIRequest) (*Response, error) {
 	// update the endpoing if OPENAI_BASE_URL is set
 	endpoint := c.baseURL + "/v1/chat/completions"
 
 	// Convert request body to JSON
 	jsonBody, err := json.Marshal(reqBody)
 	if err != nil {
-		return "", fmt.Errorf("failed to marshal request: %w", err)
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
 	}
 
-	ctx, cancel := context.WithTimeout(ctx, RequestTimeout)
-	defer cancel()
-
 	// Create new HTTP request with JSON body
 	req, err := http.NewRequestWithContext(ctx, "POST", endpoint, bytes.NewBuffer(jsonBody))
 	if err != nil {
-		return "", fmt.Errorf("failed to create request: %w", err)
+		return nil, fmt.Errorf("failed to create request: %w", err)
 	}
 
 	// Set required headers
@@ -146,34 +215,87 @@ func (c *OpenAIClient) makeRequest(ctx context.Context, reqBody openAIRequest) (
 	// Send the request
 	resp, err := c.client.Do(req)
 	if err != nil {
-		return "", fmt.Errorf("failed to make request: %w", err)
+		return nil, fmt.Errorf("failed to make request: %w", err)
 	}
 	defer resp.Body.Close()
 
 	// Read response body
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return "", fmt.Errorf("failed to read response body: %w", err)
+		return nil, fmt.Errorf("failed to read response body: %w", err)
 	}
 
 	// Check for non-200 status code
 	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("API request failed with status %d: %s", resp.StatusCode, string(body))
+		return nil, newStatusError(resp, body)
 	}
 
+	return openAIChatResponse(body)
+}
+
+// openAIChatResponse converts the body of a chat completion into a Response
+func openAIChatResponse(body []byte) (*Response, error) {
 	// Parse response JSON
 	var openAIResp openAIResponse
 	if err := json.Unmarshal(body, &openAIResp); err != nil {
-		return "", fmt.Errorf("failed to unmarshal response: %w", err)
+		return nil, fmt.Errorf("failed to unmarshal response: %w", err)
 	}
 
 	// Ensure we got at least one choice back
 	if len(openAIResp.Choices) == 0 {
-		return "", fmt.Errorf("no content in response")
+		return nil, fmt.Errorf("no content in response")
+	}
+
+	// A filtered response has no content to return
+	if openAIResp.Choices[0].FinishReason == "content_filter" && openAIResp.Choices[0].Message.Content == "" {
+		return nil, fmt.Errorf("response blocked: %w", ErrContentFiltered)
 	}
 
 	// Return the content of the first choice
-	return openAIResp.Choices[0].Message.Content, nil
+	return &Response{
+		Text:         openAIResp.Choices[0].Message.Content,
+		Model:        openAIResp.Model,
+		FinishReason: openAIResp.Choices[0].FinishReason,
+		InputTokens:  openAIResp.Usage.PromptTokens,
+		OutputTokens: openAIResp.Usage.CompletionTokens,
+		ToolCalls:    chatToolCalls(openAIResp.Choices[0].Message.ToolCalls),
+	}, nil
+}
+
+func (c *OpenAIClient) makeStreamRequest(ctx context.Context, reqBody openAIRequest, stream StreamFunc) (*Response, error) {
+	endpoint := c.baseURL + "/v1/chat/completions"
+
+	// Convert request body to JSON
+	jsonBody, err := json.Marshal(reqBody)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", endpoint, bytes.NewBuffer(jsonBody))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	// Set required headers
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "text/event-stream")
+	req.Header.Set("Authorization", "Bearer "+c.apiKey)
+
+	// Send the request
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to make request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	// Check for non-200 status code
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, newStatusError(resp, body)
+	}
+
+	// Read the event stream
+	return readChatStream(resp.Body, stream)
 }
 
 // Close implements the Close method for the Client interface.
@@ -181,3 +303,244 @@ func (c *OpenAIClient) Close() error {
 	// http.client does not require explicit close
 	return nil
 }
+
+// Ensure OpenAIClient implements the BatchClient interface
+var _ BatchClient = (*OpenAIClient)(nil)
+
+// openAIBatchLine is a line of the input file of an OpenAI batch
+type openAIBatchLine struct {
+	CustomID string        `json:"custom_id"`
+	Method   string        `json:"method"`
+	URL      string        `json:"url"`
+	Body     openAIRequest `json:"body"`
+}
+
+// openAIBatch is a batch object of the OpenAI Batch API
+type openAIBatch struct {
+	ID            string `json:"id"`
+	Status        string `json:"status"`
+	OutputFileID  string `json:"output_file_id"`
+	ErrorFileID   string `json:"error_file_id"`
+	RequestCounts struct {
+		Total     int `json:"total"`
+		Completed int `json:"completed"`
+		Failed    int `json:"failed"`
+	} `json:"request_counts"`
+}
+
+// openAIBatchOutput is a line of the output or error file of an OpenAI batch
+type openAIBatchOutput struct {
+	CustomID string `json:"custom_id"`
+	Response *struct {
+		StatusCode int             `json:"status_code"`
+		Body       json.RawMessage `json:"body"`
+	} `json:"response"`
+	Error *struct {
+		Code    string `json:"code"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// status converts a batch object into a BatchStatus
+func (b *openAIBatch) status() *BatchStatus {
+	counts := b.RequestCounts
+	return &BatchStatus{
+		ID:        b.ID,
+		Provider:  OpenAI,
+		Status:    b.Status,
+		Done:      b.Status == "completed" || b.Status == "failed" || b.Status == "expired" || b.Status == "cancelled",
+		Succeeded: counts.Completed,
+		Failed:    counts.Failed,
+		Pending:   max(counts.Total-counts.Completed-counts.Failed, 0),
+	}
+}
+
+// SubmitBatch uploads the requests as a JSONL file of chat completions and
+// creates a batch that processes it within 24 hours.
+func (c *OpenAIClient) SubmitBatch(ctx context.Context, requests []BatchRequest) (*BatchStatus, error) {
+	if err := validateBatch(requests); err != nil {
+		return nil, err
+	}
+
+	var input bytes.Buffer
+	encoder := json.NewEncoder(&input)
+	for _, r := range requests {
+		body, err := c.newRequest(ctx, r.System, r.Messages, r.Model, r.Options)
+		if err != nil {
+			return nil, fmt.Errorf("batch request %s: %w", r.ID, err)
+		}
+		line := openAIBatchLine{CustomID: r.ID, Method: http.MethodPost, URL: "/v1/chat/completions", Body: body}
+		if err := encoder.Encode(line); err != nil {
+			return nil, fmt.Errorf("failed to marshal batch request %s: %w", r.ID, err)
+		}
+	}
+
+	fileID, err := c.uploadBatchFile(ctx, input.Bytes())
+	if err != nil {
+		return nil, newProviderError(OpenAI, err)
+	}
+	var batch openAIBatch
+	err = c.doJSON(ctx, http.MethodPost, "/v1/batches", map[string]string{
+		"input_file_id":     fileID,
+		"endpoint":          "/v1/chat/completions",
+		"completion_window": "24h",
+	}, &batch)
+	if err != nil {
+		return nil, newProviderError(OpenAI, err)
+	}
+	return batch.status(), nil
+}
+
+// BatchStatus returns the status of an OpenAI batch.
+func (c *OpenAIClient) BatchStatus(ctx context.Context, id string) (*BatchStatus, error) {
+	var batch openAIBatch
+	if err := c.doJSON(ctx, http.MethodGet, "/v1/batches/"+url.PathEscape(id), nil, &batch); err != nil {
+		return nil, newProviderError(OpenAI, err)
+	}
+	return batch.status(), nil
+}
+
+// BatchResults downloads the output and error files of an ended OpenAI batch.
+// Requests the batch did not process, for example because it failed
+// validation, have no result.
+func (c *OpenAIClient) BatchResults(ctx context.Context, id string) ([]BatchResult, error) {
+	var batch openAIBatch
+	if err := c.doJSON(ctx, http.MethodGet, "/v1/batches/"+url.PathEscape(id), nil, &batch); err != nil {
+		return nil, newProviderError(OpenAI, err)
+	}
+	if !batch.status().Done {
+		return nil, fmt.Errorf("openai batch %s has not ended, status %s", id, batch.Status)
+	}
+
+	var results []BatchResult
+	for _, fileID := range []string{batch.OutputFileID, batch.ErrorFileID} {
+		if fileID == "" {
+			continue
+		}
+		content, err := c.downloadFile(ctx, fileID)
+		if err != nil {
+			return nil, newProviderError(OpenAI, err)
+		}
+		for _, line := range bytes.Split(content, []byte("\n")) {
+			if len(bytes.TrimSpace(line)) == 0 {
+				continue
+			}
+			var output openAIBatchOutput
+			if err := json.Unmarshal(line, &output); err != nil {
+				return nil, fmt.Errorf("failed to unmarshal batch result: %w", err)
+			}
+			results = append(results, output.result())
+		}
+	}
+	return results, nil
+}
+
+// result converts a line of a batch output or error file into a BatchResult
+func (o *openAIBatchOutput) result() BatchResult {
+	result := BatchResult{ID: o.CustomID}
+	switch {
+	case o.Error != nil:
+		result.Err = fmt.Errorf("%s: %s", o.Error.Code, o.Error.Message)
+	case o.Response == nil:
+		result.Err = fmt.Errorf("no response in batch result")
+	case o.Response.StatusCode != http.StatusOK:
+		result.Err = &StatusError{StatusCode: o.Response.StatusCode, Body: string(o.Response.Body)}
+	default:
+		result.Response, result.Err = openAIChatResponse(o.Response.Body)
+	}
+	result.Err = newProviderError(OpenAI, result.Err)
+	return result
+}
+
+// uploadBatchFile uploads the input file of a batch and returns its file ID
+func (c *OpenAIClient) uploadBatchFile(ctx context.Context, data []byte) (string, error) {
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	if err := writer.WriteField("purpose", "batch"); err != nil {
+		return "", fmt.Errorf("failed to write batch file: %w", err)
+	}
+	part, err := writer.CreateFormFile("file", "batch.jsonl")
+	if err != nil {
+		return "", fmt.Errorf("failed to write batch file: %w", err)
+	}
+	if _, err := part.Write(data); err != nil {
+		return "", fmt.Errorf("failed to write batch file: %w", err)
+	}
+	if err := writer.Close(); err != nil {
+		return "", fmt.Errorf("failed to write batch file: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.baseURL+"/v1/files", &body)
+	if err != nil {
+		return "", fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	respBody, err := c.send(req)
+	if err != nil {
+		return "", err
+	}
+
+	var file struct {
+		ID string `json:"id"`
+	}
+	if err := json.Unmarshal(respBody, &file); err != nil {
+		return "", fmt.Errorf("failed to unmarshal response: %w", err)
+	}
+	return file.ID, nil
+}
+
+// downloadFile returns the content of a file
+func (c *OpenAIClient) downloadFile(ctx context.Context, fileID string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.baseURL+"/v1/files/"+url.PathEscape(fileID)+"/content", nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	return c.send(req)
+}
+
+// doJSON sends a request with an optional JSON body to path and decodes the JSON response into out
+func (c *OpenAIClient) doJSON(ctx context.Context, method, path string, in, out any) error {
+	var body io.Reader
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return fmt.Errorf("failed to marshal request: %w", err)
+		}
+		body = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, body)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	respBody, err := c.send(req)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(respBody, out); err != nil {
+		return fmt.Errorf("failed to unmarshal response: %w", err)
+	}
+	return nil
+}
+
+// send authenticates and sends a request, returning the body of a successful response
+func (c *OpenAIClient) send(req *http.Request) ([]byte, error) {
+	req.Header.Set("Authorization", "Bearer "+c.apiKey)
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to make request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response body: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, newStatusError(resp, body)
+	}
+	return body, nil
+}
@@ -2,7 +2,11 @@ package sqirvy
 
 import (
 	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"reflect"
 	"testing"
 )
 
@@ -52,3 +56,129 @@ func TestOpenAIClient_QueryText(t *testing.T) {
 		})
 	}
 }
+
+func TestOpenAIClient_QueryMessages(t *testing.T) {
+	// capture the roles sent to the server
+	var roles []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req openAIRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		roles = roles[:0]
+		for _, m := range req.Messages {
+			roles = append(roles, m.Role)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"model":"gpt-4o-2024-08-06","choices":[{"message":{"content":"Paris is still the capital."},"finish_reason":"stop"}],"usage":{"prompt_tokens":31,"completion_tokens":7}}`))
+	}))
+	defer ts.Close()
+
+	client := &OpenAIClient{apiKey: "test", baseURL: ts.URL, client: ts.Client()}
+
+	tests := []struct {
+		name      string
+		messages  []Message
+		wantRoles []string
+		wantErr   bool
+	}{
+		{
+			name: "Follow-up question",
+			messages: []Message{
+				{Role: RoleUser, Content: "What is the capital of France?"},
+				{Role: RoleAssistant, Content: "Paris."},
+				{Role: RoleUser, Content: "Are you sure?"},
+			},
+			wantRoles: []string{"system", "user", "assistant", "user"},
+		},
+		{
+			name:     "Unsupported role",
+			messages: []Message{{Role: "system", Content: "Hello"}},
+			wantErr:  true,
+		},
+		{
+			name:     "Empty messages",
+			messages: []Message{},
+			wantErr:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := client.QueryMessages(context.Background(), assistant, tt.messages, "gpt-4o", Options{}, nil)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("OpenAIClient.QueryMessages() error = %v, wantErr %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("OpenAIClient.QueryMessages() error = %v", err)
+			}
+			if got.Text == "" {
+				t.Error("OpenAIClient.QueryMessages() returned empty response")
+			}
+			want := Response{Text: "Paris is still the capital.", Model: "gpt-4o-2024-08-06", FinishReason: "stop", InputTokens: 31, OutputTokens: 7}
+			if !reflect.DeepEqual(*got, want) {
+				t.Errorf("OpenAIClient.QueryMessages() = %+v, want %+v", *got, want)
+			}
+			if len(roles) != len(tt.wantRoles) {
+				t.Fatalf("OpenAIClient.QueryMessages() sent roles %v, want %v", roles, tt.wantRoles)
+			}
+			for i := range roles {
+				if roles[i] != tt.wantRoles[i] {
+					t.Errorf("OpenAIClient.QueryMessages() sent roles %v, want %v", roles, tt.wantRoles)
+					break
+				}
+			}
+		})
+	}
+}
+
+func TestOpenAIClient_Replay(t *testing.T) {
+	add := newAddTool(t).Tool
+	runCassetteTests(t, OpenAI, []cassetteTest{
+		{
+			cassette: "openai_text",
+			model:    "gpt-4o-mini",
+			prompts:  []string{"Say hello"},
+			want:     Response{Text: "Hello! How can I help you today?", Model: "gpt-4o-mini-2024-07-18", FinishReason: "stop", InputTokens: 19, OutputTokens: 10},
+		},
+		{
+			cassette: "openai_stream",
+			model:    "gpt-4o-mini",
+			prompts:  []string{"Say hello"},
+			stream:   true,
+			want:     Response{Text: "Hello! How can I help?", Model: "gpt-4o-mini-2024-07-18", FinishReason: "stop", InputTokens: 19, OutputTokens: 6},
+		},
+		{
+			cassette: "openai_length",
+			model:    "gpt-4o-mini",
+			prompts:  []string{"Write a story about a squirrel"},
+			options:  Options{MaxTokens: 8},
+			want:     Response{Text: "Once upon a time, in a quiet", Model: "gpt-4o-mini-2024-07-18", FinishReason: "length", InputTokens: 22, OutputTokens: 8},
+		},
+		{
+			cassette: "openai_tool_call",
+			model:    "gpt-4o-mini",
+			prompts:  []string{"What is 2 + 3?"},
+			options:  Options{Tools: []Tool{add}},
+			want: Response{Model: "gpt-4o-mini-2024-07-18", FinishReason: "tool_calls", InputTokens: 68, OutputTokens: 18,
+				ToolCalls: []ToolCall{{ID: "call_Qm3xV8sT2bLk", Name: "add", Arguments: json.RawMessage(`{"a":2,"b":3}`)}}},
+		},
+		{
+			cassette: "openai_auth_error",
+			model:    "gpt-4o-mini",
+			prompts:  []string{"Say hello"},
+			apiKey:   "sk-invalid",
+			wantErr:  ErrAuthentication,
+		},
+		{
+			cassette: "openai_model_not_found",
+			model:    "gpt-0-nope",
+			prompts:  []string{"Say hello"},
+			wantErr:  ErrModelNotFound,
+		},
+	})
+}
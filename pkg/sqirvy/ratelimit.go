@@ -0,0 +1,238 @@
+// Package sqirvy provides client side rate limits for providers.
+//
+// This file implements RateLimiter, a set of token buckets that keeps the
+// queries of a process within the requests per minute, tokens per minute and
+// concurrent queries allowed for each provider, and RateLimitedClient, which
+// applies a limiter to any Client. A limiter is safe for concurrent use, so
+// all the clients of a process can share one. Limits may be read from a YAML
+// or JSON file:
+//
+//	openai:
+//	  requests_per_minute: 500
+//	  tokens_per_minute: 30000
+//	  max_concurrent: 8
+//	anthropic:
+//	  requests_per_minute: 50
+package sqirvy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+	"gopkg.in/yaml.v3"
+)
+
+// RateLimit is the limit on the queries sent to a provider.
+// A zero field sets no limit.
+type RateLimit struct {
+	RequestsPerMinute int `json:"requests_per_minute" yaml:"requests_per_minute"` // Queries started per minute
+	TokensPerMinute   int `json:"tokens_per_minute" yaml:"tokens_per_minute"`     // Prompt and response tokens per minute
+	MaxConcurrent     int `json:"max_concurrent" yaml:"max_concurrent"`           // Queries in flight at once
+}
+
+// RateLimiter holds the token buckets of each provider with a limit.
+// Providers without a limit are not throttled.
+type RateLimiter struct {
+	providers map[string]*providerLimiter
+}
+
+// providerLimiter holds the buckets of one provider, nil for a limit that is not set
+type providerLimiter struct {
+	requests *rate.Limiter
+	tokens   *rate.Limiter
+	slots    chan struct{}
+}
+
+// NewRateLimiter creates a limiter for the given limits by provider name.
+// Each bucket starts full and holds one minute of its limit.
+func NewRateLimiter(limits map[string]RateLimit) *RateLimiter {
+	l := &RateLimiter{providers: make(map[string]*providerLimiter)}
+	for provider, limit := range limits {
+		p := &providerLimiter{}
+		if limit.RequestsPerMinute > 0 {
+			p.requests = rate.NewLimiter(perMinute(limit.RequestsPerMinute), limit.RequestsPerMinute)
+		}
+		if limit.TokensPerMinute > 0 {
+			p.tokens = rate.NewLimiter(perMinute(limit.TokensPerMinute), limit.TokensPerMinute)
+		}
+		if limit.MaxConcurrent > 0 {
+			p.slots = make(chan struct{}, limit.MaxConcurrent)
+		}
+		l.providers[provider] = p
+	}
+	return l
+}
+
+// LoadRateLimits reads the limits of each provider from a file.
+// Files ending in .json are decoded as JSON and all others as YAML.
+func LoadRateLimits(path string) (map[string]RateLimit, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read rate limits: %w", err)
+	}
+
+	var limits map[string]RateLimit
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.DisallowUnknownFields()
+		err = decoder.Decode(&limits)
+	} else {
+		decoder := yaml.NewDecoder(bytes.NewReader(data))
+		decoder.KnownFields(true)
+		err = decoder.Decode(&limits)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode rate limits %s: %w", path, err)
+	}
+
+	var errs []error
+	for provider, limit := range limits {
+		if !slices.Contains(Providers(), provider) {
+			errs = append(errs, fmt.Errorf("unknown provider %s", provider))
+		}
+		if limit.RequestsPerMinute < 0 || limit.TokensPerMinute < 0 || limit.MaxConcurrent < 0 {
+			errs = append(errs, fmt.Errorf("provider %s: limits cannot be negative", provider))
+		}
+	}
+	if len(errs) > 0 {
+		return nil, fmt.Errorf("invalid rate limits %s: %w", path, errors.Join(errs...))
+	}
+	return limits, nil
+}
+
+// Wait blocks until a query of about tokens tokens may be sent to provider,
+// or ctx is done. A query larger than the tokens per minute waits for a full
+// bucket. The returned function must be called when the query completes, to
+// free its place among the concurrent queries.
+func (l *RateLimiter) Wait(ctx context.Context, provider string, tokens int) (func(), error) {
+	p, ok := l.providers[provider]
+	if !ok {
+		return func() {}, nil
+	}
+
+	if p.slots != nil {
+		select {
+		case p.slots <- struct{}{}:
+		case <-ctx.Done():
+			return nil, rateLimitError(ctx, provider, ctx.Err())
+		}
+	}
+	release := func() {
+		if p.slots != nil {
+			<-p.slots
+		}
+	}
+
+	if p.requests != nil {
+		if err := p.requests.Wait(ctx); err != nil {
+			release()
+			return nil, rateLimitError(ctx, provider, err)
+		}
+	}
+	if p.tokens != nil && tokens > 0 {
+		if err := p.tokens.WaitN(ctx, min(tokens, p.tokens.Burst())); err != nil {
+			release()
+			return nil, rateLimitError(ctx, provider, err)
+		}
+	}
+	return sync.OnceFunc(release), nil
+}
+
+// Charge takes tokens used beyond the estimate of a query from the bucket of
+// provider, delaying the queries that follow instead of the one that used them.
+func (l *RateLimiter) Charge(provider string, tokens int) {
+	if p, ok := l.providers[provider]; ok && p.tokens != nil && tokens > 0 {
+		p.tokens.ReserveN(time.Now(), min(tokens, p.tokens.Burst()))
+	}
+}
+
+// perMinute converts a limit per minute into a rate.Limit per second
+func perMinute(n int) rate.Limit {
+	return rate.Limit(float64(n) / 60)
+}
+
+// rateLimitError reports a query that could not wait for its turn. A query
+// whose deadline would pass while waiting fails with ErrTimeout.
+func rateLimitError(ctx context.Context, provider string, err error) error {
+	if errors.Is(ctx.Err(), context.Canceled) {
+		return ctx.Err()
+	}
+	return fmt.Errorf("%w: waiting for the %s rate limit: %v", ErrTimeout, provider, err)
+}
+
+// RateLimitedClient implements the Client interface by waiting for the rate
+// limit of a model's provider before passing each query to the client it wraps.
+type RateLimitedClient struct {
+	client  Client
+	limiter *RateLimiter
+	timeout time.Duration // default Options.Timeout of the wrapped client
+}
+
+// Ensure RateLimitedClient implements the Client interface
+var _ Client = (*RateLimitedClient)(nil)
+
+// NewRateLimitedClient wraps client so its queries wait for limiter.
+// Several clients may share a limiter to keep a whole process within the limits.
+func NewRateLimitedClient(client Client, limiter *RateLimiter) *RateLimitedClient {
+	return &RateLimitedClient{client: client, limiter: limiter}
+}
+
+// QueryText sends a text query once the rate limit allows it.
+func (c *RateLimitedClient) QueryText(ctx context.Context, system string, prompts []string, model string, options Options) (string, error) {
+	return responseText(c.QueryMessages(ctx, system, UserMessages(prompts), model, options, nil))
+}
+
+// QueryStream sends a streaming text query once the rate limit allows it.
+func (c *RateLimitedClient) QueryStream(ctx context.Context, system string, prompts []string, model string, options Options, stream StreamFunc) (string, error) {
+	return responseText(c.QueryMessages(ctx, system, UserMessages(prompts), model, options, stream))
+}
+
+// QueryMessages waits until the provider of model may receive a query of the
+// estimated size of the prompt, then sends the conversation to the wrapped
+// client. The wait counts against Options.Timeout, or the default timeout of
+// a client created by NewClient, and the query is given the time left. Tokens
+// the response used beyond the estimate are charged to the limit afterwards.
+// Retries made by the wrapped client are not limited.
+func (c *RateLimitedClient) QueryMessages(ctx context.Context, system string, messages []Message, model string, options Options, stream StreamFunc) (*Response, error) {
+	provider, err := GetProviderName(model)
+	if err != nil {
+		return c.client.QueryMessages(ctx, system, messages, model, options, stream)
+	}
+
+	estimate := estimateTokens(provider, system, messages)
+	ctx, cancel := queryContext(ctx, options, c.timeout)
+	defer cancel()
+	release, err := c.limiter.Wait(ctx, provider, estimate)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	if deadline, ok := ctx.Deadline(); ok {
+		left := time.Until(deadline)
+		if left <= 0 {
+			return nil, fmt.Errorf("%w: no time left after waiting for the %s rate limit", ErrTimeout, provider)
+		}
+		options.Timeout = left
+	}
+
+	response, err := c.client.QueryMessages(ctx, system, messages, model, options, stream)
+	if err == nil {
+		c.limiter.Charge(provider, int(response.InputTokens+response.OutputTokens)-estimate)
+	}
+	return response, err
+}
+
+// Close closes the wrapped client.
+func (c *RateLimitedClient) Close() error {
+	return c.client.Close()
+}
@@ -0,0 +1,177 @@
+package sqirvy
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// waitBriefly waits for the limiter with a short deadline
+func waitBriefly(l *RateLimiter, provider string, tokens int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	release, err := l.Wait(ctx, provider, tokens)
+	if err == nil {
+		release()
+	}
+	return err
+}
+
+func TestRateLimiter(t *testing.T) {
+	limiter := NewRateLimiter(map[string]RateLimit{
+		"openai":    {RequestsPerMinute: 1},
+		"anthropic": {TokensPerMinute: 100},
+	})
+
+	// the bucket of a provider starts full, then waits a minute per request
+	if err := waitBriefly(limiter, "openai", 0); err != nil {
+		t.Fatalf("Wait() error = %v", err)
+	}
+	if err := waitBriefly(limiter, "openai", 0); !errors.Is(err, ErrTimeout) {
+		t.Errorf("Wait() over the request limit error = %v, want ErrTimeout", err)
+	}
+
+	// tokens are taken by the estimate and charged for what a query used beyond it
+	if err := waitBriefly(limiter, "anthropic", 10); err != nil {
+		t.Fatalf("Wait() error = %v", err)
+	}
+	limiter.Charge("anthropic", 90)
+	if err := waitBriefly(limiter, "anthropic", 10); !errors.Is(err, ErrTimeout) {
+		t.Errorf("Wait() over the token limit error = %v, want ErrTimeout", err)
+	}
+
+	// providers without a limit are not throttled
+	for range 3 {
+		if err := waitBriefly(limiter, "gemini", 1000); err != nil {
+			t.Errorf("Wait() without a limit error = %v", err)
+		}
+	}
+
+	// a cancelled query is not reported as a timeout
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := limiter.Wait(ctx, "openai", 0); !errors.Is(err, context.Canceled) || errors.Is(err, ErrTimeout) {
+		t.Errorf("Wait() with a cancelled context error = %v, want context.Canceled", err)
+	}
+}
+
+func TestRateLimiter_Concurrent(t *testing.T) {
+	limiter := NewRateLimiter(map[string]RateLimit{"openai": {MaxConcurrent: 1}})
+
+	release, err := limiter.Wait(context.Background(), "openai", 0)
+	if err != nil {
+		t.Fatalf("Wait() error = %v", err)
+	}
+	if err := waitBriefly(limiter, "openai", 0); !errors.Is(err, ErrTimeout) {
+		t.Errorf("Wait() with a query in flight error = %v, want ErrTimeout", err)
+	}
+	release()
+	release() // releasing twice frees a single place
+	if err := waitBriefly(limiter, "openai", 0); err != nil {
+		t.Errorf("Wait() after release error = %v", err)
+	}
+}
+
+func TestRateLimitedClient(t *testing.T) {
+	limiter := NewRateLimiter(map[string]RateLimit{"mock": {RequestsPerMinute: 1}})
+	mock, _ := NewMockClient()
+	client := NewRateLimitedClient(mock, limiter)
+	defer client.Close()
+
+	if got, err := client.QueryText(context.Background(), "", []string{"hello"}, MockEchoModel, Options{}); err != nil || got != "hello" {
+		t.Fatalf("QueryText() = %q, %v", got, err)
+	}
+
+	// clients that share the limiter share its buckets
+	other, err := NewClient("mock", WithRateLimiter(limiter))
+	if err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+	if _, ok := other.(*RateLimitedClient); !ok {
+		t.Fatalf("NewClient() with a rate limiter = %T, want *RateLimitedClient", other)
+	}
+	_, err = other.QueryText(context.Background(), "", []string{"hello"}, MockEchoModel, Options{Timeout: 20 * time.Millisecond})
+	if !errors.Is(err, ErrTimeout) {
+		t.Errorf("QueryText() over the limit error = %v, want ErrTimeout", err)
+	}
+}
+
+func TestRateLimitedClient_Deadline(t *testing.T) {
+	limiter := NewRateLimiter(map[string]RateLimit{"mock": {MaxConcurrent: 1}})
+	client, err := NewClient("mock", WithRateLimiter(limiter), WithTimeout(150*time.Millisecond))
+	if err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+	defer client.Close()
+	client.(*RateLimitedClient).client.(*MockClient).SetScript(MockScript{
+		Responses: []MockResponse{{Match: "hello", Text: "hi", Delay: 100 * time.Millisecond}},
+	})
+
+	// hold the only place for 100ms, so the wait and the query together
+	// take longer than the client's timeout
+	release, err := limiter.Wait(context.Background(), "mock", 0)
+	if err != nil {
+		t.Fatalf("Wait() error = %v", err)
+	}
+	time.AfterFunc(100*time.Millisecond, release)
+
+	start := time.Now()
+	_, err = client.QueryText(context.Background(), "", []string{"hello"}, MockScriptModel, Options{})
+	if err == nil {
+		t.Fatalf("QueryText() succeeded after %v, want the wait to count against the timeout", time.Since(start))
+	}
+	if elapsed := time.Since(start); elapsed > 250*time.Millisecond {
+		t.Errorf("QueryText() took %v, want about the 150ms timeout", elapsed)
+	}
+
+	// a query that fits in the time left succeeds
+	if got, err := client.QueryText(context.Background(), "", []string{"hello"}, MockScriptModel, Options{Timeout: time.Second}); err != nil || got != "hi" {
+		t.Errorf("QueryText() = %q, %v", got, err)
+	}
+}
+
+func TestLoadRateLimits(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		name    string
+		file    string
+		data    string
+		want    RateLimit
+		wantErr bool
+	}{
+		{
+			name: "YAML",
+			file: "limits.yaml",
+			data: "openai:\n  requests_per_minute: 500\n  tokens_per_minute: 30000\n  max_concurrent: 8\n",
+			want: RateLimit{RequestsPerMinute: 500, TokensPerMinute: 30000, MaxConcurrent: 8},
+		},
+		{
+			name: "JSON",
+			file: "limits.json",
+			data: `{"openai": {"requests_per_minute": 60}}`,
+			want: RateLimit{RequestsPerMinute: 60},
+		},
+		{name: "Unknown provider", file: "unknown.yaml", data: "nosuch:\n  requests_per_minute: 1\n", wantErr: true},
+		{name: "Negative limit", file: "negative.yaml", data: "openai:\n  max_concurrent: -1\n", wantErr: true},
+		{name: "Unknown field", file: "field.yaml", data: "openai:\n  rpm: 1\n", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(dir, tt.file)
+			if err := os.WriteFile(path, []byte(tt.data), 0644); err != nil {
+				t.Fatal(err)
+			}
+			limits, err := LoadRateLimits(path)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("LoadRateLimits() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && limits["openai"] != tt.want {
+				t.Errorf("LoadRateLimits() = %+v, want %+v", limits["openai"], tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,192 @@
+// Package sqirvy provides a registry of providers and models.
+//
+// This file implements the registry that NewClient and the model lookup
+// functions use. Each provider registers a factory for its client, and the
+// models it serves with their metadata. The built-in providers register
+// themselves when the package is loaded, and other code can register its
+// own providers and models the same way without changing this package.
+package sqirvy
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// ProviderFactory creates a client for a provider with the given options.
+// Factories can read the options with NewClientConfig.
+type ProviderFactory func(opts ...ClientOption) (Client, error)
+
+// Capabilities lists the optional features a model supports.
+type Capabilities struct {
+	Streaming bool `json:"streaming" yaml:"streaming"` // Responses can be streamed with QueryStream
+	JSON      bool `json:"json" yaml:"json"`           // Structured output with QueryJSON
+	Tools     bool `json:"tools" yaml:"tools"`         // Tool calling with Options.Tools
+}
+
+// ModelInfo describes a model served by a registered provider.
+type ModelInfo struct {
+	Name          string       `json:"name"`            // Model identifier sent to the provider
+	Provider      string       `json:"provider"`        // Name of the provider that serves the model
+	Aliases       []string     `json:"aliases"`         // Other names the model can be selected by
+	MaxTokens     int64        `json:"max_tokens"`      // Maximum tokens in a response, zero for MaxTokensDefault
+	ContextWindow int64        `json:"context_window"`  // Maximum tokens in the prompt and response, zero if unknown
+	Price         *ModelPrice  `json:"price,omitempty"` // Price per million tokens, nil if unknown
+	Capabilities  Capabilities `json:"capabilities"`    // Optional features the model supports
+}
+
+// registry holds the registered providers and models
+type registry struct {
+	mu        sync.RWMutex
+	providers map[string]ProviderFactory
+	models    map[string]ModelInfo
+	aliases   map[string]string // alias to model name
+}
+
+var defaultRegistry = &registry{
+	providers: map[string]ProviderFactory{},
+	models:    map[string]ModelInfo{},
+	aliases:   map[string]string{},
+}
+
+// RegisterProvider adds a provider and the factory that creates its clients.
+// Returns an error if a provider with the same name is already registered.
+func RegisterProvider(name string, factory ProviderFactory) error {
+	if name == "" || factory == nil {
+		return fmt.Errorf("provider name and factory are required")
+	}
+
+	r := defaultRegistry
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.providers[name]; ok {
+		return fmt.Errorf("provider %s is already registered", name)
+	}
+	r.providers[name] = factory
+	return nil
+}
+
+// RegisterModel adds a model served by a registered provider, replacing the
+// metadata of a model that is already registered with the same name.
+// Returns an error if the provider is unknown or an alias is already taken
+// by another model.
+func RegisterModel(model ModelInfo) error {
+	r := defaultRegistry
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.addModel(model)
+}
+
+// addModel adds or replaces a model, the caller must hold the write lock
+func (r *registry) addModel(model ModelInfo) error {
+	if model.Name == "" {
+		return fmt.Errorf("model name is required")
+	}
+	if _, ok := r.providers[model.Provider]; !ok {
+		return fmt.Errorf("model %s: unsupported provider: %s", model.Name, model.Provider)
+	}
+	if target, ok := r.aliases[model.Name]; ok {
+		return fmt.Errorf("model %s is already an alias of %s", model.Name, target)
+	}
+	for _, alias := range model.Aliases {
+		if target, ok := r.aliases[alias]; ok && target != model.Name {
+			return fmt.Errorf("model %s: alias %s is already used by %s", model.Name, alias, target)
+		}
+		if _, ok := r.models[alias]; ok {
+			return fmt.Errorf("model %s: alias %s is the name of a model", model.Name, alias)
+		}
+	}
+
+	// drop the aliases of the model being replaced
+	if old, ok := r.models[model.Name]; ok {
+		for _, alias := range old.Aliases {
+			delete(r.aliases, alias)
+		}
+	}
+	model.Aliases = append([]string(nil), model.Aliases...)
+	r.models[model.Name] = model
+	for _, alias := range model.Aliases {
+		r.aliases[alias] = model.Name
+	}
+	return nil
+}
+
+// mustRegister registers a built-in provider and its models, panicking on
+// a conflict since that is a programming error in this package
+func mustRegister(name string, factory ProviderFactory, models []ModelInfo) {
+	if err := RegisterProvider(name, factory); err != nil {
+		panic(err)
+	}
+	for _, model := range models {
+		model.Provider = name
+		if err := RegisterModel(model); err != nil {
+			panic(err)
+		}
+	}
+}
+
+// Providers returns the names of the registered providers in sorted order.
+func Providers() []string {
+	r := defaultRegistry
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	names := make([]string, 0, len(r.providers))
+	for name := range r.providers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Models returns the registered models sorted by name.
+func Models() []ModelInfo {
+	r := defaultRegistry
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	models := make([]ModelInfo, 0, len(r.models))
+	for _, model := range r.models {
+		models = append(models, model)
+	}
+	sort.Slice(models, func(i, j int) bool { return models[i].Name < models[j].Name })
+	return models
+}
+
+// LookupModel returns the metadata of a registered model by name or alias.
+func LookupModel(name string) (ModelInfo, bool) {
+	r := defaultRegistry
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if target, ok := r.aliases[name]; ok {
+		name = target
+	}
+	model, ok := r.models[name]
+	return model, ok
+}
+
+// NewClient creates a new AI client for the specified provider.
+// The options are passed to the provider's constructor, which falls back to
+// environment variables for the API key and base URL if they are not set.
+// A client created with WithRateLimiter is wrapped in a RateLimitedClient.
+func NewClient(provider string, opts ...ClientOption) (Client, error) {
+	r := defaultRegistry
+	r.mu.RLock()
+	factory, ok := r.providers[provider]
+	r.mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unsupported provider: %s", provider)
+	}
+
+	client, err := factory(opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create %s client: %w", provider, err)
+	}
+	if config := NewClientConfig(opts...); config.Limiter != nil {
+		limited := NewRateLimitedClient(client, config.Limiter)
+		limited.timeout = config.Timeout
+		return limited, nil
+	}
+	return client, nil
+}
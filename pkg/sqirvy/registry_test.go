@@ -0,0 +1,98 @@
+package sqirvy
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestBuiltinProviders(t *testing.T) {
+	providers := Providers()
+	for _, name := range []string{Anthropic, DeepSeek, Gemini, OpenAI, Llama, Mock} {
+		if !slices.Contains(providers, name) {
+			t.Errorf("Providers() = %v, missing %s", providers, name)
+		}
+	}
+
+	if got := GetModelAlias("claude-3-5-haiku"); got != "claude-3-5-haiku-latest" {
+		t.Errorf("GetModelAlias(claude-3-5-haiku) = %s, want claude-3-5-haiku-latest", got)
+	}
+	if got := GetModelAlias("gpt-4o"); got != "gpt-4o" {
+		t.Errorf("GetModelAlias(gpt-4o) = %s, want gpt-4o", got)
+	}
+	if provider, err := GetProviderName("gemini-2.0-flash"); err != nil || provider != Gemini {
+		t.Errorf("GetProviderName(gemini-2.0-flash) = %s, %v", provider, err)
+	}
+	if got := GetMaxTokens("claude-3-opus-latest"); got != 4096 {
+		t.Errorf("GetMaxTokens(claude-3-opus-latest) = %d, want 4096", got)
+	}
+	if _, err := NewClient("no-such-provider"); err == nil {
+		t.Errorf("NewClient() should fail for an unknown provider")
+	}
+}
+
+func TestRegisterProvider(t *testing.T) {
+	const provider = "test-local"
+	factory := func(opts ...ClientOption) (Client, error) { return &OpenAIClient{}, nil }
+	if err := RegisterProvider(provider, factory); err != nil {
+		t.Fatalf("RegisterProvider() error = %v", err)
+	}
+	if err := RegisterProvider(provider, factory); err == nil {
+		t.Errorf("RegisterProvider() should reject a duplicate provider")
+	}
+	if err := RegisterProvider(OpenAI, factory); err == nil {
+		t.Errorf("RegisterProvider() should reject a built-in provider name")
+	}
+
+	client, err := NewClient(provider)
+	if err != nil || client == nil {
+		t.Fatalf("NewClient(%s) = %v, %v", provider, client, err)
+	}
+
+	model := ModelInfo{Name: "test-local-7b", Provider: provider, Aliases: []string{"test-local-small"}}
+	if err := RegisterModel(model); err != nil {
+		t.Fatalf("RegisterModel() error = %v", err)
+	}
+	if got, err := GetProviderName("test-local-small"); err != nil || got != provider {
+		t.Errorf("GetProviderName() by alias = %s, %v", got, err)
+	}
+	if got := GetMaxTokens(model.Name); got != MaxTokensDefault {
+		t.Errorf("GetMaxTokens() = %d, want MaxTokensDefault", got)
+	}
+	if _, ok := GetCost(model.Name, 1000, 1000); ok {
+		t.Errorf("GetCost() should not have a price for %s", model.Name)
+	}
+	if !slices.Contains(GetModelList(), model.Name) {
+		t.Errorf("GetModelList() does not include %s", model.Name)
+	}
+
+	// replacing a model updates its metadata and aliases
+	model.Aliases = []string{"test-local-7"}
+	model.Price = &ModelPrice{Input: 1, Output: 2}
+	if err := RegisterModel(model); err != nil {
+		t.Fatalf("RegisterModel() replacing a model error = %v", err)
+	}
+	if _, ok := LookupModel("test-local-small"); ok {
+		t.Errorf("LookupModel() found an alias that was replaced")
+	}
+	if cost, ok := GetCost("test-local-7", 1e6, 1e6); !ok || cost != 3 {
+		t.Errorf("GetCost() = %v, %v, want 3, true", cost, ok)
+	}
+
+	tests := []struct {
+		name  string
+		model ModelInfo
+	}{
+		{name: "Unknown provider", model: ModelInfo{Name: "test-other", Provider: "test-missing"}},
+		{name: "Alias of another model", model: ModelInfo{Name: "test-other", Provider: provider, Aliases: []string{"claude-3-5-haiku"}}},
+		{name: "Alias is a model name", model: ModelInfo{Name: "test-other", Provider: provider, Aliases: []string{"gpt-4o"}}},
+		{name: "Name is an alias", model: ModelInfo{Name: "claude-3-5-haiku", Provider: provider}},
+		{name: "No name", model: ModelInfo{Provider: provider}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := RegisterModel(tt.model); err == nil {
+				t.Errorf("RegisterModel(%+v) should fail", tt.model)
+			}
+		})
+	}
+}
@@ -0,0 +1,204 @@
+// Package sqirvy provides retry support for provider requests.
+//
+// This file implements the retry policy shared by all providers. Failed requests
+// are classified as retryable (rate limits, overloaded or unavailable servers,
+// timeouts and dropped connections) or permanent, and retryable requests are
+// repeated with jittered exponential backoff, honoring any Retry-After delay
+// requested by the provider.
+package sqirvy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"regexp"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/anthropics/anthropic-sdk-go"
+)
+
+// RetryPolicy controls how failed provider requests are retried.
+// The zero value uses DefaultRetryPolicy.
+type RetryPolicy struct {
+	MaxAttempts int           // Total attempts including the first, 1 disables retries
+	BaseDelay   time.Duration // Backoff before the first retry, doubled for each retry after it
+	MaxDelay    time.Duration // Upper limit on any single delay, including Retry-After
+}
+
+// DefaultRetryPolicy is used when Options.Retry is not set
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts: 4,
+	BaseDelay:   time.Second,
+	MaxDelay:    time.Minute,
+}
+
+// StatusError is returned when a provider API responds with an unsuccessful HTTP status.
+type StatusError struct {
+	StatusCode int           // HTTP status code
+	RetryAfter time.Duration // Delay requested by the Retry-After header, zero if not set
+	Body       string        // Response body
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("API request failed with status %d: %s", e.StatusCode, e.Body)
+}
+
+// newStatusError creates a StatusError from an unsuccessful HTTP response and its body
+func newStatusError(resp *http.Response, body []byte) *StatusError {
+	return &StatusError{
+		StatusCode: resp.StatusCode,
+		RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After")),
+		Body:       string(body),
+	}
+}
+
+// parseRetryAfter parses a Retry-After header given either in seconds or as an HTTP date.
+// Returns zero if the header is empty or invalid.
+func parseRetryAfter(header string) time.Duration {
+	if header == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(header); err == nil {
+		return max(time.Duration(seconds)*time.Second, 0)
+	}
+	if date, err := http.ParseTime(header); err == nil {
+		return max(time.Until(date), 0)
+	}
+	return 0
+}
+
+// retryableStatus reports whether an HTTP status indicates a transient failure
+func retryableStatus(code int) bool {
+	switch code {
+	case http.StatusRequestTimeout,
+		http.StatusConflict,
+		http.StatusTooEarly,
+		http.StatusTooManyRequests,
+		http.StatusInternalServerError,
+		http.StatusBadGateway,
+		http.StatusServiceUnavailable,
+		http.StatusGatewayTimeout,
+		529: // Anthropic overloaded
+		return true
+	}
+	return false
+}
+
+// langchaingo only reports the status code in its error message
+var statusCodePattern = regexp.MustCompile(`status code: (\d{3})`)
+
+// errorStatus returns the HTTP status and requested retry delay of a provider error.
+// Returns a zero status if the error does not carry one.
+func errorStatus(err error) (int, time.Duration) {
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.StatusCode, statusErr.RetryAfter
+	}
+
+	var anthropicErr *anthropic.Error
+	if errors.As(err, &anthropicErr) {
+		var retryAfter time.Duration
+		if anthropicErr.Response != nil {
+			retryAfter = parseRetryAfter(anthropicErr.Response.Header.Get("Retry-After"))
+		}
+		return anthropicErr.StatusCode, retryAfter
+	}
+
+	// Google API errors
+	var httpErr interface{ HTTPCode() int }
+	if errors.As(err, &httpErr) && httpErr.HTTPCode() > 0 {
+		return httpErr.HTTPCode(), 0
+	}
+
+	if match := statusCodePattern.FindStringSubmatch(err.Error()); match != nil {
+		code, _ := strconv.Atoi(match[1])
+		return code, 0
+	}
+
+	return 0, 0
+}
+
+// isRetryable reports whether a failed request may succeed if it is repeated
+func isRetryable(err error) bool {
+	if errors.Is(err, context.Canceled) {
+		return false
+	}
+
+	if code, _ := errorStatus(err); code != 0 {
+		return retryableStatus(code)
+	}
+
+	// timeouts and dropped connections
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	return errors.Is(err, context.DeadlineExceeded) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.ECONNREFUSED)
+}
+
+// retryDelay returns how long to wait before retry number attempt (starting at 0).
+// A Retry-After delay requested by the provider takes precedence over the backoff.
+// Returns false if the requested delay is longer than the policy allows.
+func (p RetryPolicy) retryDelay(attempt int, err error) (time.Duration, bool) {
+	if _, retryAfter := errorStatus(err); retryAfter > 0 {
+		return retryAfter, retryAfter <= p.MaxDelay
+	}
+
+	// exponential backoff with jitter in the upper half of the interval
+	delay := p.BaseDelay << attempt
+	if delay <= 0 || delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+	half := delay / 2
+	return half + rand.N(delay-half+1), true
+}
+
+// withRetry calls fn until it succeeds, fails with an error that is not retryable,
+// or the policy's attempts are used up. If stream is not nil it is passed to fn,
+// and a request is not retried once any of its chunks have been delivered.
+func withRetry(ctx context.Context, policy RetryPolicy, stream StreamFunc, fn func(stream StreamFunc) (*Response, error)) (*Response, error) {
+	if policy == (RetryPolicy{}) {
+		policy = DefaultRetryPolicy
+	}
+
+	var started bool
+	if stream != nil {
+		next := stream
+		stream = func(chunk string) error {
+			started = true
+			return next(chunk)
+		}
+	}
+
+	for attempt := 0; ; attempt++ {
+		resp, err := fn(stream)
+		if err == nil {
+			return resp, nil
+		}
+		if started || attempt+1 >= policy.MaxAttempts || ctx.Err() != nil || !isRetryable(err) {
+			return nil, err
+		}
+
+		delay, ok := policy.retryDelay(attempt, err)
+		if !ok {
+			return nil, err
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, err
+		case <-timer.C:
+		}
+	}
+}
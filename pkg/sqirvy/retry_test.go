@@ -0,0 +1,212 @@
+package sqirvy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// testRetryPolicy keeps retry tests fast
+var testRetryPolicy = RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: 10 * time.Millisecond}
+
+// newRetryServer returns a server that fails with the given statuses in order
+// and then responds successfully, along with a counter of the requests it received
+func newRetryServer(t *testing.T, header http.Header, statuses ...int) (*httptest.Server, *atomic.Int32) {
+	t.Helper()
+	var requests atomic.Int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := int(requests.Add(1))
+		if n <= len(statuses) {
+			for k, v := range header {
+				w.Header()[k] = v
+			}
+			http.Error(w, `{"error":{"message":"try again"}}`, statuses[n-1])
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"choices":[{"message":{"content":"Hello, World!"}}]}`)
+	}))
+	t.Cleanup(ts.Close)
+	return ts, &requests
+}
+
+func TestOpenAIClient_Retry(t *testing.T) {
+	tests := []struct {
+		name         string
+		statuses     []int
+		header       http.Header
+		policy       RetryPolicy
+		wantErr      bool
+		wantRequests int32
+	}{
+		{
+			name:         "Success",
+			policy:       testRetryPolicy,
+			wantRequests: 1,
+		},
+		{
+			name:         "Rate limited then success",
+			statuses:     []int{http.StatusTooManyRequests},
+			header:       http.Header{"Retry-After": []string{"0"}},
+			policy:       testRetryPolicy,
+			wantRequests: 2,
+		},
+		{
+			name:         "Unavailable twice then success",
+			statuses:     []int{http.StatusServiceUnavailable, http.StatusBadGateway},
+			policy:       testRetryPolicy,
+			wantRequests: 3,
+		},
+		{
+			name:         "Attempts exhausted",
+			statuses:     []int{503, 503, 503},
+			policy:       testRetryPolicy,
+			wantErr:      true,
+			wantRequests: 3,
+		},
+		{
+			name:         "Bad request is not retried",
+			statuses:     []int{http.StatusBadRequest},
+			policy:       testRetryPolicy,
+			wantErr:      true,
+			wantRequests: 1,
+		},
+		{
+			name:         "Retry-After longer than max delay",
+			statuses:     []int{http.StatusTooManyRequests},
+			header:       http.Header{"Retry-After": []string{"60"}},
+			policy:       testRetryPolicy,
+			wantErr:      true,
+			wantRequests: 1,
+		},
+		{
+			name:         "Retries disabled",
+			statuses:     []int{http.StatusServiceUnavailable},
+			policy:       RetryPolicy{MaxAttempts: 1},
+			wantErr:      true,
+			wantRequests: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts, requests := newRetryServer(t, tt.header, tt.statuses...)
+			client := &OpenAIClient{apiKey: "test", baseURL: ts.URL, client: ts.Client()}
+
+			got, err := client.QueryText(context.Background(), assistant, []string{"Say 'Hello, World!'"}, "gpt-4o", Options{Retry: tt.policy})
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("OpenAIClient.QueryText() error = %v, wantErr %v", err, tt.wantErr)
+				}
+			} else if err != nil || got != "Hello, World!" {
+				t.Errorf("OpenAIClient.QueryText() = %q, %v", got, err)
+			}
+			if requests.Load() != tt.wantRequests {
+				t.Errorf("server received %d requests, want %d", requests.Load(), tt.wantRequests)
+			}
+		})
+	}
+}
+
+func TestWithRetry_Stream(t *testing.T) {
+	// a stream that fails after delivering a chunk must not be retried
+	var calls int
+	_, err := withRetry(context.Background(), testRetryPolicy, func(string) error { return nil }, func(stream StreamFunc) (*Response, error) {
+		calls++
+		stream("partial")
+		return nil, &StatusError{StatusCode: http.StatusServiceUnavailable}
+	})
+	if err == nil || calls != 1 {
+		t.Errorf("withRetry() error = %v after %d calls, want an error after 1 call", err, calls)
+	}
+
+	// a stream that fails before any chunk is retried
+	calls = 0
+	_, err = withRetry(context.Background(), testRetryPolicy, func(string) error { return nil }, func(stream StreamFunc) (*Response, error) {
+		calls++
+		if calls == 1 {
+			return nil, &StatusError{StatusCode: http.StatusServiceUnavailable}
+		}
+		stream("complete")
+		return &Response{Text: "complete"}, nil
+	})
+	if err != nil || calls != 2 {
+		t.Errorf("withRetry() error = %v after %d calls, want success after 2 calls", err, calls)
+	}
+}
+
+func TestWithRetry_ContextCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	policy := RetryPolicy{MaxAttempts: 5, BaseDelay: time.Hour, MaxDelay: time.Hour}
+
+	var calls int
+	go cancel()
+	_, err := withRetry(ctx, policy, nil, func(StreamFunc) (*Response, error) {
+		calls++
+		return nil, &StatusError{StatusCode: http.StatusServiceUnavailable}
+	})
+	if err == nil || calls != 1 {
+		t.Errorf("withRetry() error = %v after %d calls, want an error after 1 call", err, calls)
+	}
+}
+
+func TestIsRetryable(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{name: "Rate limited", err: &StatusError{StatusCode: 429}, want: true},
+		{name: "Overloaded", err: fmt.Errorf("wrapped: %w", &StatusError{StatusCode: 529}), want: true},
+		{name: "Unauthorized", err: &StatusError{StatusCode: 401}, want: false},
+		{name: "Langchaingo status", err: errors.New("API returned unexpected status code: 503: busy"), want: true},
+		{name: "Deadline exceeded", err: fmt.Errorf("failed to make request: %w", context.DeadlineExceeded), want: true},
+		{name: "Canceled", err: fmt.Errorf("failed to make request: %w", context.Canceled), want: false},
+		{name: "Other error", err: errors.New("no content in response"), want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isRetryable(tt.err); got != tt.want {
+				t.Errorf("isRetryable(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	if got := parseRetryAfter("3"); got != 3*time.Second {
+		t.Errorf("parseRetryAfter(\"3\") = %v, want 3s", got)
+	}
+	if got := parseRetryAfter(""); got != 0 {
+		t.Errorf("parseRetryAfter(\"\") = %v, want 0", got)
+	}
+	if got := parseRetryAfter("soon"); got != 0 {
+		t.Errorf("parseRetryAfter(\"soon\") = %v, want 0", got)
+	}
+	date := time.Now().Add(time.Minute).UTC().Format(http.TimeFormat)
+	if got := parseRetryAfter(date); got <= 0 || got > time.Minute {
+		t.Errorf("parseRetryAfter(%q) = %v, want up to 1m", date, got)
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	policy := RetryPolicy{MaxAttempts: 10, BaseDelay: 100 * time.Millisecond, MaxDelay: time.Second}
+	for attempt := 0; attempt < 8; attempt++ {
+		want := min(policy.BaseDelay<<attempt, policy.MaxDelay)
+		got, ok := policy.retryDelay(attempt, errors.New("failed"))
+		if !ok || got < want/2 || got > want {
+			t.Errorf("retryDelay(%d) = %v, want between %v and %v", attempt, got, want/2, want)
+		}
+	}
+
+	got, ok := policy.retryDelay(0, &StatusError{StatusCode: 429, RetryAfter: 500 * time.Millisecond})
+	if !ok || got != 500*time.Millisecond {
+		t.Errorf("retryDelay() with Retry-After = %v, %v, want 500ms, true", got, ok)
+	}
+}
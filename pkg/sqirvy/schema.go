@@ -0,0 +1,311 @@
+// Package sqirvy provides JSON Schema support for structured output.
+//
+// This file derives JSON Schemas from Go types and validates JSON documents
+// against them. Only the parts of JSON Schema that providers accept for
+// structured output are supported: type, properties, required, items, enum,
+// additionalProperties and description. Other keywords are passed through to
+// the provider but are not checked here.
+package sqirvy
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"slices"
+	"strings"
+	"time"
+)
+
+// schemaNode is the subset of a JSON Schema used for validation and provider conversion
+type schemaNode struct {
+	Type                 schemaType             `json:"type,omitempty"`
+	Description          string                 `json:"description,omitempty"`
+	Format               string                 `json:"format,omitempty"`
+	Enum                 []any                  `json:"enum,omitempty"`
+	Properties           map[string]*schemaNode `json:"properties,omitempty"`
+	Required             []string               `json:"required,omitempty"`
+	Items                *schemaNode            `json:"items,omitempty"`
+	AdditionalProperties json.RawMessage        `json:"additionalProperties,omitempty"`
+}
+
+// schemaType is the "type" keyword, which may be a single type or a list of types
+type schemaType []string
+
+func (t *schemaType) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*t = schemaType{single}
+		return nil
+	}
+	var list []string
+	if err := json.Unmarshal(data, &list); err != nil {
+		return fmt.Errorf("schema type must be a string or a list of strings")
+	}
+	*t = list
+	return nil
+}
+
+func (t schemaType) MarshalJSON() ([]byte, error) {
+	if len(t) == 1 {
+		return json.Marshal(t[0])
+	}
+	return json.Marshal([]string(t))
+}
+
+// parseSchema decodes a JSON Schema. An empty schema accepts any JSON document.
+func parseSchema(schema json.RawMessage) (*schemaNode, error) {
+	node := &schemaNode{}
+	if len(bytes.TrimSpace(schema)) == 0 {
+		return node, nil
+	}
+	if err := json.Unmarshal(schema, node); err != nil {
+		return nil, fmt.Errorf("invalid JSON schema: %w", err)
+	}
+	return node, nil
+}
+
+// validate checks a decoded JSON value against the schema, using path in error messages
+func (n *schemaNode) validate(value any, path string) error {
+	if len(n.Type) > 0 && !slices.ContainsFunc(n.Type, func(t string) bool { return jsonTypeMatches(t, value) }) {
+		return fmt.Errorf("%s: expected %s, got %s", path, strings.Join(n.Type, " or "), jsonTypeName(value))
+	}
+
+	if len(n.Enum) > 0 && !slices.ContainsFunc(n.Enum, func(e any) bool { return reflect.DeepEqual(e, value) }) {
+		return fmt.Errorf("%s: %v is not one of the allowed values %v", path, value, n.Enum)
+	}
+
+	switch v := value.(type) {
+	case map[string]any:
+		for _, name := range n.Required {
+			if _, ok := v[name]; !ok {
+				return fmt.Errorf("%s: missing required property %q", path, name)
+			}
+		}
+		for name, field := range v {
+			if prop, ok := n.Properties[name]; ok {
+				if err := prop.validate(field, path+"."+name); err != nil {
+					return err
+				}
+				continue
+			}
+			if err := n.validateAdditional(field, path+"."+name); err != nil {
+				return err
+			}
+		}
+	case []any:
+		if n.Items != nil {
+			for i, item := range v {
+				if err := n.Items.validate(item, fmt.Sprintf("%s[%d]", path, i)); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// validateAdditional checks a property that is not listed in the schema's properties
+func (n *schemaNode) validateAdditional(value any, path string) error {
+	additional := bytes.TrimSpace(n.AdditionalProperties)
+	switch {
+	case len(additional) == 0, bytes.Equal(additional, []byte("true")):
+		return nil
+	case bytes.Equal(additional, []byte("false")):
+		return fmt.Errorf("%s: property is not allowed", path)
+	}
+	node, err := parseSchema(additional)
+	if err != nil {
+		return err
+	}
+	return node.validate(value, path)
+}
+
+// jsonTypeMatches reports whether a decoded JSON value has the given schema type
+func jsonTypeMatches(schemaType string, value any) bool {
+	switch schemaType {
+	case "integer":
+		f, ok := value.(float64)
+		return ok && f == math.Trunc(f)
+	case "number":
+		_, ok := value.(float64)
+		return ok
+	}
+	return jsonTypeName(value) == schemaType
+}
+
+// jsonTypeName returns the schema type name of a decoded JSON value
+func jsonTypeName(value any) string {
+	switch value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64:
+		return "number"
+	case string:
+		return "string"
+	case []any:
+		return "array"
+	case map[string]any:
+		return "object"
+	}
+	return fmt.Sprintf("%T", value)
+}
+
+var (
+	timeType       = reflect.TypeFor[time.Time]()
+	rawMessageType = reflect.TypeFor[json.RawMessage]()
+)
+
+// SchemaFor derives a JSON Schema from the type of v, which is usually a pointer to a struct.
+//
+// Exported struct fields become properties named by their json tag. Fields are
+// required unless they are tagged omitempty, a `description` tag is copied
+// into the schema, and an `enum` tag lists the allowed values of a string
+// field, separated by commas. Recursive types are not supported.
+func SchemaFor(v any) (*JSONSchema, error) {
+	t := reflect.TypeOf(v)
+	if t == nil {
+		return nil, fmt.Errorf("cannot derive a schema from nil")
+	}
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	node, err := typeSchema(t, map[reflect.Type]bool{})
+	if err != nil {
+		return nil, err
+	}
+	schema, err := json.Marshal(node)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal schema: %w", err)
+	}
+	return &JSONSchema{Name: schemaName(t), Schema: schema}, nil
+}
+
+// schemaName returns a schema name for t that providers accept
+func schemaName(t reflect.Type) string {
+	name := strings.Map(func(r rune) rune {
+		if r == '_' || r == '-' || (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') {
+			return r
+		}
+		return -1
+	}, t.Name())
+	if name == "" {
+		return defaultSchemaName
+	}
+	return name
+}
+
+// typeSchema builds the schema of a Go type, tracking the struct types
+// being expanded in seen to detect recursion
+func typeSchema(t reflect.Type, seen map[reflect.Type]bool) (*schemaNode, error) {
+	switch t {
+	case timeType:
+		return &schemaNode{Type: schemaType{"string"}, Format: "date-time"}, nil
+	case rawMessageType:
+		return &schemaNode{}, nil
+	}
+
+	switch t.Kind() {
+	case reflect.Pointer:
+		return typeSchema(t.Elem(), seen)
+	case reflect.Bool:
+		return &schemaNode{Type: schemaType{"boolean"}}, nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &schemaNode{Type: schemaType{"integer"}}, nil
+	case reflect.Float32, reflect.Float64:
+		return &schemaNode{Type: schemaType{"number"}}, nil
+	case reflect.String:
+		return &schemaNode{Type: schemaType{"string"}}, nil
+	case reflect.Interface:
+		return &schemaNode{}, nil
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			// encoding/json encodes byte slices as base64 strings
+			return &schemaNode{Type: schemaType{"string"}}, nil
+		}
+		items, err := typeSchema(t.Elem(), seen)
+		if err != nil {
+			return nil, err
+		}
+		return &schemaNode{Type: schemaType{"array"}, Items: items}, nil
+	case reflect.Map:
+		if t.Key().Kind() != reflect.String {
+			return nil, fmt.Errorf("unsupported map key type %s", t.Key())
+		}
+		values, err := typeSchema(t.Elem(), seen)
+		if err != nil {
+			return nil, err
+		}
+		additional, err := json.Marshal(values)
+		if err != nil {
+			return nil, err
+		}
+		return &schemaNode{Type: schemaType{"object"}, AdditionalProperties: additional}, nil
+	case reflect.Struct:
+		if seen[t] {
+			return nil, fmt.Errorf("recursive type %s is not supported", t)
+		}
+		seen[t] = true
+		defer delete(seen, t)
+
+		node := &schemaNode{Type: schemaType{"object"}, Properties: map[string]*schemaNode{}}
+		if err := structSchema(t, node, seen); err != nil {
+			return nil, err
+		}
+		return node, nil
+	}
+	return nil, fmt.Errorf("unsupported type %s", t)
+}
+
+// structSchema adds the fields of a struct to an object schema.
+// Fields of embedded structs without a json name are promoted, as encoding/json does.
+func structSchema(t reflect.Type, node *schemaNode, seen map[reflect.Type]bool) error {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+
+		if field.Anonymous && name == "" {
+			embedded := field.Type
+			if embedded.Kind() == reflect.Pointer {
+				embedded = embedded.Elem()
+			}
+			if embedded.Kind() == reflect.Struct {
+				if err := structSchema(embedded, node, seen); err != nil {
+					return err
+				}
+				continue
+			}
+		}
+		if !field.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+
+		prop, err := typeSchema(field.Type, seen)
+		if err != nil {
+			return fmt.Errorf("field %s: %w", field.Name, err)
+		}
+		prop.Description = field.Tag.Get("description")
+		if enum := field.Tag.Get("enum"); enum != "" {
+			for _, value := range strings.Split(enum, ",") {
+				prop.Enum = append(prop.Enum, value)
+			}
+		}
+		node.Properties[name] = prop
+		if !slices.Contains(strings.Split(opts, ","), "omitempty") {
+			node.Required = append(node.Required, name)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,126 @@
+package sqirvy
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+type testAddress struct {
+	City string `json:"city"`
+	Zip  string `json:"zip,omitempty"`
+}
+
+type testPerson struct {
+	Name    string          `json:"name" description:"Full name"`
+	Age     int             `json:"age"`
+	Score   float64         `json:"score,omitempty"`
+	Tags    []string        `json:"tags"`
+	Address *testAddress    `json:"address"`
+	Born    time.Time       `json:"born,omitempty"`
+	Extra   map[string]int  `json:"extra,omitempty"`
+	Ignored string          `json:"-"`
+	Raw     json.RawMessage `json:"raw,omitempty"`
+	Role    string          `json:"role,omitempty" enum:"admin,user"`
+}
+
+func TestSchemaFor(t *testing.T) {
+	schema, err := SchemaFor(&testPerson{})
+	if err != nil {
+		t.Fatalf("SchemaFor() error = %v", err)
+	}
+	if schema.Name != "testPerson" {
+		t.Errorf("SchemaFor() name = %q, want testPerson", schema.Name)
+	}
+
+	node, err := parseSchema(schema.Schema)
+	if err != nil {
+		t.Fatalf("parseSchema() error = %v", err)
+	}
+	if got := strings.Join(node.Required, ","); got != "name,age,tags,address" {
+		t.Errorf("required = %s, want name,age,tags,address", got)
+	}
+	if _, ok := node.Properties["Ignored"]; ok {
+		t.Errorf("field tagged json:\"-\" should not be a property")
+	}
+	if node.Properties["name"].Description != "Full name" {
+		t.Errorf("name description = %q, want Full name", node.Properties["name"].Description)
+	}
+	if got := node.Properties["tags"]; got.Type[0] != "array" || got.Items.Type[0] != "string" {
+		t.Errorf("tags schema = %+v, want array of string", got)
+	}
+	if got := node.Properties["address"]; got.Type[0] != "object" || got.Properties["city"] == nil {
+		t.Errorf("address schema = %+v, want object with city", got)
+	}
+	if got := node.Properties["role"]; len(got.Enum) != 2 || got.Enum[0] != "admin" || got.Enum[1] != "user" {
+		t.Errorf("role enum = %v, want admin, user", got.Enum)
+	}
+	if got := node.Properties["born"]; got.Format != "date-time" {
+		t.Errorf("born format = %q, want date-time", got.Format)
+	}
+
+	// the derived schema accepts a marshaled value
+	data, _ := json.Marshal(testPerson{Name: "Ada", Age: 36, Tags: []string{}, Address: &testAddress{City: "London"}})
+	if err := schema.Validate(data); err != nil {
+		t.Errorf("Validate() error = %v for a marshaled testPerson", err)
+	}
+
+	type node2 struct {
+		Children []node2 `json:"children"`
+	}
+	if _, err := SchemaFor(node2{}); err == nil {
+		t.Errorf("SchemaFor() should reject a recursive type")
+	}
+}
+
+func TestJSONSchema_Validate(t *testing.T) {
+	schema := &JSONSchema{Schema: json.RawMessage(`{
+		"type": "object",
+		"properties": {
+			"name": {"type": "string"},
+			"count": {"type": "integer"},
+			"color": {"type": "string", "enum": ["red", "green"]},
+			"note": {"type": ["string", "null"]},
+			"items": {"type": "array", "items": {"type": "number"}}
+		},
+		"required": ["name"],
+		"additionalProperties": false
+	}`)}
+
+	tests := []struct {
+		name    string
+		data    string
+		wantErr string
+	}{
+		{name: "Valid", data: `{"name":"a","count":2,"color":"red","note":null,"items":[1,2.5]}`},
+		{name: "Not JSON", data: `{"name":`, wantErr: "not valid JSON"},
+		{name: "Missing required", data: `{"count":1}`, wantErr: `missing required property "name"`},
+		{name: "Wrong type", data: `{"name":1}`, wantErr: "$.name: expected string, got number"},
+		{name: "Not an integer", data: `{"name":"a","count":1.5}`, wantErr: "$.count: expected integer"},
+		{name: "Not in enum", data: `{"name":"a","color":"blue"}`, wantErr: "not one of the allowed values"},
+		{name: "Bad item", data: `{"name":"a","items":[1,"x"]}`, wantErr: "$.items[1]"},
+		{name: "Additional property", data: `{"name":"a","other":true}`, wantErr: "$.other: property is not allowed"},
+		{name: "Not an object", data: `[]`, wantErr: "expected object, got array"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := schema.Validate([]byte(tt.data))
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("Validate() error = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Validate() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+
+	// an empty schema accepts any JSON document
+	if err := (&JSONSchema{}).Validate([]byte(`[1, "two"]`)); err != nil {
+		t.Errorf("Validate() with empty schema error = %v", err)
+	}
+}
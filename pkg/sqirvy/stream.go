@@ -0,0 +1,122 @@
+// Package sqirvy provides support for streamed responses.
+//
+// This file contains the server-sent events (SSE) reader shared by the
+// OpenAI-compatible HTTP clients (OpenAI and DeepSeek). Both APIs stream
+// chat completions as a series of "data:" lines terminated by "data: [DONE]".
+package sqirvy
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// chatStreamOptions requests a final stream event carrying token usage
+type chatStreamOptions struct {
+	IncludeUsage bool `json:"include_usage"`
+}
+
+// chatUsage is the token usage reported by OpenAI-compatible APIs
+type chatUsage struct {
+	PromptTokens     int64 `json:"prompt_tokens"`
+	CompletionTokens int64 `json:"completion_tokens"`
+}
+
+// chatStreamChunk represents a single streamed chat completion event
+type chatStreamChunk struct {
+	Model   string `json:"model"`
+	Choices []struct {
+		Delta struct {
+			Content   string              `json:"content"`
+			ToolCalls []chatToolCallDelta `json:"tool_calls"`
+		} `json:"delta"`
+		FinishReason string `json:"finish_reason"`
+	} `json:"choices"`
+	Usage *chatUsage `json:"usage"`
+}
+
+// chatToolCallDelta is a fragment of a streamed tool call. The first fragment
+// of each call has its id and name, later ones continue its arguments.
+type chatToolCallDelta struct {
+	Index    int              `json:"index"`
+	ID       string           `json:"id"`
+	Function chatFunctionCall `json:"function"`
+}
+
+// maxStreamToolCalls limits the index of a streamed tool call, so a malformed
+// event cannot grow the calls without bound
+const maxStreamToolCalls = 128
+
+// readChatStream reads an OpenAI-compatible SSE stream from body, passing each
+// content delta to stream. It returns the concatenated response text along with
+// the model, finish reason, usage and tool calls reported in the stream.
+func readChatStream(body io.Reader, stream StreamFunc) (*Response, error) {
+	var response strings.Builder
+	var toolCalls []chatToolCall
+	result := &Response{}
+
+	scanner := bufio.NewScanner(body)
+	// allow for large events, the default 64KB token size is too small for some responses
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+
+		// skip blank lines, comments and non-data fields
+		data, ok := strings.CutPrefix(line, "data:")
+		if !ok {
+			continue
+		}
+		data = strings.TrimSpace(data)
+		if data == "[DONE]" {
+			break
+		}
+
+		var chunk chatStreamChunk
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal stream event: %w", err)
+		}
+		if chunk.Model != "" {
+			result.Model = chunk.Model
+		}
+		if chunk.Usage != nil {
+			result.InputTokens = chunk.Usage.PromptTokens
+			result.OutputTokens = chunk.Usage.CompletionTokens
+		}
+
+		for _, choice := range chunk.Choices {
+			if choice.FinishReason != "" {
+				result.FinishReason = choice.FinishReason
+			}
+			for _, delta := range choice.Delta.ToolCalls {
+				if delta.Index < 0 || delta.Index >= maxStreamToolCalls {
+					return nil, fmt.Errorf("invalid tool call index %d in stream event", delta.Index)
+				}
+				for len(toolCalls) <= delta.Index {
+					toolCalls = append(toolCalls, chatToolCall{Type: "function"})
+				}
+				call := &toolCalls[delta.Index]
+				if delta.ID != "" {
+					call.ID = delta.ID
+				}
+				call.Function.Name += delta.Function.Name
+				call.Function.Arguments += delta.Function.Arguments
+			}
+			if choice.Delta.Content == "" {
+				continue
+			}
+			response.WriteString(choice.Delta.Content)
+			if err := stream(choice.Delta.Content); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read stream: %w", err)
+	}
+
+	result.Text = response.String()
+	result.ToolCalls = chatToolCalls(toolCalls)
+	return result, nil
+}
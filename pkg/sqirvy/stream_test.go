@@ -0,0 +1,122 @@
+package sqirvy
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestReadChatStream(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		want    Response
+		chunks  int
+		wantErr bool
+	}{
+		{
+			name: "Basic stream",
+			body: "data: {\"choices\":[{\"delta\":{\"role\":\"assistant\"}}]}\n\n" +
+				"data: {\"choices\":[{\"delta\":{\"content\":\"Hello\"}}]}\n\n" +
+				": keep-alive comment\n\n" +
+				"data: {\"model\":\"gpt-4o\",\"choices\":[{\"delta\":{\"content\":\", World!\"},\"finish_reason\":\"stop\"}]}\n\n" +
+				"data: {\"choices\":[],\"usage\":{\"prompt_tokens\":12,\"completion_tokens\":4}}\n\n" +
+				"data: [DONE]\n\n",
+			want:    Response{Text: "Hello, World!", Model: "gpt-4o", FinishReason: "stop", InputTokens: 12, OutputTokens: 4},
+			chunks:  2,
+			wantErr: false,
+		},
+		{
+			name:    "Invalid event",
+			body:    "data: {\"choices\":[{\"delta\":{\"content\":\"Hello\"}}]}\n\ndata: {not json}\n\n",
+			chunks:  1,
+			wantErr: true,
+		},
+		{
+			name:    "Negative tool call index",
+			body:    "data: {\"choices\":[{\"delta\":{\"tool_calls\":[{\"index\":-1,\"id\":\"call_1\"}]}}]}\n\n",
+			chunks:  0,
+			wantErr: true,
+		},
+		{
+			name:    "Tool call index too large",
+			body:    "data: {\"choices\":[{\"delta\":{\"tool_calls\":[{\"index\":1000000000,\"id\":\"call_1\"}]}}]}\n\n",
+			chunks:  0,
+			wantErr: true,
+		},
+		{
+			name:    "Empty stream",
+			body:    "",
+			chunks:  0,
+			wantErr: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var chunks int
+			got, err := readChatStream(strings.NewReader(tt.body), func(chunk string) error {
+				chunks++
+				return nil
+			})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("readChatStream() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && !reflect.DeepEqual(*got, tt.want) {
+				t.Errorf("readChatStream() = %+v, want %+v", *got, tt.want)
+			}
+			if chunks != tt.chunks {
+				t.Errorf("readChatStream() delivered %d chunks, want %d", chunks, tt.chunks)
+			}
+		})
+	}
+
+	t.Run("Stream func error", func(t *testing.T) {
+		body := "data: {\"choices\":[{\"delta\":{\"content\":\"Hello\"}}]}\n\n" +
+			"data: {\"choices\":[{\"delta\":{\"content\":\" again\"}}]}\n\n"
+		_, err := readChatStream(strings.NewReader(body), func(chunk string) error {
+			return fmt.Errorf("stop")
+		})
+		if err == nil || err.Error() != "stop" {
+			t.Errorf("readChatStream() error = %v, want stop", err)
+		}
+	})
+}
+
+func TestOpenAIClient_QueryStream(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req openAIRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || !req.Stream {
+			http.Error(w, "expected a streaming request", http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		for _, word := range []string{"Hello", ", ", "World!"} {
+			fmt.Fprintf(w, "data: {\"choices\":[{\"delta\":{\"content\":%q}}]}\n\n", word)
+		}
+		fmt.Fprint(w, "data: [DONE]\n\n")
+	}))
+	defer ts.Close()
+
+	client := &OpenAIClient{apiKey: "test", baseURL: ts.URL, client: ts.Client()}
+
+	var chunks []string
+	got, err := client.QueryStream(context.Background(), assistant, []string{"Say 'Hello, World!'"}, "gpt-4o", Options{}, func(chunk string) error {
+		chunks = append(chunks, chunk)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("OpenAIClient.QueryStream() error = %v", err)
+	}
+	if got != "Hello, World!" {
+		t.Errorf("OpenAIClient.QueryStream() = %q, want %q", got, "Hello, World!")
+	}
+	if len(chunks) != 3 {
+		t.Errorf("OpenAIClient.QueryStream() delivered %d chunks, want 3", len(chunks))
+	}
+}
@@ -0,0 +1,128 @@
+// Package sqirvy provides token estimates for the providers it supports.
+//
+// This file implements EstimateTokens, which approximates the number of tokens
+// a provider's tokenizer produces for a text without calling the provider, so
+// callers can budget a prompt against the context window of a model. The
+// estimate counts characters at an average rate measured for each provider
+// family on English prose and source code, and counts other scripts, which
+// tokenizers split much finer, at one token per character.
+package sqirvy
+
+import (
+	"unicode"
+	"unicode/utf8"
+)
+
+// charsPerToken is the average number of ASCII characters per token of each
+// provider family's tokenizer
+var charsPerToken = map[string]float64{
+	Anthropic: 3.5,
+	DeepSeek:  3.8,
+	Gemini:    4.0,
+	OpenAI:    4.0,
+	Llama:     3.8,
+}
+
+// defaultCharsPerToken is used for providers without a measured rate
+const defaultCharsPerToken = 4.0
+
+// EstimateTokens approximates the number of tokens text is split into by the
+// tokenizer of a provider. The mock provider counts words, as it does in its
+// usage. Unknown providers use a rate of four characters per token.
+func EstimateTokens(provider, text string) int64 {
+	if provider == Mock {
+		return countWords(text)
+	}
+	ascii, other := 0, 0
+	for i := 0; i < len(text); {
+		if text[i] < utf8.RuneSelf {
+			ascii++
+			i++
+			continue
+		}
+		_, size := utf8.DecodeRuneInString(text[i:])
+		other++
+		i += size
+	}
+	return int64(float64(ascii)/providerCharsPerToken(provider)+0.5) + int64(other)
+}
+
+// TruncateTokens returns the longest prefix of text whose estimated token
+// count for provider is at most tokens, cut at a line break when one is
+// close to the limit so that files are not cut mid-line.
+func TruncateTokens(provider, text string, tokens int64) string {
+	if tokens <= 0 {
+		return ""
+	}
+	end := truncateEnd(provider, text, tokens)
+	if end == len(text) {
+		return text
+	}
+	prefix := text[:end]
+
+	// prefer a line break within the last tenth of the prefix
+	for i := len(prefix) - 1; i >= len(prefix)*9/10 && i >= 0; i-- {
+		if prefix[i] == '\n' {
+			return prefix[:i+1]
+		}
+	}
+	return prefix
+}
+
+// truncateEnd returns the end of the longest prefix of text within tokens,
+// counting as EstimateTokens does in a single pass that stops at the first
+// rune, or for the mock provider the first word, over the limit
+func truncateEnd(provider, text string, tokens int64) int {
+	if provider == Mock {
+		words := int64(0)
+		inWord := false
+		for i, r := range text {
+			space := unicode.IsSpace(r)
+			if !space && !inWord {
+				if words == tokens {
+					return i
+				}
+				words++
+			}
+			inWord = !space
+		}
+		return len(text)
+	}
+
+	rate := providerCharsPerToken(provider)
+	ascii, other := 0, int64(0)
+	for i := 0; i < len(text); {
+		size := 1
+		if text[i] < utf8.RuneSelf {
+			ascii++
+		} else {
+			_, size = utf8.DecodeRuneInString(text[i:])
+			other++
+		}
+		if int64(float64(ascii)/rate+0.5)+other > tokens {
+			return i
+		}
+		i += size
+	}
+	return len(text)
+}
+
+// providerCharsPerToken returns the characters per token of a provider
+func providerCharsPerToken(provider string) float64 {
+	if rate, ok := charsPerToken[provider]; ok {
+		return rate
+	}
+	return defaultCharsPerToken
+}
+
+// estimateTokens approximates the tokens of a conversation sent to provider
+func estimateTokens(provider, system string, messages []Message) int {
+	tokens := EstimateTokens(provider, system)
+	for _, message := range messages {
+		tokens += EstimateTokens(provider, message.Content)
+		for _, call := range message.ToolCalls {
+			tokens += EstimateTokens(provider, call.Name+string(call.Arguments))
+		}
+	}
+	return int(tokens)
+}
@@ -0,0 +1,72 @@
+package sqirvy
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestEstimateTokens(t *testing.T) {
+	tests := []struct {
+		provider string
+		text     string
+		want     int64
+	}{
+		{OpenAI, "", 0},
+		{OpenAI, strings.Repeat("a", 400), 100},
+		{Anthropic, strings.Repeat("a", 350), 100},
+		{"unknown", strings.Repeat("a", 400), 100},
+		{Mock, "one two  three\nfour", 4},
+		// other scripts count one token per character
+		{OpenAI, "日本語", 3},
+		{Gemini, "abcd日本", 3},
+	}
+	for _, tt := range tests {
+		if got := EstimateTokens(tt.provider, tt.text); got != tt.want {
+			t.Errorf("EstimateTokens(%s, %q) = %d, want %d", tt.provider, tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestTruncateTokens(t *testing.T) {
+	text := strings.Repeat("0123456789abcdefghi\n", 100) // 2000 characters, 500 tokens
+
+	if got := TruncateTokens(OpenAI, text, 1000); got != text {
+		t.Errorf("TruncateTokens() of a text that fits changed it")
+	}
+	if got := TruncateTokens(OpenAI, text, 0); got != "" {
+		t.Errorf("TruncateTokens() to zero tokens = %q, want empty", got)
+	}
+
+	got := TruncateTokens(OpenAI, text, 100)
+	if n := EstimateTokens(OpenAI, got); n > 100 || n < 90 {
+		t.Errorf("TruncateTokens() to 100 tokens estimates %d tokens", n)
+	}
+	if !strings.HasPrefix(text, got) || !strings.HasSuffix(got, "\n") {
+		t.Errorf("TruncateTokens() = %q, want a prefix of whole lines", got)
+	}
+
+	// runes are not split
+	got = TruncateTokens(OpenAI, strings.Repeat("日本語", 10), 4)
+	if got != "日本語日" {
+		t.Errorf("TruncateTokens() of other scripts = %q, want 日本語日", got)
+	}
+
+	// the mock provider counts words
+	if got := TruncateTokens(Mock, "one two  three four", 3); got != "one two  three " {
+		t.Errorf("TruncateTokens() for the mock provider = %q, want three words", got)
+	}
+}
+
+func TestTruncateTokens_Large(t *testing.T) {
+	// 32MB of text is cut in a single pass without per-rune allocations
+	text := strings.Repeat("func main() { fmt.Println(\"héllo\") }\n", 1<<20)
+	allocs := testing.AllocsPerRun(1, func() {
+		got := TruncateTokens(OpenAI, text, 1<<20)
+		if n := EstimateTokens(OpenAI, got); n > 1<<20 || n < 1<<20*9/10 {
+			t.Errorf("TruncateTokens() of a large text estimates %d tokens", n)
+		}
+	})
+	if allocs > 10 {
+		t.Errorf("TruncateTokens() of a large text made %v allocations", allocs)
+	}
+}
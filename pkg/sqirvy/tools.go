@@ -0,0 +1,215 @@
+// Package sqirvy provides tool calling support.
+//
+// This file defines the provider-neutral tool types used with Options.Tools,
+// the conversions shared by the OpenAI-compatible HTTP clients, and RunTools,
+// a loop that executes the Go functions behind the tools a model calls and
+// sends their results back until the model gives a final reply.
+package sqirvy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+)
+
+// MaxToolTurns is the number of model replies RunTools accepts before giving up
+// on a conversation that keeps calling tools
+const MaxToolTurns = 10
+
+// Tool describes a function the model may call.
+type Tool struct {
+	Name        string          `json:"name"`                 // Function name, letters, digits, _ and - only
+	Description string          `json:"description"`          // What the function does and when to call it
+	Parameters  json.RawMessage `json:"parameters,omitempty"` // JSON Schema of the arguments object, empty for no arguments
+}
+
+// parameters returns the tool's argument schema, providers require an object schema
+func (t Tool) parameters() json.RawMessage {
+	if len(bytes.TrimSpace(t.Parameters)) == 0 {
+		return json.RawMessage(`{"type":"object","properties":{}}`)
+	}
+	return t.Parameters
+}
+
+// ToolCall is a request by the model to call one of its tools.
+type ToolCall struct {
+	ID        string          `json:"id"`        // Identifies the call, the result message refers to it
+	Name      string          `json:"name"`      // Name of the tool to call
+	Arguments json.RawMessage `json:"arguments"` // JSON object of arguments
+}
+
+// ToolFunc executes a tool call and returns its result for the model.
+type ToolFunc func(ctx context.Context, arguments json.RawMessage) (string, error)
+
+// ToolHandler is a tool together with the function that implements it.
+type ToolHandler struct {
+	Tool
+	Func ToolFunc
+}
+
+// NewTool creates a ToolHandler for fn, deriving the parameter schema from the
+// arguments type T as SchemaFor does. The arguments of each call are unmarshaled
+// into a T before fn is called.
+func NewTool[T any](name, description string, fn func(ctx context.Context, args T) (string, error)) (ToolHandler, error) {
+	schema, err := SchemaFor(new(T))
+	if err != nil {
+		return ToolHandler{}, fmt.Errorf("tool %s: %w", name, err)
+	}
+	return ToolHandler{
+		Tool: Tool{Name: name, Description: description, Parameters: schema.Schema},
+		Func: func(ctx context.Context, arguments json.RawMessage) (string, error) {
+			var args T
+			if err := json.Unmarshal(arguments, &args); err != nil {
+				return "", fmt.Errorf("invalid arguments: %w", err)
+			}
+			return fn(ctx, args)
+		},
+	}, nil
+}
+
+// RunTools sends a conversation to the model with the given tools, calls the
+// functions for any tool calls in the reply and sends their results back, until
+// the model replies without calling a tool or MaxToolTurns is reached.
+//
+// A tool that fails or does not exist is reported to the model as an error
+// result so it can recover. RunTools returns the final reply, with the token
+// usage of every turn, and the conversation including all the tool calls and
+// results, which can be used to continue it.
+func RunTools(ctx context.Context, client Client, system string, messages []Message, model string, options Options, handlers []ToolHandler) (*Response, []Message, error) {
+	funcs := make(map[string]ToolFunc, len(handlers))
+	options.Tools = make([]Tool, 0, len(handlers))
+	for _, h := range handlers {
+		funcs[h.Name] = h.Func
+		options.Tools = append(options.Tools, h.Tool)
+	}
+
+	// don't modify the caller's slice
+	messages = append([]Message(nil), messages...)
+	var inputTokens, outputTokens int64
+	for turn := 0; turn < MaxToolTurns; turn++ {
+		resp, err := client.QueryMessages(ctx, system, messages, model, options, nil)
+		if err != nil {
+			return nil, messages, err
+		}
+		inputTokens += resp.InputTokens
+		outputTokens += resp.OutputTokens
+		messages = append(messages, Message{Role: RoleAssistant, Content: resp.Text, ToolCalls: resp.ToolCalls})
+
+		if len(resp.ToolCalls) == 0 {
+			resp.InputTokens = inputTokens
+			resp.OutputTokens = outputTokens
+			return resp, messages, nil
+		}
+
+		for _, call := range resp.ToolCalls {
+			messages = append(messages, Message{
+				Role:       RoleTool,
+				Content:    callTool(ctx, funcs, call),
+				ToolCallID: call.ID,
+				ToolName:   call.Name,
+			})
+		}
+	}
+	return nil, messages, fmt.Errorf("model did not finish after %d tool turns", MaxToolTurns)
+}
+
+// callTool runs a tool call, returning the error as the result if it fails
+func callTool(ctx context.Context, funcs map[string]ToolFunc, call ToolCall) string {
+	fn, ok := funcs[call.Name]
+	if !ok {
+		return fmt.Sprintf("error: unknown tool %q", call.Name)
+	}
+	result, err := fn(ctx, call.Arguments)
+	if err != nil {
+		return "error: " + err.Error()
+	}
+	return result
+}
+
+// toolArguments decodes the arguments of a tool call for SDKs that take them as a map
+func toolArguments(call ToolCall) (map[string]any, error) {
+	args := map[string]any{}
+	if len(bytes.TrimSpace(call.Arguments)) == 0 {
+		return args, nil
+	}
+	if err := json.Unmarshal(call.Arguments, &args); err != nil {
+		return nil, fmt.Errorf("tool call %s has invalid arguments: %w", call.ID, err)
+	}
+	return args, nil
+}
+
+// chatTool is a tool definition in OpenAI-compatible chat completion requests
+type chatTool struct {
+	Type     string       `json:"type"` // Always "function"
+	Function chatFunction `json:"function"`
+}
+
+type chatFunction struct {
+	Name        string          `json:"name"`
+	Description string          `json:"description,omitempty"`
+	Parameters  json.RawMessage `json:"parameters"`
+}
+
+// chatToolCall is a tool call in OpenAI-compatible chat completion messages
+type chatToolCall struct {
+	ID       string           `json:"id"`
+	Type     string           `json:"type"` // Always "function"
+	Function chatFunctionCall `json:"function"`
+}
+
+type chatFunctionCall struct {
+	Name      string `json:"name"`
+	Arguments string `json:"arguments"` // JSON object encoded as a string
+}
+
+// newChatTools converts tools for an OpenAI-compatible request
+func newChatTools(tools []Tool) []chatTool {
+	if len(tools) == 0 {
+		return nil
+	}
+	chatTools := make([]chatTool, 0, len(tools))
+	for _, t := range tools {
+		chatTools = append(chatTools, chatTool{
+			Type:     "function",
+			Function: chatFunction{Name: t.Name, Description: t.Description, Parameters: t.parameters()},
+		})
+	}
+	return chatTools
+}
+
+// newChatToolCalls converts the tool calls of an assistant message for an OpenAI-compatible request
+func newChatToolCalls(calls []ToolCall) []chatToolCall {
+	if len(calls) == 0 {
+		return nil
+	}
+	chatCalls := make([]chatToolCall, 0, len(calls))
+	for _, call := range calls {
+		arguments := string(call.Arguments)
+		if arguments == "" {
+			arguments = "{}"
+		}
+		chatCalls = append(chatCalls, chatToolCall{
+			ID:       call.ID,
+			Type:     "function",
+			Function: chatFunctionCall{Name: call.Name, Arguments: arguments},
+		})
+	}
+	return chatCalls
+}
+
+// chatToolCalls converts the tool calls in an OpenAI-compatible response
+func chatToolCalls(chatCalls []chatToolCall) []ToolCall {
+	if len(chatCalls) == 0 {
+		return nil
+	}
+	calls := make([]ToolCall, 0, len(chatCalls))
+	for _, c := range chatCalls {
+		arguments := c.Function.Arguments
+		if arguments == "" {
+			arguments = "{}"
+		}
+		calls = append(calls, ToolCall{ID: c.ID, Name: c.Function.Name, Arguments: json.RawMessage(arguments)})
+	}
+	return calls
+}
@@ -0,0 +1,251 @@
+package sqirvy
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/generative-ai-go/genai"
+)
+
+type testAddArgs struct {
+	A int `json:"a"`
+	B int `json:"b"`
+}
+
+func newAddTool(t *testing.T) ToolHandler {
+	t.Helper()
+	tool, err := NewTool("add", "Add two numbers", func(ctx context.Context, args testAddArgs) (string, error) {
+		return fmt.Sprint(args.A + args.B), nil
+	})
+	if err != nil {
+		t.Fatalf("NewTool() error = %v", err)
+	}
+	return tool
+}
+
+func TestNewTool(t *testing.T) {
+	tool := newAddTool(t)
+	if err := (&JSONSchema{Schema: tool.Parameters}).Validate([]byte(`{"a":1}`)); err == nil {
+		t.Errorf("tool parameters should require b")
+	}
+
+	got, err := tool.Func(context.Background(), json.RawMessage(`{"a":2,"b":3}`))
+	if err != nil || got != "5" {
+		t.Errorf("tool.Func() = %q, %v, want 5", got, err)
+	}
+	if _, err := tool.Func(context.Background(), json.RawMessage(`{"a":"two"}`)); err == nil {
+		t.Errorf("tool.Func() should fail for invalid arguments")
+	}
+}
+
+func TestRunTools(t *testing.T) {
+	var requests []openAIRequest
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req openAIRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("failed to decode request: %v", err)
+		}
+		requests = append(requests, req)
+
+		w.Header().Set("Content-Type", "application/json")
+		if len(requests) == 1 {
+			fmt.Fprint(w, `{"choices":[{"message":{"content":"","tool_calls":[
+				{"id":"call_1","type":"function","function":{"name":"add","arguments":"{\"a\":2,\"b\":3}"}},
+				{"id":"call_2","type":"function","function":{"name":"divide","arguments":"{}"}}
+			]},"finish_reason":"tool_calls"}],"usage":{"prompt_tokens":10,"completion_tokens":5}}`)
+			return
+		}
+		fmt.Fprint(w, `{"choices":[{"message":{"content":"2 + 3 = 5"},"finish_reason":"stop"}],"usage":{"prompt_tokens":20,"completion_tokens":7}}`)
+	}))
+	defer ts.Close()
+	client := &OpenAIClient{apiKey: "test", baseURL: ts.URL, client: ts.Client()}
+
+	prompt := []Message{{Role: RoleUser, Content: "What is 2 + 3?"}}
+	resp, messages, err := RunTools(context.Background(), client, assistant, prompt, "gpt-4o", Options{}, []ToolHandler{newAddTool(t)})
+	if err != nil {
+		t.Fatalf("RunTools() error = %v", err)
+	}
+	if resp.Text != "2 + 3 = 5" || resp.InputTokens != 30 || resp.OutputTokens != 12 {
+		t.Errorf("RunTools() = %+v", resp)
+	}
+	if len(prompt) != 1 {
+		t.Errorf("RunTools() modified the caller's messages")
+	}
+
+	// user, assistant with calls, two results, final assistant reply
+	if len(messages) != 5 {
+		t.Fatalf("RunTools() returned %d messages, want 5", len(messages))
+	}
+	if len(messages[1].ToolCalls) != 2 {
+		t.Errorf("assistant message tool calls = %+v", messages[1].ToolCalls)
+	}
+	if m := messages[2]; m.Role != RoleTool || m.ToolCallID != "call_1" || m.ToolName != "add" || m.Content != "5" {
+		t.Errorf("first tool result = %+v", m)
+	}
+	if m := messages[3]; m.ToolCallID != "call_2" || !strings.HasPrefix(m.Content, "error: unknown tool") {
+		t.Errorf("unknown tool result = %+v", m)
+	}
+
+	// the tools are sent with each request and the results in the second
+	if len(requests) != 2 || len(requests[0].Tools) != 1 || requests[0].Tools[0].Function.Name != "add" {
+		t.Fatalf("requests = %+v", requests)
+	}
+	sent := requests[1].Messages
+	if len(sent) != 5 || len(sent[2].ToolCalls) != 2 || sent[3].Role != RoleTool || sent[3].ToolCallID != "call_1" {
+		t.Errorf("second request messages = %+v", sent)
+	}
+}
+
+func TestRunTools_MaxTurns(t *testing.T) {
+	var requests int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"choices":[{"message":{"content":"","tool_calls":[{"id":"call_1","type":"function","function":{"name":"add","arguments":"{\"a\":1,\"b\":1}"}}]},"finish_reason":"tool_calls"}]}`)
+	}))
+	defer ts.Close()
+	client := &OpenAIClient{apiKey: "test", baseURL: ts.URL, client: ts.Client()}
+
+	_, _, err := RunTools(context.Background(), client, assistant, UserMessages([]string{"Count forever"}), "gpt-4o", Options{}, []ToolHandler{newAddTool(t)})
+	if err == nil || requests != MaxToolTurns {
+		t.Errorf("RunTools() error = %v after %d requests, want an error after %d", err, requests, MaxToolTurns)
+	}
+}
+
+func TestCallTool(t *testing.T) {
+	funcs := map[string]ToolFunc{
+		"fail": func(ctx context.Context, arguments json.RawMessage) (string, error) {
+			return "", errors.New("disk full")
+		},
+	}
+	if got := callTool(context.Background(), funcs, ToolCall{Name: "fail"}); got != "error: disk full" {
+		t.Errorf("callTool() = %q, want the error as the result", got)
+	}
+}
+
+func TestReadChatStream_ToolCalls(t *testing.T) {
+	body := strings.Join([]string{
+		`data: {"choices":[{"delta":{"tool_calls":[{"index":0,"id":"call_1","type":"function","function":{"name":"add","arguments":""}}]}}]}`,
+		`data: {"choices":[{"delta":{"tool_calls":[{"index":0,"function":{"arguments":"{\"a\":2,"}}]}}]}`,
+		`data: {"choices":[{"delta":{"tool_calls":[{"index":0,"function":{"arguments":"\"b\":3}"}}]}}]}`,
+		`data: {"choices":[{"delta":{"tool_calls":[{"index":1,"id":"call_2","type":"function","function":{"name":"now","arguments":""}}]}}]}`,
+		`data: {"choices":[{"delta":{},"finish_reason":"tool_calls"}]}`,
+		`data: [DONE]`,
+	}, "\n")
+
+	var chunks int
+	got, err := readChatStream(strings.NewReader(body), func(chunk string) error {
+		chunks++
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("readChatStream() error = %v", err)
+	}
+	if chunks != 0 || got.FinishReason != "tool_calls" {
+		t.Errorf("readChatStream() = %+v after %d chunks", got, chunks)
+	}
+	if len(got.ToolCalls) != 2 {
+		t.Fatalf("readChatStream() tool calls = %+v, want 2", got.ToolCalls)
+	}
+	if call := got.ToolCalls[0]; call.ID != "call_1" || call.Name != "add" || string(call.Arguments) != `{"a":2,"b":3}` {
+		t.Errorf("first tool call = %+v", call)
+	}
+	if call := got.ToolCalls[1]; call.ID != "call_2" || string(call.Arguments) != "{}" {
+		t.Errorf("second tool call = %+v", call)
+	}
+}
+
+func TestNewAnthropicParams_Tools(t *testing.T) {
+	messages := []Message{
+		{Role: RoleUser, Content: "What is 2 + 3 and what time is it?"},
+		{Role: RoleAssistant, ToolCalls: []ToolCall{
+			{ID: "toolu_1", Name: "add", Arguments: json.RawMessage(`{"a":2,"b":3}`)},
+			{ID: "toolu_2", Name: "now"},
+		}},
+		{Role: RoleTool, ToolCallID: "toolu_1", ToolName: "add", Content: "5"},
+		{Role: RoleTool, ToolCallID: "toolu_2", ToolName: "now", Content: "noon"},
+	}
+	options := Options{Tools: []Tool{newAddTool(t).Tool, {Name: "now", Description: "Current time"}}}
+	params, err := newAnthropicParams(context.Background(), assistant, messages, "claude-3-5-haiku-latest", options)
+	if err != nil {
+		t.Fatalf("newAnthropicParams() error = %v", err)
+	}
+
+	data, err := json.Marshal(params)
+	if err != nil {
+		t.Fatalf("failed to marshal params: %v", err)
+	}
+	var request struct {
+		Tools []struct {
+			Name        string         `json:"name"`
+			InputSchema map[string]any `json:"input_schema"`
+		} `json:"tools"`
+		Messages []struct {
+			Role    string `json:"role"`
+			Content []struct {
+				Type      string         `json:"type"`
+				ID        string         `json:"id"`
+				Input     map[string]any `json:"input"`
+				ToolUseID string         `json:"tool_use_id"`
+			} `json:"content"`
+		} `json:"messages"`
+	}
+	if err := json.Unmarshal(data, &request); err != nil {
+		t.Fatalf("failed to unmarshal params: %v", err)
+	}
+
+	if len(request.Tools) != 2 || request.Tools[1].InputSchema["type"] != "object" {
+		t.Errorf("params tools = %+v", request.Tools)
+	}
+	// both results go back in a single user message
+	if len(request.Messages) != 3 {
+		t.Fatalf("params messages = %+v, want 3", request.Messages)
+	}
+	calls := request.Messages[1].Content
+	if len(calls) != 2 || calls[0].Type != "tool_use" || calls[0].Input["a"] != float64(2) {
+		t.Errorf("assistant content = %+v, want two tool_use blocks", calls)
+	}
+	results := request.Messages[2]
+	if results.Role != "user" || len(results.Content) != 2 || results.Content[1].ToolUseID != "toolu_2" {
+		t.Errorf("tool results message = %+v", results)
+	}
+
+	// a JSON query already forces a tool call
+	options.JSON = &JSONSchema{}
+	if _, err := newAnthropicParams(context.Background(), assistant, messages, "claude-3-5-haiku-latest", options); err == nil {
+		t.Errorf("newAnthropicParams() should reject a JSON query with tools")
+	}
+}
+
+func TestGeminiParts(t *testing.T) {
+	role, parts, err := geminiParts(Message{Role: RoleAssistant, ToolCalls: []ToolCall{{ID: "call_0", Name: "add", Arguments: json.RawMessage(`{"a":2}`)}}})
+	if err != nil || role != "model" || len(parts) != 1 {
+		t.Fatalf("geminiParts() = %s, %+v, %v", role, parts, err)
+	}
+	if call, ok := parts[0].(genai.FunctionCall); !ok || call.Name != "add" || call.Args["a"] != float64(2) {
+		t.Errorf("geminiParts() call = %+v", parts[0])
+	}
+
+	role, parts, err = geminiParts(Message{Role: RoleTool, ToolCallID: "call_0", ToolName: "add", Content: "5"})
+	if err != nil || role != "user" {
+		t.Fatalf("geminiParts() = %s, %+v, %v", role, parts, err)
+	}
+	if resp, ok := parts[0].(genai.FunctionResponse); !ok || resp.Name != "add" || resp.Response["result"] != "5" {
+		t.Errorf("geminiParts() response = %+v", parts[0])
+	}
+
+	resp := &genai.GenerateContentResponse{Candidates: []*genai.Candidate{{Content: &genai.Content{Parts: []genai.Part{
+		genai.Text("Let me add those."),
+		genai.FunctionCall{Name: "add", Args: map[string]any{"a": 2, "b": 3}},
+	}}}}}
+	calls, err := geminiToolCalls(resp, 1)
+	if err != nil || len(calls) != 1 || calls[0].ID != "call_1" || string(calls[0].Arguments) != `{"a":2,"b":3}` {
+		t.Errorf("geminiToolCalls() = %+v, %v", calls, err)
+	}
+}
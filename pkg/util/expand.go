@@ -0,0 +1,367 @@
+package util
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"unicode/utf8"
+)
+
+// sniffBytes is the size of the prefix of a file inspected to detect binaries
+const sniffBytes = 8000
+
+// ExpandOptions selects the files ExpandPaths finds in directories and glob patterns.
+// Patterns use the syntax of .gitignore: a pattern without a slash matches the name of a
+// file or directory at any depth, other patterns match the path relative to the
+// directory being expanded, and ** matches any number of directories.
+type ExpandOptions struct {
+	Include []string // Patterns a file must match to be returned, any file if empty
+	Exclude []string // Patterns of files and directories to skip
+}
+
+// ExpandPaths expands paths into the files to read, in a deterministic order.
+// A path to a file is returned as given. A directory is replaced by the files
+// it contains, recursively and in lexical order. A glob pattern, such as
+// pkg/**/*.go, is replaced by the files that match it, in lexical order.
+//
+// Files found in directories and glob patterns are skipped when a .gitignore
+// file ignores them, when they match an exclude pattern or do not match an
+// include pattern of opts, and when their content looks binary. The .gitignore
+// files of the directories walked, and of their parents up to the root of the
+// git repository, are honored. .git directories are always skipped. Each file is
+// returned once, at its first occurrence.
+//
+// Returns an error if a path does not exist or a glob pattern matches no files.
+func ExpandPaths(paths []string, opts ExpandOptions) ([]string, error) {
+	var files []string
+	seen := make(map[string]bool)
+	for _, p := range paths {
+		found, err := expandPath(p, opts)
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range found {
+			if !seen[f] {
+				seen[f] = true
+				files = append(files, f)
+			}
+		}
+	}
+	return files, nil
+}
+
+// expandPath returns the files of a single path, directory or glob pattern
+func expandPath(p string, opts ExpandOptions) ([]string, error) {
+	if strings.ContainsAny(p, "*?[") {
+		return expandGlob(p, opts)
+	}
+	info, err := os.Stat(p)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("file does not exist: %s", p)
+		}
+		return nil, fmt.Errorf("error accessing file %s: %v", p, err)
+	}
+	if !info.IsDir() {
+		return []string{p}, nil
+	}
+	return walkFiles(p, opts, nil)
+}
+
+// expandGlob walks the directory before the first segment of pattern that
+// has a wildcard and returns the files whose path matches the rest of it
+func expandGlob(pattern string, opts ExpandOptions) ([]string, error) {
+	segments := strings.Split(filepath.ToSlash(pattern), "/")
+	static := 0
+	for static < len(segments)-1 && !strings.ContainsAny(segments[static], "*?[") {
+		static++
+	}
+	root := filepath.FromSlash(strings.Join(segments[:static], "/"))
+	if root == "" && filepath.IsAbs(pattern) {
+		// a pattern like /*.go matches in the root directory
+		root = string(filepath.Separator)
+	} else if root == "" {
+		root = "."
+	}
+	for _, segment := range segments[static:] {
+		if _, err := path.Match(segment, ""); err != nil {
+			return nil, fmt.Errorf("invalid pattern %s: %w", pattern, err)
+		}
+	}
+
+	files, err := walkFiles(root, opts, segments[static:])
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no files match %s", pattern)
+	}
+	return files, nil
+}
+
+// walkFiles returns the files of the directory root that are not ignored,
+// excluded or binary, and match the slash separated pattern segments if any
+func walkFiles(root string, opts ExpandOptions, match []string) ([]string, error) {
+	absRoot, err := filepath.Abs(root)
+	if err != nil {
+		return nil, fmt.Errorf("error accessing directory %s: %v", root, err)
+	}
+	if _, err := os.Stat(root); err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("directory does not exist: %s", root)
+		}
+		return nil, fmt.Errorf("error accessing directory %s: %v", root, err)
+	}
+
+	ignores, err := parentIgnores(absRoot)
+	if err != nil {
+		return nil, err
+	}
+	exclude := newPatterns(absRoot, opts.Exclude)
+	include := newPatterns(absRoot, opts.Include)
+
+	var files []string
+	err = filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+		abs := filepath.Join(absRoot, rel)
+
+		if d.IsDir() {
+			if p == root {
+				return ignores.load(abs)
+			}
+			if d.Name() == ".git" || ignores.matches(abs, true) || exclude.matches(abs, true) {
+				return filepath.SkipDir
+			}
+			// a pattern without ** cannot match deeper than its segments
+			if match != nil && !slices.Contains(match, "**") && strings.Count(filepath.ToSlash(rel), "/")+1 >= len(match) {
+				return filepath.SkipDir
+			}
+			return ignores.load(abs)
+		}
+
+		if !isRegularFile(p, d) || ignores.matches(abs, false) || exclude.matches(abs, false) {
+			return nil
+		}
+		if len(include) > 0 && !include.matches(abs, false) {
+			return nil
+		}
+		if match != nil && !matchSegments(match, strings.Split(filepath.ToSlash(rel), "/")) {
+			return nil
+		}
+		if binary, err := isBinary(p); err != nil || binary {
+			return err
+		}
+		files = append(files, p)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error reading directory %s: %w", root, err)
+	}
+	return files, nil
+}
+
+// isRegularFile reports whether a directory entry is a regular file, or a
+// symbolic link to one
+func isRegularFile(p string, d fs.DirEntry) bool {
+	if d.Type().IsRegular() {
+		return true
+	}
+	if d.Type()&fs.ModeSymlink == 0 {
+		return false
+	}
+	info, err := os.Stat(p)
+	return err == nil && info.Mode().IsRegular()
+}
+
+// isBinary reports whether a file looks binary: its first bytes contain a
+// NUL byte or are not valid UTF-8
+func isBinary(p string) (bool, error) {
+	file, err := os.Open(p)
+	if err != nil {
+		return false, fmt.Errorf("error opening file %s: %w", p, err)
+	}
+	defer file.Close()
+
+	buf := make([]byte, sniffBytes)
+	n, err := io.ReadFull(file, buf)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return false, fmt.Errorf("error reading file %s: %w", p, err)
+	}
+	buf = buf[:n]
+	for i := range buf {
+		if buf[i] == 0 {
+			return true, nil
+		}
+	}
+
+	// a multi-byte rune may be cut at the end of the prefix
+	if n == sniffBytes {
+		for i := 0; i < utf8.UTFMax-1 && len(buf) > 0 && !utf8.RuneStart(buf[len(buf)-1]); i++ {
+			buf = buf[:len(buf)-1]
+		}
+		if len(buf) > 0 && buf[len(buf)-1] >= utf8.RuneSelf {
+			buf = buf[:len(buf)-1]
+		}
+	}
+	return !utf8.Valid(buf), nil
+}
+
+// pattern is a line of a .gitignore file, or an include or exclude pattern
+type pattern struct {
+	base     string   // Absolute directory the pattern is relative to, slash separated
+	segments []string // Pattern split on slashes
+	anchored bool     // The pattern has a slash before its end and is matched from base
+	dirOnly  bool     // The pattern ends with a slash and only matches directories
+	negate   bool     // The pattern starts with ! and re-includes what it matches
+}
+
+// patterns is a list of patterns where the last one that matches decides
+type patterns []pattern
+
+// newPatterns parses lines of .gitignore syntax relative to the directory base
+func newPatterns(base string, lines []string) patterns {
+	var list patterns
+	for _, line := range lines {
+		if p, ok := parsePattern(base, line); ok {
+			list = append(list, p)
+		}
+	}
+	return list
+}
+
+// parsePattern parses a line of .gitignore syntax, returning false for blank
+// lines and comments
+func parsePattern(base, line string) (pattern, bool) {
+	line = strings.TrimRight(line, " \t\r")
+	if line == "" || strings.HasPrefix(line, "#") {
+		return pattern{}, false
+	}
+	p := pattern{base: filepath.ToSlash(base)}
+	if strings.HasPrefix(line, "!") {
+		p.negate = true
+		line = line[1:]
+	}
+	line = strings.TrimPrefix(line, "\\")
+	if strings.HasSuffix(line, "/") {
+		p.dirOnly = true
+		line = strings.TrimSuffix(line, "/")
+	}
+	p.anchored = strings.Contains(line, "/")
+	line = strings.TrimPrefix(line, "/")
+	if line == "" {
+		return pattern{}, false
+	}
+	p.segments = strings.Split(line, "/")
+	return p, true
+}
+
+// match reports whether the pattern matches the file or directory abs
+func (p pattern) match(abs string, isDir bool) bool {
+	if p.dirOnly && !isDir {
+		return false
+	}
+	rel, ok := strings.CutPrefix(filepath.ToSlash(abs), strings.TrimSuffix(p.base, "/")+"/")
+	if !ok {
+		return false
+	}
+	names := strings.Split(rel, "/")
+	if !p.anchored {
+		matched, _ := path.Match(p.segments[0], names[len(names)-1])
+		return matched
+	}
+	return matchSegments(p.segments, names)
+}
+
+// matches reports whether the last pattern that matches abs includes it,
+// which for .gitignore files means it is ignored
+func (list patterns) matches(abs string, isDir bool) bool {
+	for i := len(list) - 1; i >= 0; i-- {
+		if list[i].match(abs, isDir) {
+			return !list[i].negate
+		}
+	}
+	return false
+}
+
+// load appends the patterns of the .gitignore file of dir, if it has one
+func (list *patterns) load(dir string) error {
+	file, err := os.Open(filepath.Join(dir, ".gitignore"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("error opening %s: %w", filepath.Join(dir, ".gitignore"), err)
+	}
+	defer file.Close()
+
+	var lines []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("error reading %s: %w", filepath.Join(dir, ".gitignore"), err)
+	}
+	*list = append(*list, newPatterns(dir, lines)...)
+	return nil
+}
+
+// parentIgnores returns the patterns of the .gitignore files of the parents
+// of dir, from the root of its git repository down. A directory outside a git
+// repository has none.
+func parentIgnores(dir string) (patterns, error) {
+	if _, err := os.Stat(filepath.Join(dir, ".git")); err == nil {
+		return nil, nil
+	}
+	var parents []string
+	for p := filepath.Dir(dir); ; p = filepath.Dir(p) {
+		parents = append(parents, p)
+		if _, err := os.Stat(filepath.Join(p, ".git")); err == nil {
+			break
+		}
+		if p == filepath.Dir(p) {
+			// not in a repository
+			return nil, nil
+		}
+	}
+	var list patterns
+	for i := len(parents) - 1; i >= 0; i-- {
+		if err := list.load(parents[i]); err != nil {
+			return nil, err
+		}
+	}
+	return list, nil
+}
+
+// matchSegments matches the segments of a path against the segments of a
+// pattern, where a ** segment matches any number of segments
+func matchSegments(pattern, names []string) bool {
+	if len(pattern) == 0 {
+		return len(names) == 0
+	}
+	if pattern[0] == "**" {
+		for i := 0; i <= len(names); i++ {
+			if matchSegments(pattern[1:], names[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+	if len(names) == 0 {
+		return false
+	}
+	matched, _ := path.Match(pattern[0], names[0])
+	return matched && matchSegments(pattern[1:], names[1:])
+}
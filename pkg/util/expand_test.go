@@ -0,0 +1,162 @@
+package util
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// writeTree creates files with the given content under dir
+func writeTree(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestExpandPaths(t *testing.T) {
+	dir := t.TempDir()
+	writeTree(t, dir, map[string]string{
+		".git/HEAD":           "ref: refs/heads/main\n",
+		".gitignore":          "*.log\n!keep.log\nbuild/\n",
+		"main.go":             "package main\n",
+		"README.md":           "# readme\n",
+		"app.log":             "ignored\n",
+		"keep.log":            "kept\n",
+		"logo.png":            "\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR",
+		"build/out.go":        "package build\n",
+		"pkg/a/a.go":          "package a\n",
+		"pkg/a/a_test.go":     "package a\n",
+		"pkg/b/.gitignore":    "secret.txt\n",
+		"pkg/b/b.go":          "package b\n",
+		"pkg/b/secret.txt":    "ignored\n",
+		"pkg/b/notes.txt":     "héllo wörld\n",
+		"vendor/x/x.go":       "package x\n",
+		"docs/guide/intro.md": "intro\n",
+	})
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+
+	tests := []struct {
+		name    string
+		paths   []string
+		opts    ExpandOptions
+		want    []string
+		wantErr bool
+	}{
+		{
+			name:  "directory in lexical order, honoring .gitignore and skipping binaries",
+			paths: []string{"."},
+			want: []string{".gitignore", "README.md", "docs/guide/intro.md", "keep.log", "main.go",
+				"pkg/a/a.go", "pkg/a/a_test.go", "pkg/b/.gitignore", "pkg/b/b.go", "pkg/b/notes.txt", "vendor/x/x.go"},
+		},
+		{
+			name:  "subdirectory honors the .gitignore of its parents",
+			paths: []string{"pkg/b"},
+			want:  []string{"pkg/b/.gitignore", "pkg/b/b.go", "pkg/b/notes.txt"},
+		},
+		{
+			name:  "glob with **",
+			paths: []string{"pkg/**/*.go"},
+			want:  []string{"pkg/a/a.go", "pkg/a/a_test.go", "pkg/b/b.go"},
+		},
+		{
+			name:  "glob without ** matches one level",
+			paths: []string{"*.go"},
+			want:  []string{"main.go"},
+		},
+		{
+			name:  "include and exclude",
+			paths: []string{"."},
+			opts:  ExpandOptions{Include: []string{"*.go"}, Exclude: []string{"*_test.go", "vendor"}},
+			want:  []string{"main.go", "pkg/a/a.go", "pkg/b/b.go"},
+		},
+		{
+			name:  "anchored exclude",
+			paths: []string{"."},
+			opts:  ExpandOptions{Include: []string{"*.go"}, Exclude: []string{"/pkg/a/"}},
+			want:  []string{"main.go", "pkg/b/b.go", "vendor/x/x.go"},
+		},
+		{
+			name:  "files are returned as given and only once",
+			paths: []string{"app.log", "pkg/b/*.txt", "pkg/b/notes.txt", "logo.png"},
+			want:  []string{"app.log", "pkg/b/notes.txt", "logo.png"},
+		},
+		{
+			name:    "missing file",
+			paths:   []string{"missing.go"},
+			wantErr: true,
+		},
+		{
+			name:    "glob without matches",
+			paths:   []string{"pkg/**/*.rs"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ExpandPaths(tt.paths, tt.opts)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("ExpandPaths() = %q, want an error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ExpandPaths() error = %v", err)
+			}
+			for i := range got {
+				got[i] = filepath.ToSlash(got[i])
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ExpandPaths() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExpandPaths_AbsoluteGlob(t *testing.T) {
+	dir := t.TempDir()
+	writeTree(t, dir, map[string]string{
+		"main.go":    "package main\n",
+		"README.md":  "# readme\n",
+		"pkg/a/a.go": "package a\n",
+	})
+
+	got, err := ExpandPaths([]string{filepath.Join(dir, "*.go"), filepath.Join(dir, "pkg", "*", "*.go")}, ExpandOptions{})
+	if err != nil {
+		t.Fatalf("ExpandPaths() error = %v", err)
+	}
+	want := []string{filepath.Join(dir, "main.go"), filepath.Join(dir, "pkg", "a", "a.go")}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ExpandPaths() = %q, want %q", got, want)
+	}
+
+	// a wildcard right after the root matches in the root directory, not in
+	// the current one
+	root := string(filepath.Separator)
+	got, err = ExpandPaths([]string{root + "*"}, ExpandOptions{})
+	if err != nil && !strings.Contains(err.Error(), "no files match") {
+		t.Fatalf("ExpandPaths(%s*) error = %v", root, err)
+	}
+	for _, file := range got {
+		if filepath.Dir(file) != root {
+			t.Errorf("ExpandPaths(%s*) = %q, want files of %s", root, got, root)
+			break
+		}
+	}
+}
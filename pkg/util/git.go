@@ -0,0 +1,161 @@
+package util
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// DefaultDiffContext is the number of lines around each change in a diff
+// collected with a zero DiffOptions.Context
+const DefaultDiffContext = 10
+
+// DiffOptions selects the changes GitDiff collects from a git repository.
+type DiffOptions struct {
+	Dir     string   // Directory in the repository, the current directory if empty
+	Range   string   // Revision range such as main..HEAD, or a revision to diff the working tree against
+	Staged  bool     // Diff the staged changes against HEAD instead of a range
+	Context int      // Lines of context around each change, DefaultDiffContext if zero
+	Paths   []string // Limit the diff to these paths
+}
+
+// FileDiff is the unified diff of one changed file.
+type FileDiff struct {
+	Path  string // Path of the file after the change, or before it if it was deleted
+	Patch string // Unified diff of the file, including its git headers
+}
+
+// GitDiff runs git diff with the options and returns the diff of each changed
+// file in the order git reports them. Without a range or Staged it collects the
+// unstaged changes of the working tree.
+func GitDiff(opts DiffOptions) ([]FileDiff, error) {
+	if opts.Staged && opts.Range != "" {
+		return nil, fmt.Errorf("staged changes cannot be diffed with a range")
+	}
+	if strings.HasPrefix(opts.Range, "-") {
+		return nil, fmt.Errorf("invalid revision range %s", opts.Range)
+	}
+	context := opts.Context
+	if context <= 0 {
+		context = DefaultDiffContext
+	}
+
+	args := []string{"diff", "--no-color", "--no-ext-diff", "-U" + strconv.Itoa(context)}
+	if opts.Dir != "" {
+		args = append([]string{"-C", opts.Dir}, args...)
+	}
+	if opts.Staged {
+		args = append(args, "--cached")
+	}
+	if opts.Range != "" {
+		args = append(args, opts.Range)
+	}
+	args = append(args, "--")
+	args = append(args, opts.Paths...)
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command("git", args...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("git diff failed: %v: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return parseDiff(stdout.String()), nil
+}
+
+// parseDiff splits the output of git diff into the diff of each file
+func parseDiff(output string) []FileDiff {
+	var diffs []FileDiff
+	for _, patch := range strings.SplitAfter(output, "\ndiff --git ") {
+		if patch == "" {
+			continue
+		}
+		patch = strings.TrimSuffix(patch, "diff --git ")
+		if !strings.HasPrefix(patch, "diff --git ") {
+			patch = "diff --git " + patch
+		}
+		diffs = append(diffs, FileDiff{Path: diffPath(patch), Patch: patch})
+	}
+	return diffs
+}
+
+// diffPath returns the path of the file a patch changes, from its +++ line,
+// or its --- line if it was deleted, or its diff --git line if neither exists
+// as for renames and binary files
+func diffPath(patch string) string {
+	var old string
+	for _, line := range strings.Split(patch, "\n") {
+		if strings.HasPrefix(line, "@@") {
+			break
+		}
+		if name, ok := strings.CutPrefix(line, "+++ b/"); ok {
+			return name
+		}
+		if name, ok := strings.CutPrefix(line, "--- a/"); ok {
+			old = name
+		}
+		if name, ok := strings.CutPrefix(line, "rename to "); ok {
+			return name
+		}
+	}
+	if old != "" {
+		return old
+	}
+	header, _, _ := strings.Cut(patch, "\n")
+	if i := strings.LastIndex(header, " b/"); i >= 0 {
+		return header[i+len(" b/"):]
+	}
+	return header
+}
+
+// Numbered returns the patch with the line number in the new version of the
+// file in front of each context and added line of its hunks, so comments can
+// refer to lines by number. Removed lines have no number.
+func (d FileDiff) Numbered() string {
+	var b strings.Builder
+	line := 0
+	inHunk := false
+	for _, text := range strings.SplitAfter(d.Patch, "\n") {
+		if text == "" {
+			continue
+		}
+		if strings.HasPrefix(text, "@@") {
+			line = hunkStart(text)
+			inHunk = true
+			b.WriteString(text)
+			continue
+		}
+		if !inHunk {
+			b.WriteString(text)
+			continue
+		}
+		if text[0] == ' ' || text[0] == '+' {
+			fmt.Fprintf(&b, "%6d %s", line, text)
+			line++
+		} else {
+			// removed lines and "\ No newline at end of file"
+			fmt.Fprintf(&b, "%6s %s", "", text)
+		}
+	}
+	return b.String()
+}
+
+// hunkStart returns the first line of the new file in a hunk header of the
+// form @@ -a,b +c,d @@
+func hunkStart(header string) int {
+	_, rest, ok := strings.Cut(header, " +")
+	if !ok {
+		return 0
+	}
+	end := strings.IndexAny(rest, ", ")
+	if end < 0 {
+		return 0
+	}
+	start, err := strconv.Atoi(rest[:end])
+	if err != nil {
+		return 0
+	}
+	return start
+}
@@ -0,0 +1,99 @@
+package util
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// gitRepo creates a repository with a committed main.go and old.go, then
+// commits a change to main.go and the removal of old.go on top of it
+func gitRepo(t *testing.T) string {
+	t.Helper()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	dir := t.TempDir()
+	git := func(args ...string) {
+		t.Helper()
+		cmd := exec.Command("git", append([]string{"-C", dir, "-c", "user.name=test", "-c", "user.email=test@example.com"}, args...)...)
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v: %s", args, err, out)
+		}
+	}
+	var lines []string
+	for i := 1; i <= 30; i++ {
+		lines = append(lines, "line "+string(rune('a'+i%26)))
+	}
+	writeTree(t, dir, map[string]string{
+		"main.go": strings.Join(lines, "\n") + "\n",
+		"old.go":  "package old\n",
+	})
+	git("init", "-q")
+	git("add", ".")
+	git("commit", "-q", "-m", "first")
+
+	lines[19] = "changed line 20"
+	writeTree(t, dir, map[string]string{"main.go": strings.Join(lines, "\n") + "\n"})
+	if err := os.Remove(filepath.Join(dir, "old.go")); err != nil {
+		t.Fatal(err)
+	}
+	git("add", "-A")
+	git("commit", "-q", "-m", "second")
+	return dir
+}
+
+func TestGitDiff(t *testing.T) {
+	dir := gitRepo(t)
+
+	diffs, err := GitDiff(DiffOptions{Dir: dir, Range: "HEAD~1..HEAD", Context: 2})
+	if err != nil {
+		t.Fatalf("GitDiff() error = %v", err)
+	}
+	if len(diffs) != 2 || diffs[0].Path != "main.go" || diffs[1].Path != "old.go" {
+		t.Fatalf("GitDiff() = %+v, want main.go and old.go", diffs)
+	}
+	numbered := diffs[0].Numbered()
+	for _, want := range []string{"    20 +changed line 20", "       -line u", "    18  line s", "    22  line w"} {
+		if !strings.Contains(numbered, want) {
+			t.Errorf("Numbered() = %s, want a line %q", numbered, want)
+		}
+	}
+	if strings.Contains(numbered, "    17 ") {
+		t.Errorf("Numbered() = %s, want 2 lines of context", numbered)
+	}
+
+	// paths limit the diff
+	diffs, err = GitDiff(DiffOptions{Dir: dir, Range: "HEAD~1", Paths: []string{"old.go"}})
+	if err != nil || len(diffs) != 1 || diffs[0].Path != "old.go" {
+		t.Errorf("GitDiff() of old.go = %+v, %v", diffs, err)
+	}
+
+	// staged changes
+	writeTree(t, dir, map[string]string{"new.go": "package new\n"})
+	if out, err := exec.Command("git", "-C", dir, "add", "new.go").CombinedOutput(); err != nil {
+		t.Fatalf("git add: %v: %s", err, out)
+	}
+	diffs, err = GitDiff(DiffOptions{Dir: dir, Staged: true})
+	if err != nil || len(diffs) != 1 || diffs[0].Path != "new.go" || !strings.Contains(diffs[0].Numbered(), "     1 +package new") {
+		t.Errorf("GitDiff() of staged changes = %+v, %v", diffs, err)
+	}
+
+	// no changes
+	diffs, err = GitDiff(DiffOptions{Dir: dir})
+	if err != nil || len(diffs) != 0 {
+		t.Errorf("GitDiff() without changes = %+v, %v", diffs, err)
+	}
+
+	for _, opts := range []DiffOptions{
+		{Dir: dir, Range: "nonexistent..HEAD"},
+		{Dir: dir, Range: "--output=/tmp/x"},
+		{Dir: dir, Range: "HEAD~1", Staged: true},
+	} {
+		if _, err := GitDiff(opts); err == nil {
+			t.Errorf("GitDiff(%+v) should fail", opts)
+		}
+	}
+}
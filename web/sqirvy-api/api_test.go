@@ -3,10 +3,17 @@ package main
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"path/filepath"
 	"testing"
+	"time"
+
+	"sqirvy-ai/pkg/sqirvy"
 )
 
 func TestModelsEndpoint(t *testing.T) {
@@ -170,3 +177,334 @@ func TestQueryEndpoint(t *testing.T) {
 		})
 	}
 }
+
+// TestQueryEndpoint_Mock runs queries end to end with the offline mock provider
+func TestQueryEndpoint_Mock(t *testing.T) {
+	script := filepath.Join(t.TempDir(), "script.yaml")
+	if err := os.WriteFile(script, []byte("responses:\n  - match: secret\n    status: 401\n    error: invalid api key\n"), 0644); err != nil {
+		t.Fatalf("Failed to write mock script: %v", err)
+	}
+	t.Setenv("MOCK_SCRIPT", script)
+
+	ts := httptest.NewServer(http.HandlerFunc(handleQuery))
+	defer ts.Close()
+
+	tests := []struct {
+		name       string
+		request    QueryRequest
+		wantStatus int
+		wantResult string
+	}{
+		{
+			name:       "Echo",
+			request:    QueryRequest{Model: "mock-echo", Prompt: "Say hello"},
+			wantStatus: http.StatusOK,
+			wantResult: "Say hello",
+		},
+		{
+			name:       "Scripted error",
+			request:    QueryRequest{Model: "mock-script", Prompt: "the secret word"},
+			wantStatus: http.StatusUnauthorized,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body, err := json.Marshal(tt.request)
+			if err != nil {
+				t.Fatalf("Failed to marshal request: %v", err)
+			}
+			resp, err := http.Post(ts.URL, "application/json", bytes.NewBuffer(body))
+			if err != nil {
+				t.Fatalf("Failed to make request: %v", err)
+			}
+			defer resp.Body.Close()
+
+			if resp.StatusCode != tt.wantStatus {
+				t.Fatalf("Expected status %v; got %v", tt.wantStatus, resp.StatusCode)
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+
+			var response QueryResponse
+			if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+				t.Fatalf("Failed to decode response: %v", err)
+			}
+			if response.Result != tt.wantResult || response.Usage.Model != tt.request.Model {
+				t.Errorf("Expected result %q; got %+v", tt.wantResult, response)
+			}
+			if response.Usage.Cost == nil || *response.Usage.Cost != 0 {
+				t.Errorf("Expected a zero cost; got %v", response.Usage.Cost)
+			}
+		})
+	}
+}
+
+func TestStreamEndpoint(t *testing.T) {
+	// Create a test server
+	ts := httptest.NewServer(http.HandlerFunc(handleStream))
+	defer ts.Close()
+
+	tests := []struct {
+		name       string
+		method     string
+		request    QueryRequest
+		wantStatus int
+		wantBody   string
+	}{
+		{
+			name:       "Method Not Allowed",
+			method:     http.MethodGet,
+			wantStatus: http.StatusMethodNotAllowed,
+		},
+		{
+			name:   "Empty Prompt",
+			method: http.MethodPost,
+			request: QueryRequest{
+				Model:       "claude-3-5-sonnet-latest",
+				Prompt:      "",
+				Temperature: 50,
+			},
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:   "Mock Model",
+			method: http.MethodPost,
+			request: QueryRequest{
+				Model:  "mock-echo",
+				Prompt: "Say hello",
+			},
+			wantStatus: http.StatusOK,
+			wantBody:   "Say hello",
+		},
+		{
+			name:   "Negative Timeout",
+			method: http.MethodPost,
+			request: QueryRequest{
+				Model:   "claude-3-5-sonnet-latest",
+				Prompt:  "Say hello",
+				Timeout: -1,
+			},
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:   "Invalid Model",
+			method: http.MethodPost,
+			request: QueryRequest{
+				Model:       "invalid-model",
+				Prompt:      "Say hello",
+				Temperature: 50,
+			},
+			wantStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body, err := json.Marshal(tt.request)
+			if err != nil {
+				t.Fatalf("Failed to marshal request: %v", err)
+			}
+
+			req, err := http.NewRequest(tt.method, ts.URL, bytes.NewBuffer(body))
+			if err != nil {
+				t.Fatalf("Failed to create request: %v", err)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatalf("Failed to make request: %v", err)
+			}
+			defer resp.Body.Close()
+
+			if resp.StatusCode != tt.wantStatus {
+				t.Errorf("Expected status %v; got %v", tt.wantStatus, resp.StatusCode)
+			}
+			if tt.wantBody != "" {
+				body, _ := io.ReadAll(resp.Body)
+				if string(body) != tt.wantBody {
+					t.Errorf("Expected body %q; got %q", tt.wantBody, body)
+				}
+			}
+		})
+	}
+}
+
+func TestCompareEndpoint(t *testing.T) {
+	script := filepath.Join(t.TempDir(), "script.yaml")
+	if err := os.WriteFile(script, []byte("responses:\n  - match: hello\n    status: 401\n    error: invalid api key\n"), 0644); err != nil {
+		t.Fatalf("Failed to write mock script: %v", err)
+	}
+	t.Setenv("MOCK_SCRIPT", script)
+
+	ts := httptest.NewServer(http.HandlerFunc(handleCompare))
+	defer ts.Close()
+
+	tests := []struct {
+		name       string
+		request    CompareRequest
+		wantStatus int
+	}{
+		{
+			name:       "No Models",
+			request:    CompareRequest{Prompt: "Say hello"},
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "Empty Prompt",
+			request:    CompareRequest{Models: []string{"mock-echo"}},
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "Invalid Model",
+			request:    CompareRequest{Models: []string{"mock-echo", "invalid-model"}, Prompt: "Say hello"},
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "Mock Models",
+			request:    CompareRequest{Models: []string{"mock-echo", "mock-script"}, Prompt: "Say hello"},
+			wantStatus: http.StatusOK,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body, err := json.Marshal(tt.request)
+			if err != nil {
+				t.Fatalf("Failed to marshal request: %v", err)
+			}
+			resp, err := http.Post(ts.URL, "application/json", bytes.NewBuffer(body))
+			if err != nil {
+				t.Fatalf("Failed to make request: %v", err)
+			}
+			defer resp.Body.Close()
+
+			if resp.StatusCode != tt.wantStatus {
+				t.Fatalf("Expected status %v; got %v", tt.wantStatus, resp.StatusCode)
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+
+			// the echo model answers and the scripted model fails, in request order
+			var response CompareResponse
+			if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+				t.Fatalf("Failed to decode response: %v", err)
+			}
+			if len(response.Results) != 2 {
+				t.Fatalf("Expected 2 results; got %+v", response.Results)
+			}
+			echo, script := response.Results[0], response.Results[1]
+			if echo.Model != "mock-echo" || echo.Status != http.StatusOK || echo.Result != "Say hello" || echo.Usage == nil {
+				t.Errorf("Expected the echo reply; got %+v", echo)
+			}
+			if script.Model != "mock-script" || script.Status != http.StatusUnauthorized || script.Error == "" || script.Usage != nil {
+				t.Errorf("Expected an authentication error; got %+v", script)
+			}
+		})
+	}
+}
+
+func TestQueryError(t *testing.T) {
+	tests := []struct {
+		name           string
+		err            error
+		wantStatus     int
+		wantRetryAfter string
+	}{
+		{
+			name:       "Authentication",
+			err:        &sqirvy.ProviderError{Provider: sqirvy.OpenAI, Kind: sqirvy.ErrAuthentication, Err: errors.New("bad key")},
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:           "Rate limited",
+			err:            &sqirvy.ProviderError{Provider: sqirvy.Anthropic, Kind: sqirvy.ErrRateLimited, RetryAfter: 1500 * time.Millisecond, Err: errors.New("slow down")},
+			wantStatus:     http.StatusTooManyRequests,
+			wantRetryAfter: "2",
+		},
+		{
+			name:       "Context too long",
+			err:        &sqirvy.ProviderError{Provider: sqirvy.Gemini, Kind: sqirvy.ErrContextTooLong, Err: errors.New("too long")},
+			wantStatus: http.StatusRequestEntityTooLarge,
+		},
+		{
+			name:       "Model not found",
+			err:        fmt.Errorf("wrapped: %w", &sqirvy.ProviderError{Provider: sqirvy.OpenAI, Kind: sqirvy.ErrModelNotFound, Err: errors.New("no model")}),
+			wantStatus: http.StatusNotFound,
+		},
+		{
+			name:       "Unclassified",
+			err:        errors.New("something else"),
+			wantStatus: http.StatusInternalServerError,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			queryError(w, tt.err)
+			if w.Code != tt.wantStatus {
+				t.Errorf("Expected status %v; got %v", tt.wantStatus, w.Code)
+			}
+			if got := w.Header().Get("Retry-After"); got != tt.wantRetryAfter {
+				t.Errorf("Expected Retry-After %q; got %q", tt.wantRetryAfter, got)
+			}
+		})
+	}
+}
+
+func TestClientOptions(t *testing.T) {
+	t.Setenv("ANTHROPIC_API_KEY", "")
+
+	r := httptest.NewRequest(http.MethodPost, "/query", nil)
+	if _, err := sqirvy.NewClient(sqirvy.Anthropic, clientOptions(r)...); err == nil {
+		t.Errorf("Expected an error without an API key")
+	}
+
+	r.Header.Set(apiKeyHeader, "tenant-key")
+	client, err := sqirvy.NewClient(sqirvy.Anthropic, clientOptions(r)...)
+	if err != nil {
+		t.Fatalf("Expected the %s header to provide the API key: %v", apiKeyHeader, err)
+	}
+	client.Close()
+}
+
+func TestQueryEndpoint_RateLimits(t *testing.T) {
+	limiter = sqirvy.NewRateLimiter(map[string]sqirvy.RateLimit{"mock": {RequestsPerMinute: 1}})
+	defer func() { limiter = nil }()
+
+	ts := httptest.NewServer(http.HandlerFunc(handleQuery))
+	defer ts.Close()
+
+	query := func(apiKey string) int {
+		t.Helper()
+		body, _ := json.Marshal(QueryRequest{Model: "mock-echo", Prompt: "Say hello", Timeout: 0.05})
+		req, err := http.NewRequest(http.MethodPost, ts.URL, bytes.NewBuffer(body))
+		if err != nil {
+			t.Fatalf("Failed to create request: %v", err)
+		}
+		if apiKey != "" {
+			req.Header.Set(apiKeyHeader, apiKey)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("Failed to make request: %v", err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	// the server's keys get one request per minute
+	if status := query(""); status != http.StatusOK {
+		t.Errorf("Expected status OK; got %v", status)
+	}
+	if status := query(""); status != http.StatusGatewayTimeout {
+		t.Errorf("Expected status %v over the limit; got %v", http.StatusGatewayTimeout, status)
+	}
+
+	// a tenant's own key is not limited
+	if status := query("tenant-key"); status != http.StatusOK {
+		t.Errorf("Expected status OK with a tenant key; got %v", status)
+	}
+}
@@ -2,11 +2,15 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
+	"math"
 	"net/http"
 	"sort"
+	"strconv"
+	"time"
 
 	"sqirvy-ai/pkg/sqirvy"
 )
@@ -14,11 +18,31 @@ import (
 func main() {
 	// Parse command line flags
 	addr := flag.String("addr", ":8080", "HTTP server address")
+	catalog := flag.String("catalog", "", "model catalog file (YAML or JSON) merged over the built-in models")
+	rateLimits := flag.String("rate-limits", "", "per provider rate limits file (YAML or JSON) for queries sent with the server's API keys")
 	flag.Parse()
 
+	// Add models from the catalog file to the built-in models
+	if *catalog != "" {
+		if err := sqirvy.LoadCatalog(*catalog); err != nil {
+			log.Fatalf("Failed to load model catalog: %v", err)
+		}
+	}
+
+	// Share the rate limits between all requests that use the server's keys
+	if *rateLimits != "" {
+		limits, err := sqirvy.LoadRateLimits(*rateLimits)
+		if err != nil {
+			log.Fatalf("Failed to load rate limits: %v", err)
+		}
+		limiter = sqirvy.NewRateLimiter(limits)
+	}
+
 	// Create handlers
 	http.HandleFunc("/models", handleModels)
 	http.HandleFunc("/query", handleQuery)
+	http.HandleFunc("/stream", handleStream)
+	http.HandleFunc("/compare", handleCompare)
 
 	// Start server
 	log.Printf("Starting server on %s", *addr)
@@ -78,11 +102,32 @@ func handleModels(w http.ResponseWriter, r *http.Request) {
 
 const webSystem = "you are an experienced web developer using the Go language"
 
+// apiKeyHeader is the request header that carries the caller's own provider
+// API key, so each tenant is billed to its own account
+const apiKeyHeader = "X-API-Key"
+
+// limiter keeps the queries sent with the server's API keys within the
+// provider rate limits, nil if the server has no limits
+var limiter *sqirvy.RateLimiter
+
+// clientOptions returns the options for the provider client of a request.
+// Without an API key header the server's environment variables are used, and
+// the query waits for the server's rate limits. A tenant's own key is not limited.
+func clientOptions(r *http.Request) []sqirvy.ClientOption {
+	var opts []sqirvy.ClientOption
+	if key := r.Header.Get(apiKeyHeader); key != "" {
+		opts = append(opts, sqirvy.WithAPIKey(key))
+	} else if limiter != nil {
+		opts = append(opts, sqirvy.WithRateLimiter(limiter))
+	}
+	return opts
+}
+
 func handleQuery(w http.ResponseWriter, r *http.Request) {
 	// Set CORS headers
 	w.Header().Set("Access-Control-Allow-Origin", "*")
 	w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
-	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, "+apiKeyHeader)
 
 	log.Printf("Handling query request from %s", r.RemoteAddr)
 
@@ -110,6 +155,10 @@ func handleQuery(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Prompt cannot be empty", http.StatusBadRequest)
 		return
 	}
+	if req.Timeout < 0 {
+		http.Error(w, "Timeout cannot be negative", http.StatusBadRequest)
+		return
+	}
 
 	// Get provider for the model
 	provider, err := sqirvy.GetProviderName(req.Model)
@@ -119,7 +168,7 @@ func handleQuery(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Create client for the provider
-	client, err := sqirvy.NewClient(provider)
+	client, err := sqirvy.NewClient(provider, clientOptions(r)...)
 	if err != nil {
 		http.Error(w, fmt.Sprintf("Failed to create client: %v", err), http.StatusInternalServerError)
 		return
@@ -127,17 +176,187 @@ func handleQuery(w http.ResponseWriter, r *http.Request) {
 	defer client.Close()
 
 	// Query the model
-	result, err := client.QueryText(r.Context(), webSystem, []string{req.Prompt}, req.Model, sqirvy.Options{
-		Temperature: req.Temperature,
-	})
+	result, err := client.QueryMessages(r.Context(), webSystem, sqirvy.UserMessages([]string{req.Prompt}), req.Model, queryOptions(req), nil)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Query failed: %v", err), http.StatusInternalServerError)
+		queryError(w, err)
 		return
 	}
 
 	// Send response
 	response := QueryResponse{
-		Result: result,
+		Result: result.Text,
+		Usage:  responseUsage(req.Model, result),
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+		return
+	}
+}
+
+// handleStream runs the same query as handleQuery but writes the response
+// as plain text, flushing each chunk to the client as the model generates it.
+func handleStream(w http.ResponseWriter, r *http.Request) {
+	// Set CORS headers
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, "+apiKeyHeader)
+
+	log.Printf("Handling stream request from %s", r.RemoteAddr)
+
+	// Handle OPTIONS request
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		log.Printf("Method not allowed: %s", r.Method)
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	// Parse request body
+	var req QueryRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	// Validate request
+	if req.Prompt == "" {
+		http.Error(w, "Prompt cannot be empty", http.StatusBadRequest)
+		return
+	}
+	if req.Timeout < 0 {
+		http.Error(w, "Timeout cannot be negative", http.StatusBadRequest)
+		return
+	}
+
+	// Get provider for the model
+	provider, err := sqirvy.GetProviderName(req.Model)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid model: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	// Create client for the provider
+	client, err := sqirvy.NewClient(provider, clientOptions(r)...)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to create client: %v", err), http.StatusInternalServerError)
+		return
+	}
+	defer client.Close()
+
+	// Stream the model response. Once the first chunk is written the status
+	// is committed, so later failures can only be logged.
+	var started bool
+	_, err = client.QueryStream(r.Context(), webSystem, []string{req.Prompt}, req.Model, queryOptions(req), func(chunk string) error {
+		if !started {
+			started = true
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			w.Header().Set("X-Content-Type-Options", "nosniff")
+		}
+		if _, err := fmt.Fprint(w, chunk); err != nil {
+			return err
+		}
+		flusher.Flush()
+		return nil
+	})
+	if err != nil {
+		if !started {
+			queryError(w, err)
+			return
+		}
+		log.Printf("Stream failed: %v", err)
+	}
+}
+
+// maxCompareModels limits the models of a /compare request
+const maxCompareModels = 8
+
+// handleCompare sends the same query to several models concurrently and
+// returns the response or error of each. The request succeeds even if some
+// or all of the models fail, their errors are part of the results.
+func handleCompare(w http.ResponseWriter, r *http.Request) {
+	// Set CORS headers
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, "+apiKeyHeader)
+
+	log.Printf("Handling compare request from %s", r.RemoteAddr)
+
+	// Handle OPTIONS request
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		log.Printf("Method not allowed: %s", r.Method)
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Parse request body
+	var req CompareRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	// Validate request
+	if req.Prompt == "" {
+		http.Error(w, "Prompt cannot be empty", http.StatusBadRequest)
+		return
+	}
+	if req.Timeout < 0 {
+		http.Error(w, "Timeout cannot be negative", http.StatusBadRequest)
+		return
+	}
+	if len(req.Models) == 0 || len(req.Models) > maxCompareModels {
+		http.Error(w, fmt.Sprintf("Request must name 1 to %d models", maxCompareModels), http.StatusBadRequest)
+		return
+	}
+	for _, model := range req.Models {
+		if _, err := sqirvy.GetProviderName(model); err != nil {
+			http.Error(w, fmt.Sprintf("Invalid model: %v", err), http.StatusBadRequest)
+			return
+		}
+	}
+
+	// Query the models
+	results := sqirvy.Compare(r.Context(), sqirvy.CompareQuery{
+		Models:   req.Models,
+		System:   webSystem,
+		Messages: sqirvy.UserMessages([]string{req.Prompt}),
+		Options:  queryOptions(QueryRequest{Temperature: req.Temperature, Timeout: req.Timeout}),
+	}, clientOptions(r)...)
+
+	// Send response
+	response := CompareResponse{Results: make([]CompareResult, 0, len(results))}
+	for _, result := range results {
+		compared := CompareResult{
+			Model:    result.Model,
+			Status:   http.StatusOK,
+			Duration: result.Duration.Seconds(),
+		}
+		if result.Err != nil {
+			compared.Error = fmt.Sprintf("Query failed: %v", result.Err)
+			compared.Status = queryStatus(result.Err)
+		} else {
+			usage := responseUsage(result.Model, result.Response)
+			compared.Result = result.Response.Text
+			compared.Usage = &usage
+		}
+		response.Results = append(response.Results, compared)
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -146,3 +365,58 @@ func handleQuery(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 }
+
+// responseUsage returns the usage of a model's response, with its cost if
+// the model has a known price
+func responseUsage(model string, result *sqirvy.Response) Usage {
+	usage := Usage{
+		Model:        result.Model,
+		FinishReason: result.FinishReason,
+		InputTokens:  result.InputTokens,
+		OutputTokens: result.OutputTokens,
+	}
+	if cost, ok := sqirvy.GetCost(model, result.InputTokens, result.OutputTokens); ok {
+		usage.Cost = &cost
+	}
+	return usage
+}
+
+// queryOptions returns the query options set by a request
+func queryOptions(req QueryRequest) sqirvy.Options {
+	return sqirvy.Options{
+		Temperature: req.Temperature,
+		Timeout:     time.Duration(req.Timeout * float64(time.Second)),
+	}
+}
+
+// queryStatus maps a failed query to the HTTP status returned to the client
+func queryStatus(err error) int {
+	switch {
+	case errors.Is(err, sqirvy.ErrAuthentication):
+		return http.StatusUnauthorized
+	case errors.Is(err, sqirvy.ErrRateLimited):
+		return http.StatusTooManyRequests
+	case errors.Is(err, sqirvy.ErrContextTooLong):
+		return http.StatusRequestEntityTooLarge
+	case errors.Is(err, sqirvy.ErrContentFiltered):
+		return http.StatusUnprocessableEntity
+	case errors.Is(err, sqirvy.ErrModelNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, sqirvy.ErrTimeout):
+		return http.StatusGatewayTimeout
+	case errors.Is(err, sqirvy.ErrUnavailable):
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// queryError writes a failed query to the client with the matching HTTP status,
+// passing on any Retry-After delay requested by the provider
+func queryError(w http.ResponseWriter, err error) {
+	var providerErr *sqirvy.ProviderError
+	if errors.As(err, &providerErr) && providerErr.RetryAfter > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(providerErr.RetryAfter.Seconds()))))
+	}
+	http.Error(w, fmt.Sprintf("Query failed: %v", err), queryStatus(err))
+}
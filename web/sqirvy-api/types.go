@@ -16,9 +16,46 @@ type QueryRequest struct {
 	Model       string  `json:"model"`
 	Prompt      string  `json:"prompt"`
 	Temperature float32 `json:"temperature"`
+	Timeout     float64 `json:"timeout,omitempty"` // Time limit for the query in seconds, zero for no limit
 }
 
 // QueryResponse represents the response from the /query endpoint
 type QueryResponse struct {
 	Result string `json:"result"`
+	Usage  Usage  `json:"usage"`
+}
+
+// Usage reports the tokens used by a query and their estimated cost.
+// Cost is omitted if the model has no known price.
+type Usage struct {
+	Model        string   `json:"model"`
+	FinishReason string   `json:"finish_reason"`
+	InputTokens  int64    `json:"input_tokens"`
+	OutputTokens int64    `json:"output_tokens"`
+	Cost         *float64 `json:"cost,omitempty"`
+}
+
+// CompareRequest represents the request body for the /compare endpoint
+type CompareRequest struct {
+	Models      []string `json:"models"`
+	Prompt      string   `json:"prompt"`
+	Temperature float32  `json:"temperature"`
+	Timeout     float64  `json:"timeout,omitempty"` // Time limit for each model in seconds, zero for no limit
+}
+
+// CompareResponse represents the response from the /compare endpoint,
+// with one result per requested model in the order of the request
+type CompareResponse struct {
+	Results []CompareResult `json:"results"`
+}
+
+// CompareResult is the response of one model, or its error and the
+// HTTP status the same failure would return from /query
+type CompareResult struct {
+	Model    string  `json:"model"`
+	Result   string  `json:"result,omitempty"`
+	Usage    *Usage  `json:"usage,omitempty"`
+	Error    string  `json:"error,omitempty"`
+	Status   int     `json:"status"`
+	Duration float64 `json:"duration"` // Time taken by the model in seconds
 }
@@ -47,29 +47,30 @@ document.addEventListener('DOMContentLoaded', function () {
         // Clear previous results
         results.forEach(result => result.value = 'Loading...');
 
-        // Make requests for each selected model
-        for (let i = 0; i < modelSelects.length; i++) {
-            const model = modelSelects[i].value;
-            const resultArea = results[i];
-
-            try {
-                const response = await fetch('http://localhost:8080/query', {
-                    method: 'POST',
-                    headers: {
-                        'Content-Type': 'application/json',
-                    },
-                    body: JSON.stringify({
-                        model: model,
-                        prompt: prompt,
-                        temperature: 50
-                    }),
-                });
-
-                const data = await response.json();
-                resultArea.value = data.result;
-            } catch (error) {
-                resultArea.value = 'Error: ' + error.message;
+        // Query all selected models at once and show each model's result or error
+        const models = Array.from(modelSelects, select => select.value);
+        try {
+            const response = await fetch('http://localhost:8080/compare', {
+                method: 'POST',
+                headers: {
+                    'Content-Type': 'application/json',
+                },
+                body: JSON.stringify({
+                    models: models,
+                    prompt: prompt,
+                    temperature: 50
+                }),
+            });
+            if (!response.ok) {
+                throw new Error(await response.text());
             }
+
+            const data = await response.json();
+            data.results.forEach((result, i) => {
+                results[i].value = result.error ? 'Error: ' + result.error : result.result;
+            });
+        } catch (error) {
+            results.forEach(result => result.value = 'Error: ' + error.message);
         }
     });
 });